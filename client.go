@@ -9,10 +9,20 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
+	"maps"
+	"net"
 	"net/http"
 	"net/url"
+	"reflect"
+	"slices"
+	"strconv"
+	"sync"
 	"time"
+
+	"github.com/bluenviron/gohlslib/v2/pkg/codecparams"
+	"github.com/bluenviron/gohlslib/v2/pkg/playlist"
 )
 
 const (
@@ -21,9 +31,76 @@ const (
 	clientMaxDTSSystemDiff      = 10 * time.Second
 )
 
-// ErrClientEOS is returned by Wait() when the stream has ended.
+// ErrClientEOS is returned by Run() and Wait2() when the stream has ended.
 var ErrClientEOS = errors.New("end of stream")
 
+// ErrClientTerminated is returned by Wait2() when the client has been closed with Close().
+var ErrClientTerminated = errors.New("terminated")
+
+// ErrClientPlaybackTooLate is returned when the client is too far from the end of a live playlist.
+var ErrClientPlaybackTooLate = errors.New("playback is too late")
+
+// ErrClientMixedFormats is returned when a stream playlist switches from MPEG-TS or packed audio to fMP4.
+var ErrClientMixedFormats = errors.New("switching from MPEG-TS or packed audio to fMP4 is not supported")
+
+// ErrClientTooManyTracks is returned when a stream contains more tracks than the supported ones.
+var ErrClientTooManyTracks = errors.New("too many tracks per stream")
+
+// ErrClientLeadingTrackNotFound is returned when a segment does not contain data of the leading track.
+var ErrClientLeadingTrackNotFound = errors.New("could not find data of leading track")
+
+// HTTPStatusError is returned when the server replies with an unexpected status code.
+type HTTPStatusError struct {
+	URL        string
+	StatusCode int
+}
+
+// Error implements the error interface.
+func (e *HTTPStatusError) Error() string {
+	return "bad status code: " + strconv.FormatInt(int64(e.StatusCode), 10)
+}
+
+// clientCallbackError wraps an error returned by a user callback.
+type clientCallbackError struct {
+	err error
+}
+
+func (e clientCallbackError) Error() string {
+	return e.err.Error()
+}
+
+func (e clientCallbackError) Unwrap() error {
+	return e.err
+}
+
+// isRetryableError returns whether an error is caused by a temporary condition,
+// like a network failure, a timeout, a server error or a playback that fell behind.
+func isRetryableError(err error) bool {
+	if errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, ErrClientPlaybackTooLate) {
+		return true
+	}
+
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	var statusErr *HTTPStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= 500 ||
+			statusErr.StatusCode == http.StatusRequestTimeout ||
+			statusErr.StatusCode == http.StatusTooManyRequests
+	}
+
+	return false
+}
+
 // ClientOnDownloadPrimaryPlaylistFunc is the prototype of Client.OnDownloadPrimaryPlaylist.
 type ClientOnDownloadPrimaryPlaylistFunc func(url string)
 
@@ -45,6 +122,21 @@ type ClientOnRequestFunc func(*http.Request)
 // ClientOnTracksFunc is the prototype of the function passed to OnTracks().
 type ClientOnTracksFunc func([]*Track) error
 
+// ClientOnTracksChangedFunc is the prototype of Client.OnTracksChanged.
+type ClientOnTracksChangedFunc func([]*Track) error
+
+// ClientCodecFilterFunc is the prototype of Client.CodecFilter.
+type ClientCodecFilterFunc func(codec codecparams.Codec) bool
+
+// ClientRenditionFilterFunc is the prototype of Client.RenditionFilter.
+type ClientRenditionFilterFunc func(rendition *playlist.MultivariantRendition) bool
+
+// ClientOnCatchUpFunc is the prototype of Client.OnCatchUp.
+type ClientOnCatchUpFunc func(skipped time.Duration)
+
+// ClientOnReconnectFunc is the prototype of Client.OnReconnect.
+type ClientOnReconnectFunc func(err error)
+
 // ClientOnDataAV1Func is the prototype of the function passed to OnDataAV1().
 type ClientOnDataAV1Func func(pts int64, tu [][]byte)
 
@@ -60,6 +152,38 @@ type ClientOnDataMPEG4AudioFunc func(pts int64, aus [][]byte)
 // ClientOnDataOpusFunc is the prototype of the function passed to OnDataOpus().
 type ClientOnDataOpusFunc func(pts int64, packets [][]byte)
 
+// ClientOnDataMPEG1AudioFunc is the prototype of the function passed to OnDataMPEG1Audio().
+type ClientOnDataMPEG1AudioFunc func(pts int64, frames [][]byte)
+
+// ClientOnDataAC3Func is the prototype of the function passed to OnDataAC3().
+type ClientOnDataAC3Func func(pts int64, frame []byte)
+
+// ClientSample is a sample returned by ReadSample().
+type ClientSample struct {
+	// track the sample belongs to.
+	Track *Track
+
+	// presentation timestamp, expressed in Track.ClockRate units.
+	PTS int64
+
+	// decoding timestamp, expressed in Track.ClockRate units.
+	DTS int64
+
+	// absolute timestamp, if available.
+	NTP *time.Time
+
+	// payload.
+	// its format depends on the codec:
+	// - AV1: OBUs of a temporal unit
+	// - VP9: a single frame
+	// - H264, H265: NALUs of an access unit
+	// - MPEG-4 Audio: access units
+	// - Opus: packets
+	// - MPEG-1 Audio: frames
+	// - AC-3: a single frame
+	Payload [][]byte
+}
+
 func clientAbsoluteURL(base *url.URL, relative string) (*url.URL, error) {
 	u, err := url.Parse(relative)
 	if err != nil {
@@ -69,6 +193,11 @@ func clientAbsoluteURL(base *url.URL, relative string) (*url.URL, error) {
 }
 
 // Client is a HLS client.
+//
+// Streams of a session can use different formats (for instance, a MPEG-TS video variant
+// and a fMP4 audio rendition). In this case, MPEG-TS timestamps are assumed to be generated
+// from the same clock of fMP4 timestamps, modulo 2^33; the client stops with an error
+// when the first timestamps of the two formats are too far apart.
 type Client struct {
 	//
 	// parameters (all optional except URI)
@@ -83,17 +212,80 @@ type Client struct {
 	// expressed as number of segments.
 	// It defaults to 5.
 	MaxDistance int
+	// When the distance from the end of the playlist exceeds CatchUpDistance,
+	// skip forward to the nearest independent segment or part,
+	// instead of stopping with ErrClientPlaybackTooLate.
+	// This allows to keep latency within a fixed budget, at the cost of dropping content.
+	CatchUp bool
+	// Distance from the end of the playlist that triggers a skip forward when CatchUp is enabled,
+	// expressed as number of segments.
+	// It must be greater than StartDistance.
+	// It defaults to MaxDistance.
+	CatchUpDistance int
 	// HTTP client.
 	// It defaults to http.DefaultClient.
 	HTTPClient *http.Client
+	// Deliver samples of tracks without callbacks through ReadSample().
+	// When disabled, these samples are discarded.
+	PullSamples bool
+	// Read the I-frame playlist of the stream, in order to receive key frames only.
+	// This is useful to perform trick play or to generate thumbnails with a low bandwidth usage.
+	IFramesOnly bool
+	// Restart the client when a temporary error occurs, instead of stopping it.
+	// Temporary errors are network errors, timeouts, truncated responses, server errors (5xx, 408, 429)
+	// and ErrClientPlaybackTooLate.
+	// Other errors, like client errors (4xx), unsupported codecs and errors returned by callbacks,
+	// stop the client immediately.
+	// Tracks are reused when their parameters do not change.
+	// Timestamps of the new session are shifted by the time elapsed since the beginning of the first session,
+	// therefore the gap between sessions reflects the time spent reconnecting,
+	// and timestamps are always greater than the ones already delivered.
+	Reconnect bool
+	// Minimum delay between reconnection attempts.
+	// It is doubled after every failed attempt.
+	// It defaults to 1 second.
+	ReconnectMinDelay time.Duration
+	// Maximum delay between reconnection attempts.
+	// It defaults to 30 seconds.
+	ReconnectMaxDelay time.Duration
+	// Group of clients whose samples are delivered in sync,
+	// by using absolute timestamps.
+	SyncGroup *ClientSyncGroup
+	// Capabilities of the application.
+	// It is called with every codec of every variant,
+	// and variants with at least one rejected codec are not picked.
+	// Codecs whose parameters cannot be parsed are not passed to the filter,
+	// and are accepted when their identifier (for instance, "avc1") is supported.
+	// By default, all codecs supported by the client are accepted.
+	CodecFilter ClientCodecFilterFunc
+	// Selection of alternate renditions (for instance, camera angles).
+	// It is called with every audio and video rendition of the picked variant,
+	// and rejected renditions are not read.
+	// Renditions whose media is included in the variant are always read.
+	// Selection happens when the stream is started:
+	// switching rendition while reading is not supported, a new Client must be started instead.
+	// By default, all audio renditions and a single video rendition
+	// (the one with DEFAULT=YES, or the first one) are read.
+	RenditionFilter ClientRenditionFilterFunc
+	// Logger used by default callbacks to report events.
+	// Lifecycle events are logged with the info level,
+	// downloads of stream playlists, segments and parts with the debug level.
+	// It defaults to slog.Default().
+	Logger *slog.Logger
 
 	//
 	// callbacks (all optional)
+	// when set, they replace the default logging of the related event.
 	//
 	// called when sending a request to the server.
 	OnRequest ClientOnRequestFunc
 	// called when tracks are available.
 	OnTracks ClientOnTracksFunc
+	// called when tracks change in the middle of the stream,
+	// for instance when the initialization segment changes.
+	// Track objects are never modified: tracks whose parameters changed
+	// are replaced by new ones, that must be configured with OnData*().
+	OnTracksChanged ClientOnTracksChangedFunc
 	// called before downloading a primary playlist.
 	OnDownloadPrimaryPlaylist ClientOnDownloadPrimaryPlaylistFunc
 	// called before downloading a stream playlist.
@@ -104,35 +296,87 @@ type Client struct {
 	OnDownloadPart ClientOnDownloadPartFunc
 	// called when a non-fatal decode error occurs.
 	OnDecodeError ClientOnDecodeErrorFunc
+	// called when content is skipped in order to catch up with the live edge,
+	// with the duration of skipped content.
+	OnCatchUp ClientOnCatchUpFunc
+	// called when a fatal error occurs and the client is about to reconnect.
+	OnReconnect ClientOnReconnectFunc
 
 	//
 	// private
 	//
 
-	ctx               context.Context
-	ctxCancel         func()
+	ctxCancel         context.CancelCauseFunc
 	playlistURL       *url.URL
 	primaryDownloader *clientPrimaryDownloader
-	leadingTimeConv   clientTimeConv
+	leadingTimeConv   *clientTimeConv
+	tracksMutex       sync.RWMutex
 	tracks            map[*Track]*clientTrack
+	trackList         []*Track
+	pacing            clientPacing
+	timeOffset        time.Duration
+	sampleQueue       clientSampleQueue
 	closeError        error
 
 	// out
+	doneOnce             sync.Once
 	done                 chan struct{}
 	leadingTimeConvReady chan struct{}
 }
 
-// Start starts the client.
+// Start starts the client in background.
+// Resources are released by calling Close().
 func (c *Client) Start() error {
+	err := c.initialize()
+	if err != nil {
+		return err
+	}
+
+	var ctx context.Context
+	ctx, c.ctxCancel = context.WithCancelCause(context.Background())
+
+	go c.run(ctx)
+
+	return nil
+}
+
+// Run runs the client until the context is canceled or a fatal error occurs.
+// When the context is canceled, the returned error is the cancellation cause,
+// as returned by context.Cause().
+// Wait2() returns the same error once Run() has returned.
+func (c *Client) Run(ctx context.Context) error {
+	err := c.initialize()
+	if err != nil {
+		return err
+	}
+
+	c.run(ctx)
+
+	return c.closeError
+}
+
+func (c *Client) initialize() error {
 	if c.StartDistance == 0 {
 		c.StartDistance = 3
 	}
 	if c.MaxDistance == 0 {
 		c.MaxDistance = 5
 	}
+	if c.CatchUpDistance == 0 {
+		c.CatchUpDistance = c.MaxDistance
+	}
+	if c.CatchUp && c.CatchUpDistance <= c.StartDistance {
+		return fmt.Errorf("CatchUpDistance must be greater than StartDistance")
+	}
 	if c.HTTPClient == nil {
 		c.HTTPClient = http.DefaultClient
 	}
+	if c.ReconnectMinDelay == 0 {
+		c.ReconnectMinDelay = 1 * time.Second
+	}
+	if c.ReconnectMaxDelay == 0 {
+		c.ReconnectMaxDelay = 30 * time.Second
+	}
 	if c.OnRequest == nil {
 		c.OnRequest = func(_ *http.Request) {}
 	}
@@ -141,29 +385,22 @@ func (c *Client) Start() error {
 			return nil
 		}
 	}
-	if c.OnDownloadPrimaryPlaylist == nil {
-		c.OnDownloadPrimaryPlaylist = func(u string) {
-			log.Printf("downloading primary playlist %v", u)
-		}
-	}
-	if c.OnDownloadStreamPlaylist == nil {
-		c.OnDownloadStreamPlaylist = func(u string) {
-			log.Printf("downloading stream playlist %v", u)
+	if c.OnTracksChanged == nil {
+		c.OnTracksChanged = func(_ []*Track) error {
+			return nil
 		}
 	}
-	if c.OnDownloadSegment == nil {
-		c.OnDownloadSegment = func(u string) {
-			log.Printf("downloading segment %v", u)
-		}
+	if c.Logger == nil {
+		c.Logger = slog.Default()
 	}
-	if c.OnDownloadPart == nil {
-		c.OnDownloadPart = func(u string) {
-			log.Printf("downloading part %v", u)
+	if c.OnDownloadPrimaryPlaylist == nil {
+		c.OnDownloadPrimaryPlaylist = func(u string) {
+			c.Logger.Info("downloading primary playlist", "uri", u)
 		}
 	}
-	if c.OnDecodeError == nil {
-		c.OnDecodeError = func(err error) {
-			log.Println(err.Error())
+	if c.OnReconnect == nil {
+		c.OnReconnect = func(err error) {
+			c.Logger.Warn("reconnecting", "error", err)
 		}
 	}
 
@@ -173,20 +410,14 @@ func (c *Client) Start() error {
 		return err
 	}
 
-	c.ctx, c.ctxCancel = context.WithCancel(context.Background())
-
-	c.done = make(chan struct{})
-	c.leadingTimeConvReady = make(chan struct{})
-
-	go c.run()
-
 	return nil
 }
 
 // Close closes all the Client resources and waits for them to exit.
+// It can be used only when the client has been started with Start().
 func (c *Client) Close() {
-	c.ctxCancel()
-	<-c.done
+	c.ctxCancel(ErrClientTerminated)
+	<-c.doneChan()
 }
 
 // Wait waits for any error of the Client.
@@ -195,7 +426,7 @@ func (c *Client) Close() {
 func (c *Client) Wait() chan error {
 	ch := make(chan error)
 	go func() {
-		<-c.done
+		<-c.doneChan()
 		ch <- c.closeError
 	}()
 	return ch
@@ -203,67 +434,148 @@ func (c *Client) Wait() chan error {
 
 // Wait2 waits until all client resources are closed.
 // This can happen when a fatal error occurs or when Close() is called.
+// When the client is run with Run(), it returns once Run() has returned.
 func (c *Client) Wait2() error {
-	<-c.done
+	<-c.doneChan()
 	return c.closeError
 }
 
+func (c *Client) doneChan() chan struct{} {
+	c.doneOnce.Do(func() {
+		c.done = make(chan struct{})
+	})
+	return c.done
+}
+
 // OnDataAV1 sets a callback that is called when data from an AV1 track is received.
 func (c *Client) OnDataAV1(track *Track, cb ClientOnDataAV1Func) {
-	c.tracks[track].onData = func(pts int64, _ int64, data [][]byte) {
+	c.getTrack(track).onData = func(pts int64, _ int64, data [][]byte) {
 		cb(pts, data)
 	}
 }
 
 // OnDataVP9 sets a callback that is called when data from a VP9 track is received.
 func (c *Client) OnDataVP9(track *Track, cb ClientOnDataVP9Func) {
-	c.tracks[track].onData = func(pts int64, _ int64, data [][]byte) {
+	c.getTrack(track).onData = func(pts int64, _ int64, data [][]byte) {
 		cb(pts, data[0])
 	}
 }
 
 // OnDataH26x sets a callback that is called when data from an H26x track is received.
 func (c *Client) OnDataH26x(track *Track, cb ClientOnDataH26xFunc) {
-	c.tracks[track].onData = func(pts int64, dts int64, data [][]byte) {
+	c.getTrack(track).onData = func(pts int64, dts int64, data [][]byte) {
 		cb(pts, dts, data)
 	}
 }
 
 // OnDataMPEG4Audio sets a callback that is called when data from a MPEG-4 Audio track is received.
 func (c *Client) OnDataMPEG4Audio(track *Track, cb ClientOnDataMPEG4AudioFunc) {
-	c.tracks[track].onData = func(pts int64, _ int64, data [][]byte) {
+	c.getTrack(track).onData = func(pts int64, _ int64, data [][]byte) {
 		cb(pts, data)
 	}
 }
 
 // OnDataOpus sets a callback that is called when data from an Opus track is received.
 func (c *Client) OnDataOpus(track *Track, cb ClientOnDataOpusFunc) {
-	c.tracks[track].onData = func(pts int64, _ int64, data [][]byte) {
+	c.getTrack(track).onData = func(pts int64, _ int64, data [][]byte) {
+		cb(pts, data)
+	}
+}
+
+// OnDataMPEG1Audio sets a callback that is called when data from a MPEG-1 Audio track is received.
+func (c *Client) OnDataMPEG1Audio(track *Track, cb ClientOnDataMPEG1AudioFunc) {
+	c.getTrack(track).onData = func(pts int64, _ int64, data [][]byte) {
 		cb(pts, data)
 	}
 }
 
+// OnDataAC3 sets a callback that is called when data from an AC-3 track is received.
+func (c *Client) OnDataAC3(track *Track, cb ClientOnDataAC3Func) {
+	c.getTrack(track).onData = func(pts int64, _ int64, data [][]byte) {
+		cb(pts, data[0])
+	}
+}
+
 var zero time.Time
 
 // AbsoluteTime returns the absolute timestamp of the last sample.
 func (c *Client) AbsoluteTime(track *Track) (time.Time, bool) {
-	return c.tracks[track].absoluteTime()
+	return c.getTrack(track).absoluteTime()
+}
+
+// ReadSample reads the next sample, in DTS order among all tracks.
+// A sample is returned once all tracks have provided a sample,
+// in order to guarantee that no sample with a lower DTS can arrive later.
+// It is available when PullSamples is true, and returns an error when the client
+// stops or when the context is canceled.
+// The client does not proceed when too many samples are waiting to be read.
+func (c *Client) ReadSample(ctx context.Context) (*ClientSample, error) {
+	return c.sampleQueue.pull(ctx)
+}
+
+func (c *Client) run(ctx context.Context) {
+	c.closeError = c.runInner(ctx)
+	close(c.doneChan())
 }
 
-func (c *Client) run() {
-	c.closeError = c.runInner()
-	close(c.done)
+func (c *Client) runInner(ctx context.Context) error {
+	err := c.runInner2(ctx)
+	c.sampleQueue.close(err)
+	return err
 }
 
-func (c *Client) runInner() error {
+func (c *Client) runInner2(ctx context.Context) error {
+	delay := c.ReconnectMinDelay
+
+	for {
+		err := c.runSession(ctx)
+
+		var callbackErr clientCallbackError
+		if errors.As(err, &callbackErr) {
+			return callbackErr.err
+		}
+
+		if !c.Reconnect || ctx.Err() != nil || !isRetryableError(err) {
+			return err
+		}
+
+		// reset the delay when the previous session was able to read data
+		select {
+		case <-c.leadingTimeConvReady:
+			delay = c.ReconnectMinDelay
+		default:
+		}
+
+		c.OnReconnect(err)
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		}
+
+		delay = min(delay*2, c.ReconnectMaxDelay)
+	}
+}
+
+func (c *Client) runSession(ctx context.Context) error {
+	c.leadingTimeConv = nil
+	c.leadingTimeConvReady = make(chan struct{})
+	c.pacing.resetSkipped()
+
 	rp := &clientRoutinePool{}
-	rp.initialize()
+	rp.initialize(ctx)
 
 	c.primaryDownloader = &clientPrimaryDownloader{
 		primaryPlaylistURL:        c.playlistURL,
 		startDistance:             c.StartDistance,
 		maxDistance:               c.MaxDistance,
+		catchUp:                   c.CatchUp,
+		catchUpDistance:           c.CatchUpDistance,
 		httpClient:                c.HTTPClient,
+		iframesOnly:               c.IFramesOnly,
+		codecFilter:               c.CodecFilter,
+		renditionFilter:           c.RenditionFilter,
 		rp:                        rp,
 		onRequest:                 c.OnRequest,
 		onDownloadPrimaryPlaylist: c.OnDownloadPrimaryPlaylist,
@@ -271,6 +583,8 @@ func (c *Client) runInner() error {
 		onDownloadSegment:         c.OnDownloadSegment,
 		onDownloadPart:            c.OnDownloadPart,
 		onDecodeError:             c.OnDecodeError,
+		onCatchUp:                 c.OnCatchUp,
+		logger:                    c.Logger,
 		client:                    c,
 	}
 	c.primaryDownloader.initialize()
@@ -281,36 +595,180 @@ func (c *Client) runInner() error {
 		rp.close()
 		return err
 
-	case <-c.ctx.Done():
+	case <-ctx.Done():
 		rp.close()
-		return fmt.Errorf("terminated")
+		return context.Cause(ctx)
+	}
+}
+
+func (c *Client) getTrack(track *Track) *clientTrack {
+	c.tracksMutex.RLock()
+	defer c.tracksMutex.RUnlock()
+	return c.tracks[track]
+}
+
+func (c *Client) newClientTrack(track *Track) *clientTrack {
+	ct := &clientTrack{
+		track:      track,
+		pacing:     &c.pacing,
+		timeOffset: c.timeOffset,
+		syncGroup:  c.SyncGroup,
 	}
+
+	if c.PullSamples {
+		ct.sampleQueue = &c.sampleQueue
+	} else {
+		ct.onData = func(_, _ int64, _ [][]byte) {}
+	}
+
+	return ct
 }
 
 func (c *Client) setTracks(tracks []*Track) (map[*Track]*clientTrack, error) {
+	if c.tracks != nil {
+		return c.resumeTracks(tracks)
+	}
+
+	c.tracksMutex.Lock()
 	c.tracks = make(map[*Track]*clientTrack)
 	for _, track := range tracks {
-		c.tracks[track] = &clientTrack{
-			track:  track,
-			onData: func(_, _ int64, _ [][]byte) {},
-		}
+		c.tracks[track] = c.newClientTrack(track)
 	}
+	c.trackList = tracks
+	c.tracksMutex.Unlock()
 
 	err := c.OnTracks(tracks)
 	if err != nil {
-		return nil, err
+		return nil, clientCallbackError{err}
+	}
+
+	c.updateSampleQueueTracks()
+
+	return maps.Clone(c.tracks), nil
+}
+
+// resumeTracks associates tracks of a new session with the ones of the previous session.
+func (c *Client) resumeTracks(tracks []*Track) (map[*Track]*clientTrack, error) {
+	c.tracksMutex.Lock()
+
+	ret := make(map[*Track]*clientTrack)
+	unused := slices.Clone(c.trackList)
+	var trackList []*Track
+	changed := false
+
+	for _, track := range tracks {
+		i := slices.IndexFunc(unused, func(t *Track) bool {
+			return reflect.DeepEqual(*t, *track)
+		})
+		if i >= 0 {
+			ret[track] = c.tracks[unused[i]]
+			trackList = append(trackList, unused[i])
+			unused = slices.Delete(unused, i, i+1)
+		} else {
+			ret[track] = c.newClientTrack(track)
+			trackList = append(trackList, track)
+			changed = true
+		}
 	}
 
-	return c.tracks, nil
+	if len(unused) != 0 {
+		changed = true
+	}
+
+	c.tracks = make(map[*Track]*clientTrack)
+	for _, ct := range ret {
+		c.tracks[ct.track] = ct
+	}
+	c.trackList = trackList
+
+	c.tracksMutex.Unlock()
+
+	if changed {
+		err := c.OnTracksChanged(trackList)
+		if err != nil {
+			return nil, clientCallbackError{err}
+		}
+	}
+
+	c.updateSampleQueueTracks()
+
+	return ret, nil
 }
 
-func (c *Client) setLeadingTimeConv(ts clientTimeConv) {
+func (c *Client) updateTracks(prevTracks []*clientTrack, tracks []*Track) ([]*clientTrack, error) {
+	c.tracksMutex.Lock()
+
+	for _, prevTrack := range prevTracks {
+		if !slices.Contains(tracks, prevTrack.track) {
+			delete(c.tracks, prevTrack.track)
+			c.trackList = slices.DeleteFunc(slices.Clone(c.trackList), func(t *Track) bool {
+				return t == prevTrack.track
+			})
+		}
+	}
+
+	streamTracks := make([]*clientTrack, len(tracks))
+
+	for i, track := range tracks {
+		ct, ok := c.tracks[track]
+		if !ok {
+			ct = c.newClientTrack(track)
+			c.tracks[track] = ct
+			c.trackList = append(slices.Clone(c.trackList), track)
+		}
+		streamTracks[i] = ct
+	}
+
+	allTracks := c.trackList
+
+	c.tracksMutex.Unlock()
+
+	err := c.OnTracksChanged(allTracks)
+	if err != nil {
+		return nil, clientCallbackError{err}
+	}
+
+	c.updateSampleQueueTracks()
+
+	return streamTracks, nil
+}
+
+// updateSampleQueueTracks sets the tracks whose samples are delivered through ReadSample().
+func (c *Client) updateSampleQueueTracks() {
+	if !c.PullSamples {
+		return
+	}
+
+	c.tracksMutex.RLock()
+	var tracks []*Track
+	for _, track := range c.trackList {
+		if c.tracks[track].onData == nil {
+			tracks = append(tracks, track)
+		}
+	}
+	c.tracksMutex.RUnlock()
+
+	c.sampleQueue.setTracks(tracks)
+}
+
+func (c *Client) setLeadingTimeConv(ts *clientTimeConv) {
+	c.tracksMutex.Lock()
+	defer c.tracksMutex.Unlock()
+
 	c.leadingTimeConv = ts
 
-	startSystem := time.Now()
+	// when resuming a session, shift timestamps by the time elapsed since the first session,
+	// placing them after the last delivered sample.
+	if !c.pacing.start() {
+		c.timeOffset = c.pacing.elapsed()
+		for _, track := range c.tracks {
+			c.timeOffset = max(c.timeOffset, track.endDTS())
+		}
+	}
 
 	for _, track := range c.tracks {
-		track.startSystem = startSystem
+		track.timeOffset = c.timeOffset
+		track.sessionStarted = false
 	}
 
 	close(c.leadingTimeConvReady)
@@ -325,6 +783,10 @@ func (c *Client) waitLeadingTimeConv(ctx context.Context) bool {
 	return true
 }
 
-func (c *Client) getLeadingTimeConv() clientTimeConv {
+func (c *Client) getLeadingTimeConv() *clientTimeConv {
 	return c.leadingTimeConv
 }
+
+func (c *Client) skipPacing(streamSkipped time.Duration) {
+	c.pacing.skip(streamSkipped)
+}
@@ -4,20 +4,61 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
 
+	"github.com/bluenviron/gohlslib/v2/pkg/codecparams"
 	"github.com/bluenviron/gohlslib/v2/pkg/playlist"
 )
 
-func checkSupport(codecs []string) bool {
-	for _, codec := range codecs {
-		if !strings.HasPrefix(codec, "avc1.") &&
-			!strings.HasPrefix(codec, "hvc1.") &&
-			!strings.HasPrefix(codec, "hev1.") &&
-			!strings.HasPrefix(codec, "mp4a.") &&
-			codec != "opus" {
+func isCodecSupported(codec codecparams.Codec) bool {
+	switch codec := codec.(type) {
+	case *codecparams.AVC, *codecparams.HEVC, *codecparams.AV1, *codecparams.VP9,
+		*codecparams.Opus, *codecparams.AC3:
+		return true
+
+	case *codecparams.MPEG4Audio:
+		// MPEG-4 Audio, MPEG-2 Audio (MP3), MPEG-1 Audio (MP3)
+		return codec.ObjectTypeIndication == 0x40 ||
+			codec.ObjectTypeIndication == 0x69 ||
+			codec.ObjectTypeIndication == 0x6B
+	}
+
+	return false
+}
+
+// isUnparsedCodecSupported checks whether a codec whose parameters cannot be parsed
+// is supported, by using its identifier.
+func isUnparsedCodecSupported(v string) bool {
+	for _, prefix := range []string{"avc1.", "avc3.", "hvc1.", "hev1.", "av01.", "vp09.", "mp4a."} {
+		if strings.HasPrefix(v, prefix) {
+			return true
+		}
+	}
+
+	return v == "opus" || v == "Opus" || v == "ac-3"
+}
+
+func checkSupport(codecs []string, filter ClientCodecFilterFunc) bool {
+	for _, v := range codecs {
+		codec, err := codecparams.Unmarshal(v)
+		if err != nil {
+			// parameters of some packagers are malformed or not supported yet,
+			// fall back to the codec identifier.
+			if !isUnparsedCodecSupported(strings.TrimSpace(v)) {
+				return false
+			}
+			continue
+		}
+
+		if !isCodecSupported(codec) {
+			return false
+		}
+
+		if filter != nil && !filter(codec) {
 			return false
 		}
 	}
@@ -60,7 +101,7 @@ func downloadPlaylist(
 	defer res.Body.Close()
 
 	if res.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("bad status code: %d", res.StatusCode)
+		return nil, &HTTPStatusError{URL: ur.String(), StatusCode: res.StatusCode}
 	}
 
 	byts, err := io.ReadAll(res.Body)
@@ -71,10 +112,13 @@ func downloadPlaylist(
 	return playlist.Unmarshal(byts)
 }
 
-func pickLeadingPlaylist(variants []*playlist.MultivariantVariant) *playlist.MultivariantVariant {
+func pickLeadingPlaylist(
+	variants []*playlist.MultivariantVariant,
+	filter ClientCodecFilterFunc,
+) *playlist.MultivariantVariant {
 	var candidates []*playlist.MultivariantVariant //nolint:prealloc
 	for _, v := range variants {
-		if !checkSupport(v.Codecs) {
+		if !checkSupport(v.Codecs, filter) {
 			continue
 		}
 		candidates = append(candidates, v)
@@ -94,14 +138,33 @@ func pickLeadingPlaylist(variants []*playlist.MultivariantVariant) *playlist.Mul
 	return leadingPlaylist
 }
 
+func pickLeadingIFramePlaylist(
+	variants []*playlist.MultivariantIFrameVariant,
+	filter ClientCodecFilterFunc,
+) *playlist.MultivariantIFrameVariant {
+	// pick the variant with the greatest bandwidth
+	var leadingPlaylist *playlist.MultivariantIFrameVariant
+	for _, v := range variants {
+		if !checkSupport(v.Codecs, filter) {
+			continue
+		}
+		if leadingPlaylist == nil ||
+			v.Bandwidth > leadingPlaylist.Bandwidth {
+			leadingPlaylist = v
+		}
+	}
+	return leadingPlaylist
+}
+
 func getRenditionsByGroup(
 	renditions []*playlist.MultivariantRendition,
+	typ playlist.MultivariantRenditionType,
 	groupID string,
 ) []*playlist.MultivariantRendition {
 	var ret []*playlist.MultivariantRendition
 
 	for _, alt := range renditions {
-		if alt.GroupID == groupID {
+		if alt.Type == typ && alt.GroupID == groupID {
 			ret = append(ret, alt)
 		}
 	}
@@ -109,18 +172,35 @@ func getRenditionsByGroup(
 	return ret
 }
 
+// getDefaultRendition returns the rendition with DEFAULT=YES, or the first one.
+func getDefaultRendition(renditions []*playlist.MultivariantRendition) *playlist.MultivariantRendition {
+	for _, r := range renditions {
+		if r.Default {
+			return r
+		}
+	}
+	return renditions[0]
+}
+
 type clientPrimaryDownloaderClient interface {
 	setTracks([]*Track) (map[*Track]*clientTrack, error)
-	setLeadingTimeConv(ts clientTimeConv)
+	updateTracks(prevTracks []*clientTrack, tracks []*Track) ([]*clientTrack, error)
+	setLeadingTimeConv(ts *clientTimeConv)
 	waitLeadingTimeConv(ctx context.Context) bool
-	getLeadingTimeConv() clientTimeConv
+	getLeadingTimeConv() *clientTimeConv
+	skipPacing(skipped time.Duration)
 }
 
 type clientPrimaryDownloader struct {
 	primaryPlaylistURL        *url.URL
 	startDistance             int
 	maxDistance               int
+	catchUp                   bool
+	catchUpDistance           int
 	httpClient                *http.Client
+	iframesOnly               bool
+	codecFilter               ClientCodecFilterFunc
+	renditionFilter           ClientRenditionFilterFunc
 	rp                        *clientRoutinePool
 	onRequest                 ClientOnRequestFunc
 	onDownloadPrimaryPlaylist ClientOnDownloadPrimaryPlaylistFunc
@@ -128,6 +208,8 @@ type clientPrimaryDownloader struct {
 	onDownloadSegment         ClientOnDownloadSegmentFunc
 	onDownloadPart            ClientOnDownloadPartFunc
 	onDecodeError             ClientOnDecodeErrorFunc
+	onCatchUp                 ClientOnCatchUpFunc
+	logger                    *slog.Logger
 	client                    clientPrimaryDownloaderClient
 
 	clientTracks map[*Track]*clientTrack
@@ -152,12 +234,16 @@ func (d *clientPrimaryDownloader) run(ctx context.Context) error {
 			isLeading:                true,
 			startDistance:            d.startDistance,
 			maxDistance:              d.maxDistance,
+			catchUp:                  d.catchUp,
+			catchUpDistance:          d.catchUpDistance,
 			httpClient:               d.httpClient,
 			onRequest:                d.onRequest,
 			onDownloadStreamPlaylist: d.onDownloadStreamPlaylist,
 			onDownloadSegment:        d.onDownloadSegment,
 			onDownloadPart:           d.onDownloadPart,
 			onDecodeError:            d.onDecodeError,
+			onCatchUp:                d.onCatchUp,
+			logger:                   d.logger.With("stream", "main"),
 			playlistURL:              d.primaryPlaylistURL,
 			firstPlaylist:            plt,
 			rp:                       d.rp,
@@ -168,9 +254,46 @@ func (d *clientPrimaryDownloader) run(ctx context.Context) error {
 		streams = append(streams, stream)
 
 	case *playlist.Multivariant:
-		leadingPlaylist := pickLeadingPlaylist(plt.Variants)
+		if d.iframesOnly {
+			leadingPlaylist := pickLeadingIFramePlaylist(plt.IFrameVariants, d.codecFilter)
+			if leadingPlaylist == nil {
+				return fmt.Errorf("no I-frame variants with supported codecs found: %w", ErrUnsupportedCodec)
+			}
+
+			var u *url.URL
+			u, err = clientAbsoluteURL(d.primaryPlaylistURL, leadingPlaylist.URI)
+			if err != nil {
+				return err
+			}
+
+			stream := &clientStreamDownloader{
+				isLeading:                true,
+				startDistance:            d.startDistance,
+				maxDistance:              d.maxDistance,
+				catchUp:                  d.catchUp,
+				catchUpDistance:          d.catchUpDistance,
+				httpClient:               d.httpClient,
+				onRequest:                d.onRequest,
+				onDownloadStreamPlaylist: d.onDownloadStreamPlaylist,
+				onDownloadSegment:        d.onDownloadSegment,
+				onDownloadPart:           d.onDownloadPart,
+				onDecodeError:            d.onDecodeError,
+				onCatchUp:                d.onCatchUp,
+				logger:                   d.logger.With("stream", "main"),
+				playlistURL:              u,
+				firstPlaylist:            nil,
+				rp:                       d.rp,
+				client:                   d.client,
+			}
+			stream.initialize()
+			d.rp.add(stream)
+			streams = append(streams, stream)
+			break
+		}
+
+		leadingPlaylist := pickLeadingPlaylist(plt.Variants, d.codecFilter)
 		if leadingPlaylist == nil {
-			return fmt.Errorf("no variants with supported codecs found")
+			return fmt.Errorf("no variants with supported codecs found: %w", ErrUnsupportedCodec)
 		}
 
 		var u *url.URL
@@ -183,61 +306,101 @@ func (d *clientPrimaryDownloader) run(ctx context.Context) error {
 			isLeading:                true,
 			startDistance:            d.startDistance,
 			maxDistance:              d.maxDistance,
+			catchUp:                  d.catchUp,
+			catchUpDistance:          d.catchUpDistance,
 			httpClient:               d.httpClient,
 			onRequest:                d.onRequest,
 			onDownloadStreamPlaylist: d.onDownloadStreamPlaylist,
 			onDownloadSegment:        d.onDownloadSegment,
 			onDownloadPart:           d.onDownloadPart,
 			onDecodeError:            d.onDecodeError,
+			onCatchUp:                d.onCatchUp,
+			logger:                   d.logger.With("stream", "main"),
 			playlistURL:              u,
 			firstPlaylist:            nil,
 			rp:                       d.rp,
 			client:                   d.client,
 		}
 		stream.initialize()
-		d.rp.add(stream)
 		streams = append(streams, stream)
 
-		if leadingPlaylist.Audio != "" {
-			audioPlaylists := getRenditionsByGroup(plt.Renditions, leadingPlaylist.Audio)
-			if audioPlaylists == nil {
-				return fmt.Errorf("no playlist with Group ID \"%s\" found", leadingPlaylist.Audio)
+		for _, group := range []struct {
+			typ     playlist.MultivariantRenditionType
+			groupID string
+		}{
+			{playlist.MultivariantRenditionTypeVideo, leadingPlaylist.Video},
+			{playlist.MultivariantRenditionTypeAudio, leadingPlaylist.Audio},
+		} {
+			if group.groupID == "" {
+				continue
+			}
+
+			renditions := getRenditionsByGroup(plt.Renditions, group.typ, group.groupID)
+			if renditions == nil {
+				return fmt.Errorf("no playlist with Group ID \"%s\" found", group.groupID)
 			}
 
-			for _, pl := range audioPlaylists {
+			for _, pl := range renditions {
 				// stream data already included in the leading playlist
 				if pl.URI == nil {
+					if group.typ == playlist.MultivariantRenditionTypeVideo {
+						streams[0].rendition = pl
+					}
 					continue
 				}
 
-				if pl.URI != nil {
-					u, err = clientAbsoluteURL(d.primaryPlaylistURL, *pl.URI)
-					if err != nil {
-						return err
+				var ru *url.URL
+				ru, err = clientAbsoluteURL(d.primaryPlaylistURL, *pl.URI)
+				if err != nil {
+					return err
+				}
+
+				// rendition that points to the leading playlist
+				if ru.String() == u.String() {
+					if group.typ == playlist.MultivariantRenditionTypeVideo {
+						streams[0].rendition = pl
 					}
+					continue
+				}
 
-					stream = &clientStreamDownloader{
-						isLeading:                false,
-						onRequest:                d.onRequest,
-						startDistance:            d.startDistance,
-						maxDistance:              d.maxDistance,
-						httpClient:               d.httpClient,
-						onDownloadStreamPlaylist: d.onDownloadStreamPlaylist,
-						onDownloadSegment:        d.onDownloadSegment,
-						onDownloadPart:           d.onDownloadPart,
-						onDecodeError:            d.onDecodeError,
-						playlistURL:              u,
-						rendition:                pl,
-						rp:                       d.rp,
-						client:                   d.client,
+				if d.renditionFilter != nil {
+					if !d.renditionFilter(pl) {
+						continue
 					}
-					stream.initialize()
-					d.rp.add(stream)
-					streams = append(streams, stream)
+				} else if group.typ == playlist.MultivariantRenditionTypeVideo && pl != getDefaultRendition(renditions) {
+					// by default, a single video rendition is read
+					continue
+				}
+
+				stream = &clientStreamDownloader{
+					isLeading:                false,
+					onRequest:                d.onRequest,
+					startDistance:            d.startDistance,
+					maxDistance:              d.maxDistance,
+					catchUp:                  d.catchUp,
+					catchUpDistance:          d.catchUpDistance,
+					httpClient:               d.httpClient,
+					onDownloadStreamPlaylist: d.onDownloadStreamPlaylist,
+					onDownloadSegment:        d.onDownloadSegment,
+					onDownloadPart:           d.onDownloadPart,
+					onDecodeError:            d.onDecodeError,
+					onCatchUp:                d.onCatchUp,
+					logger:                   d.logger.With("stream", strings.ToLower(string(pl.Type))+":"+pl.Name),
+					playlistURL:              ru,
+					rendition:                pl,
+					rp:                       d.rp,
+					client:                   d.client,
 				}
+				stream.initialize()
+				streams = append(streams, stream)
 			}
 		}
 
+		// streams are started after the rendition of the leading stream has been set
+		for _, stream := range streams {
+			d.rp.add(stream)
+		}
+
 	default:
 		return fmt.Errorf("invalid playlist")
 	}
@@ -250,12 +413,12 @@ func (d *clientPrimaryDownloader) run(ctx context.Context) error {
 			tracks = append(tracks, streamTracks...)
 
 		case <-ctx.Done():
-			return fmt.Errorf("terminated")
+			return context.Cause(ctx)
 		}
 	}
 
 	if len(tracks) == 0 {
-		return fmt.Errorf("no supported tracks found")
+		return fmt.Errorf("no supported tracks found: %w", ErrUnsupportedCodec)
 	}
 
 	d.clientTracks, err = d.client.setTracks(tracks)
@@ -267,7 +430,7 @@ func (d *clientPrimaryDownloader) run(ctx context.Context) error {
 		select {
 		case stream.chStartStreaming <- d.clientTracks:
 		case <-ctx.Done():
-			return fmt.Errorf("terminated")
+			return context.Cause(ctx)
 		}
 	}
 
@@ -275,7 +438,7 @@ func (d *clientPrimaryDownloader) run(ctx context.Context) error {
 		select {
 		case err = <-stream.chProcessorError:
 		case <-ctx.Done():
-			return fmt.Errorf("terminated")
+			return context.Cause(ctx)
 		}
 	}
 
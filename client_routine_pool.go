@@ -11,19 +11,19 @@ type clientRoutinePoolRunnable interface {
 
 type clientRoutinePool struct {
 	ctx       context.Context
-	ctxCancel func()
+	ctxCancel context.CancelCauseFunc
 	wg        sync.WaitGroup
 
 	err chan error
 }
 
-func (rp *clientRoutinePool) initialize() {
-	rp.ctx, rp.ctxCancel = context.WithCancel(context.Background())
+func (rp *clientRoutinePool) initialize(parentCtx context.Context) {
+	rp.ctx, rp.ctxCancel = context.WithCancelCause(parentCtx)
 	rp.err = make(chan error)
 }
 
 func (rp *clientRoutinePool) close() {
-	rp.ctxCancel()
+	rp.ctxCancel(ErrClientTerminated)
 	rp.wg.Wait()
 }
 
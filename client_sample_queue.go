@@ -0,0 +1,165 @@
+package gohlslib
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// maximum number of samples of a single track that can wait to be read.
+	// When this is reached, samples are delivered even if other tracks are late,
+	// in order to allow the client to proceed.
+	clientSampleQueueMaxTrackSamples = 256
+)
+
+type clientSampleQueueEntry struct {
+	sample *ClientSample
+	dts    time.Duration
+}
+
+// clientSampleQueue merges samples of all tracks in DTS order.
+// A sample is delivered when all active tracks have at least a sample waiting,
+// that is, when there's no track that can still provide a sample with a lower DTS.
+// Producers are blocked when too many samples are waiting, in order to provide backpressure.
+type clientSampleQueue struct {
+	mutex   sync.Mutex
+	tracks  []*Track
+	queue   []*clientSampleQueueEntry
+	pending map[*Track]int
+	err     error
+	changed chan struct{}
+}
+
+func (q *clientSampleQueue) changedChan() chan struct{} {
+	if q.changed == nil {
+		q.changed = make(chan struct{})
+	}
+	return q.changed
+}
+
+func (q *clientSampleQueue) notify() {
+	close(q.changedChan())
+	q.changed = make(chan struct{})
+}
+
+// setTracks sets the tracks that provide samples.
+func (q *clientSampleQueue) setTracks(tracks []*Track) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.tracks = slices.Clone(tracks)
+	q.notify()
+}
+
+func (q *clientSampleQueue) push(ctx context.Context, sample *ClientSample, dts time.Duration) error {
+	q.mutex.Lock()
+
+	if q.pending == nil {
+		q.pending = make(map[*Track]int)
+	}
+
+	for q.pending[sample.Track] >= clientSampleQueueMaxTrackSamples {
+		if q.err != nil {
+			err := q.err
+			q.mutex.Unlock()
+			return err
+		}
+
+		changed := q.changedChan()
+		q.mutex.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		}
+
+		q.mutex.Lock()
+	}
+
+	i := len(q.queue)
+	for i > 0 && q.queue[i-1].dts > dts {
+		i--
+	}
+	q.queue = slices.Insert(q.queue, i, &clientSampleQueueEntry{
+		sample: sample,
+		dts:    dts,
+	})
+	q.pending[sample.Track]++
+
+	q.notify()
+
+	q.mutex.Unlock()
+
+	return nil
+}
+
+func (q *clientSampleQueue) close(err error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.err = err
+	q.notify()
+}
+
+// headReady returns whether the first sample can be delivered.
+func (q *clientSampleQueue) headReady() bool {
+	if len(q.queue) == 0 {
+		return false
+	}
+
+	// the stream has ended
+	if q.err != nil {
+		return true
+	}
+
+	for _, track := range q.tracks {
+		// a producer is blocked, deliver samples in order to let it proceed
+		if q.pending[track] >= clientSampleQueueMaxTrackSamples {
+			return true
+		}
+	}
+
+	for _, track := range q.tracks {
+		if q.pending[track] == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (q *clientSampleQueue) pull(ctx context.Context) (*ClientSample, error) {
+	q.mutex.Lock()
+
+	for !q.headReady() {
+		if len(q.queue) == 0 && q.err != nil {
+			err := q.err
+			q.mutex.Unlock()
+			return nil, err
+		}
+
+		changed := q.changedChan()
+		q.mutex.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil, context.Cause(ctx)
+		}
+
+		q.mutex.Lock()
+	}
+
+	var entry *clientSampleQueueEntry
+	entry, q.queue = q.queue[0], q.queue[1:]
+	q.pending[entry.sample.Track]--
+
+	q.notify()
+
+	q.mutex.Unlock()
+
+	return entry.sample, nil
+}
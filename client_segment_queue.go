@@ -2,6 +2,7 @@ package gohlslib
 
 import (
 	"context"
+	"slices"
 	"sync"
 	"time"
 )
@@ -9,6 +10,7 @@ import (
 type segmentData struct {
 	dateTime *time.Time
 	payload  []byte
+	initFile []byte
 	err      error
 }
 
@@ -38,6 +40,33 @@ func (q *clientSegmentQueue) push(seg *segmentData) {
 	q.mutex.Unlock()
 }
 
+// mediaSize returns the number of queued segments or parts.
+func (q *clientSegmentQueue) mediaSize() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	n := 0
+	for _, seg := range q.queue {
+		if seg.payload != nil {
+			n++
+		}
+	}
+	return n
+}
+
+// dropMedia removes queued segments and parts, while keeping initialization segments.
+// It returns the number of removed entries.
+func (q *clientSegmentQueue) dropMedia() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	n := len(q.queue)
+	q.queue = slices.DeleteFunc(q.queue, func(seg *segmentData) bool {
+		return seg.payload != nil
+	})
+	return n - len(q.queue)
+}
+
 func (q *clientSegmentQueue) waitUntilSizeIsBelow(ctx context.Context, n int) bool {
 	q.mutex.Lock()
 
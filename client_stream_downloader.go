@@ -4,11 +4,14 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"net/url"
+	"slices"
 	"strconv"
 	"time"
 
+	"github.com/bluenviron/gohlslib/v2/pkg/codecparams"
 	"github.com/bluenviron/gohlslib/v2/pkg/playlist"
 )
 
@@ -49,30 +52,96 @@ func dateTimeOfPreloadHint(pl *playlist.Media) *time.Time {
 	return &d
 }
 
+func partOfPreloadHint(pl *playlist.Media, preloadHint *playlist.MediaPreloadHint) *playlist.MediaPart {
+	parts := pl.Parts
+	if len(pl.Segments) != 0 {
+		parts = append(slices.Clone(pl.Segments[len(pl.Segments)-1].Parts), parts...)
+	}
+
+	for _, part := range parts {
+		if part.URI == preloadHint.URI &&
+			(part.ByteRangeStart == nil || *part.ByteRangeStart == preloadHint.ByteRangeStart) {
+			return part
+		}
+	}
+	return nil
+}
+
+// maxQueuedParts returns the number of parts that corresponds to the given distance from the end of the playlist.
+func maxQueuedParts(pl *playlist.Media, maxDistance int) int {
+	if pl.PartInf == nil || pl.PartInf.PartTarget == 0 {
+		return maxDistance
+	}
+	return int(time.Duration(maxDistance*pl.TargetDuration) * time.Second / pl.PartInf.PartTarget)
+}
+
+// lastPartsDuration returns the duration of the last n parts of the playlist.
+func lastPartsDuration(pl *playlist.Media, n int) time.Duration {
+	var parts []*playlist.MediaPart
+	for _, seg := range pl.Segments {
+		parts = append(parts, seg.Parts...)
+	}
+	parts = append(parts, pl.Parts...)
+
+	var ret time.Duration
+	for _, part := range parts[max(len(parts)-n, 0):] {
+		ret += part.Duration
+	}
+	return ret
+}
+
+func mapOfPreloadHint(pl *playlist.Media) *playlist.MediaMap {
+	if len(pl.Segments) != 0 {
+		lastSeg := pl.Segments[len(pl.Segments)-1]
+		if lastSeg.Map != nil {
+			return lastSeg.Map
+		}
+	}
+	return pl.Map
+}
+
+// isPackedAudio returns whether a segment is a packed audio segment,
+// that starts with an ID3 tag containing the timestamp of the first sample.
+func isPackedAudio(payload []byte) bool {
+	_, _, err := packedAudioUnmarshalHeader(payload)
+	return err == nil
+}
+
 type clientStreamDownloaderClient interface {
-	setLeadingTimeConv(ts clientTimeConv)
+	updateTracks(prevTracks []*clientTrack, tracks []*Track) ([]*clientTrack, error)
+	setLeadingTimeConv(ts *clientTimeConv)
 	waitLeadingTimeConv(ctx context.Context) bool
-	getLeadingTimeConv() clientTimeConv
+	getLeadingTimeConv() *clientTimeConv
+	skipPacing(streamSkipped time.Duration)
 }
 
 type clientStreamDownloader struct {
 	isLeading                bool
 	startDistance            int
 	maxDistance              int
+	catchUp                  bool
+	catchUpDistance          int
 	httpClient               *http.Client
 	onRequest                ClientOnRequestFunc
 	onDownloadStreamPlaylist ClientOnDownloadStreamPlaylistFunc
 	onDownloadSegment        ClientOnDownloadSegmentFunc
 	onDownloadPart           ClientOnDownloadPartFunc
 	onDecodeError            ClientOnDecodeErrorFunc
+	onCatchUp                ClientOnCatchUpFunc
+	logger                   *slog.Logger
 	playlistURL              *url.URL
 	rendition                *playlist.MultivariantRendition
 	firstPlaylist            *playlist.Media
 	rp                       *clientRoutinePool
 	client                   clientStreamDownloaderClient
 
-	segmentQueue *clientSegmentQueue
-	curSegmentID *int
+	segmentQueue     *clientSegmentQueue
+	curSegmentID     *int
+	curMap           *playlist.MediaMap
+	processorStarted bool
+	catchingUp       bool
+	skipped          time.Duration
+	totalSkipped     time.Duration
 
 	// out
 	chTracks         chan []*Track
@@ -83,6 +152,17 @@ type clientStreamDownloader struct {
 }
 
 func (d *clientStreamDownloader) initialize() {
+	if d.onDecodeError == nil {
+		d.onDecodeError = func(err error) {
+			d.logger.Warn("decode error", "error", err)
+		}
+	}
+	if d.onCatchUp == nil {
+		d.onCatchUp = func(skipped time.Duration) {
+			d.logger.Warn("skipping content to catch up with the live edge", "skipped", skipped)
+		}
+	}
+
 	d.chTracks = make(chan []*Track)
 	d.chProcessorError = make(chan error)
 	d.chStartStreaming = make(chan map[*Track]*clientTrack)
@@ -100,6 +180,10 @@ func (d *clientStreamDownloader) run(ctx context.Context) error {
 	d.segmentQueue = &clientSegmentQueue{}
 	d.segmentQueue.initialize()
 
+	d.curMap = d.firstPlaylist.Map
+
+	// the format of streams without initialization segments
+	// is detected from the first segment.
 	if d.firstPlaylist.Map != nil && d.firstPlaylist.Map.URI != "" {
 		initFile, err := d.downloadSegment(
 			ctx,
@@ -113,6 +197,7 @@ func (d *clientStreamDownloader) run(ctx context.Context) error {
 		proc := &clientStreamProcessorFMP4{
 			ctx:              ctx,
 			isLeading:        d.isLeading,
+			iframesOnly:      d.firstPlaylist.IFramesOnly,
 			rendition:        d.rendition,
 			initFile:         initFile,
 			segmentQueue:     d.segmentQueue,
@@ -122,17 +207,7 @@ func (d *clientStreamDownloader) run(ctx context.Context) error {
 		}
 		proc.initialize()
 		d.rp.add(proc)
-	} else {
-		proc := &clientStreamProcessorMPEGTS{
-			onDecodeError:    d.onDecodeError,
-			isLeading:        d.isLeading,
-			segmentQueue:     d.segmentQueue,
-			rp:               d.rp,
-			streamDownloader: d,
-			client:           d.client,
-		}
-		proc.initialize()
-		d.rp.add(proc)
+		d.processorStarted = true
 	}
 
 	var err error
@@ -145,33 +220,100 @@ func (d *clientStreamDownloader) run(ctx context.Context) error {
 		err = d.runTraditional(ctx)
 	}
 
+	if !d.processorStarted {
+		return err
+	}
+
 	d.segmentQueue.push(&segmentData{
 		err: err,
 	})
 	<-ctx.Done()
 
-	return fmt.Errorf("terminated")
+	return context.Cause(ctx)
+}
+
+// pushMedia pushes a segment or part into the queue.
+// When the stream processor has not been started yet, it is started
+// with a format that depends on the content of the segment.
+func (d *clientStreamDownloader) pushMedia(seg *segmentData) {
+	if !d.processorStarted {
+		d.processorStarted = true
+
+		if isPackedAudio(seg.payload) {
+			proc := &clientStreamProcessorPackedAudio{
+				isLeading:        d.isLeading,
+				rendition:        d.rendition,
+				segmentQueue:     d.segmentQueue,
+				streamDownloader: d,
+				client:           d.client,
+			}
+			proc.initialize()
+			d.rp.add(proc)
+		} else {
+			proc := &clientStreamProcessorMPEGTS{
+				onDecodeError:    d.onDecodeError,
+				isLeading:        d.isLeading,
+				rendition:        d.rendition,
+				segmentQueue:     d.segmentQueue,
+				rp:               d.rp,
+				streamDownloader: d,
+				client:           d.client,
+			}
+			proc.initialize()
+			d.rp.add(proc)
+		}
+	}
+
+	d.segmentQueue.push(seg)
 }
 
 func (d *clientStreamDownloader) runLowLatency(ctx context.Context) error {
 	pl := d.firstPlaylist
 
 	for {
-		byts, err := d.downloadPreloadHint(ctx, pl.PreloadHint)
+		err := d.handleMap(ctx, mapOfPreloadHint(pl))
 		if err != nil {
 			return err
 		}
 
-		d.segmentQueue.push(&segmentData{
+		preloadHint := pl.PreloadHint
+
+		var byts []byte
+		byts, err = d.downloadPreloadHint(ctx, preloadHint, pl.MediaSequence+len(pl.Segments))
+		if err != nil {
+			return err
+		}
+
+		seg := &segmentData{
 			dateTime: dateTimeOfPreloadHint(pl),
 			payload:  byts,
-		})
+		}
+
+		if !d.catchingUp {
+			d.pushMedia(seg)
+		}
 
 		pl, err = d.downloadPlaylist(ctx, d.firstPlaylist.ServerControl.CanSkipUntil != nil)
 		if err != nil {
 			return err
 		}
 
+		if d.catchingUp {
+			// resume from the first independent part
+			part := partOfPreloadHint(pl, preloadHint)
+			if part != nil && part.Independent {
+				d.catchingUp = false
+				d.pushMedia(seg)
+				d.caughtUp(d.skipped)
+			} else if part != nil {
+				d.skipped += part.Duration
+			}
+		} else if d.catchUp && d.segmentQueue.mediaSize() > maxQueuedParts(pl, d.catchUpDistance) {
+			dropped := d.segmentQueue.dropMedia()
+			d.catchingUp = true
+			d.skipped = lastPartsDuration(pl, dropped)
+		}
+
 		if pl.PreloadHint == nil {
 			return fmt.Errorf("preload hint disappeared")
 		}
@@ -187,14 +329,24 @@ func (d *clientStreamDownloader) runTraditional(ctx context.Context) error {
 			return err
 		}
 
-		d.segmentQueue.push(&segmentData{
+		segMap := seg.Map
+		if segMap == nil {
+			segMap = pl.Map
+		}
+
+		err = d.handleMap(ctx, segMap)
+		if err != nil {
+			return err
+		}
+
+		d.pushMedia(&segmentData{
 			dateTime: seg.DateTime,
 			payload:  payload,
 		})
 
 		ok := d.segmentQueue.waitUntilSizeIsBelow(ctx, 1)
 		if !ok {
-			return fmt.Errorf("terminated")
+			return context.Cause(ctx)
 		}
 
 		pl, err = d.downloadPlaylist(ctx, false)
@@ -204,6 +356,25 @@ func (d *clientStreamDownloader) runTraditional(ctx context.Context) error {
 	}
 }
 
+func (d *clientStreamDownloader) handleMap(ctx context.Context, m *playlist.MediaMap) error {
+	if m == nil || m.Equal(d.curMap) {
+		return nil
+	}
+
+	initFile, err := d.downloadSegment(ctx, m.URI, m.ByteRangeStart, m.ByteRangeLength)
+	if err != nil {
+		return err
+	}
+
+	d.curMap = m
+
+	d.segmentQueue.push(&segmentData{
+		initFile: initFile,
+	})
+
+	return nil
+}
+
 func (d *clientStreamDownloader) downloadPlaylist(
 	ctx context.Context,
 	skipUntil bool,
@@ -218,7 +389,7 @@ func (d *clientStreamDownloader) downloadPlaylist(
 		ur = newUR
 	}
 
-	d.onDownloadStreamPlaylist(ur.String())
+	d.logDownload(d.onDownloadStreamPlaylist, "downloading stream playlist", ur)
 
 	pl, err := downloadPlaylist(ctx, d.httpClient, d.onRequest, ur)
 	if err != nil {
@@ -236,13 +407,14 @@ func (d *clientStreamDownloader) downloadPlaylist(
 func (d *clientStreamDownloader) downloadPreloadHint(
 	ctx context.Context,
 	preloadHint *playlist.MediaPreloadHint,
+	mediaSequence int,
 ) ([]byte, error) {
 	u, err := clientAbsoluteURL(d.playlistURL, preloadHint.URI)
 	if err != nil {
 		return nil, err
 	}
 
-	d.onDownloadPart(u.String())
+	d.logDownload(d.onDownloadPart, "downloading part", u, "media_sequence", mediaSequence)
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
 	if err != nil {
@@ -263,7 +435,7 @@ func (d *clientStreamDownloader) downloadPreloadHint(
 	defer res.Body.Close()
 
 	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusPartialContent {
-		return nil, fmt.Errorf("bad status code: %d", res.StatusCode)
+		return nil, &HTTPStatusError{URL: u.String(), StatusCode: res.StatusCode}
 	}
 
 	byts, err := io.ReadAll(res.Body)
@@ -279,13 +451,14 @@ func (d *clientStreamDownloader) downloadSegment(
 	uri string,
 	start *uint64,
 	length *uint64,
+	logArgs ...any,
 ) ([]byte, error) {
 	u, err := clientAbsoluteURL(d.playlistURL, uri)
 	if err != nil {
 		return nil, err
 	}
 
-	d.onDownloadSegment(u.String())
+	d.logDownload(d.onDownloadSegment, "downloading segment", u, logArgs...)
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
 	if err != nil {
@@ -309,7 +482,7 @@ func (d *clientStreamDownloader) downloadSegment(
 	defer res.Body.Close()
 
 	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusPartialContent {
-		return nil, fmt.Errorf("bad status code: %d", res.StatusCode)
+		return nil, &HTTPStatusError{URL: u.String(), StatusCode: res.StatusCode}
 	}
 
 	byts, err := io.ReadAll(res.Body)
@@ -352,14 +525,28 @@ func (d *clientStreamDownloader) downloadNextSegment(
 			return nil, nil, fmt.Errorf("next segment not found or not ready yet")
 		}
 
-		if !pl.Endlist && invPos > d.maxDistance {
-			return nil, nil, fmt.Errorf("playback is too late")
+		if !pl.Endlist {
+			if d.catchUp && invPos > d.catchUpDistance {
+				// skip forward to StartDistance
+				if skipSeg, skipSegPos := findSegmentWithInvPosition(pl.Segments, d.startDistance); skipSeg != nil {
+					var skipped time.Duration
+					for _, s := range pl.Segments[segPos:skipSegPos] {
+						skipped += s.Duration
+					}
+
+					seg, segPos = skipSeg, skipSegPos
+					d.caughtUp(skipped)
+				}
+			} else if invPos > d.maxDistance {
+				return nil, nil, ErrClientPlaybackTooLate
+			}
 		}
 	}
 
 	d.curSegmentID = ptrOf(pl.MediaSequence + segPos)
 
-	byts, err := d.downloadSegment(ctx, seg.URI, seg.ByteRangeStart, seg.ByteRangeLength)
+	byts, err := d.downloadSegment(ctx, seg.URI, seg.ByteRangeStart, seg.ByteRangeLength,
+		"media_sequence", *d.curSegmentID)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -367,7 +554,32 @@ func (d *clientStreamDownloader) downloadNextSegment(
 	return seg, byts, nil
 }
 
+// caughtUp is called after content has been skipped.
+func (d *clientStreamDownloader) caughtUp(skipped time.Duration) {
+	// skipped content must not delay delivery of next samples.
+	d.totalSkipped += skipped
+	d.client.skipPacing(d.totalSkipped)
+
+	d.onCatchUp(skipped)
+}
+
+// logDownload calls a download callback,
+// or logs the download when the callback is not set.
+// Downloads are logged with the debug level since they happen continuously.
+func (d *clientStreamDownloader) logDownload(cb func(string), msg string, u *url.URL, args ...any) {
+	if cb != nil {
+		cb(u.String())
+		return
+	}
+
+	d.logger.Debug(msg, append([]any{"uri", u.String()}, args...)...)
+}
+
 func (d *clientStreamDownloader) setTracks(ctx context.Context, tracks []*Track) ([]*clientTrack, bool) {
+	for i, track := range tracks {
+		d.logger.Debug("track available", "track", i+1, "codec", codecparams.Marshal(track.Codec))
+	}
+
 	select {
 	case d.chTracks <- tracks:
 	case <-ctx.Done():
@@ -3,7 +3,8 @@ package gohlslib
 import (
 	"bytes"
 	"context"
-	"fmt"
+	"reflect"
+	"slices"
 
 	"github.com/bluenviron/mediacommon/v2/pkg/formats/fmp4"
 
@@ -43,13 +44,10 @@ func findTimeScaleOfLeadingTrack(tracks []*fmp4.InitTrack, leadingTrackID int) u
 	return 0
 }
 
-func leadingTimeConvFMP4(client clientStreamDownloaderClient) *clientTimeConvFMP4 {
-	return client.getLeadingTimeConv().(*clientTimeConvFMP4)
-}
-
 type clientStreamProcessorFMP4 struct {
 	ctx              context.Context
 	isLeading        bool
+	iframesOnly      bool
 	rendition        *playlist.MultivariantRendition
 	initFile         []byte
 	segmentQueue     *clientSegmentQueue
@@ -61,6 +59,9 @@ type clientStreamProcessorFMP4 struct {
 	leadingTrackID     int
 	trackProcessors    map[int]*clientTrackProcessorFMP4
 	clientStreamTracks []*clientTrack
+	rebaseTimeConv     bool
+	leadingEndDTS      int64
+	leadingEndScale    int
 
 	// in
 	chPartTrackProcessed chan struct{}
@@ -76,69 +77,120 @@ func (p *clientStreamProcessorFMP4) run(ctx context.Context) error {
 		return err
 	}
 
-	if !p.isLeading && len(p.init.Tracks) != 1 {
-		return fmt.Errorf("rendition playlists with multiple tracks are not supported")
-	}
-
 	p.leadingTrackID = fmp4PickLeadingTrack(&p.init)
 
 	tracks := make([]*Track, len(p.init.Tracks))
 
 	for i, track := range p.init.Tracks {
-		tracks[i] = &Track{
-			Codec:     codecs.FromFMP4(track.Codec),
-			ClockRate: int(track.TimeScale),
-			Name: func() string {
-				if !p.isLeading {
-					return p.rendition.Name
-				}
-				return ""
-			}(),
-			Language: func() string {
-				if !p.isLeading {
-					return p.rendition.Language
-				}
-				return ""
-			}(),
-			IsDefault: func() bool {
-				if !p.isLeading {
-					return p.rendition.Default
-				}
-				return false
-			}(),
-		}
+		tracks[i] = p.newTrack(track)
 	}
 
 	if len(tracks) > clientMaxTracksPerStream {
-		return fmt.Errorf("too many tracks per stream")
+		return ErrClientTooManyTracks
 	}
 
 	var ok bool
 	p.clientStreamTracks, ok = p.streamDownloader.setTracks(p.ctx, tracks)
 	if !ok {
-		return fmt.Errorf("terminated")
+		return context.Cause(ctx)
 	}
 
 	for {
 		var seg *segmentData
 		seg, ok = p.segmentQueue.pull(ctx)
 		if !ok {
-			return fmt.Errorf("terminated")
+			return context.Cause(ctx)
 		}
 
 		if seg.err != nil {
 			p.streamDownloader.onProcessorError(ctx, seg.err)
 			<-ctx.Done()
-			return fmt.Errorf("terminated")
+			return context.Cause(ctx)
 		}
 
-		err = p.processSegment(ctx, seg)
+		if seg.initFile != nil {
+			err = p.reinitialize(ctx, seg.initFile)
+		} else {
+			err = p.processSegment(ctx, seg)
+		}
 		if err != nil {
 			return err
 		}
 	}
 }
 
+func (p *clientStreamProcessorFMP4) newTrack(track *fmp4.InitTrack) *Track {
+	return newRenditionTrack(p.rendition, codecs.FromFMP4(track.Codec), int(track.TimeScale))
+}
+
+func (p *clientStreamProcessorFMP4) reinitialize(ctx context.Context, initFile []byte) error {
+	var init fmp4.Init
+	err := init.Unmarshal(bytes.NewReader(initFile))
+	if err != nil {
+		return err
+	}
+
+	if len(init.Tracks) > clientMaxTracksPerStream {
+		return ErrClientTooManyTracks
+	}
+
+	tracks := make([]*Track, len(init.Tracks))
+
+	for i, initTrack := range init.Tracks {
+		track := p.newTrack(initTrack)
+
+		// keep tracks whose parameters are unchanged.
+		// Tracks are never modified in place since they are shared with the user,
+		// changed tracks are replaced by new ones.
+		if prevIndex := slices.IndexFunc(p.init.Tracks, func(t *fmp4.InitTrack) bool {
+			return t.ID == initTrack.ID
+		}); prevIndex >= 0 {
+			prevTrack := p.clientStreamTracks[prevIndex].track
+			if reflect.DeepEqual(prevTrack, track) {
+				track = prevTrack
+			}
+		}
+
+		tracks[i] = track
+	}
+
+	// wait for track processors to process all pending data
+	// before replacing them.
+	if p.trackProcessors != nil {
+		for _, trackProc := range p.trackProcessors {
+			trackProc.close()
+		}
+
+		for _, trackProc := range p.trackProcessors {
+			trackProc.wait()
+		}
+	}
+
+	p.clientStreamTracks, err = p.client.updateTracks(p.clientStreamTracks, tracks)
+	if err != nil {
+		return err
+	}
+
+	p.init = init
+	p.leadingTrackID = fmp4PickLeadingTrack(&p.init)
+
+	if p.trackProcessors != nil {
+		// timestamps of the leading track may now be expressed with a different timescale
+		// or may restart from an arbitrary value.
+		// Rebuild the time converter when the next segment is received.
+		if p.isLeading {
+			p.rebaseTimeConv = true
+		}
+
+		err = p.createTrackProcessors()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func (p *clientStreamProcessorFMP4) processSegment(ctx context.Context, seg *segmentData) error {
 	var parts fmp4.Parts
 	err := parts.Unmarshal(seg.payload)
@@ -148,7 +200,7 @@ func (p *clientStreamProcessorFMP4) processSegment(ctx context.Context, seg *seg
 
 	leadingPartTrack := findFirstPartTrackOfLeadingTrack(parts, p.leadingTrackID)
 	if leadingPartTrack == nil {
-		return fmt.Errorf("could not find data of leading track")
+		return ErrClientLeadingTrackNotFound
 	}
 
 	if p.trackProcessors == nil {
@@ -158,15 +210,29 @@ func (p *clientStreamProcessorFMP4) processSegment(ctx context.Context, seg *seg
 		}
 	}
 
+	if p.rebaseTimeConv {
+		p.rebaseTimeConv = false
+
+		// keep timestamps continuous, by placing the first sample
+		// after the last sample received with the previous timescale.
+		timeScale := int(findTimeScaleOfLeadingTrack(p.init.Tracks, p.leadingTrackID))
+		endDTS := multiplyAndDivide(p.leadingEndDTS, int64(timeScale), int64(p.leadingEndScale))
+		p.client.getLeadingTimeConv().rebase(int64(leadingPartTrack.BaseTime)-endDTS, timeScale)
+	}
+
 	if p.isLeading {
 		if seg.dateTime != nil {
 			leadingPartTrackProc := p.trackProcessors[leadingPartTrack.ID]
-			dts := leadingTimeConvFMP4(p.client).
-				convert(int64(leadingPartTrack.BaseTime), leadingPartTrackProc.track.track.ClockRate)
-			leadingTimeConvFMP4(p.client).
+			var dts int64
+			dts, err = p.client.getLeadingTimeConv().
+				convertFMP4(int64(leadingPartTrack.BaseTime), leadingPartTrackProc.track.track.ClockRate)
+			if err != nil {
+				return err
+			}
+			p.client.getLeadingTimeConv().
 				setNTP(*seg.dateTime, dts, leadingPartTrackProc.track.track.ClockRate)
 		}
-		leadingTimeConvFMP4(p.client).setLeadingNTPReceived()
+		p.client.getLeadingTimeConv().setLeadingNTPReceived()
 	}
 
 	partTrackCount := 0
@@ -178,8 +244,13 @@ func (p *clientStreamProcessorFMP4) processSegment(ctx context.Context, seg *seg
 				continue
 			}
 
-			dts := leadingTimeConvFMP4(p.client).convert(int64(partTrack.BaseTime), trackProc.track.track.ClockRate)
-			ntp := leadingTimeConvFMP4(p.client).getNTP(ctx, dts, trackProc.track.track.ClockRate)
+			var dts int64
+			dts, err = p.client.getLeadingTimeConv().convertFMP4(int64(partTrack.BaseTime), trackProc.track.track.ClockRate)
+			if err != nil {
+				return err
+			}
+
+			ntp := p.client.getLeadingTimeConv().getNTP(ctx, dts, trackProc.track.track.ClockRate)
 
 			err = trackProc.push(ctx, &procEntryFMP4{
 				partTrack: partTrack,
@@ -190,6 +261,14 @@ func (p *clientStreamProcessorFMP4) processSegment(ctx context.Context, seg *seg
 				return err
 			}
 
+			if p.isLeading && partTrack.ID == p.leadingTrackID {
+				p.leadingEndDTS = dts
+				p.leadingEndScale = trackProc.track.track.ClockRate
+				for _, sample := range partTrack.Samples {
+					p.leadingEndDTS += int64(sample.Duration)
+				}
+			}
+
 			partTrackCount++
 		}
 	}
@@ -223,9 +302,9 @@ func (p *clientStreamProcessorFMP4) initializeTrackProcessors(
 	if p.isLeading {
 		timeScale := findTimeScaleOfLeadingTrack(p.init.Tracks, p.leadingTrackID)
 
-		timeConv := &clientTimeConvFMP4{
-			leadingTimeScale: int64(timeScale),
-			leadingBaseTime:  int64(partTrack.BaseTime),
+		timeConv := &clientTimeConv{
+			startDTS:       int64(partTrack.BaseTime),
+			startClockRate: int(timeScale),
 		}
 		timeConv.initialize()
 
@@ -233,20 +312,20 @@ func (p *clientStreamProcessorFMP4) initializeTrackProcessors(
 	} else {
 		ok := p.client.waitLeadingTimeConv(ctx)
 		if !ok {
-			return fmt.Errorf("terminated")
-		}
-
-		_, ok = p.client.getLeadingTimeConv().(*clientTimeConvFMP4)
-		if !ok {
-			return fmt.Errorf("stream playlists are mixed MPEG-TS/fMP4")
+			return context.Cause(ctx)
 		}
 	}
 
+	return p.createTrackProcessors()
+}
+
+func (p *clientStreamProcessorFMP4) createTrackProcessors() error {
 	p.trackProcessors = make(map[int]*clientTrackProcessorFMP4)
 
 	for i, track := range p.clientStreamTracks {
 		trackProc := &clientTrackProcessorFMP4{
 			track:           track,
+			iframesOnly:     p.iframesOnly,
 			streamProcessor: p,
 		}
 		err := trackProc.initialize()
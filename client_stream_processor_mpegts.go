@@ -11,12 +11,14 @@ import (
 	tscodecs "github.com/bluenviron/mediacommon/v2/pkg/formats/mpegts/codecs"
 
 	"github.com/bluenviron/gohlslib/v2/pkg/codecs"
+	"github.com/bluenviron/gohlslib/v2/pkg/playlist"
 )
 
 func mpegtsPickLeadingTrack(mpegtsTracks []*mpegts.Track) int {
 	// pick first video track
 	for i, track := range mpegtsTracks {
-		if _, ok := track.Codec.(*tscodecs.H264); ok {
+		switch track.Codec.(type) {
+		case *tscodecs.H265, *tscodecs.H264:
 			return i
 		}
 	}
@@ -25,10 +27,6 @@ func mpegtsPickLeadingTrack(mpegtsTracks []*mpegts.Track) int {
 	return 0
 }
 
-func leadingTimeConvMPEGTS(client clientStreamDownloaderClient) *clientTimeConvMPEGTS {
-	return client.getLeadingTimeConv().(*clientTimeConvMPEGTS)
-}
-
 type switchableReader struct {
 	r io.Reader
 }
@@ -45,6 +43,7 @@ type clientStreamProcessorStreamDownloader interface {
 type clientStreamProcessorMPEGTS struct {
 	onDecodeError    ClientOnDecodeErrorFunc
 	isLeading        bool
+	rendition        *playlist.MultivariantRendition
 	segmentQueue     *clientSegmentQueue
 	rp               *clientRoutinePool
 	streamDownloader clientStreamProcessorStreamDownloader
@@ -69,13 +68,17 @@ func (p *clientStreamProcessorMPEGTS) run(ctx context.Context) error {
 	for {
 		seg, ok := p.segmentQueue.pull(ctx)
 		if !ok {
-			return fmt.Errorf("terminated")
+			return context.Cause(ctx)
 		}
 
 		if seg.err != nil {
 			p.streamDownloader.onProcessorError(ctx, seg.err)
 			<-ctx.Done()
-			return fmt.Errorf("terminated")
+			return context.Cause(ctx)
+		}
+
+		if seg.initFile != nil {
+			return ErrClientMixedFormats
 		}
 
 		err := p.processSegment(ctx, seg)
@@ -110,7 +113,7 @@ func (p *clientStreamProcessorMPEGTS) processSegment(ctx context.Context, seg *s
 	}
 
 	if !p.leadingTrackFound {
-		return fmt.Errorf("could not find data of leading track")
+		return ErrClientLeadingTrackNotFound
 	}
 
 	return p.joinTrackProcessors(ctx)
@@ -159,13 +162,14 @@ func (p *clientStreamProcessorMPEGTS) initializeReader(ctx context.Context, firs
 
 	for _, track := range p.reader.Tracks() {
 		switch track.Codec.(type) {
-		case *tscodecs.H264, *tscodecs.MPEG4Audio:
+		case *tscodecs.H265, *tscodecs.H264, *tscodecs.Opus,
+			*tscodecs.MPEG4Audio, *tscodecs.MPEG1Audio, *tscodecs.AC3:
 			supportedTracks = append(supportedTracks, track)
 		}
 	}
 
 	if len(supportedTracks) == 0 {
-		return fmt.Errorf("no supported tracks found")
+		return fmt.Errorf("no supported tracks found: %w", ErrUnsupportedCodec)
 	}
 
 	leadingTrackID := mpegtsPickLeadingTrack(supportedTracks)
@@ -173,20 +177,17 @@ func (p *clientStreamProcessorMPEGTS) initializeReader(ctx context.Context, firs
 	tracks := make([]*Track, len(supportedTracks))
 
 	for i, mpegtsTrack := range supportedTracks {
-		tracks[i] = &Track{
-			Codec:     codecs.FromMPEGTS(mpegtsTrack.Codec),
-			ClockRate: 90000,
-		}
+		tracks[i] = newRenditionTrack(p.rendition, codecs.FromMPEGTS(mpegtsTrack.Codec), 90000)
 	}
 
 	if len(tracks) > clientMaxTracksPerStream {
-		return fmt.Errorf("too many tracks per stream")
+		return ErrClientTooManyTracks
 	}
 
 	var ok bool
 	p.clientStreamTracks, ok = p.streamDownloader.setTracks(ctx, tracks)
 	if !ok {
-		return fmt.Errorf("terminated")
+		return context.Cause(ctx)
 	}
 
 	for i, mpegtsTrack := range supportedTracks {
@@ -215,19 +216,26 @@ func (p *clientStreamProcessorMPEGTS) initializeReader(ctx context.Context, firs
 				}
 			}
 
-			pts := leadingTimeConvMPEGTS(p.client).convert(rawPTS)
-			dts := leadingTimeConvMPEGTS(p.client).convert(rawDTS)
+			pts, err2 := p.client.getLeadingTimeConv().convertMPEGTS(rawPTS)
+			if err2 != nil {
+				return err2
+			}
+
+			dts, err2 := p.client.getLeadingTimeConv().convertMPEGTS(rawDTS)
+			if err2 != nil {
+				return err2
+			}
 
 			if !p.dateTimeProcessed && p.isLeading && isLeadingTrack {
 				p.dateTimeProcessed = true
 
 				if p.curSegment.dateTime != nil {
-					leadingTimeConvMPEGTS(p.client).setNTP(*p.curSegment.dateTime, dts)
+					p.client.getLeadingTimeConv().setNTP(*p.curSegment.dateTime, dts, 90000)
 				}
-				leadingTimeConvMPEGTS(p.client).setLeadingNTPReceived()
+				p.client.getLeadingTimeConv().setLeadingNTPReceived()
 			}
 
-			ntp := leadingTimeConvMPEGTS(p.client).getNTP(ctx, dts)
+			ntp := p.client.getLeadingTimeConv().getNTP(ctx, dts, 90000)
 
 			return trackProc.push(ctx, &procEntryMPEGTS{
 				pts:  pts,
@@ -238,15 +246,35 @@ func (p *clientStreamProcessorMPEGTS) initializeReader(ctx context.Context, firs
 		}
 
 		switch track.track.Codec.(type) {
+		case *codecs.H265:
+			p.reader.OnDataH265(mpegtsTrack, func(pts int64, dts int64, au [][]byte) error {
+				return processSample(pts, dts, au)
+			})
+
 		case *codecs.H264:
 			p.reader.OnDataH264(mpegtsTrack, func(pts int64, dts int64, au [][]byte) error {
 				return processSample(pts, dts, au)
 			})
 
+		case *codecs.Opus:
+			p.reader.OnDataOpus(mpegtsTrack, func(pts int64, packets [][]byte) error {
+				return processSample(pts, pts, packets)
+			})
+
 		case *codecs.MPEG4Audio:
 			p.reader.OnDataMPEG4Audio(mpegtsTrack, func(pts int64, aus [][]byte) error {
 				return processSample(pts, pts, aus)
 			})
+
+		case *codecs.MPEG1Audio:
+			p.reader.OnDataMPEG1Audio(mpegtsTrack, func(pts int64, frames [][]byte) error {
+				return processSample(pts, pts, frames)
+			})
+
+		case *codecs.AC3:
+			p.reader.OnDataAC3(mpegtsTrack, func(pts int64, frame []byte) error {
+				return processSample(pts, pts, [][]byte{frame})
+			})
 		}
 	}
 
@@ -258,8 +286,10 @@ func (p *clientStreamProcessorMPEGTS) initializeTrackProcessors(
 	dts int64,
 ) error {
 	if p.isLeading {
-		timeConv := &clientTimeConvMPEGTS{
-			startDTS: dts,
+		timeConv := &clientTimeConv{
+			startDTS:       dts,
+			startClockRate: 90000,
+			startIsMPEGTS:  true,
 		}
 		timeConv.initialize()
 
@@ -267,12 +297,7 @@ func (p *clientStreamProcessorMPEGTS) initializeTrackProcessors(
 	} else {
 		ok := p.client.waitLeadingTimeConv(ctx)
 		if !ok {
-			return fmt.Errorf("terminated")
-		}
-
-		_, ok = p.client.getLeadingTimeConv().(*clientTimeConvMPEGTS)
-		if !ok {
-			return fmt.Errorf("stream playlists are mixed MPEGTS/FMP4")
+			return context.Cause(ctx)
 		}
 	}
 
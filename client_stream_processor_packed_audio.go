@@ -0,0 +1,226 @@
+package gohlslib
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/bluenviron/mediacommon/v2/pkg/codecs/mpeg1audio"
+	"github.com/bluenviron/mediacommon/v2/pkg/codecs/mpeg4audio"
+
+	"github.com/bluenviron/gohlslib/v2/pkg/codecs"
+	"github.com/bluenviron/gohlslib/v2/pkg/playlist"
+)
+
+func packedAudioIsADTS(buf []byte) bool {
+	// ADTS and MPEG-1 audio share the same sync word, but ADTS has layer set to zero.
+	return len(buf) >= 2 && buf[0] == 0xFF && (buf[1]&0xF6) == 0xF0
+}
+
+func packedAudioNewCodec(payload []byte) (codecs.Codec, error) {
+	if packedAudioIsADTS(payload) {
+		var pkts mpeg4audio.ADTSPackets
+		err := pkts.Unmarshal(payload)
+		if err != nil {
+			return nil, err
+		}
+
+		return &codecs.MPEG4Audio{
+			Config: mpeg4audio.AudioSpecificConfig{
+				Type:          pkts[0].Type,
+				SampleRate:    pkts[0].SampleRate,
+				ChannelConfig: pkts[0].ChannelConfig,
+				ChannelCount:  pkts[0].ChannelCount,
+			},
+		}, nil
+	}
+
+	var h mpeg1audio.FrameHeader
+	err := h.Unmarshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported packed audio format: %w", err)
+	}
+
+	channelCount := 2
+	if h.ChannelMode == mpeg1audio.ChannelModeMono {
+		channelCount = 1
+	}
+
+	return &codecs.MPEG1Audio{
+		SampleRate:   h.SampleRate,
+		ChannelCount: channelCount,
+	}, nil
+}
+
+// clientStreamProcessorPackedAudio processes packed audio segments,
+// that are made of an ID3 tag followed by ADTS or MPEG-1 audio frames.
+type clientStreamProcessorPackedAudio struct {
+	isLeading        bool
+	rendition        *playlist.MultivariantRendition
+	segmentQueue     *clientSegmentQueue
+	streamDownloader clientStreamProcessorStreamDownloader
+	client           clientStreamDownloaderClient
+
+	track         *clientTrack
+	timeConvReady bool
+}
+
+func (p *clientStreamProcessorPackedAudio) initialize() {
+}
+
+func (p *clientStreamProcessorPackedAudio) run(ctx context.Context) error {
+	for {
+		seg, ok := p.segmentQueue.pull(ctx)
+		if !ok {
+			return context.Cause(ctx)
+		}
+
+		if seg.err != nil {
+			p.streamDownloader.onProcessorError(ctx, seg.err)
+			<-ctx.Done()
+			return context.Cause(ctx)
+		}
+
+		if seg.initFile != nil {
+			return ErrClientMixedFormats
+		}
+
+		err := p.processSegment(ctx, seg)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (p *clientStreamProcessorPackedAudio) processSegment(ctx context.Context, seg *segmentData) error {
+	rawDTS, n, err := packedAudioUnmarshalHeader(seg.payload)
+	if err != nil {
+		return err
+	}
+
+	payload := seg.payload[n:]
+	if len(payload) == 0 {
+		return nil
+	}
+
+	if p.track == nil {
+		err = p.initializeTrack(ctx, payload)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !p.timeConvReady {
+		p.timeConvReady = true
+
+		if p.isLeading {
+			timeConv := &clientTimeConv{
+				startDTS:       rawDTS,
+				startClockRate: 90000,
+				startIsMPEGTS:  true,
+			}
+			timeConv.initialize()
+
+			p.client.setLeadingTimeConv(timeConv)
+		} else {
+			ok := p.client.waitLeadingTimeConv(ctx)
+			if !ok {
+				return context.Cause(ctx)
+			}
+		}
+	}
+
+	timeConv := p.client.getLeadingTimeConv()
+	dts, err := timeConv.convertMPEGTS(rawDTS)
+	if err != nil {
+		return err
+	}
+
+	if p.isLeading {
+		if seg.dateTime != nil {
+			timeConv.setNTP(*seg.dateTime, dts, 90000)
+		}
+		timeConv.setLeadingNTPReceived()
+	}
+
+	ntp := timeConv.getNTP(ctx, dts, 90000)
+
+	switch codec := p.track.track.Codec.(type) {
+	case *codecs.MPEG4Audio:
+		var pkts mpeg4audio.ADTSPackets
+		err = pkts.Unmarshal(payload)
+		if err != nil {
+			return err
+		}
+
+		for i, pkt := range pkts {
+			pts := dts + int64(i)*mpeg4audio.SamplesPerAccessUnit*90000/int64(codec.Config.SampleRate)
+
+			err = p.handleFrame(ctx, pts, dts, ntp, pkt.AU)
+			if err != nil {
+				return err
+			}
+		}
+
+	case *codecs.MPEG1Audio:
+		sampleCount := int64(0)
+
+		for len(payload) != 0 {
+			var h mpeg1audio.FrameHeader
+			err = h.Unmarshal(payload)
+			if err != nil {
+				return err
+			}
+
+			frameLen := h.FrameLen()
+			if frameLen > len(payload) {
+				return fmt.Errorf("MPEG-1 audio frame is truncated")
+			}
+
+			pts := dts + sampleCount*90000/int64(codec.SampleRate)
+
+			err = p.handleFrame(ctx, pts, dts, ntp, payload[:frameLen])
+			if err != nil {
+				return err
+			}
+
+			sampleCount += int64(h.SampleCount())
+			payload = payload[frameLen:]
+		}
+	}
+
+	return nil
+}
+
+func (p *clientStreamProcessorPackedAudio) handleFrame(
+	ctx context.Context,
+	pts int64,
+	segmentDTS int64,
+	segmentNTP *time.Time,
+	frame []byte,
+) error {
+	var ntp *time.Time
+	if segmentNTP != nil {
+		ntp = ptrOf(segmentNTP.Add(timestampToDuration(pts-segmentDTS, 90000)))
+	}
+
+	return p.track.handleData(ctx, pts, pts, ntp, [][]byte{frame})
+}
+
+func (p *clientStreamProcessorPackedAudio) initializeTrack(ctx context.Context, payload []byte) error {
+	codec, err := packedAudioNewCodec(payload)
+	if err != nil {
+		return err
+	}
+
+	clientStreamTracks, ok := p.streamDownloader.setTracks(ctx, []*Track{
+		newRenditionTrack(p.rendition, codec, 90000),
+	})
+	if !ok {
+		return context.Cause(ctx)
+	}
+
+	p.track = clientStreamTracks[0]
+
+	return nil
+}
@@ -0,0 +1,84 @@
+package gohlslib
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// maximum jump of absolute timestamps of a client
+// before the reference of the group is reset.
+const clientSyncGroupMaxJump = 5 * time.Second
+
+// ClientSyncGroup synchronizes the delivery of samples of multiple Clients.
+// Samples are paced against a wall-clock reference that is shared by all clients
+// of the group and is derived from absolute timestamps (EXT-X-PROGRAM-DATE-TIME),
+// so that samples with the same absolute timestamp are delivered at the same time.
+// Samples without an absolute timestamp are paced by their client.
+// When the absolute timestamps of a client jump and are not consistent with
+// the reference anymore (i.e. the clock of the source has been reset), the reference is reset.
+type ClientSyncGroup struct {
+	// Delay between the reception of the first sample of the group and its delivery.
+	// It allows clients that start later or that have a greater latency
+	// to be aligned with the others.
+	// It defaults to 2 seconds. Set a negative value to deliver samples without delay.
+	Delay time.Duration
+
+	mutex     sync.Mutex
+	refNTP    time.Time
+	refSystem time.Time
+}
+
+func (g *ClientSyncGroup) delay() time.Duration {
+	switch {
+	case g.Delay == 0:
+		return 2 * time.Second
+	case g.Delay < 0:
+		return 0
+	}
+	return g.Delay
+}
+
+// deliveryTime returns the system time at which a sample with the given absolute timestamp must be delivered.
+// prevNTP is the absolute timestamp of the previous sample of the same track, if available.
+func (g *ClientSyncGroup) deliveryTime(prevNTP *time.Time, ntp time.Time) time.Time {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	now := time.Now()
+
+	switch {
+	case g.refSystem.IsZero():
+		g.refSystem = now
+		g.refNTP = ntp
+
+	// absolute timestamps of the track jumped and are far from the reference: reset the reference.
+	// Other clients that are fed by the same source follow the jump without resetting it again.
+	case prevNTP != nil &&
+		ntp.Sub(*prevNTP).Abs() > clientSyncGroupMaxJump &&
+		g.refSystem.Add(ntp.Sub(g.refNTP)).Sub(now).Abs() > clientSyncGroupMaxJump:
+		g.refSystem = now
+		g.refNTP = ntp
+	}
+
+	return g.refSystem.Add(g.delay() + ntp.Sub(g.refNTP))
+}
+
+func (g *ClientSyncGroup) wait(ctx context.Context, prevNTP *time.Time, ntp time.Time) error {
+	diff := time.Until(g.deliveryTime(prevNTP, ntp))
+	if diff <= 0 {
+		return nil
+	}
+
+	if diff > (g.delay() + clientMaxDTSSystemDiff) {
+		return fmt.Errorf("difference between absolute time and system time is too big")
+	}
+
+	select {
+	case <-time.After(diff):
+		return nil
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	}
+}
@@ -4,17 +4,26 @@ import (
 	"bytes"
 	"context"
 	"crypto/tls"
+	"errors"
+	"fmt"
 	"io"
+	"log/slog"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/asticode/go-astits"
+	"github.com/bluenviron/gohlslib/v2/pkg/codecparams"
 	"github.com/bluenviron/gohlslib/v2/pkg/codecs"
+	"github.com/bluenviron/gohlslib/v2/pkg/playlist"
 	"github.com/bluenviron/mediacommon/v2/pkg/codecs/h264"
+	"github.com/bluenviron/mediacommon/v2/pkg/codecs/h265"
 	"github.com/bluenviron/mediacommon/v2/pkg/codecs/mpeg4audio"
 	"github.com/stretchr/testify/require"
 
@@ -1404,7 +1413,6 @@ func TestClientUnsupportedTracks(t *testing.T) {
 func TestClientErrors(t *testing.T) {
 	for _, ca := range []string{
 		"invalid sequence id",
-		"multiple tracks in rendition",
 	} {
 		t.Run(ca, func(t *testing.T) {
 			first := true
@@ -1465,82 +1473,6 @@ func TestClientErrors(t *testing.T) {
 							require.NoError(t, err)
 						}
 
-					case "multiple tracks in rendition":
-						switch {
-						case r.Method == http.MethodGet && r.URL.Path == "/index.m3u8":
-							w.Header().Set("Content-Type", `application/vnd.apple.mpegurl`)
-							w.Write([]byte("#EXTM3U\n" +
-								"#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"aac\",NAME=\"English\"," +
-								"DEFAULT=YES,AUTOSELECT=YES,LANGUAGE=\"en\",URI=\"audio.m3u8\"\n" +
-								"#EXT-X-STREAM-INF:BANDWIDTH=7680000,CODECS=\"avc1.640015,mp4a.40.5\",AUDIO=\"aac\"\n" +
-								"video.m3u8\n"))
-
-						case r.Method == http.MethodGet && r.URL.Path == "/video.m3u8":
-							w.Header().Set("Content-Type", `application/vnd.apple.mpegurl`)
-							w.Write([]byte("#EXTM3U\n" +
-								"#EXT-X-VERSION:7\n" +
-								"#EXT-X-MEDIA-SEQUENCE:20\n" +
-								"#EXT-X-PLAYLIST-TYPE:VOD\n" +
-								"#EXT-X-INDEPENDENT-SEGMENTS\n" +
-								"#EXT-X-TARGETDURATION:2\n" +
-								"#EXT-X-MAP:URI=\"init_video.mp4\"\n" +
-								"#EXT-X-PROGRAM-DATE-TIME:2015-02-05T01:02:02Z\n" +
-								"#EXTINF:2,\n" +
-								"segment_video.mp4\n" +
-								"#EXT-X-ENDLIST\n"))
-
-						case r.Method == http.MethodGet && r.URL.Path == "/audio.m3u8":
-							w.Header().Set("Content-Type", `application/vnd.apple.mpegurl`)
-							w.Write([]byte("#EXTM3U\n" +
-								"#EXT-X-VERSION:7\n" +
-								"#EXT-X-MEDIA-SEQUENCE:20\n" +
-								"#EXT-X-PLAYLIST-TYPE:VOD\n" +
-								"#EXT-X-INDEPENDENT-SEGMENTS\n" +
-								"#EXT-X-TARGETDURATION:2\n" +
-								"#EXT-X-MAP:URI=\"init_audio.mp4\"\n" +
-								"#EXT-X-PROGRAM-DATE-TIME:2014-02-05T01:02:02Z\n" +
-								"#EXTINF:2,\n" +
-								"segment_audio.mp4\n" +
-								"#EXT-X-ENDLIST"))
-
-						case r.Method == http.MethodGet && r.URL.Path == "/init_video.mp4":
-							w.Header().Set("Content-Type", `video/mp4`)
-							err := mp4ToWriter(&fmp4.Init{
-								Tracks: []*fmp4.InitTrack{
-									{
-										ID:        1,
-										TimeScale: 90000,
-										Codec: &mp4codecs.H264{
-											SPS: testSPS,
-											PPS: testPPS,
-										},
-									},
-								},
-							}, w)
-							require.NoError(t, err)
-
-						case r.Method == http.MethodGet && r.URL.Path == "/init_audio.mp4":
-							w.Header().Set("Content-Type", `video/mp4`)
-							err := mp4ToWriter(&fmp4.Init{
-								Tracks: []*fmp4.InitTrack{
-									{
-										ID:        1,
-										TimeScale: 44100,
-										Codec: &mp4codecs.MPEG4Audio{
-											Config: testConfig,
-										},
-									},
-									{
-										ID:        2,
-										TimeScale: 44100,
-										Codec: &mp4codecs.MPEG4Audio{
-											Config: testConfig,
-										},
-									},
-								},
-							}, w)
-							require.NoError(t, err)
-						}
 					}
 				}),
 			}
@@ -1569,8 +1501,6 @@ func TestClientErrors(t *testing.T) {
 			switch ca {
 			case "invalid sequence id":
 				require.EqualError(t, err, "next segment not found or not ready yet")
-			case "multiple tracks in rendition":
-				require.EqualError(t, err, "rendition playlists with multiple tracks are not supported")
 			}
 		})
 	}
@@ -1668,3 +1598,2453 @@ func TestClientVODWithoutPlaylistType(t *testing.T) {
 
 	<-videoRecv
 }
+
+func TestClientRunContext(t *testing.T) {
+	httpServ := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case r.Method == http.MethodGet && r.URL.Path == "/stream.m3u8":
+				w.Header().Set("Content-Type", `application/vnd.apple.mpegurl`)
+				w.Write([]byte("#EXTM3U\n" +
+					"#EXT-X-VERSION:3\n" +
+					"#EXT-X-ALLOW-CACHE:NO\n" +
+					"#EXT-X-TARGETDURATION:2\n" +
+					"#EXT-X-MEDIA-SEQUENCE:0\n" +
+					"#EXTINF:2,\n" +
+					"segment1.ts\n" +
+					"#EXTINF:2,\n" +
+					"segment1.ts\n" +
+					"#EXTINF:2,\n" +
+					"segment1.ts\n"))
+
+			case r.Method == http.MethodGet && r.URL.Path == "/segment1.ts":
+				w.Header().Set("Content-Type", `video/MP2T`)
+
+				h264Track := &mpegts.Track{
+					Codec: &tscodecs.H264{},
+				}
+				mw := &mpegts.Writer{W: w, Tracks: []*mpegts.Track{h264Track}}
+				err := mw.Initialize()
+				require.NoError(t, err)
+
+				err = mw.WriteH264(
+					h264Track,
+					90000,
+					90000,
+					[][]byte{
+						{7, 1, 2, 3}, // SPS
+						{8},          // PPS
+						{5},          // IDR
+					},
+				)
+				require.NoError(t, err)
+			}
+		}),
+	}
+
+	ln, err := net.Listen("tcp", "localhost:5780")
+	require.NoError(t, err)
+
+	go httpServ.Serve(ln)
+	defer httpServ.Shutdown(context.Background())
+
+	tr := &http.Transport{}
+	defer tr.CloseIdleConnections()
+
+	errCustom := errors.New("custom cause")
+
+	ctx, ctxCancel := context.WithCancelCause(context.Background())
+
+	var c *Client
+	c = &Client{
+		URI:        "http://localhost:5780/stream.m3u8",
+		HTTPClient: &http.Client{Transport: tr},
+		OnTracks: func(tracks []*Track) error {
+			c.OnDataH26x(tracks[0], func(_, _ int64, _ [][]byte) {
+				ctxCancel(errCustom)
+			})
+			return nil
+		},
+	}
+
+	err = c.Run(ctx)
+	require.ErrorIs(t, err, errCustom)
+
+	err = c.Wait2()
+	require.ErrorIs(t, err, errCustom)
+}
+
+func TestClientClose(t *testing.T) {
+	httpServ := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+		}),
+	}
+
+	ln, err := net.Listen("tcp", "localhost:5780")
+	require.NoError(t, err)
+
+	go httpServ.Serve(ln)
+	defer httpServ.Shutdown(context.Background())
+
+	tr := &http.Transport{}
+	defer tr.CloseIdleConnections()
+
+	c := &Client{
+		URI:        "http://localhost:5780/stream.m3u8",
+		HTTPClient: &http.Client{Transport: tr},
+		OnDownloadPrimaryPlaylist: func(_ string) {
+			time.Sleep(100 * time.Millisecond)
+		},
+	}
+
+	err = c.Start()
+	require.NoError(t, err)
+
+	c.Close()
+
+	err = c.Wait2()
+	require.ErrorIs(t, err, ErrClientTerminated)
+}
+
+func TestClientLogger(t *testing.T) {
+	httpServ := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case r.Method == http.MethodGet && r.URL.Path == "/index.m3u8":
+				w.Header().Set("Content-Type", `application/vnd.apple.mpegurl`)
+				w.Write([]byte("#EXTM3U\n" +
+					"#EXT-X-VERSION:7\n" +
+					"#EXT-X-TARGETDURATION:2\n" +
+					"#EXT-X-MEDIA-SEQUENCE:3\n" +
+					"#EXT-X-PLAYLIST-TYPE:VOD\n" +
+					"#EXT-X-MAP:URI=\"init.mp4\"\n" +
+					"#EXTINF:1,\n" +
+					"segment1.mp4\n" +
+					"#EXT-X-ENDLIST\n"))
+
+			case r.Method == http.MethodGet && r.URL.Path == "/init.mp4":
+				w.Header().Set("Content-Type", `video/mp4`)
+				err := mp4ToWriter(&fmp4.Init{
+					Tracks: []*fmp4.InitTrack{
+						{
+							ID:        1,
+							TimeScale: 90000,
+							Codec: &mp4codecs.H264{
+								SPS: testSPS,
+								PPS: testPPS,
+							},
+						},
+					},
+				}, w)
+				require.NoError(t, err)
+
+			case r.Method == http.MethodGet && r.URL.Path == "/segment1.mp4":
+				w.Header().Set("Content-Type", `video/mp4`)
+				err := mp4ToWriter(&fmp4.Part{
+					Tracks: []*fmp4.PartTrack{
+						{
+							ID: 1,
+							Samples: []*fmp4.Sample{{
+								Duration: 90000,
+								Payload: mustMarshalAVCC([][]byte{
+									{5}, // IDR
+								}),
+							}},
+						},
+					},
+				}, w)
+				require.NoError(t, err)
+			}
+		}),
+	}
+
+	ln, err := net.Listen("tcp", "localhost:5780")
+	require.NoError(t, err)
+
+	go httpServ.Serve(ln)
+	defer httpServ.Shutdown(context.Background())
+
+	tr := &http.Transport{}
+	defer tr.CloseIdleConnections()
+
+	var buf bytes.Buffer
+
+	c := &Client{
+		URI:        "http://localhost:5780/index.m3u8",
+		HTTPClient: &http.Client{Transport: tr},
+		Logger: slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{
+			Level: slog.LevelDebug,
+			ReplaceAttr: func(_ []string, a slog.Attr) slog.Attr {
+				if a.Key == slog.TimeKey {
+					return slog.Attr{}
+				}
+				return a
+			},
+		})),
+	}
+
+	err = c.Start()
+	require.NoError(t, err)
+	defer c.Close()
+
+	err = c.Wait2()
+	require.Equal(t, ErrClientEOS, err)
+
+	require.Contains(t, buf.String(),
+		`{"level":"INFO","msg":"downloading primary playlist","uri":"http://localhost:5780/index.m3u8"}`)
+	require.Contains(t, buf.String(),
+		`{"level":"DEBUG","msg":"downloading segment","stream":"main","uri":"http://localhost:5780/init.mp4"}`)
+	require.Contains(t, buf.String(),
+		`{"level":"DEBUG","msg":"track available","stream":"main","track":1,"codec":"avc1.`)
+	require.Contains(t, buf.String(),
+		`{"level":"DEBUG","msg":"downloading segment","stream":"main","uri":"http://localhost:5780/segment1.mp4",`+
+			`"media_sequence":3}`)
+}
+
+func TestClientReadSample(t *testing.T) {
+	httpServ := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case r.Method == http.MethodGet && r.URL.Path == "/index.m3u8":
+				w.Header().Set("Content-Type", `application/vnd.apple.mpegurl`)
+				w.Write([]byte("#EXTM3U\n" +
+					"#EXT-X-VERSION:3\n" +
+					"#EXT-X-ALLOW-CACHE:NO\n" +
+					"#EXT-X-TARGETDURATION:2\n" +
+					"#EXT-X-MEDIA-SEQUENCE:0\n" +
+					"#EXT-X-PLAYLIST-TYPE:VOD\n" +
+					"#EXT-X-PROGRAM-DATE-TIME:2015-02-05T01:02:02Z\n" +
+					"#EXTINF:1,\n" +
+					"segment1.ts\n" +
+					"#EXT-X-ENDLIST\n"))
+
+			case r.Method == http.MethodGet && r.URL.Path == "/segment1.ts":
+				w.Header().Set("Content-Type", `video/MP2T`)
+
+				h264Track := &mpegts.Track{
+					Codec: &tscodecs.H264{},
+				}
+				mpeg4audioTrack := &mpegts.Track{
+					Codec: &tscodecs.MPEG4Audio{
+						Config: testConfig,
+					},
+				}
+				mw := &mpegts.Writer{W: w, Tracks: []*mpegts.Track{h264Track, mpeg4audioTrack}}
+				err := mw.Initialize()
+				require.NoError(t, err)
+
+				err = mw.WriteH264(h264Track, 90000, 90000, [][]byte{
+					{7, 1, 2, 3}, // SPS
+					{8},          // PPS
+					{5},          // IDR
+				})
+				require.NoError(t, err)
+
+				err = mw.WriteH264(h264Track, 90000+3000, 90000+3000, [][]byte{{1, 4, 5, 6}})
+				require.NoError(t, err)
+
+				err = mw.WriteMPEG4Audio(mpeg4audioTrack, 90000+1500, [][]byte{{1, 2, 3, 4}})
+				require.NoError(t, err)
+
+				err = mw.WriteMPEG4Audio(mpeg4audioTrack, 90000+4500, [][]byte{{5, 6, 7, 8}})
+				require.NoError(t, err)
+			}
+		}),
+	}
+
+	ln, err := net.Listen("tcp", "localhost:5780")
+	require.NoError(t, err)
+
+	go httpServ.Serve(ln)
+	defer httpServ.Shutdown(context.Background())
+
+	tr := &http.Transport{}
+	defer tr.CloseIdleConnections()
+
+	c := &Client{
+		URI:         "http://localhost:5780/index.m3u8",
+		HTTPClient:  &http.Client{Transport: tr},
+		PullSamples: true,
+	}
+
+	err = c.Start()
+	require.NoError(t, err)
+	defer c.Close()
+
+	var samples []*ClientSample
+
+	for {
+		var sample *ClientSample
+		sample, err = c.ReadSample(context.Background())
+		if err != nil {
+			break
+		}
+		samples = append(samples, sample)
+	}
+
+	require.Equal(t, ErrClientEOS, err)
+	require.Len(t, samples, 4)
+
+	for i, ca := range []struct {
+		codec   codecs.Codec
+		dts     int64
+		ntp     time.Time
+		payload [][]byte
+	}{
+		{
+			&codecs.H264{},
+			0,
+			time.Date(2015, time.February, 5, 1, 2, 2, 0, time.UTC),
+			[][]byte{{7, 1, 2, 3}, {8}, {5}},
+		},
+		{
+			&codecs.MPEG4Audio{Config: testConfig},
+			1500,
+			time.Date(2015, time.February, 5, 1, 2, 2, 16666666, time.UTC),
+			[][]byte{{1, 2, 3, 4}},
+		},
+		{
+			&codecs.H264{},
+			3000,
+			time.Date(2015, time.February, 5, 1, 2, 2, 33333333, time.UTC),
+			[][]byte{{1, 4, 5, 6}},
+		},
+		{
+			&codecs.MPEG4Audio{Config: testConfig},
+			4500,
+			time.Date(2015, time.February, 5, 1, 2, 2, 50000000, time.UTC),
+			[][]byte{{5, 6, 7, 8}},
+		},
+	} {
+		require.Equal(t, ca.codec, samples[i].Track.Codec)
+		require.Equal(t, ca.dts, samples[i].DTS)
+		require.Equal(t, ca.dts, samples[i].PTS)
+		require.Equal(t, ca.ntp, *samples[i].NTP)
+		require.Equal(t, ca.payload, samples[i].Payload)
+	}
+}
+
+func TestClientSampleQueue(t *testing.T) {
+	track1 := &Track{Codec: &codecs.H264{}, ClockRate: 90000}
+	track2 := &Track{Codec: &codecs.MPEG4Audio{}, ClockRate: 90000}
+
+	var q clientSampleQueue
+	q.setTracks([]*Track{track1, track2})
+
+	push := func(track *Track, dts time.Duration) {
+		err := q.push(context.Background(), &ClientSample{Track: track, DTS: int64(dts)}, dts)
+		require.NoError(t, err)
+	}
+
+	pullTimeout := func() (*ClientSample, error) {
+		ctx, ctxCancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer ctxCancel()
+		return q.pull(ctx)
+	}
+
+	// samples of the first track are not delivered until the second track provides a sample
+	push(track1, 2)
+	push(track1, 4)
+	_, err := pullTimeout()
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	push(track2, 3)
+	push(track2, 1)
+
+	var dtss []int64
+	for range 3 {
+		var sample *ClientSample
+		sample, err = pullTimeout()
+		require.NoError(t, err)
+		dtss = append(dtss, sample.DTS)
+	}
+	require.Equal(t, []int64{1, 2, 3}, dtss)
+
+	// the second track has no samples left
+	_, err = pullTimeout()
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// a canceled push does not leave samples in the queue
+	for i := range clientSampleQueueMaxTrackSamples - 1 {
+		push(track1, time.Duration(5+i))
+	}
+	ctx, ctxCancel := context.WithCancel(context.Background())
+	ctxCancel()
+	err = q.push(ctx, &ClientSample{Track: track1, DTS: 1000}, 1000)
+	require.ErrorIs(t, err, context.Canceled)
+
+	// remaining samples are delivered when the stream ends
+	errEnd := errors.New("end")
+	q.close(errEnd)
+
+	n := 0
+	for {
+		_, err = pullTimeout()
+		if err != nil {
+			break
+		}
+		n++
+	}
+	require.ErrorIs(t, err, errEnd)
+	require.Equal(t, clientSampleQueueMaxTrackSamples, n)
+}
+
+func TestClientInitChange(t *testing.T) {
+	for _, ca := range []string{
+		"timescale change",
+		"timestamp reset",
+	} {
+		t.Run(ca, func(t *testing.T) {
+			videoTimeScale := 1000
+			if ca == "timestamp reset" {
+				videoTimeScale = 90000
+			}
+
+			httpServ := &http.Server{
+				Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					switch {
+					case r.Method == http.MethodGet && r.URL.Path == "/index.m3u8":
+						w.Header().Set("Content-Type", `application/vnd.apple.mpegurl`)
+						w.Write([]byte("#EXTM3U\n" +
+							"#EXT-X-VERSION:7\n" +
+							"#EXT-X-TARGETDURATION:2\n" +
+							"#EXT-X-MEDIA-SEQUENCE:0\n" +
+							"#EXT-X-PLAYLIST-TYPE:VOD\n" +
+							"#EXT-X-MAP:URI=\"init1.mp4\"\n" +
+							"#EXTINF:1,\n" +
+							"segment1.mp4\n" +
+							"#EXT-X-DISCONTINUITY\n" +
+							"#EXT-X-MAP:URI=\"init2.mp4\"\n" +
+							"#EXTINF:1,\n" +
+							"segment2.mp4\n" +
+							"#EXT-X-ENDLIST\n"))
+
+					case r.Method == http.MethodGet && r.URL.Path == "/init1.mp4":
+						w.Header().Set("Content-Type", `video/mp4`)
+						err := mp4ToWriter(&fmp4.Init{
+							Tracks: []*fmp4.InitTrack{
+								{
+									ID:        1,
+									TimeScale: 90000,
+									Codec: &mp4codecs.H264{
+										SPS: testSPS,
+										PPS: testPPS,
+									},
+								},
+							},
+						}, w)
+						require.NoError(t, err)
+
+					case r.Method == http.MethodGet && r.URL.Path == "/init2.mp4":
+						w.Header().Set("Content-Type", `video/mp4`)
+						err := mp4ToWriter(&fmp4.Init{
+							Tracks: []*fmp4.InitTrack{
+								{
+									ID:        1,
+									TimeScale: uint32(videoTimeScale),
+									Codec: &mp4codecs.H264{
+										SPS: testSPS,
+										PPS: []byte{8, 1},
+									},
+								},
+								{
+									ID:        2,
+									TimeScale: 44100,
+									Codec: &mp4codecs.MPEG4Audio{
+										Config: testConfig,
+									},
+								},
+							},
+						}, w)
+						require.NoError(t, err)
+
+					case r.Method == http.MethodGet && r.URL.Path == "/segment1.mp4":
+						w.Header().Set("Content-Type", `video/mp4`)
+						err := mp4ToWriter(&fmp4.Part{
+							Tracks: []*fmp4.PartTrack{
+								{
+									ID: 1,
+									Samples: []*fmp4.Sample{{
+										Duration: 90000,
+										Payload: mustMarshalAVCC([][]byte{
+											{5}, // IDR
+										}),
+									}},
+								},
+							},
+						}, w)
+						require.NoError(t, err)
+
+					case r.Method == http.MethodGet && r.URL.Path == "/segment2.mp4":
+						w.Header().Set("Content-Type", `video/mp4`)
+						err := mp4ToWriter(&fmp4.Part{
+							Tracks: []*fmp4.PartTrack{
+								{
+									ID: 1,
+									// timestamps restart
+									Samples: []*fmp4.Sample{{
+										Duration: uint32(videoTimeScale),
+										Payload: mustMarshalAVCC([][]byte{
+											{5}, // IDR
+										}),
+									}},
+								},
+								{
+									ID: 2,
+									Samples: []*fmp4.Sample{{
+										Duration: 1024,
+										Payload:  []byte{1, 2, 3, 4},
+									}},
+								},
+							},
+						}, w)
+						require.NoError(t, err)
+					}
+				}),
+			}
+
+			ln, err := net.Listen("tcp", "localhost:5780")
+			require.NoError(t, err)
+
+			go httpServ.Serve(ln)
+			defer httpServ.Shutdown(context.Background())
+
+			tr := &http.Transport{}
+			defer tr.CloseIdleConnections()
+
+			var videoTrack *Track
+			var videoDTS []int64
+			var newVideoDTS []int64
+			var audioDTS []int64
+
+			var c *Client
+			c = &Client{
+				URI:        "http://localhost:5780/index.m3u8",
+				HTTPClient: &http.Client{Transport: tr},
+				OnTracks: func(tracks []*Track) error {
+					require.Len(t, tracks, 1)
+					videoTrack = tracks[0]
+
+					c.OnDataH26x(tracks[0], func(_ int64, dts int64, _ [][]byte) {
+						videoDTS = append(videoDTS, dts)
+					})
+
+					return nil
+				},
+				OnTracksChanged: func(tracks []*Track) error {
+					require.Equal(t, []*Track{
+						{
+							Codec: &codecs.H264{
+								SPS: testSPS,
+								PPS: []byte{8, 1},
+							},
+							ClockRate: videoTimeScale,
+						},
+						{
+							Codec:     &codecs.MPEG4Audio{Config: testConfig},
+							ClockRate: 44100,
+						},
+					}, tracks)
+
+					// previous tracks are not modified
+					require.Equal(t, &Track{
+						Codec: &codecs.H264{
+							SPS: testSPS,
+							PPS: testPPS,
+						},
+						ClockRate: 90000,
+					}, videoTrack)
+
+					c.OnDataH26x(tracks[0], func(_ int64, dts int64, _ [][]byte) {
+						newVideoDTS = append(newVideoDTS, dts)
+					})
+
+					c.OnDataMPEG4Audio(tracks[1], func(pts int64, _ [][]byte) {
+						audioDTS = append(audioDTS, pts)
+					})
+
+					return nil
+				},
+			}
+
+			err = c.Start()
+			require.NoError(t, err)
+			defer c.Close()
+
+			err = c.Wait2()
+			require.Equal(t, ErrClientEOS, err)
+
+			require.Equal(t, []int64{0}, videoDTS)
+			require.Equal(t, []int64{int64(videoTimeScale)}, newVideoDTS)
+			require.Equal(t, []int64{44100}, audioDTS)
+		})
+	}
+}
+
+func TestClientIFramesOnly(t *testing.T) {
+	var segment bytes.Buffer
+	err := mp4ToWriter(&fmp4.Part{
+		Tracks: []*fmp4.PartTrack{{
+			ID: 1,
+			Samples: []*fmp4.Sample{
+				{
+					Duration: 90000,
+					Payload: mustMarshalAVCC([][]byte{
+						{5}, // IDR
+					}),
+				},
+				{
+					Duration:        90000,
+					IsNonSyncSample: true,
+					Payload: mustMarshalAVCC([][]byte{
+						{1}, // non-IDR
+					}),
+				},
+			},
+		}},
+	}, &segment)
+	require.NoError(t, err)
+
+	httpServ := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case r.Method == http.MethodGet && r.URL.Path == "/index.m3u8":
+				w.Header().Set("Content-Type", `application/vnd.apple.mpegurl`)
+				w.Write([]byte("#EXTM3U\n" +
+					"#EXT-X-VERSION:7\n" +
+					"#EXT-X-STREAM-INF:BANDWIDTH=200000,CODECS=\"avc1.42c028\"\n" +
+					"stream.m3u8\n" +
+					"#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=10000,CODECS=\"avc1.42c028\",URI=\"iframes.m3u8\"\n"))
+
+			case r.Method == http.MethodGet && r.URL.Path == "/iframes.m3u8":
+				w.Header().Set("Content-Type", `application/vnd.apple.mpegurl`)
+				w.Write([]byte("#EXTM3U\n" +
+					"#EXT-X-VERSION:7\n" +
+					"#EXT-X-TARGETDURATION:2\n" +
+					"#EXT-X-MEDIA-SEQUENCE:0\n" +
+					"#EXT-X-PLAYLIST-TYPE:VOD\n" +
+					"#EXT-X-I-FRAMES-ONLY\n" +
+					"#EXT-X-MAP:URI=\"init.mp4\"\n" +
+					"#EXTINF:2,\n" +
+					"#EXT-X-BYTERANGE:" + strconv.FormatInt(int64(segment.Len()), 10) + "@0\n" +
+					"segment1.mp4\n" +
+					"#EXT-X-ENDLIST\n"))
+
+			case r.Method == http.MethodGet && r.URL.Path == "/init.mp4":
+				w.Header().Set("Content-Type", `video/mp4`)
+				err2 := mp4ToWriter(&fmp4.Init{
+					Tracks: []*fmp4.InitTrack{{
+						ID:        1,
+						TimeScale: 90000,
+						Codec: &mp4codecs.H264{
+							SPS: testSPS,
+							PPS: testPPS,
+						},
+					}},
+				}, w)
+				require.NoError(t, err2)
+
+			case r.Method == http.MethodGet && r.URL.Path == "/segment1.mp4":
+				require.Equal(t, "bytes=0-"+strconv.FormatInt(int64(segment.Len()-1), 10), r.Header.Get("Range"))
+				w.Header().Set("Content-Type", `video/mp4`)
+				w.WriteHeader(http.StatusPartialContent)
+				w.Write(segment.Bytes())
+			}
+		}),
+	}
+
+	ln, err := net.Listen("tcp", "localhost:5780")
+	require.NoError(t, err)
+
+	go httpServ.Serve(ln)
+	defer httpServ.Shutdown(context.Background())
+
+	tr := &http.Transport{}
+	defer tr.CloseIdleConnections()
+
+	var aus [][][]byte
+
+	var c *Client
+	c = &Client{
+		URI:         "http://localhost:5780/index.m3u8",
+		HTTPClient:  &http.Client{Transport: tr},
+		IFramesOnly: true,
+		OnTracks: func(tracks []*Track) error {
+			require.Len(t, tracks, 1)
+
+			c.OnDataH26x(tracks[0], func(_ int64, _ int64, au [][]byte) {
+				aus = append(aus, au)
+			})
+
+			return nil
+		},
+	}
+
+	err = c.Start()
+	require.NoError(t, err)
+	defer c.Close()
+
+	err = c.Wait2()
+	require.Equal(t, ErrClientEOS, err)
+
+	require.Equal(t, [][][]byte{{{5}}}, aus)
+}
+
+func TestClientReconnect(t *testing.T) {
+	segment2Requested := false
+
+	httpServ := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case r.Method == http.MethodGet && r.URL.Path == "/index.m3u8":
+				w.Header().Set("Content-Type", `application/vnd.apple.mpegurl`)
+				w.Write([]byte("#EXTM3U\n" +
+					"#EXT-X-VERSION:7\n" +
+					"#EXT-X-TARGETDURATION:2\n" +
+					"#EXT-X-MEDIA-SEQUENCE:0\n" +
+					"#EXT-X-PLAYLIST-TYPE:VOD\n" +
+					"#EXT-X-MAP:URI=\"init.mp4\"\n" +
+					"#EXTINF:1,\n" +
+					"segment1.mp4\n" +
+					"#EXTINF:1,\n" +
+					"segment2.mp4\n" +
+					"#EXT-X-ENDLIST\n"))
+
+			case r.Method == http.MethodGet && r.URL.Path == "/init.mp4":
+				w.Header().Set("Content-Type", `video/mp4`)
+				err := mp4ToWriter(&fmp4.Init{
+					Tracks: []*fmp4.InitTrack{
+						{
+							ID:        1,
+							TimeScale: 90000,
+							Codec: &mp4codecs.H264{
+								SPS: testSPS,
+								PPS: testPPS,
+							},
+						},
+					},
+				}, w)
+				require.NoError(t, err)
+
+			case r.Method == http.MethodGet && r.URL.Path == "/segment1.mp4":
+				w.Header().Set("Content-Type", `video/mp4`)
+				err := mp4ToWriter(&fmp4.Part{
+					Tracks: []*fmp4.PartTrack{
+						{
+							ID: 1,
+							Samples: []*fmp4.Sample{{
+								Duration: 90000,
+								Payload: mustMarshalAVCC([][]byte{
+									{5}, // IDR
+								}),
+							}},
+						},
+					},
+				}, w)
+				require.NoError(t, err)
+
+			case r.Method == http.MethodGet && r.URL.Path == "/segment2.mp4":
+				// simulate a failure of the origin
+				if !segment2Requested {
+					segment2Requested = true
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+
+				w.Header().Set("Content-Type", `video/mp4`)
+				err := mp4ToWriter(&fmp4.Part{
+					Tracks: []*fmp4.PartTrack{
+						{
+							ID:       1,
+							BaseTime: 90000,
+							Samples: []*fmp4.Sample{{
+								Duration: 90000,
+								Payload: mustMarshalAVCC([][]byte{
+									{5}, // IDR
+								}),
+							}},
+						},
+					},
+				}, w)
+				require.NoError(t, err)
+			}
+		}),
+	}
+
+	ln, err := net.Listen("tcp", "localhost:5780")
+	require.NoError(t, err)
+
+	go httpServ.Serve(ln)
+	defer httpServ.Shutdown(context.Background())
+
+	tr := &http.Transport{}
+	defer tr.CloseIdleConnections()
+
+	onTracksCount := 0
+	var reconnectErrors []error
+	var dtss []int64
+
+	var c *Client
+	c = &Client{
+		URI:               "http://localhost:5780/index.m3u8",
+		HTTPClient:        &http.Client{Transport: tr},
+		Reconnect:         true,
+		ReconnectMinDelay: 100 * time.Millisecond,
+		OnTracks: func(tracks []*Track) error {
+			onTracksCount++
+			require.Len(t, tracks, 1)
+
+			c.OnDataH26x(tracks[0], func(_ int64, dts int64, _ [][]byte) {
+				dtss = append(dtss, dts)
+			})
+
+			return nil
+		},
+		OnTracksChanged: func(_ []*Track) error {
+			t.Errorf("should not happen")
+			return nil
+		},
+		OnReconnect: func(err error) {
+			reconnectErrors = append(reconnectErrors, err)
+		},
+	}
+
+	err = c.Start()
+	require.NoError(t, err)
+	defer c.Close()
+
+	err = c.Wait2()
+	require.Equal(t, ErrClientEOS, err)
+
+	require.Equal(t, 1, onTracksCount)
+	require.Len(t, reconnectErrors, 1)
+	require.EqualError(t, reconnectErrors[0], "bad status code: 503")
+
+	var statusErr *HTTPStatusError
+	require.ErrorAs(t, reconnectErrors[0], &statusErr)
+	require.Equal(t, &HTTPStatusError{
+		URL:        "http://localhost:5780/segment2.mp4",
+		StatusCode: http.StatusServiceUnavailable,
+	}, statusErr)
+
+	// timestamps of the second session are shifted forward
+	require.Len(t, dtss, 3)
+	require.Equal(t, int64(0), dtss[0])
+	require.Greater(t, dtss[1], int64(90000*100/1000))
+	require.Equal(t, int64(90000), dtss[2]-dtss[1])
+}
+
+func TestClientReconnectIncreasingDTS(t *testing.T) {
+	segment2Requested := false
+
+	httpServ := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case r.Method == http.MethodGet && r.URL.Path == "/index.m3u8":
+				w.Header().Set("Content-Type", `application/vnd.apple.mpegurl`)
+				w.Write([]byte("#EXTM3U\n" +
+					"#EXT-X-VERSION:7\n" +
+					"#EXT-X-TARGETDURATION:4\n" +
+					"#EXT-X-MEDIA-SEQUENCE:0\n" +
+					"#EXT-X-PLAYLIST-TYPE:VOD\n" +
+					"#EXT-X-MAP:URI=\"init.mp4\"\n" +
+					"#EXT-X-PROGRAM-DATE-TIME:2015-02-05T01:02:02Z\n" +
+					"#EXTINF:4,\n" +
+					"segment1.mp4\n" +
+					"#EXTINF:1,\n" +
+					"segment2.mp4\n" +
+					"#EXT-X-ENDLIST\n"))
+
+			case r.Method == http.MethodGet && r.URL.Path == "/init.mp4":
+				w.Header().Set("Content-Type", `video/mp4`)
+				err := mp4ToWriter(&fmp4.Init{
+					Tracks: []*fmp4.InitTrack{
+						{
+							ID:        1,
+							TimeScale: 90000,
+							Codec: &mp4codecs.H264{
+								SPS: testSPS,
+								PPS: testPPS,
+							},
+						},
+					},
+				}, w)
+				require.NoError(t, err)
+
+			case r.Method == http.MethodGet && r.URL.Path == "/segment1.mp4":
+				w.Header().Set("Content-Type", `video/mp4`)
+				samples := make([]*fmp4.Sample, 4)
+				for i := range samples {
+					samples[i] = &fmp4.Sample{
+						Duration: 90000,
+						Payload: mustMarshalAVCC([][]byte{
+							{5}, // IDR
+						}),
+					}
+				}
+				err := mp4ToWriter(&fmp4.Part{
+					Tracks: []*fmp4.PartTrack{
+						{
+							ID:      1,
+							Samples: samples,
+						},
+					},
+				}, w)
+				require.NoError(t, err)
+
+			case r.Method == http.MethodGet && r.URL.Path == "/segment2.mp4":
+				if !segment2Requested {
+					segment2Requested = true
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+
+				w.Header().Set("Content-Type", `video/mp4`)
+				err := mp4ToWriter(&fmp4.Part{
+					Tracks: []*fmp4.PartTrack{
+						{
+							ID:       1,
+							BaseTime: 4 * 90000,
+							Samples: []*fmp4.Sample{{
+								Duration: 90000,
+								Payload: mustMarshalAVCC([][]byte{
+									{5}, // IDR
+								}),
+							}},
+						},
+					},
+				}, w)
+				require.NoError(t, err)
+			}
+		}),
+	}
+
+	ln, err := net.Listen("tcp", "localhost:5780")
+	require.NoError(t, err)
+
+	go httpServ.Serve(ln)
+	defer httpServ.Shutdown(context.Background())
+
+	tr := &http.Transport{}
+	defer tr.CloseIdleConnections()
+
+	// anchor the group to a later absolute time,
+	// in order to deliver samples immediately, ahead of the pacing of the client.
+	group := &ClientSyncGroup{
+		Delay: time.Millisecond,
+	}
+	group.deliveryTime(nil, time.Date(2015, 2, 5, 1, 2, 7, 0, time.UTC))
+
+	var dtss []int64
+
+	var c *Client
+	c = &Client{
+		URI:               "http://localhost:5780/index.m3u8",
+		HTTPClient:        &http.Client{Transport: tr},
+		Reconnect:         true,
+		ReconnectMinDelay: 100 * time.Millisecond,
+		SyncGroup:         group,
+		OnTracks: func(tracks []*Track) error {
+			c.OnDataH26x(tracks[0], func(_ int64, dts int64, _ [][]byte) {
+				dtss = append(dtss, dts)
+			})
+			return nil
+		},
+	}
+
+	err = c.Start()
+	require.NoError(t, err)
+	defer c.Close()
+
+	err = c.Wait2()
+	require.Equal(t, ErrClientEOS, err)
+
+	require.Len(t, dtss, 9)
+
+	for i := 1; i < len(dtss); i++ {
+		require.Greater(t, dtss[i], dtss[i-1])
+	}
+
+	// the second session starts after the end of the last sample of the first session
+	require.GreaterOrEqual(t, dtss[4], int64(4*90000))
+}
+
+func TestClientReconnectPermanentError(t *testing.T) {
+	for _, ca := range []string{
+		"client error",
+		"callback error",
+	} {
+		t.Run(ca, func(t *testing.T) {
+			httpServ := &http.Server{
+				Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					switch {
+					case r.Method == http.MethodGet && r.URL.Path == "/index.m3u8":
+						if ca == "client error" {
+							w.WriteHeader(http.StatusNotFound)
+							return
+						}
+
+						w.Header().Set("Content-Type", `application/vnd.apple.mpegurl`)
+						w.Write([]byte("#EXTM3U\n" +
+							"#EXT-X-VERSION:7\n" +
+							"#EXT-X-TARGETDURATION:2\n" +
+							"#EXT-X-MEDIA-SEQUENCE:0\n" +
+							"#EXT-X-PLAYLIST-TYPE:VOD\n" +
+							"#EXT-X-MAP:URI=\"init.mp4\"\n" +
+							"#EXTINF:1,\n" +
+							"segment1.mp4\n" +
+							"#EXT-X-ENDLIST\n"))
+
+					case r.Method == http.MethodGet && r.URL.Path == "/init.mp4":
+						w.Header().Set("Content-Type", `video/mp4`)
+						err := mp4ToWriter(&fmp4.Init{
+							Tracks: []*fmp4.InitTrack{
+								{
+									ID:        1,
+									TimeScale: 90000,
+									Codec: &mp4codecs.H264{
+										SPS: testSPS,
+										PPS: testPPS,
+									},
+								},
+							},
+						}, w)
+						require.NoError(t, err)
+					}
+				}),
+			}
+
+			ln, err := net.Listen("tcp", "localhost:5780")
+			require.NoError(t, err)
+
+			go httpServ.Serve(ln)
+			defer httpServ.Shutdown(context.Background())
+
+			tr := &http.Transport{}
+			defer tr.CloseIdleConnections()
+
+			callbackErr := errors.New("callback error")
+
+			c := &Client{
+				URI:               "http://localhost:5780/index.m3u8",
+				HTTPClient:        &http.Client{Transport: tr},
+				Reconnect:         true,
+				ReconnectMinDelay: 100 * time.Millisecond,
+				OnTracks: func(_ []*Track) error {
+					return callbackErr
+				},
+				OnReconnect: func(_ error) {
+					t.Errorf("should not happen")
+				},
+			}
+
+			err = c.Start()
+			require.NoError(t, err)
+			defer c.Close()
+
+			err = c.Wait2()
+
+			if ca == "client error" {
+				var statusErr *HTTPStatusError
+				require.ErrorAs(t, err, &statusErr)
+				require.Equal(t, http.StatusNotFound, statusErr.StatusCode)
+			} else {
+				require.Equal(t, callbackErr, err)
+			}
+		})
+	}
+}
+
+func TestClientRetryableErrors(t *testing.T) {
+	for _, ca := range []struct {
+		name      string
+		err       error
+		retryable bool
+	}{
+		{
+			"network error",
+			fmt.Errorf("wrapped: %w", &net.OpError{Op: "read", Err: errors.New("connection reset")}),
+			true,
+		},
+		{
+			"truncated response",
+			io.ErrUnexpectedEOF,
+			true,
+		},
+		{
+			"server error",
+			&HTTPStatusError{StatusCode: http.StatusBadGateway},
+			true,
+		},
+		{
+			"too many requests",
+			&HTTPStatusError{StatusCode: http.StatusTooManyRequests},
+			true,
+		},
+		{
+			"playback too late",
+			ErrClientPlaybackTooLate,
+			true,
+		},
+		{
+			"client error",
+			&HTTPStatusError{StatusCode: http.StatusNotFound},
+			false,
+		},
+		{
+			"unsupported codec",
+			ErrUnsupportedCodec,
+			false,
+		},
+		{
+			"too many tracks",
+			ErrClientTooManyTracks,
+			false,
+		},
+		{
+			"leading track not found",
+			ErrClientLeadingTrackNotFound,
+			false,
+		},
+		{
+			"generic error",
+			errors.New("invalid playlist"),
+			false,
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			require.Equal(t, ca.retryable, isRetryableError(ca.err))
+		})
+	}
+}
+
+func TestClientSyncGroup(t *testing.T) {
+	httpServ := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case r.Method == http.MethodGet && (r.URL.Path == "/cam1.m3u8" || r.URL.Path == "/cam2.m3u8"):
+				w.Header().Set("Content-Type", `application/vnd.apple.mpegurl`)
+				w.Write([]byte("#EXTM3U\n" +
+					"#EXT-X-VERSION:7\n" +
+					"#EXT-X-TARGETDURATION:2\n" +
+					"#EXT-X-MEDIA-SEQUENCE:0\n" +
+					"#EXT-X-PLAYLIST-TYPE:VOD\n" +
+					"#EXT-X-MAP:URI=\"init.mp4\"\n" +
+					"#EXT-X-PROGRAM-DATE-TIME:2015-02-05T01:02:02Z\n" +
+					"#EXTINF:1,\n" +
+					"segment.mp4\n" +
+					"#EXT-X-ENDLIST\n"))
+
+			case r.Method == http.MethodGet && r.URL.Path == "/init.mp4":
+				w.Header().Set("Content-Type", `video/mp4`)
+				err := mp4ToWriter(&fmp4.Init{
+					Tracks: []*fmp4.InitTrack{
+						{
+							ID:        1,
+							TimeScale: 90000,
+							Codec: &mp4codecs.H264{
+								SPS: testSPS,
+								PPS: testPPS,
+							},
+						},
+					},
+				}, w)
+				require.NoError(t, err)
+
+			case r.Method == http.MethodGet && r.URL.Path == "/segment.mp4":
+				w.Header().Set("Content-Type", `video/mp4`)
+				err := mp4ToWriter(&fmp4.Part{
+					Tracks: []*fmp4.PartTrack{
+						{
+							ID: 1,
+							Samples: []*fmp4.Sample{
+								{
+									Duration: 45000,
+									Payload: mustMarshalAVCC([][]byte{
+										{5}, // IDR
+									}),
+								},
+								{
+									Duration: 45000,
+									Payload: mustMarshalAVCC([][]byte{
+										{1}, // non-IDR
+									}),
+								},
+							},
+						},
+					},
+				}, w)
+				require.NoError(t, err)
+			}
+		}),
+	}
+
+	ln, err := net.Listen("tcp", "localhost:5780")
+	require.NoError(t, err)
+
+	go httpServ.Serve(ln)
+	defer httpServ.Shutdown(context.Background())
+
+	tr := &http.Transport{}
+	defer tr.CloseIdleConnections()
+
+	group := &ClientSyncGroup{
+		Delay: 500 * time.Millisecond,
+	}
+
+	var mutex sync.Mutex
+	deliveries := make(map[string][]time.Time)
+
+	newClient := func(name string) *Client {
+		var c *Client
+		c = &Client{
+			URI:        "http://localhost:5780/" + name + ".m3u8",
+			HTTPClient: &http.Client{Transport: tr},
+			SyncGroup:  group,
+			OnTracks: func(tracks []*Track) error {
+				c.OnDataH26x(tracks[0], func(_ int64, _ int64, _ [][]byte) {
+					mutex.Lock()
+					defer mutex.Unlock()
+					deliveries[name] = append(deliveries[name], time.Now())
+				})
+				return nil
+			},
+		}
+		return c
+	}
+
+	c1 := newClient("cam1")
+	err = c1.Start()
+	require.NoError(t, err)
+	defer c1.Close()
+
+	time.Sleep(200 * time.Millisecond)
+
+	c2 := newClient("cam2")
+	err = c2.Start()
+	require.NoError(t, err)
+	defer c2.Close()
+
+	err = c1.Wait2()
+	require.Equal(t, ErrClientEOS, err)
+
+	err = c2.Wait2()
+	require.Equal(t, ErrClientEOS, err)
+
+	require.Len(t, deliveries["cam1"], 2)
+	require.Len(t, deliveries["cam2"], 2)
+
+	// samples with the same absolute timestamp are delivered at the same time,
+	// even if the second client started later.
+	for i := range 2 {
+		diff := deliveries["cam2"][i].Sub(deliveries["cam1"][i])
+		require.Less(t, diff.Abs(), 100*time.Millisecond)
+	}
+
+	require.Greater(t, deliveries["cam1"][1].Sub(deliveries["cam1"][0]), 400*time.Millisecond)
+}
+
+func TestClientSyncGroupReference(t *testing.T) {
+	group := &ClientSyncGroup{
+		Delay: -1,
+	}
+
+	ntp1 := time.Date(2015, 2, 5, 1, 2, 2, 0, time.UTC)
+	require.WithinDuration(t, time.Now(), group.deliveryTime(nil, ntp1), 50*time.Millisecond)
+
+	// a track that is late by less than the maximum jump does not reset the reference
+	ntp2 := ntp1.Add(-2 * time.Second)
+	require.WithinDuration(t, time.Now().Add(-2*time.Second), group.deliveryTime(nil, ntp2), 50*time.Millisecond)
+
+	// the clock of the source is reset
+	ntp3 := ntp1.Add(-time.Hour)
+	require.WithinDuration(t, time.Now(), group.deliveryTime(&ntp1, ntp3), 50*time.Millisecond)
+
+	// another track of the same source follows the jump without resetting the reference
+	time.Sleep(100 * time.Millisecond)
+	require.WithinDuration(t, time.Now().Add(-100*time.Millisecond), group.deliveryTime(&ntp1, ntp3), 50*time.Millisecond)
+}
+
+func TestClientCatchUp(t *testing.T) {
+	playlistCount := 0
+
+	httpServ := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case r.Method == http.MethodGet && r.URL.Path == "/index.m3u8":
+				playlistCount++
+
+				// simulate a client that is falling behind,
+				// by increasing the number of segments between two requests.
+				segmentCount := 5
+				if playlistCount >= 2 {
+					segmentCount = 15
+				}
+
+				pl := "#EXTM3U\n" +
+					"#EXT-X-VERSION:7\n" +
+					"#EXT-X-TARGETDURATION:2\n" +
+					"#EXT-X-MEDIA-SEQUENCE:0\n" +
+					"#EXT-X-MAP:URI=\"init.mp4\"\n"
+
+				for i := range segmentCount {
+					pl += "#EXTINF:0.1,\n" +
+						"segment" + strconv.FormatInt(int64(i), 10) + ".mp4\n"
+				}
+
+				if playlistCount >= 3 {
+					pl += "#EXT-X-ENDLIST\n"
+				}
+
+				w.Header().Set("Content-Type", `application/vnd.apple.mpegurl`)
+				w.Write([]byte(pl))
+
+			case r.Method == http.MethodGet && r.URL.Path == "/init.mp4":
+				w.Header().Set("Content-Type", `video/mp4`)
+				err := mp4ToWriter(&fmp4.Init{
+					Tracks: []*fmp4.InitTrack{
+						{
+							ID:        1,
+							TimeScale: 90000,
+							Codec: &mp4codecs.H264{
+								SPS: testSPS,
+								PPS: testPPS,
+							},
+						},
+					},
+				}, w)
+				require.NoError(t, err)
+
+			case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/segment"):
+				i, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/segment"), ".mp4"), 10, 64)
+				require.NoError(t, err)
+
+				w.Header().Set("Content-Type", `video/mp4`)
+				err = mp4ToWriter(&fmp4.Part{
+					Tracks: []*fmp4.PartTrack{
+						{
+							ID:       1,
+							BaseTime: i * 9000,
+							Samples: []*fmp4.Sample{{
+								Duration: 9000,
+								Payload: mustMarshalAVCC([][]byte{
+									{5}, // IDR
+								}),
+							}},
+						},
+					},
+				}, w)
+				require.NoError(t, err)
+			}
+		}),
+	}
+
+	ln, err := net.Listen("tcp", "localhost:5780")
+	require.NoError(t, err)
+
+	go httpServ.Serve(ln)
+	defer httpServ.Shutdown(context.Background())
+
+	tr := &http.Transport{}
+	defer tr.CloseIdleConnections()
+
+	var skipped []time.Duration
+	var dtss []int64
+	var deliveries []time.Time
+
+	var c *Client
+	c = &Client{
+		URI:        "http://localhost:5780/index.m3u8",
+		HTTPClient: &http.Client{Transport: tr},
+		CatchUp:    true,
+		OnTracks: func(tracks []*Track) error {
+			c.OnDataH26x(tracks[0], func(_ int64, dts int64, _ [][]byte) {
+				dtss = append(dtss, dts)
+				deliveries = append(deliveries, time.Now())
+			})
+			return nil
+		},
+		OnCatchUp: func(s time.Duration) {
+			skipped = append(skipped, s)
+		},
+	}
+
+	err = c.Start()
+	require.NoError(t, err)
+	defer c.Close()
+
+	err = c.Wait2()
+	require.Equal(t, ErrClientEOS, err)
+
+	require.Equal(t, []time.Duration{900 * time.Millisecond}, skipped)
+
+	// segments 3 to 11 are skipped
+	require.Equal(t, []int64{0, 10 * 9000, 11 * 9000, 12 * 9000}, dtss)
+
+	// delivery is not delayed by skipped content
+	require.Less(t, deliveries[1].Sub(deliveries[0]), 500*time.Millisecond)
+}
+
+func TestClientCatchUpRendition(t *testing.T) {
+	videoPlaylistCount := 0
+	audioPlaylistCount := 0
+
+	mediaPlaylist := func(prefix string, segmentCount int, endlist bool) string {
+		pl := "#EXTM3U\n" +
+			"#EXT-X-VERSION:7\n" +
+			"#EXT-X-TARGETDURATION:2\n" +
+			"#EXT-X-MEDIA-SEQUENCE:0\n" +
+			"#EXT-X-MAP:URI=\"" + prefix + "_init.mp4\"\n"
+
+		for i := range segmentCount {
+			pl += "#EXTINF:0.1,\n" +
+				prefix + "_segment" + strconv.FormatInt(int64(i), 10) + ".mp4\n"
+		}
+
+		if endlist {
+			pl += "#EXT-X-ENDLIST\n"
+		}
+
+		return pl
+	}
+
+	httpServ := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case r.Method == http.MethodGet && r.URL.Path == "/index.m3u8":
+				w.Header().Set("Content-Type", `application/vnd.apple.mpegurl`)
+				w.Write([]byte("#EXTM3U\n" +
+					"#EXT-X-VERSION:7\n" +
+					"#EXT-X-INDEPENDENT-SEGMENTS\n" +
+					"#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"aac\",NAME=\"English\"," +
+					"DEFAULT=YES,AUTOSELECT=YES,LANGUAGE=\"en\",URI=\"audio.m3u8\"\n" +
+					"#EXT-X-STREAM-INF:BANDWIDTH=7680000,CODECS=\"avc1.640015,mp4a.40.5\",AUDIO=\"aac\"\n" +
+					"video.m3u8\n"))
+
+			case r.Method == http.MethodGet && r.URL.Path == "/video.m3u8":
+				videoPlaylistCount++
+				w.Header().Set("Content-Type", `application/vnd.apple.mpegurl`)
+				w.Write([]byte(mediaPlaylist("video", 5, videoPlaylistCount >= 3)))
+
+			case r.Method == http.MethodGet && r.URL.Path == "/audio.m3u8":
+				audioPlaylistCount++
+
+				// simulate an audio rendition that is falling behind,
+				// by increasing the number of segments between two requests.
+				segmentCount := 5
+				if audioPlaylistCount >= 2 {
+					segmentCount = 15
+				}
+
+				w.Header().Set("Content-Type", `application/vnd.apple.mpegurl`)
+				w.Write([]byte(mediaPlaylist("audio", segmentCount, audioPlaylistCount >= 3)))
+
+			case r.Method == http.MethodGet && r.URL.Path == "/video_init.mp4":
+				w.Header().Set("Content-Type", `video/mp4`)
+				err := mp4ToWriter(&fmp4.Init{
+					Tracks: []*fmp4.InitTrack{
+						{
+							ID:        1,
+							TimeScale: 90000,
+							Codec: &mp4codecs.H264{
+								SPS: testSPS,
+								PPS: testPPS,
+							},
+						},
+					},
+				}, w)
+				require.NoError(t, err)
+
+			case r.Method == http.MethodGet && r.URL.Path == "/audio_init.mp4":
+				w.Header().Set("Content-Type", `video/mp4`)
+				err := mp4ToWriter(&fmp4.Init{
+					Tracks: []*fmp4.InitTrack{
+						{
+							ID:        1,
+							TimeScale: 44100,
+							Codec: &mp4codecs.MPEG4Audio{
+								Config: testConfig,
+							},
+						},
+					},
+				}, w)
+				require.NoError(t, err)
+
+			case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/video_segment"):
+				i, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/video_segment"), ".mp4"), 10, 64)
+				require.NoError(t, err)
+
+				w.Header().Set("Content-Type", `video/mp4`)
+				err = mp4ToWriter(&fmp4.Part{
+					Tracks: []*fmp4.PartTrack{
+						{
+							ID:       1,
+							BaseTime: i * 9000,
+							Samples: []*fmp4.Sample{{
+								Duration: 9000,
+								Payload: mustMarshalAVCC([][]byte{
+									{5}, // IDR
+								}),
+							}},
+						},
+					},
+				}, w)
+				require.NoError(t, err)
+
+			case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/audio_segment"):
+				i, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/audio_segment"), ".mp4"), 10, 64)
+				require.NoError(t, err)
+
+				w.Header().Set("Content-Type", `video/mp4`)
+				err = mp4ToWriter(&fmp4.Part{
+					Tracks: []*fmp4.PartTrack{
+						{
+							ID:       1,
+							BaseTime: i * 4410,
+							Samples: []*fmp4.Sample{{
+								Duration: 4410,
+								Payload:  []byte{1, 2, 3, 4},
+							}},
+						},
+					},
+				}, w)
+				require.NoError(t, err)
+			}
+		}),
+	}
+
+	ln, err := net.Listen("tcp", "localhost:5780")
+	require.NoError(t, err)
+
+	go httpServ.Serve(ln)
+	defer httpServ.Shutdown(context.Background())
+
+	tr := &http.Transport{}
+	defer tr.CloseIdleConnections()
+
+	var mutex sync.Mutex
+	var skipped []time.Duration
+	var audioPTSs []int64
+	var audioDeliveries []time.Time
+
+	var c *Client
+	c = &Client{
+		URI:        "http://localhost:5780/index.m3u8",
+		HTTPClient: &http.Client{Transport: tr},
+		CatchUp:    true,
+		OnTracks: func(tracks []*Track) error {
+			require.Len(t, tracks, 2)
+
+			c.OnDataH26x(tracks[0], func(_ int64, _ int64, _ [][]byte) {
+			})
+
+			c.OnDataMPEG4Audio(tracks[1], func(pts int64, _ [][]byte) {
+				mutex.Lock()
+				defer mutex.Unlock()
+				audioPTSs = append(audioPTSs, pts)
+				audioDeliveries = append(audioDeliveries, time.Now())
+			})
+			return nil
+		},
+		OnCatchUp: func(s time.Duration) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			skipped = append(skipped, s)
+		},
+	}
+
+	err = c.Start()
+	require.NoError(t, err)
+	defer c.Close()
+
+	err = c.Wait2()
+	require.Equal(t, ErrClientEOS, err)
+
+	require.Equal(t, []time.Duration{900 * time.Millisecond}, skipped)
+
+	// segments 3 to 11 of the audio rendition are skipped
+	require.Equal(t, []int64{0, 10 * 4410, 11 * 4410, 12 * 4410}, audioPTSs)
+
+	// delivery is not delayed by content skipped by the rendition
+	require.Less(t, audioDeliveries[1].Sub(audioDeliveries[0]), 500*time.Millisecond)
+}
+
+func TestClientMultiTrackRendition(t *testing.T) {
+	httpServ := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case r.Method == http.MethodGet && r.URL.Path == "/index.m3u8":
+				w.Header().Set("Content-Type", `application/vnd.apple.mpegurl`)
+				w.Write([]byte("#EXTM3U\n" +
+					"#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"aac\",NAME=\"English\"," +
+					"DEFAULT=YES,AUTOSELECT=YES,LANGUAGE=\"en\",URI=\"audio.m3u8\"\n" +
+					"#EXT-X-STREAM-INF:BANDWIDTH=7680000,CODECS=\"avc1.640015,mp4a.40.5\",AUDIO=\"aac\"\n" +
+					"video.m3u8\n"))
+
+			case r.Method == http.MethodGet && (r.URL.Path == "/video.m3u8" || r.URL.Path == "/audio.m3u8"):
+				name := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/"), ".m3u8")
+				w.Header().Set("Content-Type", `application/vnd.apple.mpegurl`)
+				w.Write([]byte("#EXTM3U\n" +
+					"#EXT-X-VERSION:7\n" +
+					"#EXT-X-MEDIA-SEQUENCE:20\n" +
+					"#EXT-X-PLAYLIST-TYPE:VOD\n" +
+					"#EXT-X-INDEPENDENT-SEGMENTS\n" +
+					"#EXT-X-TARGETDURATION:2\n" +
+					"#EXT-X-MAP:URI=\"init_" + name + ".mp4\"\n" +
+					"#EXTINF:2,\n" +
+					"segment_" + name + ".mp4\n" +
+					"#EXT-X-ENDLIST\n"))
+
+			case r.Method == http.MethodGet && r.URL.Path == "/init_video.mp4":
+				w.Header().Set("Content-Type", `video/mp4`)
+				err := mp4ToWriter(&fmp4.Init{
+					Tracks: []*fmp4.InitTrack{
+						{
+							ID:        1,
+							TimeScale: 90000,
+							Codec: &mp4codecs.H264{
+								SPS: testSPS,
+								PPS: testPPS,
+							},
+						},
+					},
+				}, w)
+				require.NoError(t, err)
+
+			case r.Method == http.MethodGet && r.URL.Path == "/init_audio.mp4":
+				w.Header().Set("Content-Type", `video/mp4`)
+				err := mp4ToWriter(&fmp4.Init{
+					Tracks: []*fmp4.InitTrack{
+						{
+							ID:        1,
+							TimeScale: 44100,
+							Codec: &mp4codecs.MPEG4Audio{
+								Config: testConfig,
+							},
+						},
+						{
+							ID:        2,
+							TimeScale: 48000,
+							Codec: &mp4codecs.Opus{
+								ChannelCount: 6,
+							},
+						},
+					},
+				}, w)
+				require.NoError(t, err)
+
+			case r.Method == http.MethodGet && r.URL.Path == "/segment_video.mp4":
+				w.Header().Set("Content-Type", `video/mp4`)
+				err := mp4ToWriter(&fmp4.Part{
+					Tracks: []*fmp4.PartTrack{
+						{
+							ID: 1,
+							Samples: []*fmp4.Sample{{
+								Duration: 90000,
+								Payload: mustMarshalAVCC([][]byte{
+									{5}, // IDR
+								}),
+							}},
+						},
+					},
+				}, w)
+				require.NoError(t, err)
+
+			case r.Method == http.MethodGet && r.URL.Path == "/segment_audio.mp4":
+				w.Header().Set("Content-Type", `video/mp4`)
+				err := mp4ToWriter(&fmp4.Part{
+					Tracks: []*fmp4.PartTrack{
+						{
+							ID: 1,
+							Samples: []*fmp4.Sample{{
+								Duration: 1024,
+								Payload:  []byte{1, 2, 3, 4},
+							}},
+						},
+						{
+							ID: 2,
+							Samples: []*fmp4.Sample{{
+								Duration: 960,
+								Payload:  []byte{5, 6, 7, 8},
+							}},
+						},
+					},
+				}, w)
+				require.NoError(t, err)
+			}
+		}),
+	}
+
+	ln, err := net.Listen("tcp", "localhost:5780")
+	require.NoError(t, err)
+
+	go httpServ.Serve(ln)
+	defer httpServ.Shutdown(context.Background())
+
+	tr := &http.Transport{}
+	defer tr.CloseIdleConnections()
+
+	var aacRecv [][]byte
+	var opusRecv [][]byte
+
+	var c *Client
+	c = &Client{
+		URI:        "http://localhost:5780/index.m3u8",
+		HTTPClient: &http.Client{Transport: tr},
+		OnTracks: func(tracks []*Track) error {
+			require.Equal(t, []*Track{
+				{
+					Codec: &codecs.H264{
+						SPS: testSPS,
+						PPS: testPPS,
+					},
+					ClockRate: 90000,
+				},
+				{
+					Codec: &codecs.MPEG4Audio{
+						Config: testConfig,
+					},
+					ClockRate: 44100,
+					Name:      "English",
+					Language:  "en",
+					IsDefault: true,
+				},
+				{
+					Codec: &codecs.Opus{
+						ChannelCount: 6,
+					},
+					ClockRate: 48000,
+					Name:      "English",
+					Language:  "en",
+					IsDefault: true,
+				},
+			}, tracks)
+
+			c.OnDataMPEG4Audio(tracks[1], func(_ int64, aus [][]byte) {
+				aacRecv = append(aacRecv, aus...)
+			})
+
+			c.OnDataOpus(tracks[2], func(_ int64, packets [][]byte) {
+				opusRecv = append(opusRecv, packets...)
+			})
+
+			return nil
+		},
+	}
+
+	err = c.Start()
+	require.NoError(t, err)
+	defer c.Close()
+
+	err = c.Wait2()
+	require.Equal(t, ErrClientEOS, err)
+
+	require.Equal(t, [][]byte{{1, 2, 3, 4}}, aacRecv)
+	require.Equal(t, [][]byte{{5, 6, 7, 8}}, opusRecv)
+}
+
+func TestClientMixedFormats(t *testing.T) {
+	for _, ca := range []string{
+		"same clock",
+		"different clocks",
+	} {
+		t.Run(ca, func(t *testing.T) {
+			// fMP4 timestamps do not wrap around,
+			// therefore they correspond to MPEG-TS ones modulo 2^33.
+			audioBaseTime := uint64((0x200000000*44100)/90000 + 10*44100)
+			if ca == "different clocks" {
+				audioBaseTime += 100 * 44100
+			}
+
+			httpServ := &http.Server{
+				Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					switch {
+					case r.Method == http.MethodGet && r.URL.Path == "/index.m3u8":
+						w.Header().Set("Content-Type", `application/vnd.apple.mpegurl`)
+						w.Write([]byte("#EXTM3U\n" +
+							"#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"aac\",NAME=\"English\"," +
+							"DEFAULT=YES,AUTOSELECT=YES,LANGUAGE=\"en\",URI=\"audio.m3u8\"\n" +
+							"#EXT-X-STREAM-INF:BANDWIDTH=7680000,CODECS=\"avc1.640015,mp4a.40.5\",AUDIO=\"aac\"\n" +
+							"video.m3u8\n"))
+
+					case r.Method == http.MethodGet && r.URL.Path == "/video.m3u8":
+						w.Header().Set("Content-Type", `application/vnd.apple.mpegurl`)
+						w.Write([]byte("#EXTM3U\n" +
+							"#EXT-X-VERSION:3\n" +
+							"#EXT-X-MEDIA-SEQUENCE:0\n" +
+							"#EXT-X-PLAYLIST-TYPE:VOD\n" +
+							"#EXT-X-TARGETDURATION:2\n" +
+							"#EXTINF:1,\n" +
+							"segment_video.ts\n" +
+							"#EXT-X-ENDLIST\n"))
+
+					case r.Method == http.MethodGet && r.URL.Path == "/audio.m3u8":
+						w.Header().Set("Content-Type", `application/vnd.apple.mpegurl`)
+						w.Write([]byte("#EXTM3U\n" +
+							"#EXT-X-VERSION:7\n" +
+							"#EXT-X-MEDIA-SEQUENCE:0\n" +
+							"#EXT-X-PLAYLIST-TYPE:VOD\n" +
+							"#EXT-X-TARGETDURATION:2\n" +
+							"#EXT-X-MAP:URI=\"init_audio.mp4\"\n" +
+							"#EXTINF:1,\n" +
+							"segment_audio.mp4\n" +
+							"#EXT-X-ENDLIST\n"))
+
+					case r.Method == http.MethodGet && r.URL.Path == "/segment_video.ts":
+						w.Header().Set("Content-Type", `video/MP2T`)
+
+						h264Track := &mpegts.Track{
+							Codec: &tscodecs.H264{},
+						}
+						mw := &mpegts.Writer{W: w, Tracks: []*mpegts.Track{h264Track}}
+						err := mw.Initialize()
+						require.NoError(t, err)
+
+						err = mw.WriteH264(
+							h264Track,
+							10*90000,
+							10*90000,
+							[][]byte{
+								testSPS,
+								testPPS,
+								{5}, // IDR
+							},
+						)
+						require.NoError(t, err)
+
+					case r.Method == http.MethodGet && r.URL.Path == "/init_audio.mp4":
+						w.Header().Set("Content-Type", `video/mp4`)
+						err := mp4ToWriter(&fmp4.Init{
+							Tracks: []*fmp4.InitTrack{
+								{
+									ID:        1,
+									TimeScale: 44100,
+									Codec: &mp4codecs.MPEG4Audio{
+										Config: testConfig,
+									},
+								},
+							},
+						}, w)
+						require.NoError(t, err)
+
+					case r.Method == http.MethodGet && r.URL.Path == "/segment_audio.mp4":
+						w.Header().Set("Content-Type", `video/mp4`)
+						err := mp4ToWriter(&fmp4.Part{
+							Tracks: []*fmp4.PartTrack{
+								{
+									ID:       1,
+									BaseTime: audioBaseTime,
+									Samples: []*fmp4.Sample{{
+										Duration: 1024,
+										Payload:  []byte{1, 2, 3, 4},
+									}},
+								},
+							},
+						}, w)
+						require.NoError(t, err)
+					}
+				}),
+			}
+
+			ln, err := net.Listen("tcp", "localhost:5780")
+			require.NoError(t, err)
+
+			go httpServ.Serve(ln)
+			defer httpServ.Shutdown(context.Background())
+
+			tr := &http.Transport{}
+			defer tr.CloseIdleConnections()
+
+			var videoDTS []int64
+			var audioPTS []int64
+
+			var c *Client
+			c = &Client{
+				URI:        "http://localhost:5780/index.m3u8",
+				HTTPClient: &http.Client{Transport: tr},
+				OnTracks: func(tracks []*Track) error {
+					require.Len(t, tracks, 2)
+
+					c.OnDataH26x(tracks[0], func(_ int64, dts int64, _ [][]byte) {
+						videoDTS = append(videoDTS, dts)
+					})
+
+					c.OnDataMPEG4Audio(tracks[1], func(pts int64, _ [][]byte) {
+						audioPTS = append(audioPTS, pts)
+					})
+
+					return nil
+				},
+			}
+
+			err = c.Start()
+			require.NoError(t, err)
+			defer c.Close()
+
+			err = c.Wait2()
+
+			if ca == "same clock" {
+				require.Equal(t, ErrClientEOS, err)
+				require.Equal(t, []int64{0}, videoDTS)
+				require.Equal(t, []int64{0}, audioPTS)
+			} else {
+				require.EqualError(t, err,
+					"MPEG-TS and fMP4 timestamps differ by 1m40s, they are not generated from the same clock")
+			}
+		})
+	}
+}
+
+func TestClientMPEGTSCodecs(t *testing.T) {
+	ac3Frame := make([]byte, 128)
+	copy(ac3Frame, []byte{0x0b, 0x77, 0x00, 0x00, 0x00})
+
+	mp3Frame := make([]byte, 417)
+	copy(mp3Frame, []byte{0xff, 0xfb, 0x90, 0x64})
+
+	httpServ := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case r.Method == http.MethodGet && r.URL.Path == "/index.m3u8":
+				w.Header().Set("Content-Type", `application/vnd.apple.mpegurl`)
+				w.Write([]byte("#EXTM3U\n" +
+					"#EXT-X-VERSION:3\n" +
+					"#EXT-X-MEDIA-SEQUENCE:0\n" +
+					"#EXT-X-PLAYLIST-TYPE:VOD\n" +
+					"#EXT-X-TARGETDURATION:2\n" +
+					"#EXTINF:1,\n" +
+					"segment.ts\n" +
+					"#EXT-X-ENDLIST\n"))
+
+			case r.Method == http.MethodGet && r.URL.Path == "/segment.ts":
+				w.Header().Set("Content-Type", `video/MP2T`)
+
+				h265Track := &mpegts.Track{
+					Codec: &tscodecs.H265{},
+				}
+				opusTrack := &mpegts.Track{
+					Codec: &tscodecs.Opus{ChannelCount: 2},
+				}
+				mp3Track := &mpegts.Track{
+					Codec: &tscodecs.MPEG1Audio{},
+				}
+				ac3Track := &mpegts.Track{
+					Codec: &tscodecs.AC3{SampleRate: 48000, ChannelCount: 2},
+				}
+
+				mw := &mpegts.Writer{W: w, Tracks: []*mpegts.Track{h265Track, opusTrack, mp3Track, ac3Track}}
+				err := mw.Initialize()
+				require.NoError(t, err)
+
+				err = mw.WriteH265(h265Track, 90000, 90000, [][]byte{
+					{byte(h265.NALUType_IDR_W_RADL) << 1, 0},
+				})
+				require.NoError(t, err)
+
+				err = mw.WriteOpus(opusTrack, 90000, [][]byte{{1, 2}})
+				require.NoError(t, err)
+
+				err = mw.WriteMPEG1Audio(mp3Track, 90000, [][]byte{mp3Frame})
+				require.NoError(t, err)
+
+				err = mw.WriteAC3(ac3Track, 90000, ac3Frame)
+				require.NoError(t, err)
+			}
+		}),
+	}
+
+	ln, err := net.Listen("tcp", "localhost:5780")
+	require.NoError(t, err)
+
+	go httpServ.Serve(ln)
+	defer httpServ.Shutdown(context.Background())
+
+	tr := &http.Transport{}
+	defer tr.CloseIdleConnections()
+
+	var h265Recv [][]byte
+	var opusRecv [][]byte
+	var mp3Recv [][]byte
+	var ac3Recv []byte
+
+	var c *Client
+	c = &Client{
+		URI:        "http://localhost:5780/index.m3u8",
+		HTTPClient: &http.Client{Transport: tr},
+		OnTracks: func(tracks []*Track) error {
+			require.Equal(t, []*Track{
+				{
+					Codec:     &codecs.H265{},
+					ClockRate: 90000,
+				},
+				{
+					Codec:     &codecs.Opus{ChannelCount: 2},
+					ClockRate: 90000,
+				},
+				{
+					Codec:     &codecs.MPEG1Audio{},
+					ClockRate: 90000,
+				},
+				{
+					Codec:     &codecs.AC3{SampleRate: 48000, ChannelCount: 2},
+					ClockRate: 90000,
+				},
+			}, tracks)
+
+			c.OnDataH26x(tracks[0], func(_ int64, _ int64, au [][]byte) {
+				h265Recv = au
+			})
+
+			c.OnDataOpus(tracks[1], func(_ int64, packets [][]byte) {
+				opusRecv = packets
+			})
+
+			c.OnDataMPEG1Audio(tracks[2], func(_ int64, frames [][]byte) {
+				mp3Recv = frames
+			})
+
+			c.OnDataAC3(tracks[3], func(_ int64, frame []byte) {
+				ac3Recv = frame
+			})
+
+			return nil
+		},
+	}
+
+	err = c.Start()
+	require.NoError(t, err)
+	defer c.Close()
+
+	err = c.Wait2()
+	require.Equal(t, ErrClientEOS, err)
+
+	require.Equal(t, [][]byte{
+		{byte(h265.NALUType_IDR_W_RADL) << 1, 0},
+	}, h265Recv)
+	require.Equal(t, [][]byte{{1, 2}}, opusRecv)
+	require.Equal(t, [][]byte{mp3Frame}, mp3Recv)
+	require.Equal(t, ac3Frame, ac3Recv)
+}
+
+func TestClientCodecFilter(t *testing.T) {
+	httpServ := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case r.Method == http.MethodGet && r.URL.Path == "/index.m3u8":
+				w.Header().Set("Content-Type", `application/vnd.apple.mpegurl`)
+				w.Write([]byte("#EXTM3U\n" +
+					"#EXT-X-VERSION:7\n" +
+					"#EXT-X-STREAM-INF:BANDWIDTH=3000000,CODECS=\"av01.0.08M.08\"\n" +
+					"av1.m3u8\n" +
+					"#EXT-X-STREAM-INF:BANDWIDTH=2000000,CODECS=\"hvc1.2.4.L150.b0\"\n" +
+					"hevc.m3u8\n" +
+					"#EXT-X-STREAM-INF:BANDWIDTH=1000000,CODECS=\"avc1.42c028\"\n" +
+					"avc.m3u8\n" +
+					"#EXT-X-STREAM-INF:BANDWIDTH=4000000,CODECS=\"ec-3,avc1.42c028\"\n" +
+					"eac3.m3u8\n" +
+					// parameters cannot be parsed, the codec identifier is used
+					"#EXT-X-STREAM-INF:BANDWIDTH=5000000,CODECS=\"hvc1.unknown\"\n" +
+					"hevc_unparsed.m3u8\n"))
+
+			case r.Method == http.MethodGet && r.URL.Path == "/hevc_unparsed.m3u8":
+				w.Header().Set("Content-Type", `application/vnd.apple.mpegurl`)
+				w.Write([]byte("#EXTM3U\n" +
+					"#EXT-X-VERSION:7\n" +
+					"#EXT-X-TARGETDURATION:2\n" +
+					"#EXT-X-MEDIA-SEQUENCE:0\n" +
+					"#EXT-X-PLAYLIST-TYPE:VOD\n" +
+					"#EXT-X-MAP:URI=\"init.mp4\"\n" +
+					"#EXTINF:2,\n" +
+					"segment1.mp4\n" +
+					"#EXT-X-ENDLIST\n"))
+
+			case r.Method == http.MethodGet && r.URL.Path == "/init.mp4":
+				w.Header().Set("Content-Type", `video/mp4`)
+				err := mp4ToWriter(&fmp4.Init{
+					Tracks: []*fmp4.InitTrack{{
+						ID:        1,
+						TimeScale: 90000,
+						Codec: &mp4codecs.H265{
+							VPS: []byte{0x01, 0x02, 0x03, 0x04},
+							SPS: []byte{
+								0x42, 0x01, 0x01, 0x01, 0x60, 0x00, 0x00, 0x03,
+								0x00, 0x90, 0x00, 0x00, 0x03, 0x00, 0x00, 0x03,
+								0x00, 0x78, 0xa0, 0x03, 0xc0, 0x80, 0x10, 0xe5,
+								0x96, 0x66, 0x69, 0x24, 0xca, 0xe0, 0x10, 0x00,
+								0x00, 0x03, 0x00, 0x10, 0x00, 0x00, 0x03, 0x01,
+								0xe0, 0x80,
+							},
+							PPS: []byte{0x08},
+						},
+					}},
+				}, w)
+				require.NoError(t, err)
+
+			case r.Method == http.MethodGet && r.URL.Path == "/segment1.mp4":
+				w.Header().Set("Content-Type", `video/mp4`)
+				err := mp4ToWriter(&fmp4.Part{
+					Tracks: []*fmp4.PartTrack{{
+						ID: 1,
+						Samples: []*fmp4.Sample{{
+							Duration: 90000,
+							Payload:  mustMarshalAVCC([][]byte{{byte(h265.NALUType_IDR_W_RADL) << 1, 1}}),
+						}},
+					}},
+				}, w)
+				require.NoError(t, err)
+
+			default:
+				t.Errorf("unexpected request: %s", r.URL.Path)
+			}
+		}),
+	}
+
+	ln, err := net.Listen("tcp", "localhost:5780")
+	require.NoError(t, err)
+
+	go httpServ.Serve(ln)
+	defer httpServ.Shutdown(context.Background())
+
+	tr := &http.Transport{}
+	defer tr.CloseIdleConnections()
+
+	var filtered []codecparams.Codec
+	var aus [][][]byte
+
+	var c *Client
+	c = &Client{
+		URI:        "http://localhost:5780/index.m3u8",
+		HTTPClient: &http.Client{Transport: tr},
+		CodecFilter: func(codec codecparams.Codec) bool {
+			filtered = append(filtered, codec)
+			_, isAV1 := codec.(*codecparams.AV1)
+			return !isAV1
+		},
+		OnTracks: func(tracks []*Track) error {
+			require.Len(t, tracks, 1)
+			require.IsType(t, &codecs.H265{}, tracks[0].Codec)
+
+			c.OnDataH26x(tracks[0], func(_ int64, _ int64, au [][]byte) {
+				aus = append(aus, au)
+			})
+
+			return nil
+		},
+	}
+
+	err = c.Start()
+	require.NoError(t, err)
+	defer c.Close()
+
+	err = c.Wait2()
+	require.Equal(t, ErrClientEOS, err)
+
+	require.Equal(t, [][][]byte{{{byte(h265.NALUType_IDR_W_RADL) << 1, 1}}}, aus)
+
+	require.Equal(t, []codecparams.Codec{
+		&codecparams.AV1{
+			Level:    8,
+			BitDepth: 8,
+		},
+		&codecparams.HEVC{
+			Profile:                  2,
+			ProfileCompatibility:     [32]bool{2: true},
+			Level:                    150,
+			ConstraintIndicatorFlags: []uint8{0xb0},
+		},
+		&codecparams.AVC{
+			Profile:              0x42,
+			ProfileCompatibility: 0xc0,
+			Level:                0x28,
+		},
+	}, filtered)
+}
+
+func TestClientPackedAudio(t *testing.T) {
+	mp3Frame := make([]byte, 417)
+	copy(mp3Frame, []byte{0xff, 0xfb, 0x90, 0x64})
+
+	for _, ca := range []string{"aac", "mp3"} {
+		t.Run(ca, func(t *testing.T) {
+			httpServ := &http.Server{
+				Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					switch {
+					case r.Method == http.MethodGet && r.URL.Path == "/index.m3u8":
+						w.Header().Set("Content-Type", `application/vnd.apple.mpegurl`)
+						w.Write([]byte("#EXTM3U\n" +
+							"#EXT-X-VERSION:3\n" +
+							"#EXT-X-MEDIA-SEQUENCE:0\n" +
+							"#EXT-X-PLAYLIST-TYPE:VOD\n" +
+							"#EXT-X-TARGETDURATION:2\n" +
+							"#EXT-X-PROGRAM-DATE-TIME:2015-02-05T01:02:02Z\n" +
+							"#EXTINF:1,\n" +
+							// the format is detected from content, not from the extension
+							"segment_" + ca + "\n" +
+							"#EXT-X-ENDLIST\n"))
+
+					case r.Method == http.MethodGet && r.URL.Path == "/segment_aac":
+						w.Header().Set("Content-Type", `audio/aac`)
+						w.Write(packedAudioMarshalHeader(90000))
+
+						pkts := mpeg4audio.ADTSPackets{
+							{
+								Type:          testConfig.Type,
+								SampleRate:    testConfig.SampleRate,
+								ChannelConfig: testConfig.ChannelConfig,
+								ChannelCount:  testConfig.ChannelCount,
+								AU:            []byte{1, 2, 3, 4},
+							},
+							{
+								Type:          testConfig.Type,
+								SampleRate:    testConfig.SampleRate,
+								ChannelConfig: testConfig.ChannelConfig,
+								ChannelCount:  testConfig.ChannelCount,
+								AU:            []byte{5, 6, 7, 8},
+							},
+						}
+						buf, err := pkts.Marshal()
+						require.NoError(t, err)
+						w.Write(buf)
+
+					case r.Method == http.MethodGet && r.URL.Path == "/segment_mp3":
+						w.Header().Set("Content-Type", `audio/mpeg`)
+						w.Write(packedAudioMarshalHeader(90000))
+						w.Write(mp3Frame)
+						w.Write(mp3Frame)
+					}
+				}),
+			}
+
+			ln, err := net.Listen("tcp", "localhost:5780")
+			require.NoError(t, err)
+
+			go httpServ.Serve(ln)
+			defer httpServ.Shutdown(context.Background())
+
+			tr := &http.Transport{}
+			defer tr.CloseIdleConnections()
+
+			var recv []*ClientSample
+
+			var c *Client
+			c = &Client{
+				URI:        "http://localhost:5780/index.m3u8",
+				HTTPClient: &http.Client{Transport: tr},
+				OnTracks: func(tracks []*Track) error {
+					require.Len(t, tracks, 1)
+					track := tracks[0]
+
+					if ca == "aac" {
+						require.Equal(t, &Track{
+							Codec:     &codecs.MPEG4Audio{Config: testConfig},
+							ClockRate: 90000,
+						}, track)
+
+						c.OnDataMPEG4Audio(track, func(pts int64, aus [][]byte) {
+							ntp, ok := c.AbsoluteTime(track)
+							require.True(t, ok)
+							recv = append(recv, &ClientSample{PTS: pts, NTP: &ntp, Payload: aus})
+						})
+					} else {
+						require.Equal(t, &Track{
+							Codec:     &codecs.MPEG1Audio{SampleRate: 44100, ChannelCount: 2},
+							ClockRate: 90000,
+						}, track)
+
+						c.OnDataMPEG1Audio(track, func(pts int64, frames [][]byte) {
+							ntp, ok := c.AbsoluteTime(track)
+							require.True(t, ok)
+							recv = append(recv, &ClientSample{PTS: pts, NTP: &ntp, Payload: frames})
+						})
+					}
+
+					return nil
+				},
+			}
+
+			err = c.Start()
+			require.NoError(t, err)
+			defer c.Close()
+
+			err = c.Wait2()
+			require.Equal(t, ErrClientEOS, err)
+
+			segmentNTP := time.Date(2015, time.February, 5, 1, 2, 2, 0, time.UTC)
+
+			if ca == "aac" {
+				require.Equal(t, []*ClientSample{
+					{
+						PTS:     0,
+						NTP:     &segmentNTP,
+						Payload: [][]byte{{1, 2, 3, 4}},
+					},
+					{
+						PTS:     2089,
+						NTP:     ptrOf(segmentNTP.Add(timestampToDuration(2089, 90000))),
+						Payload: [][]byte{{5, 6, 7, 8}},
+					},
+				}, recv)
+			} else {
+				require.Equal(t, []*ClientSample{
+					{
+						PTS:     0,
+						NTP:     &segmentNTP,
+						Payload: [][]byte{mp3Frame},
+					},
+					{
+						PTS:     2351,
+						NTP:     ptrOf(segmentNTP.Add(timestampToDuration(2351, 90000))),
+						Payload: [][]byte{mp3Frame},
+					},
+				}, recv)
+			}
+		})
+	}
+}
+
+func TestClientVideoRenditions(t *testing.T) {
+	for _, ca := range []string{
+		"default",
+		"filter",
+	} {
+		t.Run(ca, func(t *testing.T) {
+			httpServ := &http.Server{
+				Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					switch {
+					case r.Method == http.MethodGet && r.URL.Path == "/index.m3u8":
+						w.Header().Set("Content-Type", `application/vnd.apple.mpegurl`)
+						w.Write([]byte("#EXTM3U\n" +
+							"#EXT-X-VERSION:7\n" +
+							"#EXT-X-MEDIA:TYPE=VIDEO,GROUP-ID=\"cam\",NAME=\"front\",DEFAULT=YES,URI=\"front.m3u8\"\n" +
+							"#EXT-X-MEDIA:TYPE=VIDEO,GROUP-ID=\"cam\",NAME=\"side\",URI=\"side.m3u8\"\n" +
+							"#EXT-X-MEDIA:TYPE=VIDEO,GROUP-ID=\"cam\",NAME=\"top\",URI=\"top.m3u8\"\n" +
+							"#EXT-X-STREAM-INF:BANDWIDTH=1000000,CODECS=\"avc1.42c028\",VIDEO=\"cam\"\n" +
+							"front.m3u8\n"))
+
+					case r.Method == http.MethodGet && (r.URL.Path == "/front.m3u8" || r.URL.Path == "/side.m3u8"):
+						w.Header().Set("Content-Type", `application/vnd.apple.mpegurl`)
+						w.Write([]byte("#EXTM3U\n" +
+							"#EXT-X-VERSION:7\n" +
+							"#EXT-X-TARGETDURATION:2\n" +
+							"#EXT-X-MEDIA-SEQUENCE:0\n" +
+							"#EXT-X-PLAYLIST-TYPE:VOD\n" +
+							"#EXT-X-MAP:URI=\"init.mp4\"\n" +
+							"#EXTINF:2,\n" +
+							"segment1.mp4\n" +
+							"#EXT-X-ENDLIST\n"))
+
+					case r.Method == http.MethodGet && r.URL.Path == "/init.mp4":
+						w.Header().Set("Content-Type", `video/mp4`)
+						err := mp4ToWriter(&fmp4.Init{
+							Tracks: []*fmp4.InitTrack{{
+								ID:        1,
+								TimeScale: 90000,
+								Codec: &mp4codecs.H264{
+									SPS: testSPS,
+									PPS: testPPS,
+								},
+							}},
+						}, w)
+						require.NoError(t, err)
+
+					case r.Method == http.MethodGet && r.URL.Path == "/segment1.mp4":
+						w.Header().Set("Content-Type", `video/mp4`)
+						err := mp4ToWriter(&fmp4.Part{
+							Tracks: []*fmp4.PartTrack{{
+								ID: 1,
+								Samples: []*fmp4.Sample{{
+									Duration: 90000,
+									Payload:  mustMarshalAVCC([][]byte{{5, 1}}),
+								}},
+							}},
+						}, w)
+						require.NoError(t, err)
+
+					default:
+						t.Errorf("unexpected request: %s", r.URL.Path)
+					}
+				}),
+			}
+
+			ln, err := net.Listen("tcp", "localhost:5780")
+			require.NoError(t, err)
+
+			go httpServ.Serve(ln)
+			defer httpServ.Shutdown(context.Background())
+
+			tr := &http.Transport{}
+			defer tr.CloseIdleConnections()
+
+			var filtered []string
+			var mutex sync.Mutex
+			aus := make(map[string]int)
+
+			var c *Client
+			c = &Client{
+				URI:        "http://localhost:5780/index.m3u8",
+				HTTPClient: &http.Client{Transport: tr},
+				OnTracks: func(tracks []*Track) error {
+					expected := []*Track{
+						{
+							Codec: &codecs.H264{
+								SPS: testSPS,
+								PPS: testPPS,
+							},
+							ClockRate: 90000,
+							Name:      "front",
+							IsDefault: true,
+						},
+					}
+
+					if ca == "filter" {
+						expected = append(expected, &Track{
+							Codec: &codecs.H264{
+								SPS: testSPS,
+								PPS: testPPS,
+							},
+							ClockRate: 90000,
+							Name:      "side",
+						})
+					}
+
+					require.Equal(t, expected, tracks)
+
+					for _, track := range tracks {
+						c.OnDataH26x(track, func(_ int64, _ int64, _ [][]byte) {
+							mutex.Lock()
+							defer mutex.Unlock()
+							aus[track.Name]++
+						})
+					}
+
+					return nil
+				},
+			}
+
+			// by default, only the default video rendition is read
+			if ca == "filter" {
+				c.RenditionFilter = func(rendition *playlist.MultivariantRendition) bool {
+					filtered = append(filtered, rendition.Name)
+					return rendition.Name != "top"
+				}
+			}
+
+			err = c.Start()
+			require.NoError(t, err)
+			defer c.Close()
+
+			err = c.Wait2()
+			require.Equal(t, ErrClientEOS, err)
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			if ca == "filter" {
+				require.Equal(t, map[string]int{"front": 1, "side": 1}, aus)
+				require.Equal(t, []string{"side", "top"}, filtered)
+			} else {
+				require.Equal(t, map[string]int{"front": 1}, aus)
+			}
+		})
+	}
+}
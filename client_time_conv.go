@@ -1,3 +1,173 @@
 package gohlslib
 
-type clientTimeConv any
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/bluenviron/mediacommon/v2/pkg/formats/mpegts"
+)
+
+const (
+	mpegtsTimestampMask = 0x1FFFFFFFF // 33 bits
+
+	// maximum difference between the first timestamp of a stream
+	// and the timestamps of streams with a different format.
+	clientMaxMixedFormatsDiff = 30 * time.Second
+)
+
+// clientTimeConv converts timestamps of all streams into a common timeline,
+// that starts from the first DTS of the leading track.
+// fMP4 timestamps are expressed in track timescales, while MPEG-TS timestamps
+// are expressed in 90kHz units and wrap around every 2^33 units.
+// Since packagers derive both from the same clock, a MPEG-TS timestamp is
+// assumed to be equal to the corresponding fMP4 timestamp, modulo 2^33,
+// and this allows to mix MPEG-TS and fMP4 streams in the same session.
+// This assumption is checked on the first timestamp of the other format.
+type clientTimeConv struct {
+	startDTS       int64
+	startClockRate int
+	startIsMPEGTS  bool
+
+	mutex        sync.Mutex
+	td           *mpegts.TimeDecoder
+	lastMPEGTS   int64
+	lastFMP4     time.Duration
+	mixedChecked bool
+	ntpAvailable bool
+	ntpValue     time.Time
+	ntpTimestamp int64
+	ntpClockRate int
+
+	chLeadingNTPReceived chan struct{}
+}
+
+func (ts *clientTimeConv) initialize() {
+	ts.initializeTimeDecoder()
+	ts.chLeadingNTPReceived = make(chan struct{})
+}
+
+// initializeTimeDecoder seeds the MPEG-TS time decoder with the reference timestamp,
+// so that MPEG-TS timestamps are converted into the same timeline of fMP4 ones.
+func (ts *clientTimeConv) initializeTimeDecoder() {
+	ts.td = &mpegts.TimeDecoder{}
+	ts.td.Initialize()
+	ts.td.Decode(multiplyAndDivide(ts.startDTS, 90000, int64(ts.startClockRate)) & mpegtsTimestampMask)
+}
+
+// rebase changes the reference of timestamps.
+// It is used when the initialization of the leading track changes,
+// since the timescale may be different and timestamps may restart.
+func (ts *clientTimeConv) rebase(startDTS int64, startClockRate int) {
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+
+	ts.startDTS = startDTS
+	ts.startClockRate = startClockRate
+	ts.initializeTimeDecoder()
+}
+
+// checkMixed checks that the first timestamp of a format different from the one of the leading track
+// is close to timestamps of the leading track.
+func (ts *clientTimeConv) checkMixed(diff time.Duration) error {
+	if ts.mixedChecked {
+		return nil
+	}
+	ts.mixedChecked = true
+
+	if diff.Abs() > clientMaxMixedFormatsDiff {
+		return fmt.Errorf("MPEG-TS and fMP4 timestamps differ by %v, they are not generated from the same clock", diff)
+	}
+	return nil
+}
+
+// convertFMP4 converts a fMP4 timestamp, expressed in clockRate units.
+func (ts *clientTimeConv) convertFMP4(v int64, clockRate int) (int64, error) {
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+
+	ret := v - multiplyAndDivide(ts.startDTS, int64(clockRate), int64(ts.startClockRate))
+
+	// MPEG-TS timestamps wrap around, while fMP4 ones do not.
+	// Pick the fMP4 timestamp that is closest to the last MPEG-TS one.
+	if ts.startIsMPEGTS {
+		ref := multiplyAndDivide(ts.lastMPEGTS, int64(clockRate), 90000)
+
+		period := multiplyAndDivide(mpegtsTimestampMask+1, int64(clockRate), 90000)
+		diff := (ret - ref) % period
+		if diff >= (period / 2) {
+			diff -= period
+		} else if diff < (-period / 2) {
+			diff += period
+		}
+		ret = ref + diff
+
+		err := ts.checkMixed(timestampToDuration(diff, clockRate))
+		if err != nil {
+			return 0, err
+		}
+	} else {
+		ts.lastFMP4 = timestampToDuration(ret, clockRate)
+	}
+
+	return ret, nil
+}
+
+// convertMPEGTS converts a MPEG-TS timestamp, expressed in 90kHz units.
+func (ts *clientTimeConv) convertMPEGTS(v int64) (int64, error) {
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+
+	ts.lastMPEGTS = ts.td.Decode(v)
+
+	if !ts.startIsMPEGTS {
+		err := ts.checkMixed(timestampToDuration(ts.lastMPEGTS, 90000) - ts.lastFMP4)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return ts.lastMPEGTS, nil
+}
+
+func (ts *clientTimeConv) setNTP(value time.Time, timestamp int64, clockRate int) {
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+
+	ts.ntpAvailable = true
+	ts.ntpValue = value
+	ts.ntpTimestamp = timestamp
+	ts.ntpClockRate = clockRate
+}
+
+func (ts *clientTimeConv) setLeadingNTPReceived() {
+	select {
+	case <-ts.chLeadingNTPReceived:
+		return
+	default:
+	}
+	close(ts.chLeadingNTPReceived)
+}
+
+func (ts *clientTimeConv) getNTP(ctx context.Context, timestamp int64, clockRate int) *time.Time {
+	select {
+	case <-ts.chLeadingNTPReceived:
+	case <-ctx.Done():
+		return nil
+	}
+
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+
+	if !ts.ntpAvailable {
+		return nil
+	}
+
+	v := ts.ntpValue.Add(
+		timestampToDuration(
+			timestamp-multiplyAndDivide(ts.ntpTimestamp, int64(clockRate), int64(ts.ntpClockRate)),
+			clockRate))
+
+	return &v
+}
@@ -3,14 +3,83 @@ package gohlslib
 import (
 	"context"
 	"fmt"
+	"sync"
 	"time"
 )
 
+// clientPacing is the reference used to deliver samples in real time.
+// It is shared by all tracks.
+type clientPacing struct {
+	mutex       sync.Mutex
+	startSystem time.Time
+	skipped     time.Duration
+}
+
+// start sets the reference, if it has not been set yet.
+// It returns whether the reference has been set.
+func (p *clientPacing) start() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if !p.startSystem.IsZero() {
+		return false
+	}
+
+	// content may have been skipped before the reference was set
+	p.startSystem = time.Now().Add(-p.skipped)
+	return true
+}
+
+func (p *clientPacing) elapsed() time.Duration {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return time.Since(p.startSystem)
+}
+
+// skip moves the reference back,
+// in order to deliver content that follows skipped content without waiting.
+// It is called with the overall content skipped by a stream in the current session.
+// Since streams skip the same content independently,
+// the reference is moved by the content skipped by the stream that skipped the most.
+func (p *clientPacing) skip(streamSkipped time.Duration) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if streamSkipped > p.skipped {
+		if !p.startSystem.IsZero() {
+			p.startSystem = p.startSystem.Add(-(streamSkipped - p.skipped))
+		}
+		p.skipped = streamSkipped
+	}
+}
+
+// resetSkipped is called when a new session begins.
+func (p *clientPacing) resetSkipped() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.skipped = 0
+}
+
 type clientTrack struct {
 	track            *Track
 	onData           func(pts int64, dts int64, data [][]byte)
+	sampleQueue      *clientSampleQueue
 	lastAbsoluteTime *time.Time
-	startSystem      time.Time
+	pacing           *clientPacing
+	timeOffset       time.Duration
+	syncGroup        *ClientSyncGroup
+	lastDTS          time.Duration
+	lastDuration     time.Duration
+	sessionStarted   bool
+}
+
+// endDTS returns the end of the last delivered sample.
+// The duration of the sample is estimated with the interval between the last two samples.
+func (t *clientTrack) endDTS() time.Duration {
+	if t.lastDuration > 0 {
+		return t.lastDTS + t.lastDuration
+	}
+	return t.lastDTS + time.Millisecond
 }
 
 func (t *clientTrack) absoluteTime() (time.Time, bool) {
@@ -20,6 +89,28 @@ func (t *clientTrack) absoluteTime() (time.Time, bool) {
 	return *t.lastAbsoluteTime, true
 }
 
+func (t *clientTrack) synchronize(ctx context.Context, dtsDuration time.Duration, ntp *time.Time) error {
+	if t.syncGroup != nil && ntp != nil {
+		return t.syncGroup.wait(ctx, t.lastAbsoluteTime, *ntp)
+	}
+
+	elapsed := t.pacing.elapsed()
+	if dtsDuration > elapsed {
+		diff := dtsDuration - elapsed
+		if diff > clientMaxDTSSystemDiff {
+			return fmt.Errorf("difference between DTS and system time is too big")
+		}
+
+		select {
+		case <-time.After(diff):
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		}
+	}
+
+	return nil
+}
+
 func (t *clientTrack) handleData(
 	ctx context.Context,
 	pts int64,
@@ -32,23 +123,36 @@ func (t *clientTrack) handleData(
 		return nil
 	}
 
-	// synchronize time
-	elapsed := time.Since(t.startSystem)
+	if t.timeOffset != 0 {
+		offset := durationToTimestamp(t.timeOffset, t.track.ClockRate)
+		pts += offset
+		dts += offset
+	}
+
 	dtsDuration := timestampToDuration(dts, t.track.ClockRate)
-	if dtsDuration > elapsed {
-		diff := dtsDuration - elapsed
-		if diff > clientMaxDTSSystemDiff {
-			return fmt.Errorf("difference between DTS and system time is too big")
-		}
 
-		select {
-		case <-time.After(diff):
-		case <-ctx.Done():
-			return fmt.Errorf("terminated")
-		}
+	err := t.synchronize(ctx, dtsDuration, ntp)
+	if err != nil {
+		return err
 	}
 
 	t.lastAbsoluteTime = ntp
+	if t.sessionStarted && dtsDuration > t.lastDTS {
+		t.lastDuration = dtsDuration - t.lastDTS
+	}
+	t.lastDTS = dtsDuration
+	t.sessionStarted = true
+
+	if t.onData == nil {
+		return t.sampleQueue.push(ctx, &ClientSample{
+			Track:   t.track,
+			PTS:     pts,
+			DTS:     dts,
+			NTP:     ntp,
+			Payload: data,
+		}, dtsDuration)
+	}
+
 	t.onData(pts, dts, data)
 	return nil
 }
@@ -2,7 +2,6 @@ package gohlslib
 
 import (
 	"context"
-	"fmt"
 	"time"
 
 	"github.com/bluenviron/gohlslib/v2/pkg/codecs"
@@ -21,12 +20,17 @@ type clientTrackProcessorFMP4StreamProcessor interface {
 
 type clientTrackProcessorFMP4 struct {
 	track           *clientTrack
+	iframesOnly     bool
 	streamProcessor clientTrackProcessorFMP4StreamProcessor
 
 	decodePayload func(sample *fmp4.Sample) ([][]byte, error)
 
 	// in
-	queue chan *procEntryFMP4
+	queue   chan *procEntryFMP4
+	chClose chan struct{}
+
+	// out
+	done chan struct{}
 }
 
 func (t *clientTrackProcessorFMP4) initialize() error {
@@ -56,18 +60,22 @@ func (t *clientTrackProcessorFMP4) initialize() error {
 			return [][]byte{sample.Payload}, nil
 		}
 
-	case *codecs.MPEG4Audio:
+	case *codecs.MPEG4Audio, *codecs.MPEG1Audio, *codecs.AC3:
 		t.decodePayload = func(sample *fmp4.Sample) ([][]byte, error) {
 			return [][]byte{sample.Payload}, nil
 		}
 	}
 
 	t.queue = make(chan *procEntryFMP4)
+	t.chClose = make(chan struct{})
+	t.done = make(chan struct{})
 
 	return nil
 }
 
 func (t *clientTrackProcessorFMP4) run(ctx context.Context) error {
+	defer close(t.done)
+
 	for {
 		select {
 		case entry := <-t.queue:
@@ -76,16 +84,34 @@ func (t *clientTrackProcessorFMP4) run(ctx context.Context) error {
 				return err
 			}
 
+		case <-t.chClose:
+			return nil
+
 		case <-ctx.Done():
 			return nil
 		}
 	}
 }
 
+func (t *clientTrackProcessorFMP4) close() {
+	close(t.chClose)
+}
+
+// wait waits for the processor to exit.
+func (t *clientTrackProcessorFMP4) wait() {
+	<-t.done
+}
+
 func (t *clientTrackProcessorFMP4) process(ctx context.Context, entry *procEntryFMP4) error {
 	dts := entry.dts
 
 	for _, sample := range entry.partTrack.Samples {
+		// I-frame playlists may point to fragments that contain additional samples
+		if t.iframesOnly && sample.IsNonSyncSample {
+			dts += int64(sample.Duration)
+			continue
+		}
+
 		data, err := t.decodePayload(sample)
 		if err != nil {
 			return err
@@ -116,6 +142,6 @@ func (t *clientTrackProcessorFMP4) push(ctx context.Context, entry *procEntryFMP
 		return nil
 
 	case <-ctx.Done():
-		return fmt.Errorf("terminated")
+		return context.Cause(ctx)
 	}
 }
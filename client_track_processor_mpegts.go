@@ -2,7 +2,6 @@ package gohlslib
 
 import (
 	"context"
-	"fmt"
 	"time"
 )
 
@@ -57,6 +56,6 @@ func (t *clientTrackProcessorMPEGTS) push(ctx context.Context, entry *procEntryM
 		return nil
 
 	case <-ctx.Done():
-		return fmt.Errorf("terminated")
+		return context.Cause(ctx)
 	}
 }
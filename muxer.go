@@ -1,11 +1,13 @@
 package gohlslib
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -23,8 +25,18 @@ const (
 	multivariantPlaylistMaxAge = "30"
 	initMaxAge                 = "30"
 	segmentMaxAge              = "3600"
+	followerMaxPendingSamples  = 256
 )
 
+// ErrMuxerClosed is returned by Write*() when the muxer has been closed with Close().
+var ErrMuxerClosed = errors.New("muxer closed")
+
+// ErrMuxerEnded is returned by Write*() when the stream has been ended with End().
+var ErrMuxerEnded = errors.New("muxer ended")
+
+// ErrMuxerSegmentTooBig is returned by Write*() when a segment exceeds SegmentMaxSize.
+var ErrMuxerSegmentTooBig = errors.New("reached maximum segment size")
+
 func ptrOf[T any](v T) *T {
 	return &v
 }
@@ -93,6 +105,15 @@ func areAllAudio(tracks []*muxerTrack) bool {
 	return true
 }
 
+// wakeUpOnDone wakes up routines that are waiting on cond when ctx is done.
+func wakeUpOnDone(ctx context.Context, mutex *sync.Mutex, cond *sync.Cond) func() bool {
+	return context.AfterFunc(ctx, func() {
+		mutex.Lock()
+		defer mutex.Unlock()
+		cond.Broadcast()
+	})
+}
+
 // a prefix is needed to prevent usage of cached segments
 // from previous muxing sessions.
 func generatePrefix() (string, error) {
@@ -109,21 +130,27 @@ func mediaPlaylistPath(streamID string) string {
 	return streamID + "_stream.m3u8"
 }
 
+func iframePlaylistPath(streamID string) string {
+	return streamID + "_iframes.m3u8"
+}
+
 func initFilePath(prefix string, streamID string) string {
 	return prefix + "_" + streamID + "_init.mp4"
 }
 
-func segmentPath(prefix string, streamID string, segmentID uint64, mp4 bool) string {
-	if mp4 {
-		return prefix + "_" + streamID + "_seg" + strconv.FormatUint(segmentID, 10) + ".mp4"
-	}
-	return prefix + "_" + streamID + "_seg" + strconv.FormatUint(segmentID, 10) + ".ts"
+func segmentPath(prefix string, streamID string, segmentID uint64, ext string) string {
+	return prefix + "_" + streamID + "_seg" + strconv.FormatUint(segmentID, 10) + ext
 }
 
 func partPath(prefix string, streamID string, partID uint64) string {
 	return prefix + "_" + streamID + "_part" + strconv.FormatUint(partID, 10) + ".mp4"
 }
 
+func isSubtitles(c codecs.Codec) bool {
+	_, ok := c.(*codecs.WebVTT)
+	return ok
+}
+
 func fmp4TimeScale(c codecs.Codec) uint32 {
 	switch codec := c.(type) {
 	case *codecs.MPEG4Audio:
@@ -144,6 +171,17 @@ func (w *switchableWriter) Write(p []byte) (int, error) {
 	return w.w.Write(p)
 }
 
+type countingWriter struct {
+	w io.Writer
+	n uint64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += uint64(n)
+	return n, err
+}
+
 // MuxerOnEncodeErrorFunc is the prototype of Muxer.OnEncodeError.
 type MuxerOnEncodeErrorFunc func(err error)
 
@@ -153,6 +191,9 @@ type Muxer struct {
 	// parameters (all optional except Tracks).
 	//
 	// tracks.
+	// When there are multiple video tracks, segments of additional video tracks
+	// are switched together with the ones of the first video track, therefore
+	// additional video tracks must have random access samples at the same timestamps.
 	Tracks []*Track
 	// Variant to use.
 	// It defaults to MuxerVariantLowLatency
@@ -162,6 +203,14 @@ type Muxer struct {
 	// Their number doesn't influence latency.
 	// It defaults to 7.
 	SegmentCount int
+	// Type of media playlists.
+	// It defaults to MuxerPlaylistTypeLive.
+	PlaylistType MuxerPlaylistType
+	// Time span covered by segments kept on the server (DVR window).
+	// When it is set, old segments are deleted when they exit the window,
+	// and SegmentCount becomes the minimum number of segments.
+	// It is supported by live playlists only.
+	DVRWindow time.Duration
 	// Minimum duration of each segment.
 	// This is adjusted in order to include at least one IDR frame in each segment.
 	// A player usually puts 3 segments in a buffer before reproducing the stream.
@@ -180,36 +229,103 @@ type Muxer struct {
 	// Directory in which to save segments.
 	// This decreases performance, since saving segments on disk is less performant
 	// than saving them on RAM, but allows to preserve RAM.
+	// It is required by EVENT and VOD playlists.
 	Directory string
+	// Destination into which playlists, init files and segments are written
+	// as soon as they are available, in order to serve the stream with
+	// a static web server or a CDN origin, in addition to Handle().
+	// It is not supported by the Low-Latency variant.
+	// Files are written by a dedicated routine and errors are reported through OnEncodeError.
+	Sink MuxerSink
+	// Generate an I-frame playlist, that allows clients to perform
+	// trick play or to generate thumbnails.
+	// It is used only when there's a video track.
+	IFramePlaylist bool
+	// CEA-608 closed caption channels carried by video tracks.
+	// Caption data is provided with WriteCEA608().
+	ClosedCaptions []*MuxerClosedCaptions
+	// Enable timed metadata, that is written with WriteMetadata().
+	// It is not supported by the Packed Audio variant.
+	TimedMetadata bool
+	// Scheme ID URI of emsg boxes that carry timed metadata
+	// in the fMP4 and Low-Latency variants.
+	// It defaults to "https://aomedia.org/emsg/ID3".
+	MetadataSchemeIDURI string
+	// Emit EXT-X-CUE-OUT and EXT-X-CUE-IN tags in addition to
+	// date ranges that contain SCTE35-OUT and SCTE35-IN.
+	LegacyCueTags bool
+	// Encryption method of segments.
+	// Init files are not encrypted.
+	// It is not supported by the Low-Latency variant and by I-frame playlists.
+	// It defaults to MuxerEncryptionNone.
+	Encryption MuxerEncryption
+	// Provider of encryption keys.
+	// It defaults to a provider that generates random keys.
+	KeyProvider MuxerKeyProvider
+	// Number of segments after which the encryption key is changed.
+	// It defaults to 0, that means that the key is never changed.
+	KeyRotationSegments int
+	// URI of encryption keys, in which "{id}" is replaced with the key ID.
+	// When it is set, keys must be served by the application.
+	// It defaults to serving keys through Handle().
+	KeyURITemplate string
+	// KEYFORMAT attribute of encryption keys, that identifies the key system
+	// (for instance, "com.apple.streamingkeydelivery").
+	// It defaults to none, that means that keys are delivered as raw bytes.
+	KeyFormat string
+	// KEYFORMATVERSIONS attribute of encryption keys.
+	KeyFormatVersions string
+	// Logger used to report events.
+	// It defaults to slog.Default().
+	Logger *slog.Logger
 
 	//
 	// callbacks (all optional)
 	//
 	// called when a non-fatal encode error occurs.
+	// It defaults to logging the error with Logger.
 	OnEncodeError MuxerOnEncodeErrorFunc
 
 	//
 	// private
 	//
 
+	ctx            context.Context
+	ctxCancel      context.CancelCauseFunc
 	mutex          sync.Mutex
 	cond           *sync.Cond
 	mtracks        []*muxerTrack
 	mtracksByTrack map[*Track]*muxerTrack
 	streams        []*muxerStream
 	leadingStream  *muxerStream
+	leadingTrack   *muxerTrack
+	metadataID     uint32
+	dateRanges     *muxerDateRanges
 	prefix         string
 	storageFactory storage.Factory
 	segmenter      *muxerSegmenter
 	server         *muxerServer
+	sinkWriter     *muxerSinkWriter
 	closed         bool
+	writeMutex     sync.Mutex // serializes Write*(), AddDateRange() and End()
+	ended          bool       // protected by writeMutex
 }
 
 // Start initializes the muxer.
 func (m *Muxer) Start() error {
+	return m.StartContext(context.Background())
+}
+
+// StartContext initializes the muxer.
+// When the context is canceled, the muxer is closed and
+// Write*() return the cancellation cause, as returned by context.Cause().
+func (m *Muxer) StartContext(ctx context.Context) error {
 	if m.Variant == 0 {
 		m.Variant = MuxerVariantLowLatency
 	}
+	if m.PlaylistType == 0 {
+		m.PlaylistType = MuxerPlaylistTypeLive
+	}
 	if m.SegmentCount == 0 {
 		m.SegmentCount = 7
 	}
@@ -222,47 +338,87 @@ func (m *Muxer) Start() error {
 	if m.SegmentMaxSize == 0 {
 		m.SegmentMaxSize = 50 * 1024 * 1024
 	}
+	if m.MetadataSchemeIDURI == "" {
+		m.MetadataSchemeIDURI = muxerDefaultMetadataSchemeIDURI
+	}
+	if m.Logger == nil {
+		m.Logger = slog.Default()
+	}
 	if m.OnEncodeError == nil {
 		m.OnEncodeError = func(e error) {
-			log.Printf("%v", e)
+			m.Logger.Warn("encode error", "error", e)
 		}
 	}
 
-	if len(m.Tracks) == 0 {
-		return fmt.Errorf("at least one track must be provided")
+	// subtitle tracks do not take part in segmentation
+	var mediaTracks []*Track
+	for _, track := range m.Tracks {
+		if !isSubtitles(track.Codec) {
+			mediaTracks = append(mediaTracks, track)
+		}
+	}
+
+	if len(mediaTracks) == 0 {
+		return fmt.Errorf("at least one video or audio track must be provided")
+	}
+
+	if len(mediaTracks) != len(m.Tracks) && m.Variant == MuxerVariantLowLatency {
+		return fmt.Errorf("subtitles are not supported by the Low-Latency variant of HLS")
 	}
 
 	hasVideo := false
 	hasAudio := false
 
-	if m.Variant == MuxerVariantMPEGTS {
-		for _, track := range m.Tracks {
+	switch m.Variant {
+	case MuxerVariantPackedAudio:
+		if len(mediaTracks) != 1 {
+			return fmt.Errorf("the Packed Audio variant of HLS supports a single track only")
+		}
+		switch mediaTracks[0].Codec.(type) {
+		case *codecs.MPEG4Audio, *codecs.MPEG1Audio:
+		default:
+			return fmt.Errorf(
+				"the Packed Audio variant of HLS supports MPEG-4 Audio and MPEG-1 Audio only: %w", ErrUnsupportedCodec)
+		}
+
+	case MuxerVariantMPEGTS:
+		for _, track := range mediaTracks {
 			if track.Codec.IsVideo() {
 				if hasVideo {
 					return fmt.Errorf("the MPEG-TS variant of HLS supports a single video track only")
 				}
-				if _, ok := track.Codec.(*codecs.H264); !ok {
+				switch track.Codec.(type) {
+				case *codecs.H265, *codecs.H264:
+				default:
 					return fmt.Errorf(
-						"the MPEG-TS variant of HLS supports H264 video only")
+						"the MPEG-TS variant of HLS supports H265 and H264 video only: %w", ErrUnsupportedCodec)
 				}
 				hasVideo = true
 			} else {
 				if hasAudio {
 					return fmt.Errorf("the MPEG-TS variant of HLS supports a single audio track only")
 				}
-				if _, ok := track.Codec.(*codecs.MPEG4Audio); !ok {
+				switch track.Codec.(type) {
+				case *codecs.Opus, *codecs.MPEG4Audio, *codecs.MPEG1Audio, *codecs.AC3:
+				default:
 					return fmt.Errorf(
-						"the MPEG-TS variant of HLS supports MPEG-4 Audio only")
+						"the MPEG-TS variant of HLS supports Opus, MPEG-4 Audio, MPEG-1 Audio and AC-3 only: %w",
+						ErrUnsupportedCodec)
 				}
 				hasAudio = true
 			}
 		}
-	} else {
-		for _, track := range m.Tracks {
+
+	default:
+		for _, track := range mediaTracks {
+			switch track.Codec.(type) {
+			case *codecs.MPEG1Audio, *codecs.AC3:
+				return fmt.Errorf(
+					"MPEG-1 Audio and AC-3 are supported by the MPEG-TS variant of HLS only: %w",
+					ErrUnsupportedCodec)
+			}
+
 			if track.Codec.IsVideo() {
-				if hasVideo {
-					return fmt.Errorf("only one video track is currently supported")
-				}
 				hasVideo = true
 			} else {
 				hasAudio = true //nolint:ineffassign,wastedassign
@@ -271,9 +427,30 @@ func (m *Muxer) Start() error {
 	}
 
 	hasDefaultAudio := false
+	hasDefaultVideo := false
+	hasDefaultSubtitles := false
+	hasVideoRenditions := false
 
 	for _, track := range m.Tracks {
-		if !track.Codec.IsVideo() && track.IsDefault {
+		if isSubtitles(track.Codec) {
+			if track.IsDefault {
+				if hasDefaultSubtitles {
+					return fmt.Errorf("multiple default subtitle tracks are not supported")
+				}
+				hasDefaultSubtitles = true
+			}
+		} else if track.Codec.IsVideo() {
+			if track.IsDefault {
+				if hasDefaultVideo {
+					return fmt.Errorf("multiple default video tracks are not supported")
+				}
+				hasDefaultVideo = true
+			}
+
+			if track.Name != "" {
+				hasVideoRenditions = true
+			}
+		} else if track.IsDefault {
 			if hasDefaultAudio {
 				return fmt.Errorf("multiple default audio tracks are not supported")
 			}
@@ -281,6 +458,13 @@ func (m *Muxer) Start() error {
 		}
 	}
 
+	if len(m.ClosedCaptions) != 0 {
+		err := m.checkClosedCaptions(hasVideo)
+		if err != nil {
+			return err
+		}
+	}
+
 	switch m.Variant {
 	case MuxerVariantLowLatency:
 		if m.SegmentCount < 7 {
@@ -293,33 +477,91 @@ func (m *Muxer) Start() error {
 		}
 	}
 
-	m.cond = sync.NewCond(&m.mutex)
-	m.mtracksByTrack = make(map[*Track]*muxerTrack)
+	if m.PlaylistType != MuxerPlaylistTypeLive {
+		if m.Directory == "" {
+			return fmt.Errorf("EVENT and VOD playlists require a Directory in which to save segments")
+		}
+		if m.DVRWindow != 0 {
+			return fmt.Errorf("DVR window is supported by live playlists only")
+		}
+		if m.PlaylistType == MuxerPlaylistTypeVOD && m.Variant == MuxerVariantLowLatency {
+			return fmt.Errorf("VOD playlists are not supported by the Low-Latency variant of HLS")
+		}
+	}
 
-	m.segmenter = &muxerSegmenter{
-		variant:            m.Variant,
-		segmentMinDuration: m.SegmentMinDuration,
-		partMinDuration:    m.PartMinDuration,
-		parent:             m,
+	if m.Sink != nil {
+		if m.Variant == MuxerVariantLowLatency {
+			return fmt.Errorf("sinks are not supported by the Low-Latency variant of HLS")
+		}
+		if m.Encryption != MuxerEncryptionNone && m.KeyURITemplate == "" {
+			return fmt.Errorf("sinks require KeyURITemplate when encryption is enabled")
+		}
 	}
-	m.segmenter.initialize()
+
+	if m.TimedMetadata && m.Variant == MuxerVariantPackedAudio {
+		return fmt.Errorf("timed metadata is not supported by the Packed Audio variant of HLS")
+	}
+
+	if m.Encryption != MuxerEncryptionNone {
+		if m.Variant == MuxerVariantLowLatency {
+			return fmt.Errorf("encryption is not supported by the Low-Latency variant of HLS")
+		}
+		if m.IFramePlaylist {
+			return fmt.Errorf("encryption is not supported by I-frame playlists")
+		}
+		if m.KeyProvider == nil {
+			m.KeyProvider = muxerRandomKeyProvider{}
+		}
+	}
+
+	if m.Encryption == MuxerEncryptionSampleAES {
+		err := m.checkSampleAES()
+		if err != nil {
+			return err
+		}
+	}
+
+	m.cond = sync.NewCond(&m.mutex)
+	m.mtracksByTrack = make(map[*Track]*muxerTrack)
 
 	m.server = &muxerServer{}
 	m.server.initialize()
 
 	m.server.registerPath("index.m3u8", m.handleMultivariantPlaylist)
 
-	for i, track := range m.Tracks {
+	leadingTrackChosen := false
+
+	for _, track := range m.Tracks {
+		// the first video track, or the first audio track when there's no video,
+		// is the leading track, that decides when segments are switched.
+		isLeading := !leadingTrackChosen && !isSubtitles(track.Codec) && (track.Codec.IsVideo() || !hasVideo)
+		if isLeading {
+			leadingTrackChosen = true
+		}
+
 		mtrack := &muxerTrack{
 			Track:     track,
 			variant:   m.Variant,
-			isLeading: track.Codec.IsVideo() || (!hasVideo && i == 0),
+			isLeading: isLeading,
 		}
 		mtrack.initialize()
 		m.mtracks = append(m.mtracks, mtrack)
 		m.mtracksByTrack[track] = mtrack
+
+		if isLeading {
+			m.leadingTrack = mtrack
+		}
 	}
 
+	m.segmenter = &muxerSegmenter{
+		variant:            m.Variant,
+		segmentMinDuration: m.SegmentMinDuration,
+		partMinDuration:    m.PartMinDuration,
+		leadingTrack:       m.leadingTrack,
+		parent:             m,
+	}
+	m.segmenter.initialize()
+
 	var err error
 	m.prefix, err = generatePrefix()
 	if err != nil {
@@ -332,27 +574,73 @@ func (m *Muxer) Start() error {
 		m.storageFactory = storage.NewFactoryRAM()
 	}
 
+	var keyring *muxerKeyring
+	if m.Encryption != MuxerEncryptionNone {
+		keyring = &muxerKeyring{
+			method:            m.Encryption,
+			keyProvider:       m.KeyProvider,
+			rotationSegments:  m.KeyRotationSegments,
+			uriTemplate:       m.KeyURITemplate,
+			keyFormat:         m.KeyFormat,
+			keyFormatVersions: m.KeyFormatVersions,
+			prefix:            m.prefix,
+			server:            m.server,
+		}
+		err = keyring.initialize()
+		if err != nil {
+			return err
+		}
+	}
+
+	if m.Sink != nil {
+		m.sinkWriter = &muxerSinkWriter{
+			sink:          m.Sink,
+			onEncodeError: m.OnEncodeError,
+		}
+		m.sinkWriter.initialize()
+	}
+
+	m.dateRanges = &muxerDateRanges{
+		legacyCueTags: m.LegacyCueTags,
+	}
+
 	// add initial gaps, required by iOS LL-HLS
 	nextSegmentID := uint64(0)
 	if m.Variant == MuxerVariantLowLatency {
 		nextSegmentID = 7
 	}
 
+	var mediaMtracks []*muxerTrack
+	for _, track := range m.mtracks {
+		if !isSubtitles(track.Codec) {
+			mediaMtracks = append(mediaMtracks, track)
+		}
+	}
+
 	switch m.Variant {
-	case MuxerVariantMPEGTS:
+	case MuxerVariantMPEGTS, MuxerVariantPackedAudio:
 		stream := &muxerStream{
 			isLeading:      true,
+			isVariant:      true,
 			variant:        m.Variant,
 			segmentMaxSize: m.SegmentMaxSize,
 			segmentCount:   m.SegmentCount,
+			playlistType:   m.PlaylistType,
+			dvrWindow:      m.DVRWindow,
 			onEncodeError:  m.OnEncodeError,
+			logger:         m.Logger.With("stream", "main"),
 			mutex:          &m.mutex,
 			cond:           m.cond,
 			prefix:         m.prefix,
 			storageFactory: m.storageFactory,
 			server:         m.server,
-			tracks:         m.mtracks,
+			tracks:         mediaMtracks,
 			id:             "main",
+			iframePlaylist: m.IFramePlaylist && hasVideo,
+			keyring:        keyring,
+			timedMetadata:  m.TimedMetadata,
+			dateRanges:     m.dateRanges,
+			sinkWriter:     m.sinkWriter,
 			nextSegmentID:  nextSegmentID,
 		}
 		err = stream.initialize()
@@ -365,27 +653,49 @@ func (m *Muxer) Start() error {
 		defaultAudioChosen := false
 
 		for i, track := range m.mtracks {
-			var id string
-			if track.Codec.IsVideo() {
-				id = "video" + strconv.FormatInt(int64(i+1), 10)
-			} else {
-				id = "audio" + strconv.FormatInt(int64(i+1), 10)
+			if isSubtitles(track.Codec) {
+				continue
 			}
 
-			isRendition := !track.isLeading || (!track.Codec.IsVideo() && len(m.Tracks) > 1)
+			var id string
+			var isRendition bool
+			var isVariant bool
 			isDefault := false
 			name := ""
 
-			if isRendition {
-				if !hasDefaultAudio {
-					if !defaultAudioChosen {
-						defaultAudioChosen = true
-						isDefault = true
+			if track.Codec.IsVideo() {
+				id = "video" + strconv.FormatInt(int64(i+1), 10)
+
+				// video tracks are either alternate renditions of a single variant
+				// or variants with different bitrates.
+				isRendition = hasVideoRenditions
+				isVariant = track.isLeading || !hasVideoRenditions
+
+				if isRendition {
+					if !hasDefaultVideo {
+						isDefault = track.isLeading
+					} else {
+						isDefault = track.IsDefault
+					}
+				}
+			} else {
+				id = "audio" + strconv.FormatInt(int64(i+1), 10)
+				isRendition = !track.isLeading || len(mediaTracks) > 1
+				isVariant = track.isLeading
+
+				if isRendition {
+					if !hasDefaultAudio {
+						if !defaultAudioChosen {
+							defaultAudioChosen = true
+							isDefault = true
+						}
+					} else {
+						isDefault = track.IsDefault
 					}
-				} else {
-					isDefault = track.IsDefault
 				}
+			}
 
+			if isRendition {
 				if track.Name != "" {
 					name = track.Name
 				} else {
@@ -397,7 +707,10 @@ func (m *Muxer) Start() error {
 				variant:        m.Variant,
 				segmentMaxSize: m.SegmentMaxSize,
 				segmentCount:   m.SegmentCount,
+				playlistType:   m.PlaylistType,
+				dvrWindow:      m.DVRWindow,
 				onEncodeError:  m.OnEncodeError,
+				logger:         m.Logger.With("stream", id),
 				mutex:          &m.mutex,
 				cond:           m.cond,
 				prefix:         m.prefix,
@@ -406,10 +719,16 @@ func (m *Muxer) Start() error {
 				tracks:         []*muxerTrack{track},
 				id:             id,
 				isLeading:      track.isLeading,
+				isVariant:      isVariant,
 				isRendition:    isRendition,
 				name:           name,
 				language:       track.Language,
 				isDefault:      isDefault,
+				iframePlaylist: m.IFramePlaylist && track.Codec.IsVideo() && isVariant,
+				keyring:        keyring,
+				timedMetadata:  m.TimedMetadata,
+				dateRanges:     m.dateRanges,
+				sinkWriter:     m.sinkWriter,
 				nextSegmentID:  nextSegmentID,
 			}
 			err = stream.initialize()
@@ -420,6 +739,61 @@ func (m *Muxer) Start() error {
 		}
 	}
 
+	defaultSubtitlesChosen := false
+
+	for i, track := range m.mtracks {
+		if !isSubtitles(track.Codec) {
+			continue
+		}
+
+		id := "subtitles" + strconv.FormatInt(int64(i+1), 10)
+
+		isDefault := false
+		if !hasDefaultSubtitles {
+			if !defaultSubtitlesChosen {
+				defaultSubtitlesChosen = true
+				isDefault = true
+			}
+		} else {
+			isDefault = track.IsDefault
+		}
+
+		name := track.Name
+		if name == "" {
+			name = id
+		}
+
+		stream := &muxerStream{
+			variant:        m.Variant,
+			segmentMaxSize: m.SegmentMaxSize,
+			segmentCount:   m.SegmentCount,
+			playlistType:   m.PlaylistType,
+			dvrWindow:      m.DVRWindow,
+			onEncodeError:  m.OnEncodeError,
+			logger:         m.Logger.With("stream", id),
+			mutex:          &m.mutex,
+			cond:           m.cond,
+			prefix:         m.prefix,
+			storageFactory: m.storageFactory,
+			server:         m.server,
+			tracks:         []*muxerTrack{track},
+			id:             id,
+			isRendition:    true,
+			name:           name,
+			language:       track.Language,
+			isDefault:      isDefault,
+			isForced:       track.IsForced,
+			dateRanges:     m.dateRanges,
+			sinkWriter:     m.sinkWriter,
+			nextSegmentID:  nextSegmentID,
+		}
+		err = stream.initialize()
+		if err != nil {
+			return err
+		}
+		m.streams = append(m.streams, stream)
+	}
+
 	m.leadingStream = func() *muxerStream {
 		for _, stream := range m.streams {
 			if stream.isLeading {
@@ -429,13 +803,71 @@ func (m *Muxer) Start() error {
 		return nil
 	}()
 
+	if m.sinkWriter != nil {
+		m.sinkWriter.start()
+	}
+
+	m.ctx, m.ctxCancel = context.WithCancelCause(ctx)
+	context.AfterFunc(m.ctx, m.close)
+
+	return nil
+}
+
+func (m *Muxer) checkSampleAES() error {
+	switch m.Variant {
+	case MuxerVariantPackedAudio:
+		return fmt.Errorf("SAMPLE-AES is not supported by the Packed Audio variant of HLS")
+
+	case MuxerVariantMPEGTS:
+		for _, track := range m.Tracks {
+			switch track.Codec.(type) {
+			case *codecs.H264, *codecs.MPEG4Audio, *codecs.WebVTT:
+			default:
+				return fmt.Errorf(
+					"SAMPLE-AES in the MPEG-TS variant of HLS supports H264 and MPEG-4 Audio only: %w",
+					ErrUnsupportedCodec)
+			}
+		}
+
+	default:
+		if m.KeyRotationSegments != 0 {
+			return fmt.Errorf("key rotation is not supported by SAMPLE-AES in the fMP4 variants of HLS")
+		}
+
+		for _, track := range m.Tracks {
+			switch track.Codec.(type) {
+			case *codecs.H265, *codecs.H264, *codecs.Opus, *codecs.MPEG4Audio, *codecs.WebVTT:
+			default:
+				return fmt.Errorf(
+					"SAMPLE-AES in the fMP4 variants of HLS supports H265, H264, Opus and MPEG-4 Audio only: %w",
+					ErrUnsupportedCodec)
+			}
+		}
+	}
+
 	return nil
 }
 
 // Close closes a Muxer.
+// It can be called even when Start() has failed.
 func (m *Muxer) Close() {
+	// Start() has failed or has not been called
+	if m.ctxCancel == nil {
+		return
+	}
+
+	m.ctxCancel(ErrMuxerClosed)
+	m.close()
+}
+
+func (m *Muxer) close() {
 	m.mutex.Lock()
 
+	if m.closed {
+		m.mutex.Unlock()
+		return
+	}
+
 	m.closed = true
 
 	for _, stream := range m.streams {
@@ -445,6 +877,127 @@ func (m *Muxer) Close() {
 	m.mutex.Unlock()
 
 	m.cond.Broadcast()
+
+	if m.sinkWriter != nil {
+		m.sinkWriter.close()
+	}
+}
+
+// End ends the stream.
+// Segments in progress are finalized, EXT-X-ENDLIST is appended to media playlists
+// and the finished stream keeps being served until Close() is called.
+// The last access unit of each track is included into segments.
+// Since its duration is unknown, it is assumed to be equal to the one of the previous access unit,
+// except for audio in the MPEG-TS and Packed Audio variants, whose duration is computed from the codec.
+// When Sink is set, End() returns once all files have been written into it.
+func (m *Muxer) End() error {
+	if m.ctx.Err() != nil {
+		return context.Cause(m.ctx)
+	}
+
+	m.writeMutex.Lock()
+	defer m.writeMutex.Unlock()
+
+	if m.ended {
+		return ErrMuxerEnded
+	}
+
+	m.ended = true
+
+	var endDTS time.Duration
+
+	if m.Variant == MuxerVariantMPEGTS || m.Variant == MuxerVariantPackedAudio {
+		endDTS = m.leadingTrack.endDTS
+	} else {
+		var err error
+		endDTS, err = m.fmp4WriteLastSamples()
+		if err != nil {
+			return err
+		}
+	}
+
+	m.mutex.Lock()
+	err := m.endInner(endDTS)
+	m.mutex.Unlock()
+
+	m.cond.Broadcast()
+
+	if err != nil {
+		return err
+	}
+
+	// wait until the final playlists have been written into the sink
+	if m.sinkWriter != nil {
+		m.sinkWriter.flush()
+	}
+
+	return nil
+}
+
+// fmp4WriteLastSamples writes pending samples of all tracks
+// and returns the DTS of the end of the leading track.
+func (m *Muxer) fmp4WriteLastSamples() (time.Duration, error) {
+	var endDTS time.Duration
+
+	// the leading track goes first, since it decides the last segment boundary
+	if m.leadingTrack.fmp4NextSample != nil {
+		var err error
+		endDTS, err = m.segmenter.fmp4WriteLastSample(m.leadingTrack)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	for _, track := range m.mtracks {
+		if track.isLeading {
+			continue
+		}
+
+		if track.fmp4NextSample != nil {
+			_, err := m.segmenter.fmp4WriteLastSample(track)
+			if err != nil {
+				return 0, err
+			}
+		}
+
+		if len(track.fmp4FollowerSamples) != 0 {
+			err := m.segmenter.fmp4WriteFollowerSamples(track)
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	return endDTS, nil
+}
+
+func (m *Muxer) endInner(endDTS time.Duration) error {
+	err := m.leadingStream.end(endDTS)
+	if err != nil {
+		return err
+	}
+
+	for _, stream := range m.streams {
+		if !stream.isLeading {
+			err = stream.end(endDTS)
+			if err != nil {
+				return err
+			}
+
+			if stream.isVideoFollower() {
+				stream.targetDuration = max(stream.targetDuration, targetDuration(stream.segments))
+			} else {
+				stream.targetDuration = m.leadingStream.targetDuration
+			}
+			stream.partTargetDuration = m.leadingStream.partTargetDuration
+		}
+	}
+
+	m.dateRanges.removeExpired(uint64(m.leadingStream.segmentDeleteCount))
+	m.pruneKeys()
+	m.writeSinkPlaylists()
+
+	return nil
 }
 
 // WriteAV1 writes an AV1 temporal unit.
@@ -454,6 +1007,17 @@ func (m *Muxer) WriteAV1(
 	pts int64,
 	tu [][]byte,
 ) error {
+	if m.ctx.Err() != nil {
+		return context.Cause(m.ctx)
+	}
+
+	m.writeMutex.Lock()
+	defer m.writeMutex.Unlock()
+
+	if m.ended {
+		return ErrMuxerEnded
+	}
+
 	return m.segmenter.writeAV1(m.mtracksByTrack[track], ntp, pts, tu)
 }
 
@@ -464,6 +1028,17 @@ func (m *Muxer) WriteVP9(
 	pts int64,
 	frame []byte,
 ) error {
+	if m.ctx.Err() != nil {
+		return context.Cause(m.ctx)
+	}
+
+	m.writeMutex.Lock()
+	defer m.writeMutex.Unlock()
+
+	if m.ended {
+		return ErrMuxerEnded
+	}
+
 	return m.segmenter.writeVP9(m.mtracksByTrack[track], ntp, pts, frame)
 }
 
@@ -474,7 +1049,21 @@ func (m *Muxer) WriteH265(
 	pts int64,
 	au [][]byte,
 ) error {
-	return m.segmenter.writeH265(m.mtracksByTrack[track], ntp, pts, au)
+	if m.ctx.Err() != nil {
+		return context.Cause(m.ctx)
+	}
+
+	m.writeMutex.Lock()
+	defer m.writeMutex.Unlock()
+
+	if m.ended {
+		return ErrMuxerEnded
+	}
+
+	mtrack := m.mtracksByTrack[track]
+	au = m.insertClosedCaptions(mtrack, au)
+
+	return m.segmenter.writeH265(mtrack, ntp, pts, au)
 }
 
 // WriteH264 writes an H264 access unit.
@@ -484,7 +1073,21 @@ func (m *Muxer) WriteH264(
 	pts int64,
 	au [][]byte,
 ) error {
-	return m.segmenter.writeH264(m.mtracksByTrack[track], ntp, pts, au)
+	if m.ctx.Err() != nil {
+		return context.Cause(m.ctx)
+	}
+
+	m.writeMutex.Lock()
+	defer m.writeMutex.Unlock()
+
+	if m.ended {
+		return ErrMuxerEnded
+	}
+
+	mtrack := m.mtracksByTrack[track]
+	au = m.insertClosedCaptions(mtrack, au)
+
+	return m.segmenter.writeH264(mtrack, ntp, pts, au)
 }
 
 // WriteOpus writes Opus packets.
@@ -494,6 +1097,17 @@ func (m *Muxer) WriteOpus(
 	pts int64,
 	packets [][]byte,
 ) error {
+	if m.ctx.Err() != nil {
+		return context.Cause(m.ctx)
+	}
+
+	m.writeMutex.Lock()
+	defer m.writeMutex.Unlock()
+
+	if m.ended {
+		return ErrMuxerEnded
+	}
+
 	return m.segmenter.writeOpus(m.mtracksByTrack[track], ntp, pts, packets)
 }
 
@@ -504,9 +1118,237 @@ func (m *Muxer) WriteMPEG4Audio(
 	pts int64,
 	aus [][]byte,
 ) error {
+	if m.ctx.Err() != nil {
+		return context.Cause(m.ctx)
+	}
+
+	m.writeMutex.Lock()
+	defer m.writeMutex.Unlock()
+
+	if m.ended {
+		return ErrMuxerEnded
+	}
+
 	return m.segmenter.writeMPEG4Audio(m.mtracksByTrack[track], ntp, pts, aus)
 }
 
+// WriteMPEG1Audio writes MPEG-1 Audio frames.
+// It is supported by the MPEG-TS and Packed Audio variants only.
+func (m *Muxer) WriteMPEG1Audio(
+	track *Track,
+	ntp time.Time,
+	pts int64,
+	frames [][]byte,
+) error {
+	if m.ctx.Err() != nil {
+		return context.Cause(m.ctx)
+	}
+
+	m.writeMutex.Lock()
+	defer m.writeMutex.Unlock()
+
+	if m.ended {
+		return ErrMuxerEnded
+	}
+
+	return m.segmenter.writeMPEG1Audio(m.mtracksByTrack[track], ntp, pts, frames)
+}
+
+// WriteAC3 writes an AC-3 frame.
+// It is supported by the MPEG-TS variant only.
+func (m *Muxer) WriteAC3(
+	track *Track,
+	ntp time.Time,
+	pts int64,
+	frame []byte,
+) error {
+	if m.ctx.Err() != nil {
+		return context.Cause(m.ctx)
+	}
+
+	m.writeMutex.Lock()
+	defer m.writeMutex.Unlock()
+
+	if m.ended {
+		return ErrMuxerEnded
+	}
+
+	return m.segmenter.writeAC3(m.mtracksByTrack[track], ntp, pts, frame)
+}
+
+// WriteWebVTT writes a WebVTT cue.
+// Cues are placed into the segments they overlap, whose boundaries are decided by other tracks.
+func (m *Muxer) WriteWebVTT(
+	track *Track,
+	cue *MuxerWebVTTCue,
+) error {
+	if m.ctx.Err() != nil {
+		return context.Cause(m.ctx)
+	}
+
+	m.writeMutex.Lock()
+	defer m.writeMutex.Unlock()
+
+	if m.ended {
+		return ErrMuxerEnded
+	}
+
+	if !isSubtitles(track.Codec) {
+		return fmt.Errorf("track is not a WebVTT track")
+	}
+
+	err := cue.validate()
+	if err != nil {
+		return err
+	}
+
+	mtrack := m.mtracksByTrack[track]
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	mtrack.stream.webvttCues = append(mtrack.stream.webvttCues, &muxerWebVTTCue{
+		MuxerWebVTTCue: *cue,
+		start:          timestampToDuration(cue.PTS, track.ClockRate),
+		end:            timestampToDuration(cue.PTS+cue.Duration, track.ClockRate),
+	})
+
+	return nil
+}
+
+// WriteCEA608 writes CEA-608 caption data of a given field (1 or 2),
+// in the form of byte pairs.
+// Data is inserted as SEI user data into the next access units
+// written with WriteH264() or WriteH265().
+func (m *Muxer) WriteCEA608(
+	track *Track,
+	field int,
+	data []byte,
+) error {
+	if m.ctx.Err() != nil {
+		return context.Cause(m.ctx)
+	}
+
+	m.writeMutex.Lock()
+	defer m.writeMutex.Unlock()
+
+	if m.ended {
+		return ErrMuxerEnded
+	}
+
+	if len(m.ClosedCaptions) == 0 {
+		return fmt.Errorf("closed captions are not enabled")
+	}
+
+	switch track.Codec.(type) {
+	case *codecs.H265, *codecs.H264:
+	default:
+		return fmt.Errorf("closed captions can be attached to H265 and H264 tracks only")
+	}
+
+	if field != 1 && field != 2 {
+		return fmt.Errorf("invalid field: %d", field)
+	}
+
+	if (len(data) % 2) != 0 {
+		return fmt.Errorf("caption data must be made of byte pairs")
+	}
+
+	mtrack := m.mtracksByTrack[track]
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for i := 0; i < len(data); i += 2 {
+		// cc_valid, cc_type
+		mtrack.pendingCEA608 = append(mtrack.pendingCEA608, 0xFC|byte(field-1), data[i], data[i+1])
+	}
+
+	return nil
+}
+
+// WriteMetadata writes a timed metadata payload, usually an ID3 tag.
+// PTS is expressed in the clock rate of the leading track, that is
+// the first video track, or the first audio track when there's no video.
+// Payloads are carried by ID3 PES packets in the MPEG-TS variant
+// and by emsg boxes in the fMP4 and Low-Latency variants.
+// Payloads written before the first segment are discarded.
+// When ntp is not zero, the payload is placed at the position of its absolute timestamp,
+// in order to be consistent with the EXT-X-PROGRAM-DATE-TIME of segments;
+// otherwise, it is placed at the position of PTS.
+func (m *Muxer) WriteMetadata(
+	ntp time.Time,
+	pts int64,
+	payload []byte,
+) error {
+	if m.ctx.Err() != nil {
+		return context.Cause(m.ctx)
+	}
+
+	m.writeMutex.Lock()
+	defer m.writeMutex.Unlock()
+
+	if m.ended {
+		return ErrMuxerEnded
+	}
+
+	if !m.TimedMetadata {
+		return fmt.Errorf("timed metadata is not enabled")
+	}
+
+	dts := timestampToDuration(pts, m.leadingTrack.ClockRate)
+
+	if !ntp.IsZero() {
+		if startDTS, startNTP, ok := m.leadingStream.nextSegmentStart(); ok {
+			dts = startDTS + ntp.Sub(startNTP)
+		}
+	}
+
+	for _, stream := range m.streams {
+		if stream.isSubtitles() {
+			continue
+		}
+
+		err := stream.writeMetadata(m.metadataID, m.MetadataSchemeIDURI, dts, payload)
+		if err != nil {
+			return err
+		}
+	}
+
+	m.metadataID++
+
+	return nil
+}
+
+// AddDateRange adds a date range, that is inserted into media playlists
+// when the segment that follows its PTS is created.
+func (m *Muxer) AddDateRange(dr *MuxerDateRange) error {
+	if m.ctx.Err() != nil {
+		return context.Cause(m.ctx)
+	}
+
+	m.writeMutex.Lock()
+	defer m.writeMutex.Unlock()
+
+	if m.ended {
+		return ErrMuxerEnded
+	}
+
+	err := dr.validate()
+	if err != nil {
+		return err
+	}
+
+	dts := timestampToDuration(dr.PTS, m.leadingTrack.ClockRate) + m.leadingStream.timeOffset()
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.dateRanges.add(dr, dts)
+
+	return nil
+}
+
 // Handle handles a HTTP request.
 func (m *Muxer) Handle(w http.ResponseWriter, r *http.Request) {
 	m.server.handle(w, r)
@@ -514,15 +1356,60 @@ func (m *Muxer) Handle(w http.ResponseWriter, r *http.Request) {
 
 func (m *Muxer) createFirstSegment(nextDTS time.Duration, nextNTP time.Time) error {
 	for _, stream := range m.streams {
+		// additional video streams create their first segment independently
+		if stream.isVideoFollower() {
+			continue
+		}
+
 		err := stream.createFirstSegment(nextDTS, nextNTP)
 		if err != nil {
 			return err
 		}
 	}
 
+	m.mutex.Lock()
+	m.addFollowerBoundaries(nextDTS, nextNTP, false)
+	m.dateRanges.activate(m.leadingStream.nextSegmentID, nextDTS, nextNTP)
+	m.mutex.Unlock()
+
 	return nil
 }
 
+// addFollowerBoundaries notifies additional video streams that the leading stream
+// has started a segment.
+func (m *Muxer) addFollowerBoundaries(nextDTS time.Duration, nextNTP time.Time, force bool) {
+	for _, stream := range m.streams {
+		if !stream.isVideoFollower() {
+			continue
+		}
+
+		// drop boundaries of segments that are not listed anymore
+		// if the stream has not started yet
+		if stream.nextSegment == nil {
+			n := 0
+			for n < len(stream.followerBoundaries) &&
+				stream.followerBoundaries[n].segmentID < uint64(m.leadingStream.segmentDeleteCount) {
+				n++
+			}
+			stream.followerBoundaries = stream.followerBoundaries[n:]
+		}
+
+		stream.followerBoundaries = append(stream.followerBoundaries, &muxerSegmentBoundary{
+			segmentID: m.leadingStream.nextSegmentID,
+			dts:       nextDTS,
+			ntp:       nextNTP,
+			force:     force,
+		})
+	}
+}
+
+func (m *Muxer) dateRangeReached(nextDTS time.Duration) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.dateRanges.spliceReached(nextDTS)
+}
+
 func (m *Muxer) rotateParts(nextDTS time.Duration) error {
 	m.mutex.Lock()
 	err := m.rotatePartsInner(nextDTS)
@@ -544,7 +1431,8 @@ func (m *Muxer) rotatePartsInner(nextDTS time.Duration) error {
 	}
 
 	for _, stream := range m.streams {
-		if !stream.isLeading {
+		// additional video streams may not have started yet
+		if !stream.isLeading && stream.nextSegment != nil {
 			err = stream.rotateParts(nextDTS, true)
 			if err != nil {
 				return err
@@ -585,7 +1473,8 @@ func (m *Muxer) rotateSegmentsInner(
 	}
 
 	for _, stream := range m.streams {
-		if !stream.isLeading {
+		// additional video streams switch segment when they reach the boundary
+		if !stream.isLeading && !stream.isVideoFollower() {
 			err = stream.rotateSegments(nextDTS, nextNTP, force)
 			if err != nil {
 				return err
@@ -595,20 +1484,132 @@ func (m *Muxer) rotateSegmentsInner(
 		}
 	}
 
+	m.addFollowerBoundaries(nextDTS, nextNTP, force)
+	m.dateRanges.activate(m.leadingStream.nextSegmentID, nextDTS, nextNTP)
+	m.dateRanges.removeExpired(uint64(m.leadingStream.segmentDeleteCount))
+	m.pruneKeys()
+
+	m.writeSinkPlaylists()
+
 	return nil
 }
 
+// pruneKeys removes keys that are not used anymore by segments of any stream.
+func (m *Muxer) pruneKeys() {
+	if m.leadingStream.keyring == nil {
+		return
+	}
+
+	oldestSegmentID := uint64(m.leadingStream.segmentDeleteCount)
+
+	for _, stream := range m.streams {
+		// streams may list older segments than the leading one
+		// (for instance, additional video tracks that are late).
+		if len(stream.segments) != 0 {
+			oldestSegmentID = min(oldestSegmentID, uint64(stream.segmentDeleteCount))
+		}
+	}
+
+	m.leadingStream.keyring.prune(oldestSegmentID)
+}
+
+// rotateFollowerSegments switches segment of an additional video stream
+// when a sample reaches a segment boundary of the leading stream.
+// It returns false when the sample precedes the first segment of the stream.
+func (m *Muxer) rotateFollowerSegments(
+	stream *muxerStream,
+	dts time.Duration,
+	randomAccess bool,
+	paramsChanged bool,
+) (bool, error) {
+	m.mutex.Lock()
+	rotated, err := m.rotateFollowerSegmentsInner(stream, dts, randomAccess, paramsChanged)
+	m.mutex.Unlock()
+
+	if err != nil {
+		return false, err
+	}
+
+	if rotated {
+		m.cond.Broadcast()
+	}
+
+	return stream.nextSegment != nil, nil
+}
+
+func (m *Muxer) rotateFollowerSegmentsInner(
+	stream *muxerStream,
+	dts time.Duration,
+	randomAccess bool,
+	paramsChanged bool,
+) (bool, error) {
+	rotated := false
+
+	for len(stream.followerBoundaries) != 0 && dts >= stream.followerBoundaries[0].dts {
+		b := stream.followerBoundaries[0]
+		stream.followerBoundaries = stream.followerBoundaries[1:]
+
+		// the first segment starts with the first random access sample placed on a boundary.
+		if stream.nextSegment == nil {
+			if dts != b.dts || !randomAccess {
+				continue
+			}
+
+			// use the same segment IDs of the leading stream
+			stream.nextSegmentID = b.segmentID
+			stream.segmentDeleteCount = int(b.segmentID)
+
+			err := stream.createFirstSegment(b.dts, b.ntp)
+			if err != nil {
+				return false, err
+			}
+
+			rotated = true
+			continue
+		}
+
+		if dts != b.dts || !randomAccess {
+			return false, fmt.Errorf("additional video track has no random access sample at %v, "+
+				"where the leading track switches segment", b.dts-fmp4StartDTS)
+		}
+
+		err := stream.rotateSegments(b.dts, b.ntp, b.force || paramsChanged)
+		if err != nil {
+			return false, err
+		}
+
+		stream.targetDuration = max(stream.targetDuration, targetDuration(stream.segments))
+		stream.partTargetDuration = m.leadingStream.partTargetDuration
+		rotated = true
+	}
+
+	if paramsChanged && !rotated && stream.nextSegment != nil {
+		return false, fmt.Errorf("additional video track changed parameters at %v, "+
+			"where the leading track does not switch segment", dts-fmp4StartDTS)
+	}
+
+	if rotated {
+		m.pruneKeys()
+		m.writeSinkPlaylists()
+	}
+
+	return rotated, nil
+}
+
 func (m *Muxer) handleMultivariantPlaylist(w http.ResponseWriter, r *http.Request) {
 	buf := func() []byte {
 		m.mutex.Lock()
 		defer m.mutex.Unlock()
 
+		stop := wakeUpOnDone(r.Context(), &m.mutex, m.cond)
+		defer stop()
+
 		for {
-			if m.closed {
+			if m.closed || r.Context().Err() != nil {
 				return nil
 			}
 
-			if m.streams[0].hasContent() {
+			if m.streams[0].playlistAvailable() {
 				break
 			}
 
@@ -637,29 +1638,68 @@ func (m *Muxer) handleMultivariantPlaylist(w http.ResponseWriter, r *http.Reques
 }
 
 func (m *Muxer) generateMultivariantPlaylist(rawQuery string) ([]byte, error) {
-	// TODO: consider segments in all streams
-	maxBandwidth, averageBandwidth := bandwidth(m.streams[0].segments)
-
 	pl := &playlist.Multivariant{
 		Version: func() int {
-			if m.Variant == MuxerVariantMPEGTS {
+			if m.Variant == MuxerVariantMPEGTS || m.Variant == MuxerVariantPackedAudio {
 				return 3
 			}
 			return 9
 		}(),
 		IndependentSegments: true,
-		Variants: []*playlist.MultivariantVariant{{
-			Bandwidth:        maxBandwidth,
-			AverageBandwidth: &averageBandwidth,
-		}},
 	}
 
+	// renditions with a dedicated playlist are played together with variants,
+	// therefore the bandwidth of the greatest rendition of each group is added to variants.
+	groupMaxBandwidths := make(map[string]int)
+	groupAverageBandwidths := make(map[string]int)
+
 	for _, stream := range m.streams {
-		err := stream.populateMultivariantPlaylist(pl, rawQuery)
+		if stream.isRendition && !stream.isVariant {
+			_, groupID := stream.renditionGroup()
+			maxBandwidth, averageBandwidth := bandwidth(stream.segments)
+			groupMaxBandwidths[groupID] = max(groupMaxBandwidths[groupID], maxBandwidth)
+			groupAverageBandwidths[groupID] = max(groupAverageBandwidths[groupID], averageBandwidth)
+		}
+	}
+
+	// each variant stream produces a variant, that is completed by other streams
+	variants := make(map[*muxerStream]*playlist.MultivariantVariant)
+
+	for _, stream := range m.streams {
+		if stream.isVariant {
+			maxBandwidth, averageBandwidth := bandwidth(stream.segments)
+
+			for groupID, groupMaxBandwidth := range groupMaxBandwidths {
+				// the variant belongs to the group and is played in place of other renditions
+				if stream.isRendition {
+					if _, ownGroupID := stream.renditionGroup(); ownGroupID == groupID {
+						maxBandwidth = max(maxBandwidth, groupMaxBandwidth)
+						averageBandwidth = max(averageBandwidth, groupAverageBandwidths[groupID])
+						continue
+					}
+				}
+
+				maxBandwidth += groupMaxBandwidth
+				averageBandwidth += groupAverageBandwidths[groupID]
+			}
+
+			mv := &playlist.MultivariantVariant{
+				Bandwidth:        maxBandwidth,
+				AverageBandwidth: &averageBandwidth,
+			}
+			pl.Variants = append(pl.Variants, mv)
+			variants[stream] = mv
+		}
+	}
+
+	for _, stream := range m.streams {
+		err := stream.populateMultivariantPlaylist(pl, variants[stream], rawQuery)
 		if err != nil {
 			return nil, err
 		}
 	}
 
+	m.populateClosedCaptions(pl, variants)
+
 	return pl.Marshal()
 }
@@ -0,0 +1,185 @@
+package gohlslib
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+
+	"github.com/bluenviron/gohlslib/v2/pkg/codecs"
+	"github.com/bluenviron/gohlslib/v2/pkg/playlist"
+	"github.com/bluenviron/mediacommon/v2/pkg/codecs/h264"
+	"github.com/bluenviron/mediacommon/v2/pkg/codecs/h265"
+)
+
+const (
+	// ATSC A/53, Part 4
+	cea608MaxCount = 31
+
+	seiPayloadTypeUserDataRegistered = 4
+)
+
+var closedCaptionsInstreamIDRegexp = regexp.MustCompile(`^CC[1-4]$`)
+
+// MuxerClosedCaptions is a CEA-608 closed caption channel carried by video tracks.
+type MuxerClosedCaptions struct {
+	// INSTREAM-ID of the channel (CC1, CC2, CC3 or CC4).
+	InstreamID string
+
+	// Name.
+	// It defaults to InstreamID.
+	Name string
+
+	// Language.
+	Language string
+
+	// whether this is the default channel.
+	IsDefault bool
+}
+
+// cea608MarshalSEI encodes CEA-608 cc_data triplets into a SEI NAL unit,
+// using the ATSC A/53 user data syntax.
+func cea608MarshalSEI(codec codecs.Codec, triplets []byte) []byte {
+	payload := []byte{
+		0xB5,       // itu_t_t35_country_code
+		0x00, 0x31, // itu_t_t35_provider_code
+		'G', 'A', '9', '4', // user_identifier
+		0x03,                         // user_data_type_code
+		0x40 | byte(len(triplets)/3), // process_cc_data_flag, cc_count
+		0xFF,                         // em_data
+	}
+	payload = append(payload, triplets...)
+	payload = append(payload, 0xFF) // marker_bits
+
+	var header []byte
+	if _, ok := codec.(*codecs.H265); ok {
+		header = []byte{byte(h265.NALUType_PREFIX_SEI_NUT) << 1, 1}
+	} else {
+		header = []byte{byte(h264.NALUTypeSEI)}
+	}
+
+	rbsp := []byte{seiPayloadTypeUserDataRegistered, byte(len(payload))}
+	rbsp = append(rbsp, payload...)
+	rbsp = append(rbsp, 0x80) // rbsp_trailing_bits
+
+	return append(header, h264EmulationPreventionAdd(rbsp)...)
+}
+
+// cea608InsertSEI inserts a SEI NAL unit before the first slice of an access unit.
+func cea608InsertSEI(codec codecs.Codec, au [][]byte, sei []byte) [][]byte {
+	pos := len(au)
+
+	for i, nalu := range au {
+		var isSlice bool
+		if _, ok := codec.(*codecs.H265); ok {
+			isSlice = h265.NALUType((nalu[0]>>1)&0b111111) < h265.NALUType_VPS_NUT
+		} else {
+			typ := h264.NALUType(nalu[0] & 0x1F)
+			isSlice = typ >= h264.NALUTypeNonIDR && typ <= h264.NALUTypeIDR
+		}
+
+		if isSlice {
+			pos = i
+			break
+		}
+	}
+
+	ret := make([][]byte, 0, len(au)+1)
+	ret = append(ret, au[:pos]...)
+	ret = append(ret, sei)
+	ret = append(ret, au[pos:]...)
+	return ret
+}
+
+func (m *Muxer) checkClosedCaptions(hasVideo bool) error {
+	if !hasVideo {
+		return fmt.Errorf("closed captions require a video track")
+	}
+
+	hasDefault := false
+	instreamIDs := make(map[string]struct{})
+
+	for _, cc := range m.ClosedCaptions {
+		if !closedCaptionsInstreamIDRegexp.MatchString(cc.InstreamID) {
+			return fmt.Errorf("invalid INSTREAM-ID: '%s'", cc.InstreamID)
+		}
+
+		if _, ok := instreamIDs[cc.InstreamID]; ok {
+			return fmt.Errorf("duplicate INSTREAM-ID: '%s'", cc.InstreamID)
+		}
+		instreamIDs[cc.InstreamID] = struct{}{}
+
+		if cc.IsDefault {
+			if hasDefault {
+				return fmt.Errorf("multiple default closed caption channels are not supported")
+			}
+			hasDefault = true
+		}
+	}
+
+	return nil
+}
+
+func (m *Muxer) populateClosedCaptions(
+	pl *playlist.Multivariant,
+	variants map[*muxerStream]*playlist.MultivariantVariant,
+) {
+	groupID := "NONE"
+
+	if len(m.ClosedCaptions) != 0 {
+		groupID = "cc"
+
+		for _, cc := range m.ClosedCaptions {
+			name := cc.Name
+			if name == "" {
+				name = cc.InstreamID
+			}
+
+			pl.Renditions = append(pl.Renditions, &playlist.MultivariantRendition{
+				Type:       playlist.MultivariantRenditionTypeClosedCaptions,
+				GroupID:    groupID,
+				Name:       name,
+				Language:   cc.Language,
+				Autoselect: true,
+				Default:    cc.IsDefault,
+				InStreamID: ptrOf(cc.InstreamID),
+			})
+		}
+	}
+
+	// closed captions are signaled on variants that contain video
+	for _, stream := range m.streams {
+		mv, ok := variants[stream]
+		if !ok {
+			continue
+		}
+
+		if mv.Video != "" || slices.ContainsFunc(stream.tracks, func(t *muxerTrack) bool {
+			return t.Codec.IsVideo()
+		}) {
+			mv.ClosedCaptions = groupID
+		}
+	}
+}
+
+// insertClosedCaptions inserts pending caption data into an access unit.
+func (m *Muxer) insertClosedCaptions(track *muxerTrack, au [][]byte) [][]byte {
+	// avoid locking the mutex when closed captions are not in use
+	if len(m.ClosedCaptions) == 0 {
+		return au
+	}
+
+	m.mutex.Lock()
+
+	if len(track.pendingCEA608) == 0 {
+		m.mutex.Unlock()
+		return au
+	}
+
+	n := min(len(track.pendingCEA608), cea608MaxCount*3)
+	triplets := track.pendingCEA608[:n]
+	track.pendingCEA608 = track.pendingCEA608[n:]
+
+	m.mutex.Unlock()
+
+	return cea608InsertSEI(track.Codec, au, cea608MarshalSEI(track.Codec, triplets))
+}
@@ -0,0 +1,236 @@
+package gohlslib
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/bluenviron/gohlslib/v2/pkg/playlist"
+)
+
+// MuxerDateRange is a date range (EXT-X-DATERANGE),
+// that can be used to signal ad breaks through SCTE-35 commands.
+type MuxerDateRange struct {
+	// ID.
+	// A date range with the same ID of a previous one updates it,
+	// for instance by adding SCTE35-IN at the end of an ad break.
+	ID string
+
+	// CLASS.
+	Class string
+
+	// PTS of the start of the date range (or of the update),
+	// expressed in the clock rate of the leading track, that is
+	// the first video track, or the first audio track when there's no video.
+	// A segment boundary is placed at the first random access unit that follows.
+	PTS int64
+
+	// Duration.
+	// In case of updates, it defaults to the time elapsed since the start of the date range.
+	Duration time.Duration
+
+	// Planned duration.
+	PlannedDuration time.Duration
+
+	// SCTE35-CMD.
+	SCTE35Cmd []byte
+
+	// SCTE35-OUT.
+	SCTE35Out []byte
+
+	// SCTE35-IN.
+	SCTE35In []byte
+
+	// Whether the date range ends at the start of the next one with the same class.
+	EndOnNext bool
+}
+
+func (dr MuxerDateRange) validate() error {
+	if dr.ID == "" {
+		return fmt.Errorf("ID is missing")
+	}
+
+	if dr.EndOnNext {
+		if dr.Class == "" {
+			return fmt.Errorf("CLASS is required when EndOnNext is true")
+		}
+		if dr.Duration != 0 {
+			return fmt.Errorf("duration is not compatible with EndOnNext")
+		}
+	}
+
+	return nil
+}
+
+type muxerPendingDateRange struct {
+	MuxerDateRange
+	dts time.Duration
+}
+
+type muxerDateRangeTag struct {
+	tag          *playlist.MediaDateRange
+	segmentID    uint64
+	startDTS     time.Duration
+	endSegmentID uint64 // 0 when the date range is still open
+	cueOut       *time.Duration
+	cueIn        bool
+}
+
+// muxerDateRanges contains date ranges, that are shared by all streams.
+type muxerDateRanges struct {
+	legacyCueTags bool
+
+	pending []*muxerPendingDateRange
+	tags    []*muxerDateRangeTag
+}
+
+func (d *muxerDateRanges) add(dr *MuxerDateRange, dts time.Duration) {
+	d.pending = append(d.pending, &muxerPendingDateRange{
+		MuxerDateRange: *dr,
+		dts:            dts,
+	})
+}
+
+// spliceReached returns whether a segment boundary must be placed at the given DTS.
+func (d *muxerDateRanges) spliceReached(dts time.Duration) bool {
+	for _, pdr := range d.pending {
+		if pdr.dts <= dts {
+			return true
+		}
+	}
+	return false
+}
+
+// activate attaches pending date ranges to a segment that has just been created.
+func (d *muxerDateRanges) activate(segmentID uint64, startDTS time.Duration, startNTP time.Time) {
+	// close date ranges whose duration has elapsed
+	for _, t := range d.tags {
+		if t.endSegmentID == 0 && t.tag.Duration != nil && (t.startDTS+*t.tag.Duration) <= startDTS {
+			t.endSegmentID = segmentID
+		}
+	}
+
+	n := 0
+
+	for _, pdr := range d.pending {
+		if pdr.dts > startDTS {
+			d.pending[n] = pdr
+			n++
+			continue
+		}
+
+		t := &muxerDateRangeTag{
+			tag: &playlist.MediaDateRange{
+				ID:        pdr.ID,
+				Class:     pdr.Class,
+				StartDate: startNTP,
+				SCTE35Cmd: pdr.SCTE35Cmd,
+				SCTE35Out: pdr.SCTE35Out,
+				SCTE35In:  pdr.SCTE35In,
+				EndOnNext: pdr.EndOnNext,
+			},
+			segmentID: segmentID,
+			startDTS:  startDTS,
+		}
+
+		if pdr.Duration != 0 {
+			t.tag.Duration = ptrOf(pdr.Duration)
+		}
+
+		if pdr.PlannedDuration != 0 {
+			t.tag.PlannedDuration = ptrOf(pdr.PlannedDuration)
+		}
+
+		for _, prev := range d.tags {
+			if prev.tag.ID == pdr.ID {
+				// an update keeps the start of the original date range
+				if prev.endSegmentID == 0 {
+					prev.endSegmentID = segmentID
+				}
+				t.tag.StartDate = prev.tag.StartDate
+				t.startDTS = prev.startDTS
+				if t.tag.Duration == nil {
+					t.tag.Duration = ptrOf(startNTP.Sub(prev.tag.StartDate))
+				}
+				t.endSegmentID = segmentID
+			} else if prev.tag.EndOnNext && prev.endSegmentID == 0 && prev.tag.Class == pdr.Class {
+				prev.endSegmentID = segmentID
+			}
+		}
+
+		if d.legacyCueTags {
+			if pdr.SCTE35Out != nil {
+				switch {
+				case t.tag.PlannedDuration != nil:
+					t.cueOut = ptrOf(*t.tag.PlannedDuration)
+				case t.tag.Duration != nil:
+					t.cueOut = ptrOf(*t.tag.Duration)
+				default:
+					t.cueOut = ptrOf(time.Duration(0))
+				}
+			}
+
+			t.cueIn = (pdr.SCTE35In != nil)
+		}
+
+		d.tags = append(d.tags, t)
+	}
+
+	d.pending = d.pending[:n]
+}
+
+// removeExpired removes date ranges that ended before the given segment.
+func (d *muxerDateRanges) removeExpired(segmentID uint64) {
+	n := 0
+
+	for _, t := range d.tags {
+		if t.segmentID >= segmentID || t.endSegmentID == 0 || t.endSegmentID > segmentID {
+			d.tags[n] = t
+			n++
+		}
+	}
+
+	d.tags = d.tags[:n]
+}
+
+// populate adds date ranges to the segments of a media playlist.
+// Date ranges of segments that are not in the playlist anymore
+// are added to the first segment as long as they are open.
+// Date ranges of segments skipped by a delta update are always added to the first segment,
+// since CAN-SKIP-DATERANGES is not advertised.
+func (d *muxerDateRanges) populate(
+	segments []*playlist.MediaSegment,
+	firstListedSegmentID uint64,
+	firstSegmentID uint64,
+) {
+	if len(segments) == 0 {
+		return
+	}
+
+	for _, t := range d.tags {
+		if t.segmentID < firstListedSegmentID {
+			if t.endSegmentID == 0 || t.endSegmentID > firstSegmentID {
+				segments[0].DateRanges = append(segments[0].DateRanges, t.tag)
+			}
+			continue
+		}
+
+		if t.segmentID < firstSegmentID {
+			segments[0].DateRanges = append(segments[0].DateRanges, t.tag)
+			continue
+		}
+
+		i := int(t.segmentID - firstSegmentID)
+		if i >= len(segments) {
+			continue
+		}
+
+		segments[i].DateRanges = append(segments[i].DateRanges, t.tag)
+
+		if t.cueOut != nil {
+			segments[i].CueOut = t.cueOut
+		}
+		if t.cueIn {
+			segments[i].CueIn = true
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package gohlslib
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/bluenviron/gohlslib/v2/pkg/playlist"
+)
+
+// MuxerEncryption is a segment encryption method.
+type MuxerEncryption int
+
+// supported encryption methods.
+const (
+	MuxerEncryptionNone MuxerEncryption = iota
+
+	// MuxerEncryptionAES128 encrypts entire segments with AES-128-CBC.
+	MuxerEncryptionAES128
+
+	// MuxerEncryptionSampleAES encrypts samples only.
+	// The fMP4 variants use the 'cbcs' scheme of Common Encryption,
+	// while the MPEG-TS variant uses the SAMPLE-AES format of Apple.
+	// In the fMP4 variants, the key cannot be rotated.
+	MuxerEncryptionSampleAES
+)
+
+// MuxerKeyProvider provides encryption keys to the Muxer.
+type MuxerKeyProvider interface {
+	// Key returns the 16-byte key with given ID.
+	// It is called once for each key, when the first segment that uses it is completed
+	// or, with MuxerEncryptionSampleAES, when it is created.
+	Key(id uint64) ([]byte, error)
+}
+
+// muxerRandomKeyProvider is the default key provider, that generates random keys.
+type muxerRandomKeyProvider struct{}
+
+func (muxerRandomKeyProvider) Key(_ uint64) ([]byte, error) {
+	key := make([]byte, 16)
+	_, err := rand.Read(key)
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func keyPath(prefix string, keyID uint64) string {
+	return prefix + "_key" + strconv.FormatUint(keyID, 10) + ".key"
+}
+
+// muxerKeyring keeps the keys that are in use by segments.
+// Keys are shared by all streams, since segment IDs are aligned.
+type muxerKeyring struct {
+	method            MuxerEncryption
+	keyProvider       MuxerKeyProvider
+	rotationSegments  int
+	uriTemplate       string
+	keyFormat         string
+	keyFormatVersions string
+	prefix            string
+	server            *muxerServer
+
+	keys map[uint64][]byte
+	iv   []byte // sample-aes only
+}
+
+func (k *muxerKeyring) initialize() error {
+	k.keys = make(map[uint64][]byte)
+
+	// SAMPLE-AES uses a constant IV, that is shared by all samples.
+	if k.method == MuxerEncryptionSampleAES {
+		k.iv = make([]byte, aes.BlockSize)
+		_, err := rand.Read(k.iv)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (k *muxerKeyring) keyID(segmentID uint64) uint64 {
+	if k.rotationSegments == 0 {
+		return 0
+	}
+	return segmentID / uint64(k.rotationSegments)
+}
+
+// key returns the key of a segment, obtaining it from the provider when needed.
+func (k *muxerKeyring) key(segmentID uint64) ([]byte, error) {
+	id := k.keyID(segmentID)
+
+	if key, ok := k.keys[id]; ok {
+		return key, nil
+	}
+
+	key, err := k.keyProvider.Key(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(key) != 16 {
+		return nil, fmt.Errorf("invalid key size: %d", len(key))
+	}
+
+	k.keys[id] = key
+
+	if k.uriTemplate == "" {
+		k.server.registerPath(
+			keyPath(k.prefix, id),
+			func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Cache-Control", "max-age="+segmentMaxAge)
+				w.Header().Set("Content-Type", "application/octet-stream")
+				w.WriteHeader(http.StatusOK)
+				w.Write(key)
+			})
+	}
+
+	return key, nil
+}
+
+// prune removes keys that are not used anymore by segments,
+// keeping the previous key in order to let lagging streams use it.
+func (k *muxerKeyring) prune(oldestSegmentID uint64) {
+	oldestID := k.keyID(oldestSegmentID)
+
+	for id := range k.keys {
+		if (id + 1) < oldestID {
+			delete(k.keys, id)
+
+			if k.uriTemplate == "" {
+				k.server.unregisterPath(keyPath(k.prefix, id))
+			}
+		}
+	}
+}
+
+// mediaKey returns the EXT-X-KEY tag of a segment.
+// With AES-128, the IV is omitted, therefore it is equal to the media sequence number of the segment.
+func (k *muxerKeyring) mediaKey(segmentID uint64, rawQuery string) *playlist.MediaKey {
+	id := k.keyID(segmentID)
+
+	var uri string
+	if k.uriTemplate != "" {
+		uri = strings.ReplaceAll(k.uriTemplate, "{id}", strconv.FormatUint(id, 10))
+	} else {
+		uri = keyPath(k.prefix, id)
+		if rawQuery != "" {
+			uri += "?" + rawQuery
+		}
+	}
+
+	key := &playlist.MediaKey{
+		Method:            playlist.MediaKeyMethodAES128,
+		URI:               uri,
+		KeyFormat:         k.keyFormat,
+		KeyFormatVersions: k.keyFormatVersions,
+	}
+
+	if k.method == MuxerEncryptionSampleAES {
+		key.Method = playlist.MediaKeyMethodSampleAES
+		key.IV = "0x" + hex.EncodeToString(k.iv)
+	}
+
+	return key
+}
+
+func aes128EncryptedSize(size uint64) uint64 {
+	// PKCS#7 padding always adds at least one byte
+	return (size/aes.BlockSize + 1) * aes.BlockSize
+}
+
+// aes128Reader encrypts the content of a reader with AES-128-CBC and PKCS#7 padding.
+type aes128Reader struct {
+	r    io.ReadCloser
+	mode cipher.BlockMode
+
+	in  []byte // cleartext that has not been encrypted yet
+	out []byte // ciphertext that has not been read yet
+	eof bool
+}
+
+func newAES128Reader(r io.ReadCloser, key []byte, segmentID uint64) (*aes128Reader, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	iv := make([]byte, aes.BlockSize)
+	binary.BigEndian.PutUint64(iv[8:], segmentID)
+
+	return &aes128Reader{
+		r:    r,
+		mode: cipher.NewCBCEncrypter(block, iv),
+	}, nil
+}
+
+func (r *aes128Reader) Read(p []byte) (int, error) {
+	for len(r.out) == 0 {
+		if r.eof {
+			return 0, io.EOF
+		}
+
+		var buf [4096]byte
+		n, err := r.r.Read(buf[:])
+		r.in = append(r.in, buf[:n]...)
+
+		switch {
+		case err == io.EOF:
+			padding := aes.BlockSize - len(r.in)%aes.BlockSize
+			for range padding {
+				r.in = append(r.in, byte(padding))
+			}
+			r.eof = true
+
+		case err != nil:
+			return 0, err
+		}
+
+		n = len(r.in) - len(r.in)%aes.BlockSize
+		r.out = make([]byte, n)
+		r.mode.CryptBlocks(r.out, r.in[:n])
+		r.in = r.in[n:]
+	}
+
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
+
+func (r *aes128Reader) Close() error {
+	return r.r.Close()
+}
@@ -0,0 +1,143 @@
+package gohlslib
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+)
+
+const (
+	muxerDefaultMetadataSchemeIDURI = "https://aomedia.org/emsg/ID3"
+
+	// Apple, Timed Metadata for HTTP Live Streaming
+	id3StreamType                = 0x15
+	id3PESStreamID               = 0xBD
+	id3DescriptorMetadataPointer = 0x25
+	id3DescriptorMetadata        = 0x26
+	id3MetadataFormat            = 0xFF
+	id3MetadataApplicationFormat = 0xFFFF
+
+	mpegtsMaxPESPacketLength         = 0xFFFF
+	mpegtsPESOptionalHeaderLengthPTS = 3 + 5
+
+	emsgTimeScale            = 90000
+	emsgEventDurationUnknown = 0xFFFFFFFF
+)
+
+var id3FormatIdentifier = []byte{'I', 'D', '3', ' '}
+
+func id3MetadataPointerDescriptor(programNumber uint16) []byte {
+	ret := []byte{id3DescriptorMetadataPointer, 15}
+	ret = binary.BigEndian.AppendUint16(ret, id3MetadataApplicationFormat)
+	ret = append(ret, id3FormatIdentifier...)
+	ret = append(ret, id3MetadataFormat)
+	ret = append(ret, id3FormatIdentifier...)
+	ret = append(ret,
+		0,    // metadata_service_id
+		0x1F) // metadata_locator_record_flag, MPEG_carriage_flags, reserved
+	ret = binary.BigEndian.AppendUint16(ret, programNumber)
+	return ret
+}
+
+func id3MetadataDescriptor() []byte {
+	ret := []byte{id3DescriptorMetadata, 13}
+	ret = binary.BigEndian.AppendUint16(ret, id3MetadataApplicationFormat)
+	ret = append(ret, id3FormatIdentifier...)
+	ret = append(ret, id3MetadataFormat)
+	ret = append(ret, id3FormatIdentifier...)
+	ret = append(ret,
+		0,    // metadata_service_id
+		0x0F) // decoder_config_flags, DSM-CC_flag, reserved
+	return ret
+}
+
+// mpegtsID3Writer writes ID3 tags into PES packets.
+type mpegtsID3Writer struct {
+	w   io.Writer
+	pid uint16
+
+	continuityCounter uint8
+}
+
+func (w *mpegtsID3Writer) write(pts int64, payload []byte) error {
+	pesLen := mpegtsPESOptionalHeaderLengthPTS + len(payload)
+	if pesLen > mpegtsMaxPESPacketLength {
+		return fmt.Errorf("metadata is too big")
+	}
+
+	pes := make([]byte, 0, 6+pesLen)
+	pes = append(pes, 0, 0, 1, id3PESStreamID)
+	pes = binary.BigEndian.AppendUint16(pes, uint16(pesLen))
+	pes = append(pes,
+		0x84, // marker bits, data_alignment_indicator
+		0x80, // PTS_DTS_flags
+		5,    // PES_header_data_length
+		0x20|byte((pts>>29)&0x0E)|1,
+		byte(pts>>22),
+		byte((pts>>14)&0xFE)|1,
+		byte(pts>>7),
+		byte((pts<<1)&0xFE)|1)
+	pes = append(pes, payload...)
+
+	pkt := make([]byte, mpegtsPacketSize)
+	start := true
+
+	for len(pes) > 0 {
+		pkt[0] = 0x47
+		pkt[1] = byte(w.pid>>8) & 0x1F
+		if start {
+			pkt[1] |= 0x40
+			start = false
+		}
+		pkt[2] = byte(w.pid)
+
+		n := min(len(pes), mpegtsPacketSize-4)
+
+		if n < (mpegtsPacketSize - 4) {
+			// fill the packet with an adaptation field
+			pkt[3] = 0x30 | w.continuityCounter
+			afLen := mpegtsPacketSize - 4 - n - 1
+			pkt[4] = byte(afLen)
+			if afLen > 0 {
+				pkt[5] = 0
+				for i := 6; i < (5 + afLen); i++ {
+					pkt[i] = 0xFF
+				}
+			}
+		} else {
+			pkt[3] = 0x10 | w.continuityCounter
+		}
+
+		copy(pkt[mpegtsPacketSize-n:], pes[:n])
+		pes = pes[n:]
+
+		w.continuityCounter = (w.continuityCounter + 1) & 0x0F
+
+		_, err := w.w.Write(pkt)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// emsgMarshal encodes a version 1 Event Message box.
+func emsgMarshal(schemeIDURI string, id uint32, presentationTime time.Duration, payload []byte) []byte {
+	size := 4 + 4 + 4 + 4 + 8 + 4 + 4 + len(schemeIDURI) + 1 + 1 + len(payload)
+
+	ret := make([]byte, 0, size)
+	ret = binary.BigEndian.AppendUint32(ret, uint32(size))
+	ret = append(ret, 'e', 'm', 's', 'g')
+	ret = append(ret, 1, 0, 0, 0) // version, flags
+	ret = binary.BigEndian.AppendUint32(ret, emsgTimeScale)
+	ret = binary.BigEndian.AppendUint64(ret, uint64(durationToTimestamp(presentationTime, emsgTimeScale)))
+	ret = binary.BigEndian.AppendUint32(ret, emsgEventDurationUnknown)
+	ret = binary.BigEndian.AppendUint32(ret, id)
+	ret = append(ret, schemeIDURI...)
+	ret = append(ret, 0)
+	ret = append(ret, 0) // value
+	ret = append(ret, payload...)
+	return ret
+}
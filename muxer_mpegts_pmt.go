@@ -0,0 +1,200 @@
+package gohlslib
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+const (
+	mpegtsPacketSize = 188
+)
+
+func crc32MPEG2(buf []byte) uint32 {
+	crc := uint32(0xFFFFFFFF)
+
+	for _, b := range buf {
+		crc ^= uint32(b) << 24
+		for range 8 {
+			if (crc & 0x80000000) != 0 {
+				crc = (crc << 1) ^ 0x04C11DB7
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+
+	return crc
+}
+
+// mpegtsPMTWriter is placed between the MPEG-TS writer and the output
+// in order to edit the program map table, that is:
+// - to signal SAMPLE-AES
+// - to declare the ID3 timed metadata stream.
+type mpegtsPMTWriter struct {
+	w               io.Writer
+	sampleAESTracks []*muxerTrack // sample-aes only
+	id3PID          uint16        // timed metadata only
+
+	buf           []byte
+	pmtPID        uint16
+	programNumber uint16
+}
+
+func (w *mpegtsPMTWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+
+	for len(w.buf) >= mpegtsPacketSize {
+		pkt := w.buf[:mpegtsPacketSize]
+
+		err := w.processPacket(pkt)
+		if err != nil {
+			return 0, err
+		}
+
+		_, err = w.w.Write(pkt)
+		if err != nil {
+			return 0, err
+		}
+
+		w.buf = w.buf[mpegtsPacketSize:]
+	}
+
+	w.buf = append([]byte(nil), w.buf...)
+
+	return len(p), nil
+}
+
+func (w *mpegtsPMTWriter) processPacket(pkt []byte) error {
+	pid := uint16(pkt[1]&0x1F)<<8 | uint16(pkt[2])
+	pusi := (pkt[1] & 0x40) != 0
+
+	if !pusi || (pid != 0 && (w.pmtPID == 0 || pid != w.pmtPID)) {
+		return nil
+	}
+
+	pos := 4
+	if (pkt[3] & 0x20) != 0 {
+		pos += 1 + int(pkt[4])
+	}
+	if pos >= mpegtsPacketSize {
+		return fmt.Errorf("invalid packet")
+	}
+	pos += 1 + int(pkt[pos]) // pointer field
+
+	if (pos + 3) > mpegtsPacketSize {
+		return fmt.Errorf("invalid packet")
+	}
+
+	sectionLen := int(binary.BigEndian.Uint16(pkt[pos+1:])&0x0FFF) + 3
+	if (pos + sectionLen) > mpegtsPacketSize {
+		return fmt.Errorf("PSI sections that span multiple packets are not supported")
+	}
+	section := pkt[pos : pos+sectionLen]
+
+	if pid == 0 {
+		return w.processPAT(section)
+	}
+
+	newSection, err := w.processPMT(section)
+	if err != nil {
+		return err
+	}
+
+	if (pos + len(newSection)) > mpegtsPacketSize {
+		return fmt.Errorf("PMT is too big")
+	}
+
+	copy(pkt[pos:], newSection)
+	for i := pos + len(newSection); i < mpegtsPacketSize; i++ {
+		pkt[i] = 0xFF
+	}
+
+	return nil
+}
+
+func (w *mpegtsPMTWriter) processPAT(section []byte) error {
+	if len(section) < 12 {
+		return fmt.Errorf("invalid PAT")
+	}
+
+	for pos := 8; (pos + 4) <= (len(section) - 4); pos += 4 {
+		programNumber := binary.BigEndian.Uint16(section[pos:])
+		if programNumber != 0 {
+			w.programNumber = programNumber
+			w.pmtPID = binary.BigEndian.Uint16(section[pos+2:]) & 0x1FFF
+			return nil
+		}
+	}
+
+	return fmt.Errorf("PMT not found")
+}
+
+func (w *mpegtsPMTWriter) processPMT(section []byte) ([]byte, error) {
+	if len(section) < 16 {
+		return nil, fmt.Errorf("invalid PMT")
+	}
+
+	programInfoLen := int(binary.BigEndian.Uint16(section[10:]) & 0x0FFF)
+	pos := 12 + programInfoLen
+	end := len(section) - 4
+
+	if pos > end {
+		return nil, fmt.Errorf("invalid PMT")
+	}
+
+	ret := append([]byte(nil), section[:pos]...)
+
+	if w.id3PID != 0 {
+		ret = append(ret, id3MetadataPointerDescriptor(w.programNumber)...)
+		programInfoLen = len(ret) - 12
+		ret[10] = (ret[10] & 0xF0) | byte(programInfoLen>>8)
+		ret[11] = byte(programInfoLen)
+	}
+
+	for pos < end {
+		if (pos + 5) > end {
+			return nil, fmt.Errorf("invalid PMT")
+		}
+
+		streamType := section[pos]
+		pid := binary.BigEndian.Uint16(section[pos+1:]) & 0x1FFF
+		infoLen := int(binary.BigEndian.Uint16(section[pos+3:]) & 0x0FFF)
+
+		if (pos + 5 + infoLen) > end {
+			return nil, fmt.Errorf("invalid PMT")
+		}
+
+		info := append([]byte(nil), section[pos+5:pos+5+infoLen]...)
+
+		for _, track := range w.sampleAESTracks {
+			if track.mpegtsTrack.PID == pid {
+				var err error
+				streamType, info, err = sampleAESStreamInfo(track.Codec, info)
+				if err != nil {
+					return nil, err
+				}
+				break
+			}
+		}
+
+		ret = append(ret, streamType, section[pos+1], section[pos+2],
+			0xF0|byte(len(info)>>8), byte(len(info)))
+		ret = append(ret, info...)
+
+		pos += 5 + infoLen
+	}
+
+	if w.id3PID != 0 {
+		info := id3MetadataDescriptor()
+		ret = append(ret, id3StreamType, 0xE0|byte(w.id3PID>>8), byte(w.id3PID),
+			0xF0|byte(len(info)>>8), byte(len(info)))
+		ret = append(ret, info...)
+	}
+
+	sectionLen := len(ret) - 3 + 4
+	ret[1] = (ret[1] & 0xF0) | byte(sectionLen>>8)
+	ret[2] = byte(sectionLen)
+
+	return binary.BigEndian.AppendUint32(ret, crc32MPEG2(ret)), nil
+}
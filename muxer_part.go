@@ -1,7 +1,6 @@
 package gohlslib
 
 import (
-	"fmt"
 	"io"
 	"time"
 
@@ -9,6 +8,39 @@ import (
 	"github.com/bluenviron/mediacommon/v2/pkg/formats/fmp4"
 )
 
+type sizeWriteSeeker struct {
+	w    io.WriteSeeker
+	pos  int64
+	size int64
+}
+
+func (w *sizeWriteSeeker) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.pos += int64(n)
+	if w.pos > w.size {
+		w.size = w.pos
+	}
+	return n, err
+}
+
+func (w *sizeWriteSeeker) Seek(offset int64, whence int) (int64, error) {
+	pos, err := w.w.Seek(offset, whence)
+	if err != nil {
+		return 0, err
+	}
+	w.pos = pos
+	return pos, nil
+}
+
+func fmp4FindTrack(tracks []*muxerTrack, id int) *muxerTrack {
+	for _, track := range tracks {
+		if track.fmp4ID == id {
+			return track
+		}
+	}
+	return nil
+}
+
 type muxerPart struct {
 	segmentMaxSize uint64
 	streamID       string
@@ -21,6 +53,7 @@ type muxerPart struct {
 
 	path          string
 	isIndependent bool
+	emsgBoxes     [][]byte
 	endDTS        time.Duration // available after finalize()
 }
 
@@ -41,10 +74,10 @@ func (p *muxerPart) finalize(endDTS time.Duration) error {
 		SequenceNumber: uint32(p.id),
 	}
 
-	for i, track := range p.streamTracks {
+	for _, track := range p.streamTracks {
 		if track.fmp4Samples != nil {
 			part.Tracks = append(part.Tracks, &fmp4.PartTrack{
-				ID:       1 + i,
+				ID:       track.fmp4ID,
 				BaseTime: uint64(track.fmp4StartDTS),
 				Samples:  track.fmp4Samples,
 			})
@@ -53,20 +86,82 @@ func (p *muxerPart) finalize(endDTS time.Duration) error {
 		}
 	}
 
-	err := part.Marshal(p.storage.Writer())
+	w := &sizeWriteSeeker{w: p.storage.Writer()}
+
+	// event messages precede the fragments they refer to
+	for _, box := range p.emsgBoxes {
+		_, err := w.Write(box)
+		if err != nil {
+			return err
+		}
+	}
+
+	if p.segment.iframeSize == 0 && p.streamTracks[0].stream.iframePlaylist {
+		err := p.writeIFrameFragment(w, &part)
+		if err != nil {
+			return err
+		}
+	}
+
+	var err error
+	if p.segment.encrypter != nil {
+		err = p.segment.encrypter.marshalFMP4Part(w, &part, p.streamTracks)
+	} else {
+		err = part.Marshal(w)
+	}
 	if err != nil {
 		return err
 	}
 
+	// segments always start with a random access sample.
+	// Save the size of the first part in order to fill I-frame playlists.
+	if p.segment.iframeSize == 0 {
+		p.segment.iframeSize = uint64(w.size)
+	}
+
 	p.endDTS = endDTS
 
 	return nil
 }
 
+// writeIFrameFragment moves the initial random access sample of the segment
+// into a dedicated fragment, in order to allow I-frame playlists to point to it.
+func (p *muxerPart) writeIFrameFragment(w *sizeWriteSeeker, part *fmp4.Part) error {
+	for _, partTrack := range part.Tracks {
+		if !fmp4FindTrack(p.streamTracks, partTrack.ID).Codec.IsVideo() ||
+			len(partTrack.Samples) < 2 ||
+			partTrack.Samples[0].IsNonSyncSample {
+			continue
+		}
+
+		iframePart := fmp4.Part{
+			SequenceNumber: part.SequenceNumber,
+			Tracks: []*fmp4.PartTrack{{
+				ID:       partTrack.ID,
+				BaseTime: partTrack.BaseTime,
+				Samples:  partTrack.Samples[:1],
+			}},
+		}
+
+		err := iframePart.Marshal(w)
+		if err != nil {
+			return err
+		}
+
+		p.segment.iframeSize = uint64(w.size)
+
+		partTrack.BaseTime += uint64(partTrack.Samples[0].Duration)
+		partTrack.Samples = partTrack.Samples[1:]
+		break
+	}
+
+	return nil
+}
+
 func (p *muxerPart) writeSample(track *muxerTrack, sample *fmp4AugmentedSample) error {
 	size := uint64(len(sample.Payload))
 	if (p.segment.size + size) > p.segmentMaxSize {
-		return fmt.Errorf("reached maximum segment size")
+		return ErrMuxerSegmentTooBig
 	}
 	p.segment.size += size
 
@@ -82,3 +177,15 @@ func (p *muxerPart) writeSample(track *muxerTrack, sample *fmp4AugmentedSample)
 
 	return nil
 }
+
+func (p *muxerPart) writeMetadata(emsg []byte) error {
+	size := uint64(len(emsg))
+	if (p.segment.size + size) > p.segmentMaxSize {
+		return ErrMuxerSegmentTooBig
+	}
+	p.segment.size += size
+
+	p.emsgBoxes = append(p.emsgBoxes, emsg)
+
+	return nil
+}
@@ -0,0 +1,20 @@
+package gohlslib
+
+// MuxerPlaylistType is a muxer playlist type.
+type MuxerPlaylistType int
+
+// supported playlist types.
+const (
+	// MuxerPlaylistTypeLive generates live playlists,
+	// in which old segments are deleted.
+	MuxerPlaylistTypeLive MuxerPlaylistType = iota + 1
+
+	// MuxerPlaylistTypeEvent generates EVENT playlists (EXT-X-PLAYLIST-TYPE:EVENT),
+	// in which all segments are kept.
+	MuxerPlaylistTypeEvent
+
+	// MuxerPlaylistTypeVOD generates VOD playlists (EXT-X-PLAYLIST-TYPE:VOD),
+	// in which all segments are kept, that are delivered once End() is called.
+	// It allows to package a finite stream.
+	MuxerPlaylistTypeVOD
+)
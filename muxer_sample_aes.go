@@ -0,0 +1,164 @@
+package gohlslib
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"fmt"
+
+	"github.com/bluenviron/gohlslib/v2/pkg/codecs"
+	"github.com/bluenviron/mediacommon/v2/pkg/codecs/h264"
+	"github.com/bluenviron/mediacommon/v2/pkg/codecs/mpeg4audio"
+)
+
+const (
+	// Apple, MPEG-2 Stream Encryption Format for HTTP Live Streaming
+	sampleAESStreamTypeH264         = 0xdb
+	sampleAESStreamTypeMPEG4Audio   = 0xcf
+	sampleAESDescriptorPrivateData  = 0x0f
+	sampleAESDescriptorRegistration = 0x05
+)
+
+// sampleAESEncrypter encrypts samples with AES-128-CBC and a constant IV.
+type sampleAESEncrypter struct {
+	block cipher.Block
+	iv    []byte
+}
+
+func newSampleAESEncrypter(key []byte, iv []byte) (*sampleAESEncrypter, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sampleAESEncrypter{
+		block: block,
+		iv:    iv,
+	}, nil
+}
+
+// encryptPattern encrypts buf in place, restarting the cipher block chain.
+// cryptBlocks blocks are encrypted, then skipBlocks blocks are left in clear, repeatedly.
+// A trailing partial block is always left in clear.
+func (e *sampleAESEncrypter) encryptPattern(buf []byte, cryptBlocks int, skipBlocks int) {
+	mode := cipher.NewCBCEncrypter(e.block, e.iv)
+
+	for len(buf) >= aes.BlockSize {
+		n := min(cryptBlocks*aes.BlockSize, len(buf)-len(buf)%aes.BlockSize)
+		mode.CryptBlocks(buf[:n], buf[:n])
+		buf = buf[n:]
+
+		n = min(skipBlocks*aes.BlockSize, len(buf))
+		buf = buf[n:]
+	}
+}
+
+// encryptH264 encrypts an H264 access unit in the MPEG-TS SAMPLE-AES format.
+// Slices are encrypted after emulation prevention bytes are removed,
+// leaving the first 32 bytes in clear, with a 1:9 pattern.
+func (e *sampleAESEncrypter) encryptH264(au [][]byte) [][]byte {
+	ret := make([][]byte, len(au))
+
+	for i, nalu := range au {
+		typ := h264.NALUType(nalu[0] & 0x1F)
+
+		if (typ != h264.NALUTypeNonIDR && typ != h264.NALUTypeIDR) || len(nalu) <= 48 {
+			ret[i] = nalu
+			continue
+		}
+
+		enc := h264.EmulationPreventionRemove(nalu)
+		if len(enc) <= 48 {
+			ret[i] = nalu
+			continue
+		}
+
+		// a block is encrypted only when more than 16 bytes remain,
+		// therefore the last byte is excluded.
+		e.encryptPattern(enc[32:len(enc)-1], 1, 9)
+
+		ret[i] = h264EmulationPreventionAdd(enc)
+	}
+
+	return ret
+}
+
+// encryptMPEG4Audio encrypts MPEG-4 Audio access units in the MPEG-TS SAMPLE-AES format,
+// leaving the first 16 bytes in clear.
+func (e *sampleAESEncrypter) encryptMPEG4Audio(aus [][]byte) [][]byte {
+	ret := make([][]byte, len(aus))
+
+	for i, au := range aus {
+		if len(au) <= 16 {
+			ret[i] = au
+			continue
+		}
+
+		enc := append([]byte(nil), au...)
+		e.encryptPattern(enc[16:], 1, 0)
+		ret[i] = enc
+	}
+
+	return ret
+}
+
+func h264EmulationPreventionAdd(nalu []byte) []byte {
+	ret := make([]byte, 0, len(nalu)+len(nalu)/64)
+	zeros := 0
+
+	for _, b := range nalu {
+		if zeros >= 2 && b <= 3 {
+			ret = append(ret, 3)
+			zeros = 0
+		}
+
+		ret = append(ret, b)
+
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+	}
+
+	return ret
+}
+
+// sampleAESStreamInfo returns the stream type and the descriptors of an encrypted elementary stream.
+func sampleAESStreamInfo(codec codecs.Codec, info []byte) (uint8, []byte, error) {
+	switch codec := codec.(type) {
+	case *codecs.H264:
+		info = append(info, sampleAESDescriptorPrivateData, 4, 'z', 'a', 'v', 'c')
+		return sampleAESStreamTypeH264, info, nil
+
+	case *codecs.MPEG4Audio:
+		setupData, err := codec.Config.Marshal()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		var audioType []byte
+		switch codec.Config.Type {
+		case mpeg4audio.ObjectTypeSBR:
+			audioType = []byte("zach")
+		case mpeg4audio.ObjectTypePS:
+			audioType = []byte("zacp")
+		default:
+			audioType = []byte("zaac")
+		}
+
+		info = append(info, sampleAESDescriptorPrivateData, 4, 'a', 'a', 'c', 'd')
+
+		// registration descriptor that contains the audio setup information
+		info = append(info, sampleAESDescriptorRegistration, byte(4+4+2+1+1+len(setupData)),
+			'a', 'p', 'a', 'd')
+		info = append(info, audioType...)
+		info = append(info, 0, 0) // priming
+		info = append(info, 1)    // version
+		info = append(info, byte(len(setupData)))
+		info = append(info, setupData...)
+
+		return sampleAESStreamTypeMPEG4Audio, info, nil
+	}
+
+	return 0, nil, fmt.Errorf("unsupported codec")
+}
@@ -0,0 +1,435 @@
+package gohlslib
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/bluenviron/gohlslib/v2/pkg/codecs"
+	"github.com/bluenviron/mediacommon/v2/pkg/formats/fmp4"
+	"github.com/bluenviron/mediacommon/v2/pkg/formats/fmp4/seekablebuffer"
+)
+
+// ISO/IEC 23001-7, Common Encryption in ISO base media file format files
+
+const (
+	cbcsSliceLeaderSize = 32
+	cbcsSchemeVersion   = 0x00010000
+	sencFlagSubsamples  = 0x02
+)
+
+type cbcsSubsample struct {
+	clearBytes     uint16
+	protectedBytes uint32
+}
+
+type mp4BoxPos struct {
+	typ   string
+	start int
+	end   int
+}
+
+func mp4Children(buf []byte, start int, end int) ([]mp4BoxPos, error) {
+	var ret []mp4BoxPos
+
+	for start < end {
+		if (start + 8) > end {
+			return nil, fmt.Errorf("invalid box")
+		}
+
+		size := int(binary.BigEndian.Uint32(buf[start:]))
+		if size < 8 || (start+size) > end {
+			return nil, fmt.Errorf("invalid box size")
+		}
+
+		ret = append(ret, mp4BoxPos{
+			typ:   string(buf[start+4 : start+8]),
+			start: start,
+			end:   start + size,
+		})
+		start += size
+	}
+
+	return ret, nil
+}
+
+// mp4FindPath finds the boxes that correspond to a path, starting from a given box.
+func mp4FindPath(buf []byte, parent mp4BoxPos, path ...string) ([]mp4BoxPos, error) {
+	ret := []mp4BoxPos{parent}
+
+	for _, typ := range path {
+		children, err := mp4Children(buf, parent.start+8, parent.end)
+		if err != nil {
+			return nil, err
+		}
+
+		found := false
+		for _, child := range children {
+			if child.typ == typ {
+				parent = child
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("box '%s' not found", typ)
+		}
+
+		ret = append(ret, parent)
+	}
+
+	return ret, nil
+}
+
+// mp4Insert inserts data at a position, and increases the size of the boxes that contain it.
+func mp4Insert(buf []byte, parents []mp4BoxPos, pos int, data []byte) []byte {
+	ret := make([]byte, 0, len(buf)+len(data))
+	ret = append(ret, buf[:pos]...)
+	ret = append(ret, data...)
+	ret = append(ret, buf[pos:]...)
+
+	for _, parent := range parents {
+		size := binary.BigEndian.Uint32(ret[parent.start:])
+		binary.BigEndian.PutUint32(ret[parent.start:], size+uint32(len(data)))
+	}
+
+	return ret
+}
+
+func mp4Box(typ string, payloads ...[]byte) []byte {
+	size := 8
+	for _, payload := range payloads {
+		size += len(payload)
+	}
+
+	ret := make([]byte, 0, size)
+	ret = binary.BigEndian.AppendUint32(ret, uint32(size))
+	ret = append(ret, typ...)
+	for _, payload := range payloads {
+		ret = append(ret, payload...)
+	}
+
+	return ret
+}
+
+func mp4FullBox(typ string, version uint8, flags uint32, payloads ...[]byte) []byte {
+	header := binary.BigEndian.AppendUint32(nil, uint32(version)<<24|flags)
+	return mp4Box(typ, append([][]byte{header}, payloads...)...)
+}
+
+func cbcsKID(keyID uint64) []byte {
+	kid := make([]byte, 16)
+	binary.BigEndian.PutUint64(kid[8:], keyID)
+	return kid
+}
+
+func cbcsPattern(codec codecs.Codec) (int, int) {
+	// video slices are encrypted with a 1:9 pattern,
+	// while audio samples are entirely encrypted.
+	if codec.IsVideo() {
+		return 1, 9
+	}
+	return 0, 0
+}
+
+// cbcsProtectInit converts sample entries of an initialization segment into protected ones.
+func cbcsProtectInit(buf []byte, tracks []*muxerTrack, kid []byte, iv []byte) ([]byte, error) {
+	for i, track := range tracks {
+		root, err := mp4Children(buf, 0, len(buf))
+		if err != nil {
+			return nil, err
+		}
+
+		var moov *mp4BoxPos
+		for _, box := range root {
+			if box.typ == "moov" {
+				moov = &box
+				break
+			}
+		}
+		if moov == nil {
+			return nil, fmt.Errorf("moov not found")
+		}
+
+		moovChildren, err := mp4Children(buf, moov.start+8, moov.end)
+		if err != nil {
+			return nil, err
+		}
+
+		var trak *mp4BoxPos
+		n := 0
+		for _, box := range moovChildren {
+			if box.typ == "trak" {
+				if n == i {
+					trak = &box
+					break
+				}
+				n++
+			}
+		}
+		if trak == nil {
+			return nil, fmt.Errorf("trak not found")
+		}
+
+		parents, err := mp4FindPath(buf, *trak, "mdia", "minf", "stbl", "stsd")
+		if err != nil {
+			return nil, err
+		}
+		parents = append([]mp4BoxPos{*moov}, parents...)
+
+		// skip version, flags and entry count of stsd
+		stsd := parents[len(parents)-1]
+		entries, err := mp4Children(buf, stsd.start+16, stsd.end)
+		if err != nil {
+			return nil, err
+		}
+		if len(entries) != 1 {
+			return nil, fmt.Errorf("unexpected sample entry count")
+		}
+		entry := entries[0]
+
+		originalFormat := buf[entry.start+4 : entry.start+8]
+
+		cryptBlocks, skipBlocks := cbcsPattern(track.Codec)
+
+		tenc := []byte{
+			0,
+			byte(cryptBlocks<<4 | skipBlocks),
+			1, // default_isProtected
+			0, // default_Per_Sample_IV_Size
+		}
+		tenc = append(tenc, kid...)
+		tenc = append(tenc, byte(len(iv)))
+		tenc = append(tenc, iv...)
+
+		sinf := mp4Box("sinf",
+			mp4Box("frma", originalFormat),
+			mp4FullBox("schm", 0, 0, []byte("cbcs"), binary.BigEndian.AppendUint32(nil, cbcsSchemeVersion)),
+			mp4Box("schi",
+				mp4FullBox("tenc", 1, 0, tenc)))
+
+		buf = mp4Insert(buf, append(parents, entry), entry.end, sinf)
+
+		if track.Codec.IsVideo() {
+			copy(buf[entry.start+4:], "encv")
+		} else {
+			copy(buf[entry.start+4:], "enca")
+		}
+	}
+
+	return buf, nil
+}
+
+// encryptFMP4Video encrypts a video sample with the 'cbcs' scheme.
+// The first bytes of each slice are left in clear, in order to cover the slice header.
+func (e *sampleAESEncrypter) encryptFMP4Video(codec codecs.Codec, payload []byte) ([]byte, []cbcsSubsample, error) {
+	enc := append([]byte(nil), payload...)
+
+	var subsamples []cbcsSubsample
+	clearBytes := 0
+
+	addSubsample := func(protectedBytes int) {
+		for clearBytes > 0xFFFF {
+			subsamples = append(subsamples, cbcsSubsample{clearBytes: 0xFFFF})
+			clearBytes -= 0xFFFF
+		}
+		subsamples = append(subsamples, cbcsSubsample{
+			clearBytes:     uint16(clearBytes),
+			protectedBytes: uint32(protectedBytes),
+		})
+		clearBytes = 0
+	}
+
+	for pos := 0; pos < len(enc); {
+		if (pos + 4) > len(enc) {
+			return nil, nil, fmt.Errorf("invalid sample")
+		}
+
+		l := int(binary.BigEndian.Uint32(enc[pos:]))
+		if (pos + 4 + l) > len(enc) {
+			return nil, nil, fmt.Errorf("invalid sample")
+		}
+
+		nalu := enc[pos+4 : pos+4+l]
+		pos += 4 + l
+
+		if l < (cbcsSliceLeaderSize+16) || !cbcsIsSlice(codec, nalu) {
+			clearBytes += 4 + l
+			continue
+		}
+
+		protectedBytes := ((l - cbcsSliceLeaderSize) / 16) * 16
+		e.encryptPattern(nalu[cbcsSliceLeaderSize:cbcsSliceLeaderSize+protectedBytes], 1, 9)
+
+		clearBytes += 4 + cbcsSliceLeaderSize
+		addSubsample(protectedBytes)
+		clearBytes = l - cbcsSliceLeaderSize - protectedBytes
+	}
+
+	if clearBytes > 0 {
+		addSubsample(0)
+	}
+
+	return enc, subsamples, nil
+}
+
+func cbcsIsSlice(codec codecs.Codec, nalu []byte) bool {
+	if _, ok := codec.(*codecs.H265); ok {
+		// VCL NAL units
+		return ((nalu[0] >> 1) & 0x3F) < 32
+	}
+
+	// coded slices
+	typ := nalu[0] & 0x1F
+	return typ >= 1 && typ <= 5
+}
+
+// marshalFMP4Part encrypts samples of a part and writes the part,
+// together with sample encryption informations.
+func (e *sampleAESEncrypter) marshalFMP4Part(w io.Writer, part *fmp4.Part, tracks []*muxerTrack) error {
+	subsamplesByTrack := make(map[int][][]cbcsSubsample)
+
+	for _, partTrack := range part.Tracks {
+		track := fmp4FindTrack(tracks, partTrack.ID)
+		samples := make([]*fmp4.Sample, len(partTrack.Samples))
+
+		for i, sample := range partTrack.Samples {
+			enc := *sample
+
+			if track.Codec.IsVideo() {
+				var subsamples []cbcsSubsample
+				var err error
+				enc.Payload, subsamples, err = e.encryptFMP4Video(track.Codec, sample.Payload)
+				if err != nil {
+					return err
+				}
+				subsamplesByTrack[partTrack.ID] = append(subsamplesByTrack[partTrack.ID], subsamples)
+			} else {
+				enc.Payload = append([]byte(nil), sample.Payload...)
+				e.encryptPattern(enc.Payload, 1, 0)
+			}
+
+			samples[i] = &enc
+		}
+
+		partTrack.Samples = samples
+	}
+
+	var buf seekablebuffer.Buffer
+	err := part.Marshal(&buf)
+	if err != nil {
+		return err
+	}
+
+	byts, err := cbcsAddSampleEncryption(buf.Bytes(), subsamplesByTrack)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(byts)
+	return err
+}
+
+// cbcsAddSampleEncryption adds 'saiz', 'saio' and 'senc' boxes to tracks that use subsamples.
+func cbcsAddSampleEncryption(buf []byte, subsamplesByTrack map[int][][]cbcsSubsample) ([]byte, error) {
+	root, err := mp4Children(buf, 0, len(buf))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(root) == 0 || root[0].typ != "moof" {
+		return nil, fmt.Errorf("moof not found")
+	}
+	moofStart := root[0].start
+	added := 0
+
+	for i := 0; ; i++ {
+		moof := mp4BoxPos{typ: "moof", start: moofStart, end: moofStart + int(binary.BigEndian.Uint32(buf[moofStart:]))}
+
+		children, err := mp4Children(buf, moof.start+8, moof.end)
+		if err != nil {
+			return nil, err
+		}
+
+		var trafs []mp4BoxPos
+		for _, child := range children {
+			if child.typ == "traf" {
+				trafs = append(trafs, child)
+			}
+		}
+
+		if i >= len(trafs) {
+			break
+		}
+		traf := trafs[i]
+
+		tfhd, err := mp4FindPath(buf, traf, "tfhd")
+		if err != nil {
+			return nil, err
+		}
+		trackID := int(binary.BigEndian.Uint32(buf[tfhd[1].start+12:]))
+
+		subsamples, ok := subsamplesByTrack[trackID]
+		if !ok {
+			continue
+		}
+
+		saiz := []byte{0} // default_sample_info_size
+		saiz = binary.BigEndian.AppendUint32(saiz, uint32(len(subsamples)))
+
+		senc := binary.BigEndian.AppendUint32(nil, uint32(len(subsamples)))
+
+		for _, sampleSubsamples := range subsamples {
+			size := 2 + 6*len(sampleSubsamples)
+			if size > 0xFF {
+				return nil, fmt.Errorf("too many subsamples")
+			}
+			saiz = append(saiz, byte(size))
+
+			senc = binary.BigEndian.AppendUint16(senc, uint16(len(sampleSubsamples)))
+			for _, subsample := range sampleSubsamples {
+				senc = binary.BigEndian.AppendUint16(senc, subsample.clearBytes)
+				senc = binary.BigEndian.AppendUint32(senc, subsample.protectedBytes)
+			}
+		}
+
+		saizBox := mp4FullBox("saiz", 0, 0, saiz)
+
+		// offset of sample encryption informations, relative to moof
+		offset := traf.end + len(saizBox) + 20 + 16 - moof.start
+
+		boxes := saizBox
+		boxes = append(boxes, mp4FullBox("saio", 0, 0,
+			binary.BigEndian.AppendUint32(nil, 1),
+			binary.BigEndian.AppendUint32(nil, uint32(offset)))...)
+		boxes = append(boxes, mp4FullBox("senc", 0, sencFlagSubsamples, senc)...)
+
+		buf = mp4Insert(buf, []mp4BoxPos{moof, traf}, traf.end, boxes)
+		added += len(boxes)
+	}
+
+	// media data has been moved forward
+	moof := mp4BoxPos{typ: "moof", start: moofStart, end: moofStart + int(binary.BigEndian.Uint32(buf[moofStart:]))}
+
+	children, err := mp4Children(buf, moof.start+8, moof.end)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, child := range children {
+		if child.typ != "traf" {
+			continue
+		}
+
+		trun, err := mp4FindPath(buf, child, "trun")
+		if err != nil {
+			return nil, err
+		}
+
+		pos := trun[1].start + 16
+		dataOffset := int32(binary.BigEndian.Uint32(buf[pos:]))
+		binary.BigEndian.PutUint32(buf[pos:], uint32(dataOffset+int32(added)))
+	}
+
+	return buf, nil
+}
@@ -12,6 +12,7 @@ type muxerSegment interface {
 	getPath() string
 	getDuration() time.Duration
 	getSize() uint64
+	getIFrameSize() uint64
 	isFromForcedRotation() bool
 	reader() (io.ReadCloser, error)
 }
@@ -39,6 +40,10 @@ func (muxerGap) getSize() uint64 {
 	return 0
 }
 
+func (muxerGap) getIFrameSize() uint64 {
+	return 0
+}
+
 func (muxerGap) isFromForcedRotation() bool {
 	return false
 }
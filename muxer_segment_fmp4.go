@@ -15,16 +15,18 @@ type muxerSegmentFMP4 struct {
 	startNTP           time.Time
 	startDTS           time.Duration
 	fromForcedRotation bool
-
-	path    string
-	storage storage.File
-	size    uint64
-	parts   []*muxerPart
-	endDTS  time.Duration // available after finalize()
+	encrypter          *sampleAESEncrypter // sample-aes only
+
+	path       string
+	storage    storage.File
+	size       uint64
+	parts      []*muxerPart
+	endDTS     time.Duration // available after finalize()
+	iframeSize uint64
 }
 
 func (s *muxerSegmentFMP4) initialize() error {
-	s.path = segmentPath(s.prefix, s.streamID, s.id, true)
+	s.path = segmentPath(s.prefix, s.streamID, s.id, ".mp4")
 
 	var err error
 	s.storage, err = s.storageFactory.NewFile(s.path)
@@ -51,6 +53,10 @@ func (s *muxerSegmentFMP4) getSize() uint64 {
 	return s.storage.Size()
 }
 
+func (s *muxerSegmentFMP4) getIFrameSize() uint64 {
+	return s.iframeSize
+}
+
 func (s *muxerSegmentFMP4) isFromForcedRotation() bool {
 	return s.fromForcedRotation
 }
@@ -6,7 +6,9 @@ import (
 	"io"
 	"time"
 
+	"github.com/bluenviron/gohlslib/v2/pkg/codecs"
 	"github.com/bluenviron/gohlslib/v2/pkg/storage"
+	"github.com/bluenviron/mediacommon/v2/pkg/codecs/ac3"
 	"github.com/bluenviron/mediacommon/v2/pkg/formats/mpegts"
 )
 
@@ -16,21 +18,25 @@ type muxerSegmentMPEGTS struct {
 	storageFactory storage.Factory
 	streamID       string
 	mpegtsWriter   *mpegts.Writer
+	id3Writer      *mpegtsID3Writer // timed metadata only
 	id             uint64
 	startNTP       time.Time
 	startDTS       time.Duration
+	encrypter      *sampleAESEncrypter // sample-aes only
 
 	storage      storage.File
 	storagePart  storage.Part
+	cw           *countingWriter
 	bw           *bufio.Writer
 	size         uint64
 	path         string
 	endDTS       time.Duration // available after finalize()
 	audioAUCount int
+	iframeSize   uint64
 }
 
 func (s *muxerSegmentMPEGTS) initialize() error {
-	s.path = segmentPath(s.prefix, s.streamID, s.id, false)
+	s.path = segmentPath(s.prefix, s.streamID, s.id, ".ts")
 
 	var err error
 	s.storage, err = s.storageFactory.NewFile(s.path)
@@ -39,7 +45,8 @@ func (s *muxerSegmentMPEGTS) initialize() error {
 	}
 
 	s.storagePart = s.storage.NewPart()
-	s.bw = bufio.NewWriter(s.storagePart.Writer())
+	s.cw = &countingWriter{w: s.storagePart.Writer()}
+	s.bw = bufio.NewWriter(s.cw)
 
 	return nil
 }
@@ -60,6 +67,10 @@ func (s *muxerSegmentMPEGTS) getSize() uint64 {
 	return s.storage.Size()
 }
 
+func (s *muxerSegmentMPEGTS) getIFrameSize() uint64 {
+	return s.iframeSize
+}
+
 func (*muxerSegmentMPEGTS) isFromForcedRotation() bool {
 	return false
 }
@@ -81,7 +92,7 @@ func (s *muxerSegmentMPEGTS) finalize(endDTS time.Duration) error {
 	return nil
 }
 
-func (s *muxerSegmentMPEGTS) writeH264(
+func (s *muxerSegmentMPEGTS) writeH26x(
 	track *muxerTrack,
 	pts int64,
 	dts int64,
@@ -92,24 +103,37 @@ func (s *muxerSegmentMPEGTS) writeH264(
 		size += uint64(len(nalu))
 	}
 	if (s.size + size) > s.segmentMaxSize {
-		return fmt.Errorf("reached maximum segment size")
+		return ErrMuxerSegmentTooBig
 	}
 	s.size += size
 
-	err := s.mpegtsWriter.WriteH264(
-		track.mpegtsTrack,
-		multiplyAndDivide(pts, 90000, int64(track.ClockRate)),
-		multiplyAndDivide(dts, 90000, int64(track.ClockRate)),
-		au,
-	)
+	pts = multiplyAndDivide(pts, 90000, int64(track.ClockRate))
+	dts = multiplyAndDivide(dts, 90000, int64(track.ClockRate))
+
+	if s.encrypter != nil {
+		au = s.encrypter.encryptH264(au)
+	}
+
+	var err error
+	if _, ok := track.Codec.(*codecs.H265); ok {
+		err = s.mpegtsWriter.WriteH265(track.mpegtsTrack, pts, dts, au)
+	} else {
+		err = s.mpegtsWriter.WriteH264(track.mpegtsTrack, pts, dts, au)
+	}
 	if err != nil {
 		return err
 	}
 
+	// segments always start with a random access unit.
+	// Save the position of its end in order to fill I-frame playlists.
+	if s.iframeSize == 0 {
+		s.iframeSize = s.cw.n + uint64(s.bw.Buffered())
+	}
+
 	return nil
 }
 
-func (s *muxerSegmentMPEGTS) writeMPEG4Audio(
+func (s *muxerSegmentMPEGTS) writeAudio(
 	track *muxerTrack,
 	pts int64,
 	aus [][]byte,
@@ -120,15 +144,44 @@ func (s *muxerSegmentMPEGTS) writeMPEG4Audio(
 	}
 
 	if (s.size + size) > s.segmentMaxSize {
-		return fmt.Errorf("reached maximum segment size")
+		return ErrMuxerSegmentTooBig
 	}
 	s.size += size
 
-	err := s.mpegtsWriter.WriteMPEG4Audio(
-		track.mpegtsTrack,
-		multiplyAndDivide(pts, 90000, int64(track.ClockRate)),
-		aus,
-	)
+	pts = multiplyAndDivide(pts, 90000, int64(track.ClockRate))
+
+	var err error
+	switch track.Codec.(type) {
+	case *codecs.Opus:
+		err = s.mpegtsWriter.WriteOpus(track.mpegtsTrack, pts, aus)
+
+	case *codecs.MPEG1Audio:
+		err = s.mpegtsWriter.WriteMPEG1Audio(track.mpegtsTrack, pts, aus)
+
+	case *codecs.AC3:
+		for _, frame := range aus {
+			// frame duration is computed from the frame itself,
+			// since Track.SampleRate may be missing or wrong.
+			var syncInfo ac3.SyncInfo
+			err = syncInfo.Unmarshal(frame)
+			if err != nil {
+				err = fmt.Errorf("invalid AC-3 frame: %w", err)
+				break
+			}
+
+			err = s.mpegtsWriter.WriteAC3(track.mpegtsTrack, pts, frame)
+			if err != nil {
+				break
+			}
+			pts += ac3.SamplesPerFrame * 90000 / int64(syncInfo.SampleRate())
+		}
+
+	default:
+		if s.encrypter != nil {
+			aus = s.encrypter.encryptMPEG4Audio(aus)
+		}
+		err = s.mpegtsWriter.WriteMPEG4Audio(track.mpegtsTrack, pts, aus)
+	}
 	if err != nil {
 		return err
 	}
@@ -139,3 +192,16 @@ func (s *muxerSegmentMPEGTS) writeMPEG4Audio(
 
 	return nil
 }
+
+func (s *muxerSegmentMPEGTS) writeMetadata(
+	pts int64,
+	payload []byte,
+) error {
+	size := uint64(len(payload))
+	if (s.size + size) > s.segmentMaxSize {
+		return ErrMuxerSegmentTooBig
+	}
+	s.size += size
+
+	return s.id3Writer.write(pts, payload)
+}
@@ -0,0 +1,126 @@
+package gohlslib
+
+import (
+	"bufio"
+	"io"
+	"time"
+
+	"github.com/bluenviron/mediacommon/v2/pkg/codecs/mpeg4audio"
+
+	"github.com/bluenviron/gohlslib/v2/pkg/codecs"
+	"github.com/bluenviron/gohlslib/v2/pkg/storage"
+)
+
+type muxerSegmentPackedAudio struct {
+	segmentMaxSize uint64
+	prefix         string
+	storageFactory storage.Factory
+	streamID       string
+	id             uint64
+	startNTP       time.Time
+	startDTS       time.Duration
+	ext            string
+
+	storage storage.File
+	bw      *bufio.Writer
+	size    uint64
+	path    string
+	endDTS  time.Duration // available after finalize()
+}
+
+func (s *muxerSegmentPackedAudio) initialize() error {
+	s.path = segmentPath(s.prefix, s.streamID, s.id, s.ext)
+
+	var err error
+	s.storage, err = s.storageFactory.NewFile(s.path)
+	if err != nil {
+		return err
+	}
+
+	s.bw = bufio.NewWriter(s.storage.NewPart().Writer())
+
+	_, err = s.bw.Write(packedAudioMarshalHeader(durationToTimestamp(s.startDTS, 90000)))
+	return err
+}
+
+func (s *muxerSegmentPackedAudio) close() {
+	s.storage.Remove()
+}
+
+func (s *muxerSegmentPackedAudio) getPath() string {
+	return s.path
+}
+
+func (s *muxerSegmentPackedAudio) getDuration() time.Duration {
+	return s.endDTS - s.startDTS
+}
+
+func (s *muxerSegmentPackedAudio) getSize() uint64 {
+	return s.storage.Size()
+}
+
+func (*muxerSegmentPackedAudio) getIFrameSize() uint64 {
+	return 0
+}
+
+func (*muxerSegmentPackedAudio) isFromForcedRotation() bool {
+	return false
+}
+
+func (s *muxerSegmentPackedAudio) reader() (io.ReadCloser, error) {
+	return s.storage.Reader()
+}
+
+func (s *muxerSegmentPackedAudio) finalize(endDTS time.Duration) error {
+	err := s.bw.Flush()
+	if err != nil {
+		return err
+	}
+
+	s.bw = nil
+	s.storage.Finalize()
+	s.endDTS = endDTS
+
+	return nil
+}
+
+func (s *muxerSegmentPackedAudio) writeAudio(
+	track *muxerTrack,
+	aus [][]byte,
+) error {
+	var buf []byte
+
+	switch codec := track.Codec.(type) {
+	case *codecs.MPEG4Audio:
+		pkts := make(mpeg4audio.ADTSPackets, len(aus))
+		for i, au := range aus {
+			pkts[i] = &mpeg4audio.ADTSPacket{
+				Type:          codec.Config.Type,
+				SampleRate:    codec.Config.SampleRate,
+				ChannelConfig: codec.Config.ChannelConfig,
+				ChannelCount:  codec.Config.ChannelCount,
+				AU:            au,
+			}
+		}
+
+		var err error
+		buf, err = pkts.Marshal()
+		if err != nil {
+			return err
+		}
+
+	case *codecs.MPEG1Audio:
+		// MPEG-1 Audio frames are self-delimiting
+		for _, frame := range aus {
+			buf = append(buf, frame...)
+		}
+	}
+
+	if (s.size + uint64(len(buf))) > s.segmentMaxSize {
+		return ErrMuxerSegmentTooBig
+	}
+	s.size += uint64(len(buf))
+
+	_, err := s.bw.Write(buf)
+	return err
+}
@@ -0,0 +1,81 @@
+package gohlslib
+
+import (
+	"io"
+	"time"
+
+	"github.com/bluenviron/gohlslib/v2/pkg/storage"
+)
+
+type muxerSegmentWebVTT struct {
+	prefix         string
+	storageFactory storage.Factory
+	streamID       string
+	id             uint64
+	startNTP       time.Time
+	startDTS       time.Duration
+	timeOffset     time.Duration // difference between timestamps of media and timestamps of cues
+
+	storage storage.File
+	path    string
+	cues    []*muxerWebVTTCue // filled before finalize()
+	endDTS  time.Duration     // available after finalize()
+}
+
+func (s *muxerSegmentWebVTT) initialize() error {
+	s.path = segmentPath(s.prefix, s.streamID, s.id, ".vtt")
+
+	var err error
+	s.storage, err = s.storageFactory.NewFile(s.path)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *muxerSegmentWebVTT) close() {
+	s.storage.Remove()
+}
+
+func (s *muxerSegmentWebVTT) getPath() string {
+	return s.path
+}
+
+func (s *muxerSegmentWebVTT) getDuration() time.Duration {
+	return s.endDTS - s.startDTS
+}
+
+func (s *muxerSegmentWebVTT) getSize() uint64 {
+	return s.storage.Size()
+}
+
+func (*muxerSegmentWebVTT) getIFrameSize() uint64 {
+	return 0
+}
+
+func (*muxerSegmentWebVTT) isFromForcedRotation() bool {
+	return false
+}
+
+func (s *muxerSegmentWebVTT) reader() (io.ReadCloser, error) {
+	return s.storage.Reader()
+}
+
+func (s *muxerSegmentWebVTT) finalize(endDTS time.Duration) error {
+	buf := webvttMarshalHeader(s.startDTS, s.startDTS-s.timeOffset)
+
+	for _, cue := range s.cues {
+		buf += cue.marshal()
+	}
+
+	_, err := s.storage.NewPart().Writer().Write([]byte(buf))
+	if err != nil {
+		return err
+	}
+
+	s.storage.Finalize()
+	s.endDTS = endDTS
+
+	return nil
+}
@@ -6,9 +6,11 @@ import (
 	"time"
 
 	"github.com/bluenviron/gohlslib/v2/pkg/codecs"
+	"github.com/bluenviron/mediacommon/v2/pkg/codecs/ac3"
 	"github.com/bluenviron/mediacommon/v2/pkg/codecs/av1"
 	"github.com/bluenviron/mediacommon/v2/pkg/codecs/h264"
 	"github.com/bluenviron/mediacommon/v2/pkg/codecs/h265"
+	"github.com/bluenviron/mediacommon/v2/pkg/codecs/mpeg1audio"
 	"github.com/bluenviron/mediacommon/v2/pkg/codecs/mpeg4audio"
 	"github.com/bluenviron/mediacommon/v2/pkg/codecs/opus"
 	"github.com/bluenviron/mediacommon/v2/pkg/codecs/vp9"
@@ -35,6 +37,42 @@ func timestampToDuration(d int64, clockRate int) time.Duration {
 	return multiplyAndDivide2(time.Duration(d), time.Second, time.Duration(clockRate))
 }
 
+// audioDuration returns the duration of audio access units.
+func audioDuration(codec codecs.Codec, aus [][]byte) time.Duration {
+	var d time.Duration
+
+	switch codec := codec.(type) {
+	case *codecs.MPEG4Audio:
+		if codec.Config.SampleRate != 0 {
+			d = time.Duration(len(aus)) * mpeg4audio.SamplesPerAccessUnit * time.Second /
+				time.Duration(codec.Config.SampleRate)
+		}
+
+	case *codecs.MPEG1Audio:
+		for _, frame := range aus {
+			var h mpeg1audio.FrameHeader
+			if h.Unmarshal(frame) == nil {
+				d += time.Duration(h.SampleCount()) * time.Second / time.Duration(h.SampleRate)
+			}
+		}
+
+	case *codecs.Opus:
+		for _, packet := range aus {
+			d += time.Duration(opus.PacketDuration2(packet)) * time.Second / 48000
+		}
+
+	case *codecs.AC3:
+		for _, frame := range aus {
+			var syncInfo ac3.SyncInfo
+			if syncInfo.Unmarshal(frame) == nil {
+				d += ac3.SamplesPerFrame * time.Second / time.Duration(syncInfo.SampleRate())
+			}
+		}
+	}
+
+	return d
+}
+
 func partDurationIsCompatible(partDuration time.Duration, sampleDuration time.Duration) bool {
 	if sampleDuration > partDuration {
 		return false
@@ -73,30 +111,34 @@ func findCompatiblePartDuration(
 
 type fmp4AugmentedSample struct {
 	fmp4.Sample
-	dts int64
-	ntp time.Time
+	dts           int64
+	ntp           time.Time
+	randomAccess  bool
+	paramsChanged bool
 }
 
 type muxerSegmenterParent interface {
 	createFirstSegment(nextDTS time.Duration, nextNTP time.Time) error
 	rotateSegments(nextDTS time.Duration, nextNTP time.Time, force bool) error
 	rotateParts(nextDTS time.Duration) error
+	rotateFollowerSegments(stream *muxerStream, dts time.Duration, randomAccess bool, paramsChanged bool) (bool, error)
+	dateRangeReached(nextDTS time.Duration) bool
 }
 
 type muxerSegmenter struct {
 	variant            MuxerVariant
 	segmentMinDuration time.Duration
 	partMinDuration    time.Duration
+	leadingTrack       *muxerTrack
 	parent             muxerSegmenterParent
 
-	pendingParamsChange            bool
 	fmp4SampleDurations            map[time.Duration]struct{} // low-latency only
 	fmp4AdjustedPartDuration       time.Duration              // low-latency only
 	fmp4FreezeAdjustedPartDuration bool                       // low-latency only
 }
 
 func (s *muxerSegmenter) initialize() {
-	if s.variant != MuxerVariantMPEGTS {
+	if s.variant != MuxerVariantMPEGTS && s.variant != MuxerVariantPackedAudio {
 		s.fmp4SampleDurations = make(map[time.Duration]struct{})
 	}
 }
@@ -117,15 +159,15 @@ func (s *muxerSegmenter) writeAV1(
 			randomAccess = true
 
 			if !bytes.Equal(codec.SequenceHeader, obu) {
-				s.pendingParamsChange = true
+				track.pendingParamsChange = true
 				codec.SequenceHeader = obu
 			}
 		}
 	}
 
 	paramsChanged := false
-	if randomAccess && s.pendingParamsChange {
-		s.pendingParamsChange = false
+	if randomAccess && track.pendingParamsChange {
+		track.pendingParamsChange = false
 		paramsChanged = true
 	}
 
@@ -165,34 +207,34 @@ func (s *muxerSegmenter) writeVP9(
 		randomAccess = true
 
 		if v := h.Width(); v != codec.Width {
-			s.pendingParamsChange = true
+			track.pendingParamsChange = true
 			codec.Width = v
 		}
 		if v := h.Height(); v != codec.Height {
-			s.pendingParamsChange = true
+			track.pendingParamsChange = true
 			codec.Height = v
 		}
 		if h.Profile != codec.Profile {
-			s.pendingParamsChange = true
+			track.pendingParamsChange = true
 			codec.Profile = h.Profile
 		}
 		if h.ColorConfig.BitDepth != codec.BitDepth {
-			s.pendingParamsChange = true
+			track.pendingParamsChange = true
 			codec.BitDepth = h.ColorConfig.BitDepth
 		}
 		if v := h.ChromaSubsampling(); v != codec.ChromaSubsampling {
-			s.pendingParamsChange = true
+			track.pendingParamsChange = true
 			codec.ChromaSubsampling = v
 		}
 		if h.ColorConfig.ColorRange != codec.ColorRange {
-			s.pendingParamsChange = true
+			track.pendingParamsChange = true
 			codec.ColorRange = h.ColorConfig.ColorRange
 		}
 	}
 
 	paramsChanged := false
-	if randomAccess && s.pendingParamsChange {
-		s.pendingParamsChange = false
+	if randomAccess && track.pendingParamsChange {
+		track.pendingParamsChange = false
 		paramsChanged = true
 	}
 
@@ -236,27 +278,27 @@ func (s *muxerSegmenter) writeH265(
 
 		case h265.NALUType_VPS_NUT:
 			if !bytes.Equal(codec.VPS, nalu) {
-				s.pendingParamsChange = true
+				track.pendingParamsChange = true
 				codec.VPS = nalu
 			}
 
 		case h265.NALUType_SPS_NUT:
 			if !bytes.Equal(codec.SPS, nalu) {
-				s.pendingParamsChange = true
+				track.pendingParamsChange = true
 				codec.SPS = nalu
 			}
 
 		case h265.NALUType_PPS_NUT:
 			if !bytes.Equal(codec.PPS, nalu) {
-				s.pendingParamsChange = true
+				track.pendingParamsChange = true
 				codec.PPS = nalu
 			}
 		}
 	}
 
 	paramsChanged := false
-	if randomAccess && s.pendingParamsChange {
-		s.pendingParamsChange = false
+	if randomAccess && track.pendingParamsChange {
+		track.pendingParamsChange = false
 		paramsChanged = true
 	}
 
@@ -276,6 +318,10 @@ func (s *muxerSegmenter) writeH265(
 		return fmt.Errorf("unable to extract DTS: %w", err)
 	}
 
+	if s.variant == MuxerVariantMPEGTS {
+		return s.mpegtsWriteVideo(track, ntp, pts, dts, randomAccess, paramsChanged, au)
+	}
+
 	ps := &fmp4.Sample{}
 	err = ps.FillH265(
 		int32(pts-dts),
@@ -317,13 +363,13 @@ func (s *muxerSegmenter) writeH264(
 
 		case h264.NALUTypeSPS:
 			if !bytes.Equal(codec.SPS, nalu) {
-				s.pendingParamsChange = true
+				track.pendingParamsChange = true
 				codec.SPS = nalu
 			}
 
 		case h264.NALUTypePPS:
 			if !bytes.Equal(codec.PPS, nalu) {
-				s.pendingParamsChange = true
+				track.pendingParamsChange = true
 				codec.PPS = nalu
 			}
 		}
@@ -334,8 +380,8 @@ func (s *muxerSegmenter) writeH264(
 	}
 
 	paramsChanged := false
-	if randomAccess && s.pendingParamsChange {
-		s.pendingParamsChange = false
+	if randomAccess && track.pendingParamsChange {
+		track.pendingParamsChange = false
 		paramsChanged = true
 	}
 
@@ -356,32 +402,7 @@ func (s *muxerSegmenter) writeH264(
 	}
 
 	if s.variant == MuxerVariantMPEGTS {
-		if track.stream.nextSegment == nil {
-			err = s.parent.createFirstSegment(timestampToDuration(dts, track.ClockRate), ntp)
-			if err != nil {
-				return err
-			}
-		} else if randomAccess && // switch segment
-			((timestampToDuration(dts, track.ClockRate)-
-				track.stream.nextSegment.(*muxerSegmentMPEGTS).startDTS) >= s.segmentMinDuration ||
-				paramsChanged) {
-			err = s.parent.rotateSegments(timestampToDuration(dts, track.ClockRate), ntp, false)
-			if err != nil {
-				return err
-			}
-		}
-
-		err = track.stream.nextSegment.(*muxerSegmentMPEGTS).writeH264(
-			track,
-			pts,
-			dts,
-			au,
-		)
-		if err != nil {
-			return err
-		}
-
-		return nil
+		return s.mpegtsWriteVideo(track, ntp, pts, dts, randomAccess, paramsChanged, au)
 	}
 
 	ps := &fmp4.Sample{}
@@ -409,6 +430,10 @@ func (s *muxerSegmenter) writeOpus(
 	pts int64,
 	packets [][]byte,
 ) error {
+	if s.variant == MuxerVariantMPEGTS {
+		return s.mpegtsWriteAudio(track, ntp, pts, packets)
+	}
+
 	for _, packet := range packets {
 		err := s.fmp4WriteSample(
 			track,
@@ -441,33 +466,11 @@ func (s *muxerSegmenter) writeMPEG4Audio(
 	aus [][]byte,
 ) error {
 	if s.variant == MuxerVariantMPEGTS {
-		if track.isLeading {
-			if track.stream.nextSegment == nil {
-				err := s.parent.createFirstSegment(timestampToDuration(pts, track.ClockRate), ntp)
-				if err != nil {
-					return err
-				}
-			} else if track.stream.nextSegment.(*muxerSegmentMPEGTS).audioAUCount >= mpegtsSegmentMinAUCount && // switch segment
-				(timestampToDuration(pts, track.ClockRate)-
-					track.stream.nextSegment.(*muxerSegmentMPEGTS).startDTS) >= s.segmentMinDuration {
-				err := s.parent.rotateSegments(timestampToDuration(pts, track.ClockRate), ntp, false)
-				if err != nil {
-					return err
-				}
-			}
-		} else {
-			// wait for the video track
-			if track.stream.nextSegment == nil {
-				return nil
-			}
-		}
-
-		err := track.stream.nextSegment.(*muxerSegmentMPEGTS).writeMPEG4Audio(track, pts, aus)
-		if err != nil {
-			return err
-		}
+		return s.mpegtsWriteAudio(track, ntp, pts, aus)
+	}
 
-		return nil
+	if s.variant == MuxerVariantPackedAudio {
+		return s.packedAudioWriteAudio(track, ntp, pts, aus)
 	}
 
 	sampleRate := track.Codec.(*codecs.MPEG4Audio).Config.SampleRate
@@ -498,6 +501,120 @@ func (s *muxerSegmenter) writeMPEG4Audio(
 	return nil
 }
 
+func (s *muxerSegmenter) writeMPEG1Audio(
+	track *muxerTrack,
+	ntp time.Time,
+	pts int64,
+	frames [][]byte,
+) error {
+	if s.variant == MuxerVariantPackedAudio {
+		return s.packedAudioWriteAudio(track, ntp, pts, frames)
+	}
+
+	return s.mpegtsWriteAudio(track, ntp, pts, frames)
+}
+
+func (s *muxerSegmenter) writeAC3(
+	track *muxerTrack,
+	ntp time.Time,
+	pts int64,
+	frame []byte,
+) error {
+	return s.mpegtsWriteAudio(track, ntp, pts, [][]byte{frame})
+}
+
+func (s *muxerSegmenter) packedAudioWriteAudio(
+	track *muxerTrack,
+	ntp time.Time,
+	pts int64,
+	aus [][]byte,
+) error {
+	if track.stream.nextSegment == nil {
+		err := s.parent.createFirstSegment(timestampToDuration(pts, track.ClockRate), ntp)
+		if err != nil {
+			return err
+		}
+	} else if (timestampToDuration(pts, track.ClockRate)- // switch segment
+		track.stream.nextSegment.(*muxerSegmentPackedAudio).startDTS) >= s.segmentMinDuration ||
+		s.parent.dateRangeReached(timestampToDuration(pts, track.ClockRate)) {
+		err := s.parent.rotateSegments(timestampToDuration(pts, track.ClockRate), ntp, false)
+		if err != nil {
+			return err
+		}
+	}
+
+	track.endDTS = timestampToDuration(pts, track.ClockRate) + audioDuration(track.Codec, aus)
+
+	return track.stream.nextSegment.(*muxerSegmentPackedAudio).writeAudio(track, aus)
+}
+
+func (s *muxerSegmenter) mpegtsWriteVideo(
+	track *muxerTrack,
+	ntp time.Time,
+	pts int64,
+	dts int64,
+	randomAccess bool,
+	paramsChanged bool,
+	au [][]byte,
+) error {
+	if track.stream.nextSegment == nil {
+		err := s.parent.createFirstSegment(timestampToDuration(dts, track.ClockRate), ntp)
+		if err != nil {
+			return err
+		}
+		track.lastDTS = timestampToDuration(dts, track.ClockRate)
+	} else if randomAccess && // switch segment
+		((timestampToDuration(dts, track.ClockRate)-
+			track.stream.nextSegment.(*muxerSegmentMPEGTS).startDTS) >= s.segmentMinDuration ||
+			paramsChanged ||
+			s.parent.dateRangeReached(timestampToDuration(dts, track.ClockRate))) {
+		err := s.parent.rotateSegments(timestampToDuration(dts, track.ClockRate), ntp, false)
+		if err != nil {
+			return err
+		}
+	}
+
+	// the duration of the last frame is unknown, use the one of the previous frame
+	track.endDTS = timestampToDuration(dts, track.ClockRate) +
+		max(timestampToDuration(dts, track.ClockRate)-track.lastDTS, 0)
+	track.lastDTS = timestampToDuration(dts, track.ClockRate)
+
+	return track.stream.nextSegment.(*muxerSegmentMPEGTS).writeH26x(track, pts, dts, au)
+}
+
+func (s *muxerSegmenter) mpegtsWriteAudio(
+	track *muxerTrack,
+	ntp time.Time,
+	pts int64,
+	aus [][]byte,
+) error {
+	if track.isLeading {
+		if track.stream.nextSegment == nil {
+			err := s.parent.createFirstSegment(timestampToDuration(pts, track.ClockRate), ntp)
+			if err != nil {
+				return err
+			}
+		} else if (track.stream.nextSegment.(*muxerSegmentMPEGTS).audioAUCount >= mpegtsSegmentMinAUCount && // switch segment
+			(timestampToDuration(pts, track.ClockRate)-
+				track.stream.nextSegment.(*muxerSegmentMPEGTS).startDTS) >= s.segmentMinDuration) ||
+			s.parent.dateRangeReached(timestampToDuration(pts, track.ClockRate)) {
+			err := s.parent.rotateSegments(timestampToDuration(pts, track.ClockRate), ntp, false)
+			if err != nil {
+				return err
+			}
+		}
+	} else {
+		// wait for the video track
+		if track.stream.nextSegment == nil {
+			return nil
+		}
+	}
+
+	track.endDTS = timestampToDuration(pts, track.ClockRate) + audioDuration(track.Codec, aus)
+
+	return track.stream.nextSegment.(*muxerSegmentMPEGTS).writeAudio(track, pts, aus)
+}
+
 // iPhone iOS fails if part durations are less than 85% of maximum part duration.
 // find a part duration that is compatible with all sample durations
 func (s *muxerSegmenter) fmp4AdjustPartDuration(sampleDuration time.Duration) {
@@ -533,6 +650,9 @@ func (s *muxerSegmenter) fmp4WriteSample(
 		return fmt.Errorf("sample timestamp is impossible to handle")
 	}
 
+	sample.randomAccess = randomAccess
+	sample.paramsChanged = paramsChanged
+
 	// put samples into a queue in order to compute the sample duration
 	sample, track.fmp4NextSample = track.fmp4NextSample, sample
 	if sample == nil {
@@ -546,6 +666,7 @@ func (s *muxerSegmenter) fmp4WriteSample(
 	}
 
 	sample.Duration = uint32(duration)
+	track.fmp4LastDuration = sample.Duration
 
 	if track.isLeading {
 		// create first segment
@@ -555,6 +676,11 @@ func (s *muxerSegmenter) fmp4WriteSample(
 				return err
 			}
 		}
+	} else if track.Codec.IsVideo() {
+		// additional video tracks switch segment on the segment boundaries of the leading track,
+		// in order to keep segments of all variants aligned.
+		track.fmp4FollowerSamples = append(track.fmp4FollowerSamples, sample)
+		return s.fmp4WriteFollowerSamples(track)
 	} else {
 		// wait for the leading track
 		if track.stream.nextSegment == nil {
@@ -578,7 +704,8 @@ func (s *muxerSegmenter) fmp4WriteSample(
 		// switch segment
 		if randomAccess && (paramsChanged ||
 			(timestampToDuration(track.fmp4NextSample.dts, track.ClockRate)-
-				track.stream.nextSegment.(*muxerSegmentFMP4).startDTS) >= s.segmentMinDuration) {
+				track.stream.nextSegment.(*muxerSegmentFMP4).startDTS) >= s.segmentMinDuration ||
+			s.parent.dateRangeReached(timestampToDuration(track.fmp4NextSample.dts, track.ClockRate))) {
 			err = s.parent.rotateSegments(timestampToDuration(track.fmp4NextSample.dts, track.ClockRate),
 				track.fmp4NextSample.ntp, paramsChanged)
 			if err != nil {
@@ -606,3 +733,75 @@ func (s *muxerSegmenter) fmp4WriteSample(
 
 	return nil
 }
+
+// fmp4WriteFollowerSamples writes samples of an additional video track.
+// Samples wait until the leading track has decided the segment boundaries that precede them.
+func (s *muxerSegmenter) fmp4WriteFollowerSamples(track *muxerTrack) error {
+	for len(track.fmp4FollowerSamples) != 0 {
+		sample := track.fmp4FollowerSamples[0]
+		dts := timestampToDuration(sample.dts, track.ClockRate)
+
+		// when the stream is ending, the leading track has no pending sample
+		// and remaining samples are written into the last segment.
+		if s.leadingTrack.stream.nextSegment == nil ||
+			(s.leadingTrack.fmp4NextSample != nil &&
+				dts > timestampToDuration(s.leadingTrack.fmp4NextSample.dts, s.leadingTrack.ClockRate)) {
+			if len(track.fmp4FollowerSamples) > followerMaxPendingSamples {
+				return fmt.Errorf("additional video track is too far ahead of the leading track")
+			}
+			return nil
+		}
+
+		track.fmp4FollowerSamples = track.fmp4FollowerSamples[1:]
+
+		ok, err := s.parent.rotateFollowerSegments(
+			track.stream,
+			dts,
+			sample.randomAccess,
+			sample.paramsChanged)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+
+		err = track.stream.nextPart.writeSample(
+			track,
+			sample,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// fmp4WriteLastSample writes the pending sample of a track when the stream ends.
+// Since there's no next sample, its duration is assumed to be equal to the one of the previous sample.
+// It returns the DTS of the end of the sample.
+func (s *muxerSegmenter) fmp4WriteLastSample(track *muxerTrack) (time.Duration, error) {
+	sample := track.fmp4NextSample
+	endDTS := timestampToDuration(sample.dts, track.ClockRate)
+
+	// the duration of a single sample is unknown
+	if track.fmp4LastDuration == 0 {
+		return endDTS, nil
+	}
+
+	track.fmp4NextSample = nil
+	sample.Duration = track.fmp4LastDuration
+	endDTS = timestampToDuration(sample.dts+int64(sample.Duration), track.ClockRate)
+
+	if !track.isLeading && track.Codec.IsVideo() {
+		track.fmp4FollowerSamples = append(track.fmp4FollowerSamples, sample)
+		return endDTS, s.fmp4WriteFollowerSamples(track)
+	}
+
+	if track.stream.nextSegment == nil {
+		return endDTS, nil
+	}
+
+	return endDTS, track.stream.nextPart.writeSample(track, sample)
+}
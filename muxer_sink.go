@@ -0,0 +1,272 @@
+package gohlslib
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// MuxerSink is a destination of the files produced by the muxer,
+// that allows to serve the stream with a static web server or a CDN origin.
+type MuxerSink interface {
+	// WriteFile writes a file atomically, replacing any existing file with the same name.
+	WriteFile(name string, r io.Reader) error
+
+	// RemoveFile removes a file.
+	RemoveFile(name string) error
+}
+
+// MuxerSinkDirectory is a MuxerSink that writes files into a directory.
+type MuxerSinkDirectory struct {
+	// Path of the directory.
+	Path string
+	// Permissions of written files.
+	// It defaults to 0644.
+	Perm os.FileMode
+}
+
+// WriteFile implements MuxerSink.
+// Files are written into temporary files, that are flushed to disk and renamed once complete.
+func (s *MuxerSinkDirectory) WriteFile(name string, r io.Reader) error {
+	f, err := os.CreateTemp(s.Path, "."+name+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	err = s.writeTempFile(f, r)
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+
+	err = f.Close()
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	err = os.Rename(f.Name(), filepath.Join(s.Path, name))
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	return nil
+}
+
+func (s *MuxerSinkDirectory) writeTempFile(f *os.File, r io.Reader) error {
+	// temporary files are created with mode 0600
+	perm := s.Perm
+	if perm == 0 {
+		perm = 0o644
+	}
+
+	err := f.Chmod(perm)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(f, r)
+	if err != nil {
+		return err
+	}
+
+	return f.Sync()
+}
+
+// RemoveFile implements MuxerSink.
+func (s *MuxerSinkDirectory) RemoveFile(name string) error {
+	err := os.Remove(filepath.Join(s.Path, name))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+type muxerSinkOp struct {
+	name string
+	r    io.ReadCloser // nil when the file must be removed
+}
+
+// muxerSinkWriter performs sink operations in a dedicated routine,
+// in order to avoid blocking the muxer while its mutex is locked.
+// Operations are performed in the same order in which they are pushed.
+type muxerSinkWriter struct {
+	sink          MuxerSink
+	onEncodeError MuxerOnEncodeErrorFunc
+
+	mutex   sync.Mutex
+	cond    *sync.Cond
+	queue   []*muxerSinkOp
+	running bool
+	closed  bool
+	done    chan struct{}
+}
+
+func (w *muxerSinkWriter) initialize() {
+	w.cond = sync.NewCond(&w.mutex)
+	w.done = make(chan struct{})
+}
+
+func (w *muxerSinkWriter) start() {
+	go w.run()
+}
+
+// close performs pending operations and stops the routine.
+func (w *muxerSinkWriter) close() {
+	w.mutex.Lock()
+	w.closed = true
+	w.mutex.Unlock()
+
+	w.cond.Broadcast()
+
+	<-w.done
+}
+
+// push adds an operation to the queue.
+func (w *muxerSinkWriter) push(op *muxerSinkOp) {
+	w.mutex.Lock()
+	w.queue = append(w.queue, op)
+	w.mutex.Unlock()
+
+	w.cond.Broadcast()
+}
+
+// flush waits until all pending operations are performed.
+func (w *muxerSinkWriter) flush() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	for len(w.queue) != 0 || w.running {
+		w.cond.Wait()
+	}
+}
+
+func (w *muxerSinkWriter) run() {
+	defer close(w.done)
+
+	for {
+		w.mutex.Lock()
+
+		for len(w.queue) == 0 && !w.closed {
+			w.cond.Wait()
+		}
+
+		if len(w.queue) == 0 {
+			w.mutex.Unlock()
+			return
+		}
+
+		op := w.queue[0]
+		w.queue = w.queue[1:]
+		w.running = true
+
+		w.mutex.Unlock()
+
+		// errors do not stop the muxer, since files are also served through Handle()
+		err := w.perform(op)
+		if err != nil {
+			w.onEncodeError(fmt.Errorf("unable to write %s into sink: %w", op.name, err))
+		}
+
+		w.mutex.Lock()
+		w.running = false
+		w.mutex.Unlock()
+
+		w.cond.Broadcast()
+	}
+}
+
+func (w *muxerSinkWriter) perform(op *muxerSinkOp) error {
+	if op.r == nil {
+		return w.sink.RemoveFile(op.name)
+	}
+
+	defer op.r.Close()
+	return w.sink.WriteFile(op.name, op.r)
+}
+
+func (w *muxerSinkWriter) writeFile(name string, byts []byte) {
+	w.push(&muxerSinkOp{
+		name: name,
+		r:    io.NopCloser(bytes.NewReader(byts)),
+	})
+}
+
+func (w *muxerSinkWriter) removeFile(name string) {
+	w.push(&muxerSinkOp{
+		name: name,
+	})
+}
+
+func (m *Muxer) writeSinkPlaylists() {
+	if m.sinkWriter == nil {
+		return
+	}
+
+	for _, stream := range m.streams {
+		stream.writeSinkPlaylists()
+	}
+
+	if !m.streams[0].playlistAvailable() {
+		return
+	}
+
+	byts, err := m.generateMultivariantPlaylist("")
+	if err != nil {
+		m.OnEncodeError(err)
+		return
+	}
+
+	m.sinkWriter.writeFile("index.m3u8", byts)
+}
+
+// writeSinkSegment opens the segment immediately,
+// since its storage is removed when the segment is deleted.
+func (s *muxerStream) writeSinkSegment(segment muxerSegment, key []byte, segmentID uint64) {
+	r, _, err := openSegment(segment, key, segmentID)
+	if err != nil {
+		s.onEncodeError(err)
+		return
+	}
+
+	s.sinkWriter.push(&muxerSinkOp{
+		name: segment.getPath(),
+		r:    r,
+	})
+}
+
+func (s *muxerStream) writeSinkPlaylists() {
+	if !s.playlistAvailable() {
+		return
+	}
+
+	byts, err := s.generateMediaPlaylist(false, "")
+	if err != nil {
+		s.onEncodeError(err)
+		return
+	}
+
+	s.sinkWriter.writeFile(mediaPlaylistPath(s.id), byts)
+
+	if s.iframePlaylist {
+		byts, err = s.generateIFramePlaylist("")
+		if err != nil {
+			s.onEncodeError(err)
+			return
+		}
+
+		s.sinkWriter.writeFile(iframePlaylistPath(s.id), byts)
+	}
+
+	// segments are removed once they are not referenced by playlists anymore
+	for _, name := range s.sinkPendingRemovals {
+		s.sinkWriter.removeFile(name)
+	}
+	s.sinkPendingRemovals = nil
+}
@@ -3,6 +3,7 @@ package gohlslib
 import (
 	"fmt"
 	"io"
+	"log/slog"
 	"math"
 	"net/http"
 	"net/url"
@@ -81,6 +82,87 @@ func partTargetDuration(
 	return time.Millisecond * time.Duration(math.Ceil(float64(ret)/float64(time.Millisecond)))
 }
 
+func iframeBandwidth(segments []muxerSegment) (int, int) {
+	var maxBandwidth uint64
+	var sizes uint64
+	var durations time.Duration
+
+	for _, seg := range segments {
+		// segments with zero duration do not contribute to bandwidth.
+		if _, ok := seg.(*muxerGap); !ok && seg.getDuration() > 0 {
+			bandwidth := 8 * seg.getIFrameSize() * uint64(time.Second) / uint64(seg.getDuration())
+			if bandwidth > maxBandwidth {
+				maxBandwidth = bandwidth
+			}
+			sizes += seg.getIFrameSize()
+			durations += seg.getDuration()
+		}
+	}
+
+	if durations == 0 {
+		return 0, 0
+	}
+
+	averageBandwidth := 8 * sizes * uint64(time.Second) / uint64(durations)
+
+	return int(maxBandwidth), int(averageBandwidth)
+}
+
+// serveWithByteRange writes the content of r,
+// honoring a single byte range requested through the Range header.
+func serveWithByteRange(w http.ResponseWriter, req *http.Request, r io.Reader, size uint64) {
+	w.Header().Set("Accept-Ranges", "bytes")
+
+	start, length, ok := parseByteRange(req.Header.Get("Range"), size)
+	if !ok {
+		w.WriteHeader(http.StatusOK)
+		io.Copy(w, r)
+		return
+	}
+
+	_, err := io.CopyN(io.Discard, r, int64(start))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Range", "bytes "+strconv.FormatUint(start, 10)+"-"+
+		strconv.FormatUint(start+length-1, 10)+"/"+strconv.FormatUint(size, 10))
+	w.Header().Set("Content-Length", strconv.FormatUint(length, 10))
+	w.WriteHeader(http.StatusPartialContent)
+	io.CopyN(w, r, int64(length)) //nolint:errcheck
+}
+
+func parseByteRange(header string, size uint64) (uint64, uint64, bool) {
+	v, ok := strings.CutPrefix(header, "bytes=")
+	if !ok || strings.Contains(v, ",") {
+		return 0, 0, false
+	}
+
+	startStr, endStr, ok := strings.Cut(v, "-")
+	if !ok || startStr == "" {
+		return 0, 0, false
+	}
+
+	start, err := strconv.ParseUint(startStr, 10, 64)
+	if err != nil || start >= size {
+		return 0, 0, false
+	}
+
+	end := size - 1
+	if endStr != "" {
+		end, err = strconv.ParseUint(endStr, 10, 64)
+		if err != nil || end < start {
+			return 0, 0, false
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+
+	return start, end - start + 1, true
+}
+
 type generateMediaPlaylistFunc func(
 	isDeltaUpdate bool,
 	rawQuery string,
@@ -90,7 +172,10 @@ type muxerStream struct {
 	variant        MuxerVariant
 	segmentMaxSize uint64
 	segmentCount   int
+	playlistType   MuxerPlaylistType
+	dvrWindow      time.Duration
 	onEncodeError  MuxerOnEncodeErrorFunc
+	logger         *slog.Logger
 	mutex          *sync.Mutex
 	cond           *sync.Cond
 	prefix         string
@@ -99,32 +184,63 @@ type muxerStream struct {
 	tracks         []*muxerTrack
 	id             string
 	isLeading      bool
+	isVariant      bool
 	isRendition    bool
 	name           string
 	language       string
 	isDefault      bool
+	isForced       bool
+	iframePlaylist bool
+	keyring        *muxerKeyring // encryption only
+	timedMetadata  bool
+	dateRanges     *muxerDateRanges
+	sinkWriter     *muxerSinkWriter
 	nextSegmentID  uint64
 	nextPartID     uint64
 
 	generateMediaPlaylist  generateMediaPlaylistFunc
 	mpegtsSwitchableWriter *switchableWriter // mpegts only
 	mpegtsWriter           *mpegts.Writer    // mpegts only
+	mpegtsID3Writer        *mpegtsID3Writer  // mpegts + timed metadata only
 	segments               []muxerSegment
 	nextSegment            muxerSegment
-	nextPart               *muxerPart // low-latency only
-	initFilePresent        bool       // fmp4 only
+	nextPart               *muxerPart        // low-latency only
+	webvttCues             []*muxerWebVTTCue // webvtt only
+	initFilePresent        bool              // fmp4 only
 	segmentDeleteCount     int
+	sinkPendingRemovals    []string                // sink only
+	followerBoundaries     []*muxerSegmentBoundary // video followers only
 	closed                 bool
+	ended                  bool
 	targetDuration         int
 	partTargetDuration     time.Duration
 }
 
+// muxerSegmentBoundary is the start of a segment of the leading stream.
+type muxerSegmentBoundary struct {
+	segmentID uint64
+	dts       time.Duration
+	ntp       time.Time
+	force     bool
+}
+
+// packedAudioExt returns the extension of packed audio segments.
+func (s *muxerStream) packedAudioExt() string {
+	if _, ok := s.tracks[0].Codec.(*codecs.MPEG1Audio); ok {
+		return ".mp3"
+	}
+	return ".aac"
+}
+
 func (s *muxerStream) initialize() error {
-	for _, track := range s.tracks {
+	for i, track := range s.tracks {
 		track.stream = s
+		track.fmp4ID = 1 + i
 	}
 
-	if s.variant == MuxerVariantMPEGTS {
+	if s.isSubtitles() {
+		s.generateMediaPlaylist = s.generateMediaPlaylistMPEGTS
+	} else if s.variant == MuxerVariantMPEGTS {
 		s.generateMediaPlaylist = s.generateMediaPlaylistMPEGTS
 
 		tracks := make([]*mpegts.Track, len(s.tracks))
@@ -132,16 +248,47 @@ func (s *muxerStream) initialize() error {
 			tracks[i] = track.mpegtsTrack
 		}
 		s.mpegtsSwitchableWriter = &switchableWriter{}
-		s.mpegtsWriter = &mpegts.Writer{W: s.mpegtsSwitchableWriter, Tracks: tracks}
+
+		var w io.Writer = s.mpegtsSwitchableWriter
+		var pmtWriter *mpegtsPMTWriter
+
+		if (s.keyring != nil && s.keyring.method == MuxerEncryptionSampleAES) || s.timedMetadata {
+			pmtWriter = &mpegtsPMTWriter{w: w}
+			if s.keyring != nil && s.keyring.method == MuxerEncryptionSampleAES {
+				pmtWriter.sampleAESTracks = s.tracks
+			}
+			w = pmtWriter
+		}
+
+		s.mpegtsWriter = &mpegts.Writer{W: w, Tracks: tracks}
 		err := s.mpegtsWriter.Initialize()
 		if err != nil {
 			return err
 		}
+
+		if s.timedMetadata {
+			// use the PID that follows the ones of tracks
+			pid := uint16(0)
+			for _, track := range tracks {
+				pid = max(pid, track.PID)
+			}
+			pid++
+
+			s.mpegtsID3Writer = &mpegtsID3Writer{w: w, pid: pid}
+			pmtWriter.id3PID = pid
+		}
+	} else if s.variant == MuxerVariantPackedAudio {
+		s.generateMediaPlaylist = s.generateMediaPlaylistMPEGTS
 	} else {
 		s.generateMediaPlaylist = s.generateMediaPlaylistFMP4
 	}
 
 	s.server.registerPath(mediaPlaylistPath(s.id), s.handleMediaPlaylist)
+
+	if s.iframePlaylist {
+		s.server.registerPath(iframePlaylistPath(s.id), s.handleIFramePlaylist)
+	}
+
 	return nil
 }
 
@@ -162,14 +309,107 @@ func (s *muxerStream) close() {
 	}
 }
 
+// end finalizes the segment in progress without creating a new one.
+func (s *muxerStream) end(endDTS time.Duration) error {
+	s.ended = true
+
+	if s.nextSegment == nil {
+		return nil
+	}
+
+	// discard the segment in progress when it is empty
+	if endDTS <= s.nextSegmentStartDTS() {
+		if s.nextPart != nil {
+			s.nextPart.finalize(0) //nolint:errcheck
+			s.nextPart = nil
+		}
+
+		s.nextSegment.finalize(0) //nolint:errcheck
+		s.nextSegment.close()
+		s.nextSegment = nil
+		return nil
+	}
+
+	if s.variant != MuxerVariantMPEGTS && s.variant != MuxerVariantPackedAudio && !s.isSubtitles() {
+		err := s.rotateParts(endDTS, false)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := s.storeNextSegment(endDTS)
+	if err != nil {
+		return err
+	}
+
+	s.updateTargetDuration()
+
+	return nil
+}
+
+func (s *muxerStream) nextSegmentStartDTS() time.Duration {
+	switch seg := s.nextSegment.(type) {
+	case *muxerSegmentMPEGTS:
+		return seg.startDTS
+
+	case *muxerSegmentPackedAudio:
+		return seg.startDTS
+
+	case *muxerSegmentWebVTT:
+		return seg.startDTS
+
+	default:
+		return s.nextSegment.(*muxerSegmentFMP4).startDTS
+	}
+}
+
+// isSubtitles returns whether the stream contains a subtitle track.
+func (s *muxerStream) isSubtitles() bool {
+	return isSubtitles(s.tracks[0].Codec)
+}
+
+// isVideoFollower returns whether the stream contains a video track that is not the leading one.
+func (s *muxerStream) isVideoFollower() bool {
+	return !s.isLeading && s.tracks[0].Codec.IsVideo()
+}
+
+// renditionGroup returns the type and the group ID of the rendition.
+func (s *muxerStream) renditionGroup() (playlist.MultivariantRenditionType, string) {
+	switch {
+	case s.isSubtitles():
+		return playlist.MultivariantRenditionTypeSubtitles, "subtitles"
+
+	case s.tracks[0].Codec.IsVideo():
+		return playlist.MultivariantRenditionTypeVideo, "video"
+
+	default:
+		return playlist.MultivariantRenditionTypeAudio, "audio"
+	}
+}
+
 func (s *muxerStream) populateMultivariantPlaylist(
 	pl *playlist.Multivariant,
+	mv *playlist.MultivariantVariant,
 	rawQuery string,
 ) error {
-	mv := pl.Variants[0]
-
 	for _, track := range s.tracks {
+		// WebVTT segments are not described by codecs
+		if isSubtitles(track.Codec) {
+			continue
+		}
+
 		codec := codecparams.Marshal(track.Codec)
+
+		// codecs of renditions are added to all variants
+		if mv == nil {
+			for _, v := range pl.Variants {
+				if !slices.Contains(v.Codecs, codec) {
+					v.Codecs = append(v.Codecs, codec)
+				}
+			}
+			continue
+		}
+
 		if !slices.Contains(mv.Codecs, codec) {
 			mv.Codecs = append(mv.Codecs, codec)
 		}
@@ -226,20 +466,55 @@ func (s *muxerStream) populateMultivariantPlaylist(
 		uri += "?" + rawQuery
 	}
 
-	if s.isLeading {
+	if mv != nil {
 		mv.URI = uri
 	}
 
+	if s.iframePlaylist {
+		maxBandwidth, averageBandwidth := iframeBandwidth(s.segments)
+
+		iv := &playlist.MultivariantIFrameVariant{
+			Bandwidth:        maxBandwidth,
+			AverageBandwidth: &averageBandwidth,
+			Resolution:       mv.Resolution,
+			URI:              iframePlaylistPath(s.id),
+		}
+
+		if rawQuery != "" {
+			iv.URI += "?" + rawQuery
+		}
+
+		for _, track := range s.tracks {
+			if track.Codec.IsVideo() {
+				iv.Codecs = append(iv.Codecs, codecparams.Marshal(track.Codec))
+			}
+		}
+
+		pl.IFrameVariants = append(pl.IFrameVariants, iv)
+	}
+
 	if s.isRendition {
-		mv.Audio = "audio"
+		typ, groupID := s.renditionGroup()
+
+		for _, v := range pl.Variants {
+			switch typ {
+			case playlist.MultivariantRenditionTypeSubtitles:
+				v.Subtitles = groupID
+			case playlist.MultivariantRenditionTypeVideo:
+				v.Video = groupID
+			default:
+				v.Audio = groupID
+			}
+		}
 
 		r := &playlist.MultivariantRendition{
-			Type:       playlist.MultivariantRenditionTypeAudio,
-			GroupID:    "audio",
+			Type:       typ,
+			GroupID:    groupID,
 			Name:       s.name,
 			Language:   s.language,
 			Autoselect: true,
 			Default:    s.isDefault,
+			Forced:     s.isForced,
 		}
 
 		// draft-pantos-hls-rfc8216bis:
@@ -247,7 +522,7 @@ func (s *muxerStream) populateMultivariantPlaylist(
 		// indicates that the media data for this Rendition is included in the
 		// Media Playlist of any EXT-X-STREAM-INF tag referencing this EXT-
 		// X-MEDIA tag.
-		if !s.isLeading {
+		if mv == nil {
 			r.URI = &uri
 		}
 
@@ -257,6 +532,33 @@ func (s *muxerStream) populateMultivariantPlaylist(
 	return nil
 }
 
+// mustDeleteOldestSegment returns whether the oldest segment is out of the playlist window.
+func (s *muxerStream) mustDeleteOldestSegment() bool {
+	if s.playlistType != MuxerPlaylistTypeLive || len(s.segments) <= s.segmentCount {
+		return false
+	}
+
+	if s.dvrWindow == 0 {
+		return true
+	}
+
+	var windowDuration time.Duration
+	for _, seg := range s.segments[1:] {
+		windowDuration += seg.getDuration()
+	}
+
+	return windowDuration >= s.dvrWindow
+}
+
+// playlistAvailable returns whether playlists can be delivered.
+// VOD playlists are delivered once the stream has ended.
+func (s *muxerStream) playlistAvailable() bool {
+	if s.playlistType == MuxerPlaylistTypeVOD {
+		return s.ended
+	}
+	return s.hasContent() || s.ended
+}
+
 func (s *muxerStream) hasContent() bool {
 	if s.variant == MuxerVariantFMP4 {
 		return len(s.segments) >= 2
@@ -312,12 +614,20 @@ func (s *muxerStream) handleMediaPlaylist(w http.ResponseWriter, r *http.Request
 				s.mutex.Lock()
 				defer s.mutex.Unlock()
 
+				stop := wakeUpOnDone(r.Context(), s.mutex, s.cond)
+				defer stop()
+
 				for {
-					if s.closed {
+					if s.closed || r.Context().Err() != nil {
 						w.WriteHeader(http.StatusInternalServerError)
 						return nil
 					}
 
+					// the stream is over, return the final playlist
+					if s.ended {
+						break
+					}
+
 					// If the _HLS_msn is greater than the Media Sequence Number of the last
 					// Media Segment in the current Playlist plus two, or if the _HLS_part
 					// exceeds the last Partial Segment in the current Playlist by the
@@ -332,6 +642,9 @@ func (s *muxerStream) handleMediaPlaylist(w http.ResponseWriter, r *http.Request
 						break
 					}
 
+					s.logger.Debug("blocking playlist request",
+						"media_sequence", msnint, "part", partint)
+
 					s.cond.Wait()
 				}
 
@@ -362,27 +675,47 @@ func (s *muxerStream) handleMediaPlaylist(w http.ResponseWriter, r *http.Request
 		}
 	}
 
+	s.servePlaylist(w, r, func() ([]byte, error) {
+		return s.generateMediaPlaylist(
+			isDeltaUpdate,
+			r.URL.RawQuery,
+		)
+	})
+}
+
+func (s *muxerStream) handleIFramePlaylist(w http.ResponseWriter, r *http.Request) {
+	s.servePlaylist(w, r, func() ([]byte, error) {
+		return s.generateIFramePlaylist(r.URL.RawQuery)
+	})
+}
+
+// servePlaylist waits until the stream has content, then writes the playlist produced by generate.
+func (s *muxerStream) servePlaylist(
+	w http.ResponseWriter,
+	r *http.Request,
+	generate func() ([]byte, error),
+) {
 	byts := func() []byte {
 		s.mutex.Lock()
 		defer s.mutex.Unlock()
 
+		stop := wakeUpOnDone(r.Context(), s.mutex, s.cond)
+		defer stop()
+
 		for {
-			if s.closed {
+			if s.closed || r.Context().Err() != nil {
 				w.WriteHeader(http.StatusInternalServerError)
 				return nil
 			}
 
-			if s.hasContent() {
+			if s.playlistAvailable() {
 				break
 			}
 
 			s.cond.Wait()
 		}
 
-		byts, err := s.generateMediaPlaylist(
-			isDeltaUpdate,
-			r.URL.RawQuery,
-		)
+		byts, err := generate()
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			return nil
@@ -399,6 +732,17 @@ func (s *muxerStream) handleMediaPlaylist(w http.ResponseWriter, r *http.Request
 	}
 }
 
+func (s *muxerStream) mediaPlaylistType() *playlist.MediaPlaylistType {
+	switch s.playlistType {
+	case MuxerPlaylistTypeEvent:
+		return ptrOf(playlist.MediaPlaylistTypeEvent)
+
+	case MuxerPlaylistTypeVOD:
+		return ptrOf(playlist.MediaPlaylistTypeVOD)
+	}
+	return nil
+}
+
 func (s *muxerStream) generateMediaPlaylistMPEGTS(
 	_ bool,
 	rawQuery string,
@@ -408,23 +752,52 @@ func (s *muxerStream) generateMediaPlaylistMPEGTS(
 		AllowCache:     ptrOf(false),
 		TargetDuration: s.targetDuration,
 		MediaSequence:  s.segmentDeleteCount,
+		PlaylistType:   s.mediaPlaylistType(),
+		Endlist:        s.ended,
 	}
 
-	for _, s := range s.segments {
-		if seg, ok := s.(*muxerSegmentMPEGTS); ok {
-			uri := seg.path
-			if rawQuery != "" {
-				uri += "?" + rawQuery
-			}
+	// SAMPLE-AES and KEYFORMAT require version 5
+	if s.keyring != nil && (s.keyring.method == MuxerEncryptionSampleAES || s.keyring.keyFormat != "") {
+		pl.Version = 5
+	}
 
-			pl.Segments = append(pl.Segments, &playlist.MediaSegment{
-				DateTime: &seg.startNTP,
-				Duration: seg.getDuration(),
-				URI:      uri,
-			})
+	for i, sog := range s.segments {
+		var startNTP time.Time
+
+		switch seg := sog.(type) {
+		case *muxerSegmentMPEGTS:
+			startNTP = seg.startNTP
+
+		case *muxerSegmentPackedAudio:
+			startNTP = seg.startNTP
+
+		case *muxerSegmentWebVTT:
+			startNTP = seg.startNTP
+
+		default:
+			continue
+		}
+
+		uri := sog.getPath()
+		if rawQuery != "" {
+			uri += "?" + rawQuery
+		}
+
+		plse := &playlist.MediaSegment{
+			DateTime: &startNTP,
+			Duration: sog.getDuration(),
+			URI:      uri,
+		}
+
+		if s.keyring != nil {
+			plse.Key = s.keyring.mediaKey(uint64(s.segmentDeleteCount+i), rawQuery)
 		}
+
+		pl.Segments = append(pl.Segments, plse)
 	}
 
+	s.dateRanges.populate(pl.Segments, uint64(s.segmentDeleteCount), uint64(s.segmentDeleteCount))
+
 	return pl.Marshal()
 }
 
@@ -439,6 +812,8 @@ func (s *muxerStream) generateMediaPlaylistFMP4(
 		Version:        10,
 		TargetDuration: s.targetDuration,
 		MediaSequence:  s.segmentDeleteCount,
+		PlaylistType:   s.mediaPlaylistType(),
+		Endlist:        s.ended,
 	}
 
 	if s.variant == MuxerVariantLowLatency {
@@ -504,6 +879,10 @@ func (s *muxerStream) generateMediaPlaylistFMP4(
 				plse.DateTime = &seg.startNTP
 			}
 
+			if s.keyring != nil {
+				plse.Key = s.keyring.mediaKey(uint64(s.segmentDeleteCount+i), rawQuery)
+			}
+
 			if s.variant == MuxerVariantLowLatency && (len(s.segments)-i) <= 2 {
 				for _, part := range seg.parts {
 					u = part.path
@@ -530,7 +909,9 @@ func (s *muxerStream) generateMediaPlaylistFMP4(
 		}
 	}
 
-	if s.variant == MuxerVariantLowLatency {
+	s.dateRanges.populate(pl.Segments, uint64(s.segmentDeleteCount), uint64(s.segmentDeleteCount+skipped))
+
+	if s.variant == MuxerVariantLowLatency && !s.ended {
 		for _, part := range s.nextSegment.(*muxerSegmentFMP4).parts {
 			u := part.path
 			if rawQuery != "" {
@@ -558,17 +939,92 @@ func (s *muxerStream) generateMediaPlaylistFMP4(
 	return pl.Marshal()
 }
 
+func (s *muxerStream) generateIFramePlaylist(rawQuery string) ([]byte, error) {
+	rawQuery = filterOutHLSParams(rawQuery)
+
+	pl := &playlist.Media{
+		TargetDuration: s.targetDuration,
+		MediaSequence:  s.segmentDeleteCount,
+		PlaylistType:   s.mediaPlaylistType(),
+		IFramesOnly:    true,
+		Endlist:        s.ended,
+	}
+
+	if s.variant == MuxerVariantMPEGTS {
+		pl.Version = 4
+	} else {
+		pl.Version = 10
+
+		uri := initFilePath(s.prefix, s.id)
+		if rawQuery != "" {
+			uri += "?" + rawQuery
+		}
+
+		pl.Map = &playlist.MediaMap{
+			URI: uri,
+		}
+	}
+
+	for _, sog := range s.segments {
+		if _, ok := sog.(*muxerGap); ok {
+			pl.Segments = append(pl.Segments, &playlist.MediaSegment{
+				Gap:      true,
+				Duration: sog.getDuration(),
+				URI:      "gap.mp4",
+			})
+			continue
+		}
+
+		uri := sog.getPath()
+		if rawQuery != "" {
+			uri += "?" + rawQuery
+		}
+
+		// each segment starts with a random access unit,
+		// therefore its duration is the one of the segment.
+		pl.Segments = append(pl.Segments, &playlist.MediaSegment{
+			Duration:        sog.getDuration(),
+			URI:             uri,
+			ByteRangeLength: ptrOf(sog.getIFrameSize()),
+			ByteRangeStart:  ptrOf(uint64(0)),
+		})
+	}
+
+	return pl.Marshal()
+}
+
+// openSegment returns a reader of a segment, as delivered to clients, and its size.
+func openSegment(segment muxerSegment, key []byte, segmentID uint64) (io.ReadCloser, uint64, error) {
+	r, err := segment.reader()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	size := segment.getSize()
+
+	if key != nil {
+		var er *aes128Reader
+		er, err = newAES128Reader(r, key, segmentID)
+		if err != nil {
+			r.Close()
+			return nil, 0, err
+		}
+		r = er
+		size = aes128EncryptedSize(size)
+	}
+
+	return r, size, nil
+}
+
 func (s *muxerStream) generateAndCacheInitFile() error {
 	var init fmp4.Init
-	trackID := 1
 
 	for _, track := range s.tracks {
 		init.Tracks = append(init.Tracks, &fmp4.InitTrack{
-			ID:        trackID,
+			ID:        track.fmp4ID,
 			TimeScale: fmp4TimeScale(track.Codec),
 			Codec:     codecs.ToFMP4(track.Codec),
 		})
-		trackID++
 	}
 
 	var w seekablebuffer.Buffer
@@ -580,6 +1036,13 @@ func (s *muxerStream) generateAndCacheInitFile() error {
 	s.initFilePresent = true
 	initFile := w.Bytes()
 
+	if s.keyring != nil && s.keyring.method == MuxerEncryptionSampleAES {
+		initFile, err = cbcsProtectInit(initFile, s.tracks, cbcsKID(s.keyring.keyID(s.nextSegmentID)), s.keyring.iv)
+		if err != nil {
+			return err
+		}
+	}
+
 	var contentType string
 	if areAllAudio(s.tracks) {
 		contentType = "audio/mp4"
@@ -598,31 +1061,87 @@ func (s *muxerStream) generateAndCacheInitFile() error {
 			w.Write(initFile)
 		})
 
+	if s.sinkWriter != nil {
+		s.sinkWriter.writeFile(initFilePath(s.prefix, s.id), initFile)
+	}
+
 	return nil
 }
 
+// sampleAESEncrypter returns the encrypter of samples of a segment.
+func (s *muxerStream) sampleAESEncrypter(segmentID uint64) (*sampleAESEncrypter, error) {
+	if s.keyring == nil || s.keyring.method != MuxerEncryptionSampleAES {
+		return nil, nil
+	}
+
+	key, err := s.keyring.key(segmentID)
+	if err != nil {
+		return nil, err
+	}
+
+	return newSampleAESEncrypter(key, s.keyring.iv)
+}
+
 func (s *muxerStream) createFirstSegment(
 	nextDTS time.Duration,
 	nextNTP time.Time,
 ) error {
-	if s.variant == MuxerVariantMPEGTS { //nolint:dupl
+	encrypter, err := s.sampleAESEncrypter(s.nextSegmentID)
+	if err != nil {
+		return err
+	}
+
+	if s.isSubtitles() {
+		seg := &muxerSegmentWebVTT{
+			prefix:         s.prefix,
+			storageFactory: s.storageFactory,
+			streamID:       s.id,
+			id:             s.nextSegmentID,
+			startNTP:       nextNTP,
+			startDTS:       nextDTS,
+			timeOffset:     s.timeOffset(),
+		}
+		err = seg.initialize()
+		if err != nil {
+			return err
+		}
+		s.nextSegment = seg
+	} else if s.variant == MuxerVariantMPEGTS { //nolint:dupl
 		seg := &muxerSegmentMPEGTS{
 			segmentMaxSize: s.segmentMaxSize,
 			prefix:         s.prefix,
 			storageFactory: s.storageFactory,
 			streamID:       s.id,
 			mpegtsWriter:   s.mpegtsWriter,
+			id3Writer:      s.mpegtsID3Writer,
 			id:             s.nextSegmentID,
 			startNTP:       nextNTP,
 			startDTS:       nextDTS,
+			encrypter:      encrypter,
 		}
-		err := seg.initialize()
+		err = seg.initialize()
 		if err != nil {
 			return err
 		}
 		s.nextSegment = seg
 
 		s.mpegtsSwitchableWriter.w = seg.bw
+	} else if s.variant == MuxerVariantPackedAudio {
+		seg := &muxerSegmentPackedAudio{
+			segmentMaxSize: s.segmentMaxSize,
+			prefix:         s.prefix,
+			storageFactory: s.storageFactory,
+			streamID:       s.id,
+			id:             s.nextSegmentID,
+			startNTP:       nextNTP,
+			startDTS:       nextDTS,
+			ext:            s.packedAudioExt(),
+		}
+		err = seg.initialize()
+		if err != nil {
+			return err
+		}
+		s.nextSegment = seg
 	} else {
 		seg := &muxerSegmentFMP4{
 			prefix:             s.prefix,
@@ -632,8 +1151,9 @@ func (s *muxerStream) createFirstSegment(
 			startNTP:           nextNTP,
 			startDTS:           nextDTS,
 			fromForcedRotation: false,
+			encrypter:          encrypter,
 		}
-		err := seg.initialize()
+		err = seg.initialize()
 		if err != nil {
 			return err
 		}
@@ -669,6 +1189,9 @@ func (s *muxerStream) rotateParts(
 		return err
 	}
 
+	s.logger.Debug("part created",
+		"media_sequence", part.segment.id, "part", part.id, "duration", part.getDuration())
+
 	if s.variant == MuxerVariantLowLatency {
 		part.segment.parts = append(part.segment.parts, part)
 
@@ -703,8 +1226,12 @@ func (s *muxerStream) rotateParts(
 			func(w http.ResponseWriter, r *http.Request) {
 				s.mutex.Lock()
 
+				stop := wakeUpOnDone(r.Context(), s.mutex, s.cond)
+				defer stop()
+
 				for {
-					if s.closed {
+					if s.closed || r.Context().Err() != nil {
+						s.mutex.Unlock()
 						w.WriteHeader(http.StatusInternalServerError)
 						return
 					}
@@ -713,6 +1240,15 @@ func (s *muxerStream) rotateParts(
 						break
 					}
 
+					// the part will never be produced
+					if s.ended {
+						s.mutex.Unlock()
+						w.WriteHeader(http.StatusNotFound)
+						return
+					}
+
+					s.logger.Debug("blocking part request", "part", capturePartID)
+
 					s.cond.Wait()
 				}
 
@@ -764,24 +1300,136 @@ func (s *muxerStream) rotateSegments(
 	nextNTP time.Time,
 	force bool,
 ) error {
-	if s.variant != MuxerVariantMPEGTS {
+	if s.variant != MuxerVariantMPEGTS && s.variant != MuxerVariantPackedAudio && !s.isSubtitles() {
 		err := s.rotateParts(nextDTS, false)
 		if err != nil {
 			return err
 		}
 	}
 
+	err := s.storeNextSegment(nextDTS)
+	if err != nil {
+		return err
+	}
+
+	encrypter, err := s.sampleAESEncrypter(s.nextSegmentID)
+	if err != nil {
+		return err
+	}
+
+	if s.isSubtitles() {
+		seg := &muxerSegmentWebVTT{
+			prefix:         s.prefix,
+			storageFactory: s.storageFactory,
+			streamID:       s.id,
+			id:             s.nextSegmentID,
+			startNTP:       nextNTP,
+			startDTS:       nextDTS,
+			timeOffset:     s.timeOffset(),
+		}
+		err = seg.initialize()
+		if err != nil {
+			return err
+		}
+		s.nextSegment = seg
+	} else if s.variant == MuxerVariantMPEGTS { //nolint:dupl
+		seg := &muxerSegmentMPEGTS{
+			segmentMaxSize: s.segmentMaxSize,
+			prefix:         s.prefix,
+			storageFactory: s.storageFactory,
+			streamID:       s.id,
+			mpegtsWriter:   s.mpegtsWriter,
+			id3Writer:      s.mpegtsID3Writer,
+			id:             s.nextSegmentID,
+			startNTP:       nextNTP,
+			startDTS:       nextDTS,
+			encrypter:      encrypter,
+		}
+		err = seg.initialize()
+		if err != nil {
+			return err
+		}
+		s.nextSegment = seg
+
+		s.mpegtsSwitchableWriter.w = seg.bw
+	} else if s.variant == MuxerVariantPackedAudio {
+		seg := &muxerSegmentPackedAudio{
+			segmentMaxSize: s.segmentMaxSize,
+			prefix:         s.prefix,
+			storageFactory: s.storageFactory,
+			streamID:       s.id,
+			id:             s.nextSegmentID,
+			startNTP:       nextNTP,
+			startDTS:       nextDTS,
+			ext:            s.packedAudioExt(),
+		}
+		err = seg.initialize()
+		if err != nil {
+			return err
+		}
+		s.nextSegment = seg
+	} else {
+		seg := &muxerSegmentFMP4{
+			prefix:             s.prefix,
+			storageFactory:     s.storageFactory,
+			streamID:           s.id,
+			id:                 s.nextSegmentID,
+			startNTP:           nextNTP,
+			startDTS:           nextDTS,
+			fromForcedRotation: force,
+			encrypter:          encrypter,
+		}
+		err = seg.initialize()
+		if err != nil {
+			return err
+		}
+		s.nextSegment = seg
+
+		s.nextPart = &muxerPart{
+			segmentMaxSize: s.segmentMaxSize,
+			streamID:       s.id,
+			streamTracks:   s.tracks,
+			segment:        seg,
+			startDTS:       seg.startDTS,
+			prefix:         seg.prefix,
+			id:             s.nextPartID,
+			storage:        seg.storage.NewPart(),
+		}
+		s.nextPart.initialize()
+	}
+
+	s.updateTargetDuration()
+
+	return nil
+}
+
+// storeNextSegment finalizes the segment in progress and adds it to the playlist.
+func (s *muxerStream) storeNextSegment(nextDTS time.Duration) error {
+	segmentID := s.nextSegmentID
 	s.nextSegmentID++
 
 	segment := s.nextSegment
 	s.nextSegment = nil
 
+	if seg, ok := segment.(*muxerSegmentWebVTT); ok {
+		seg.cues = s.takeWebVTTCues(seg.startDTS-seg.timeOffset, nextDTS-seg.timeOffset)
+	}
+
 	err := segment.finalize(nextDTS)
 	if err != nil {
 		segment.close()
 		return err
 	}
 
+	var key []byte
+	if s.keyring != nil && s.keyring.method == MuxerEncryptionAES128 {
+		key, err = s.keyring.key(segmentID)
+		if err != nil {
+			segment.close()
+			return err
+		}
+	}
+
 	// add initial gaps, required by iOS LL-HLS
 	if s.variant == MuxerVariantLowLatency && len(s.segments) == 0 {
 		for range 7 {
@@ -793,10 +1441,13 @@ func (s *muxerStream) rotateSegments(
 
 	s.segments = append(s.segments, segment)
 
+	s.logger.Debug("segment created",
+		"media_sequence", s.nextSegmentID-1, "duration", segment.getDuration(), "size", segment.getSize())
+
 	s.server.registerPath(
 		segment.getPath(),
-		func(w http.ResponseWriter, _ *http.Request) {
-			r, err2 := segment.reader()
+		func(w http.ResponseWriter, req *http.Request) {
+			r, size, err2 := openSegment(segment, key, segmentID)
 			if err2 != nil {
 				w.WriteHeader(http.StatusInternalServerError)
 				return
@@ -805,9 +1456,18 @@ func (s *muxerStream) rotateSegments(
 
 			var contentType string
 			switch {
+			case s.isSubtitles():
+				contentType = "text/vtt"
+
 			case s.variant == MuxerVariantMPEGTS:
 				contentType = "video/mp2t"
 
+			case s.variant == MuxerVariantPackedAudio && s.packedAudioExt() == ".mp3":
+				contentType = "audio/mpeg"
+
+			case s.variant == MuxerVariantPackedAudio:
+				contentType = "audio/aac"
+
 			case areAllAudio(s.tracks):
 				contentType = "audio/mp4"
 
@@ -817,12 +1477,15 @@ func (s *muxerStream) rotateSegments(
 
 			w.Header().Set("Cache-Control", "max-age="+segmentMaxAge)
 			w.Header().Set("Content-Type", contentType)
-			w.WriteHeader(http.StatusOK)
-			io.Copy(w, r)
+			serveWithByteRange(w, req, r, size)
 		})
 
+	if s.sinkWriter != nil {
+		s.writeSinkSegment(segment, key, segmentID)
+	}
+
 	// delete old segments and parts
-	if len(s.segments) > s.segmentCount {
+	for s.mustDeleteOldestSegment() {
 		toDelete := s.segments[0]
 
 		if toDeleteSeg, ok := toDelete.(*muxerSegmentFMP4); ok {
@@ -835,77 +1498,109 @@ func (s *muxerStream) rotateSegments(
 
 		s.server.unregisterPath(toDelete.getPath())
 
+		if s.sinkWriter != nil {
+			s.sinkPendingRemovals = append(s.sinkPendingRemovals, toDelete.getPath())
+		}
+
 		s.segments = s.segments[1:]
 
 		s.segmentDeleteCount++
 	}
 
 	// regenerate init files only if missing or codec parameters have changed
-	if s.variant != MuxerVariantMPEGTS && (!s.initFilePresent || segment.isFromForcedRotation()) {
+	if s.variant != MuxerVariantMPEGTS && s.variant != MuxerVariantPackedAudio && !s.isSubtitles() &&
+		(!s.initFilePresent || segment.isFromForcedRotation()) {
 		err = s.generateAndCacheInitFile()
 		if err != nil {
 			return err
 		}
 	}
 
-	if s.variant == MuxerVariantMPEGTS { //nolint:dupl
-		seg := &muxerSegmentMPEGTS{
-			segmentMaxSize: s.segmentMaxSize,
-			prefix:         s.prefix,
-			storageFactory: s.storageFactory,
-			streamID:       s.id,
-			mpegtsWriter:   s.mpegtsWriter,
-			id:             s.nextSegmentID,
-			startNTP:       nextNTP,
-			startDTS:       nextDTS,
-		}
-		err = seg.initialize()
-		if err != nil {
-			return err
-		}
-		s.nextSegment = seg
+	return nil
+}
 
-		s.mpegtsSwitchableWriter.w = seg.bw
-	} else {
-		seg := &muxerSegmentFMP4{
-			prefix:             s.prefix,
-			storageFactory:     s.storageFactory,
-			streamID:           s.id,
-			id:                 s.nextSegmentID,
-			startNTP:           nextNTP,
-			startDTS:           nextDTS,
-			fromForcedRotation: force,
-		}
-		err = seg.initialize()
-		if err != nil {
-			return err
+func (s *muxerStream) updateTargetDuration() {
+	if !s.isLeading {
+		return
+	}
+
+	targetDuration := targetDuration(s.segments)
+	if s.targetDuration == 0 {
+		s.targetDuration = targetDuration
+	} else if targetDuration > s.targetDuration {
+		s.onEncodeError(fmt.Errorf(
+			"segment duration changed from %ds to %ds - this will cause an error in iOS clients",
+			s.targetDuration, targetDuration))
+		s.targetDuration = targetDuration
+	}
+}
+
+// timeOffset returns the difference between timestamps of segments
+// and timestamps provided by the user.
+func (s *muxerStream) timeOffset() time.Duration {
+	if s.variant == MuxerVariantMPEGTS || s.variant == MuxerVariantPackedAudio {
+		return 0
+	}
+	return fmp4StartDTS
+}
+
+// takeWebVTTCues returns cues that overlap a segment,
+// keeping the ones that also overlap next segments.
+// Cues that end before the segment are discarded.
+func (s *muxerStream) takeWebVTTCues(startDTS time.Duration, endDTS time.Duration) []*muxerWebVTTCue {
+	var ret []*muxerWebVTTCue
+	n := 0
+
+	for _, cue := range s.webvttCues {
+		if cue.start < endDTS && cue.end > startDTS {
+			ret = append(ret, cue)
 		}
-		s.nextSegment = seg
 
-		s.nextPart = &muxerPart{
-			segmentMaxSize: s.segmentMaxSize,
-			streamID:       s.id,
-			streamTracks:   s.tracks,
-			segment:        seg,
-			startDTS:       seg.startDTS,
-			prefix:         seg.prefix,
-			id:             s.nextPartID,
-			storage:        seg.storage.NewPart(),
+		if cue.end > endDTS {
+			s.webvttCues[n] = cue
+			n++
 		}
-		s.nextPart.initialize()
 	}
 
-	if s.isLeading {
-		targetDuration := targetDuration(s.segments)
-		if s.targetDuration == 0 {
-			s.targetDuration = targetDuration
-		} else if targetDuration > s.targetDuration {
-			s.onEncodeError(fmt.Errorf(
-				"segment duration changed from %ds to %ds - this will cause an error in iOS clients",
-				s.targetDuration, targetDuration))
-			s.targetDuration = targetDuration
+	s.webvttCues = s.webvttCues[:n]
+
+	return ret
+}
+
+// writeMetadata writes a timed metadata payload into the current segment or part.
+// nextSegmentStart returns the DTS and the absolute timestamp of the beginning of the next segment.
+// The DTS does not include the time offset of the stream.
+func (s *muxerStream) nextSegmentStart() (time.Duration, time.Time, bool) {
+	var startDTS time.Duration
+	var startNTP time.Time
+
+	switch seg := s.nextSegment.(type) {
+	case *muxerSegmentMPEGTS:
+		startDTS, startNTP = seg.startDTS, seg.startNTP
+
+	case *muxerSegmentPackedAudio:
+		startDTS, startNTP = seg.startDTS, seg.startNTP
+
+	case *muxerSegmentFMP4:
+		startDTS, startNTP = seg.startDTS, seg.startNTP
+
+	default:
+		return 0, time.Time{}, false
+	}
+
+	return startDTS - s.timeOffset(), startNTP, true
+}
+
+func (s *muxerStream) writeMetadata(id uint32, schemeIDURI string, dts time.Duration, payload []byte) error {
+	if s.variant == MuxerVariantMPEGTS {
+		if s.nextSegment == nil {
+			return nil
 		}
+		return s.nextSegment.(*muxerSegmentMPEGTS).writeMetadata(durationToTimestamp(dts, 90000), payload)
 	}
 
-	return nil
+	if s.nextPart == nil {
+		return nil
+	}
+	return s.nextPart.writeMetadata(emsgMarshal(schemeIDURI, id, dts+s.timeOffset(), payload))
 }
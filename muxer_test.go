@@ -2,19 +2,32 @@ package gohlslib
 
 import (
 	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/hex"
 	"fmt"
+	"io"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/asticode/go-astits"
+	"github.com/bluenviron/mediacommon/v2/pkg/codecs/h264"
+	"github.com/bluenviron/mediacommon/v2/pkg/codecs/h265"
 	"github.com/bluenviron/mediacommon/v2/pkg/codecs/mpeg4audio"
 	"github.com/bluenviron/mediacommon/v2/pkg/formats/fmp4"
 	mp4codecs "github.com/bluenviron/mediacommon/v2/pkg/formats/mp4/codecs"
+	"github.com/bluenviron/mediacommon/v2/pkg/formats/mpegts"
+	mpegtscodecs "github.com/bluenviron/mediacommon/v2/pkg/formats/mpegts/codecs"
 	"github.com/stretchr/testify/require"
 
 	"github.com/bluenviron/gohlslib/v2/pkg/codecs"
@@ -107,6 +120,49 @@ func doRequest(m *Muxer, pathAndQuery string) ([]byte, http.Header, error) {
 	return w.Bytes(), w.h, nil
 }
 
+// testVariant is a variant of a muxer that contains testVideoTrack only,
+// used by tests that are run with both MPEG-TS and fMP4.
+type testVariant struct {
+	name       string
+	variant    MuxerVariant
+	streamPath string
+	segExt     string
+}
+
+var testVariants = []testVariant{
+	{
+		name:       "mpegts",
+		variant:    MuxerVariantMPEGTS,
+		streamPath: "main_stream.m3u8",
+		segExt:     "ts",
+	},
+	{
+		name:       "fmp4",
+		variant:    MuxerVariantFMP4,
+		streamPath: "video1_stream.m3u8",
+		segExt:     "mp4",
+	},
+}
+
+// writeTestH264 writes an access unit of testVideoTrack at the i-th second.
+func writeTestH264(t *testing.T, m *Muxer, i int, idr bool) {
+	au := [][]byte{{1}} // non-IDR
+	if idr {
+		au = [][]byte{
+			testSPS,
+			testPPS,
+			{5}, // IDR
+		}
+	}
+
+	err := m.WriteH264(
+		testVideoTrack,
+		testTime.Add(time.Duration(i)*time.Second),
+		int64(i)*90000,
+		au)
+	require.NoError(t, err)
+}
+
 func TestMuxer(t *testing.T) {
 	createMuxer := func(t *testing.T, variant string, content string) *Muxer {
 		var v MuxerVariant
@@ -391,7 +447,7 @@ func TestMuxer(t *testing.T) {
 				"#EXT-X-INDEPENDENT-SEGMENTS\n"+
 				"\n"+
 				"#EXT-X-STREAM-INF:BANDWIDTH=4512,AVERAGE-BANDWIDTH=3008,"+
-				"CODECS=\"avc1.42c028,mp4a.40.2\",RESOLUTION=1920x1080,FRAME-RATE=30.000\n"+
+				"CODECS=\"avc1.42c028,mp4a.40.2\",RESOLUTION=1920x1080,FRAME-RATE=30.000,CLOSED-CAPTIONS=NONE\n"+
 				"main_stream.m3u8?key=value\n", string(byts))
 
 		case content == "video+audio" && variant == "fmp4":
@@ -402,8 +458,8 @@ func TestMuxer(t *testing.T) {
 				"#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"audio\","+
 				"NAME=\"audio2\",AUTOSELECT=YES,DEFAULT=YES,URI=\"audio2_stream.m3u8?key=value\"\n"+
 				"\n"+
-				"#EXT-X-STREAM-INF:BANDWIDTH=872,AVERAGE-BANDWIDTH=436,CODECS=\"avc1.42c028,mp4a.40.2\","+
-				"RESOLUTION=1920x1080,FRAME-RATE=30.000,AUDIO=\"audio\"\n"+
+				"#EXT-X-STREAM-INF:BANDWIDTH=1128,AVERAGE-BANDWIDTH=660,CODECS=\"avc1.42c028,mp4a.40.2\","+
+				"RESOLUTION=1920x1080,FRAME-RATE=30.000,AUDIO=\"audio\",CLOSED-CAPTIONS=NONE\n"+
 				"video1_stream.m3u8?key=value\n", string(byts))
 
 		case content == "video+audio" && variant == "lowLatency":
@@ -414,8 +470,8 @@ func TestMuxer(t *testing.T) {
 				"#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"audio\","+
 				"NAME=\"audio2\",AUTOSELECT=YES,DEFAULT=YES,URI=\"audio2_stream.m3u8?key=value\"\n"+
 				"\n"+
-				"#EXT-X-STREAM-INF:BANDWIDTH=872,AVERAGE-BANDWIDTH=584,CODECS=\"avc1.42c028,mp4a.40.2\","+
-				"RESOLUTION=1920x1080,FRAME-RATE=30.000,AUDIO=\"audio\"\n"+
+				"#EXT-X-STREAM-INF:BANDWIDTH=1152,AVERAGE-BANDWIDTH=859,CODECS=\"avc1.42c028,mp4a.40.2\","+
+				"RESOLUTION=1920x1080,FRAME-RATE=30.000,AUDIO=\"audio\",CLOSED-CAPTIONS=NONE\n"+
 				"video1_stream.m3u8?key=value\n", string(byts))
 
 		case content == "video" && variant == "mpegts":
@@ -424,7 +480,7 @@ func TestMuxer(t *testing.T) {
 				"#EXT-X-INDEPENDENT-SEGMENTS\n"+
 				"\n"+
 				"#EXT-X-STREAM-INF:BANDWIDTH=4512,AVERAGE-BANDWIDTH=1804,"+
-				"CODECS=\"avc1.42c028\",RESOLUTION=1920x1080,FRAME-RATE=30.000\n"+
+				"CODECS=\"avc1.42c028\",RESOLUTION=1920x1080,FRAME-RATE=30.000,CLOSED-CAPTIONS=NONE\n"+
 				"main_stream.m3u8?key=value\n", string(byts))
 
 		case content == "video" && variant == "fmp4":
@@ -433,7 +489,7 @@ func TestMuxer(t *testing.T) {
 				"#EXT-X-INDEPENDENT-SEGMENTS\n"+
 				"\n"+
 				"#EXT-X-STREAM-INF:BANDWIDTH=872,AVERAGE-BANDWIDTH=403,CODECS=\"avc1.42c028\","+
-				"RESOLUTION=1920x1080,FRAME-RATE=30.000\n"+
+				"RESOLUTION=1920x1080,FRAME-RATE=30.000,CLOSED-CAPTIONS=NONE\n"+
 				"video1_stream.m3u8?key=value\n", string(byts))
 
 		case content == "video" && variant == "lowLatency":
@@ -442,7 +498,7 @@ func TestMuxer(t *testing.T) {
 				"#EXT-X-INDEPENDENT-SEGMENTS\n"+
 				"\n"+
 				"#EXT-X-STREAM-INF:BANDWIDTH=872,AVERAGE-BANDWIDTH=403,CODECS=\"avc1.42c028\","+
-				"RESOLUTION=1920x1080,FRAME-RATE=30.000\n"+
+				"RESOLUTION=1920x1080,FRAME-RATE=30.000,CLOSED-CAPTIONS=NONE\n"+
 				"video1_stream.m3u8?key=value\n", string(byts))
 
 		case content == "audio" && variant == "mpegts":
@@ -479,8 +535,8 @@ func TestMuxer(t *testing.T) {
 				"#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"audio\","+
 				"LANGUAGE=\"de\",NAME=\"German\",AUTOSELECT=YES,URI=\"audio3_stream.m3u8?key=value\"\n"+
 				"\n"+
-				"#EXT-X-STREAM-INF:BANDWIDTH=872,AVERAGE-BANDWIDTH=403,"+
-				"CODECS=\"avc1.42c028,mp4a.40.2\",RESOLUTION=1920x1080,FRAME-RATE=30.000,AUDIO=\"audio\"\n"+
+				"#EXT-X-STREAM-INF:BANDWIDTH=1128,AVERAGE-BANDWIDTH=505,"+
+				"CODECS=\"avc1.42c028,mp4a.40.2\",RESOLUTION=1920x1080,FRAME-RATE=30.000,AUDIO=\"audio\",CLOSED-CAPTIONS=NONE\n"+
 				"video1_stream.m3u8?key=value\n", string(byts))
 
 		case content == "multiaudio" && variant == "fmp4":
@@ -879,6 +935,7 @@ func TestMuxerMaxSegmentSize(t *testing.T) {
 			{5}, // IDR
 		})
 	require.EqualError(t, err, "reached maximum segment size")
+	require.ErrorIs(t, err, ErrMuxerSegmentTooBig)
 }
 
 func TestMuxerDoubleRead(t *testing.T) {
@@ -1077,7 +1134,7 @@ func TestMuxerDynamicParams(t *testing.T) {
 		"#EXT-X-INDEPENDENT-SEGMENTS\n"+
 		"\n"+
 		"#EXT-X-STREAM-INF:BANDWIDTH=1144,AVERAGE-BANDWIDTH=1028,"+
-		"CODECS=\"avc1.42c028\",RESOLUTION=1920x1080,FRAME-RATE=30.000\n"+
+		"CODECS=\"avc1.42c028\",RESOLUTION=1920x1080,FRAME-RATE=30.000,CLOSED-CAPTIONS=NONE\n"+
 		"video1_stream.m3u8\n", string(bu))
 
 	byts, _, err := doRequest(m, "video1_stream.m3u8")
@@ -1133,7 +1190,7 @@ func TestMuxerDynamicParams(t *testing.T) {
 		"#EXT-X-INDEPENDENT-SEGMENTS\n"+
 		"\n"+
 		"#EXT-X-STREAM-INF:BANDWIDTH=912,AVERAGE-BANDWIDTH=752,"+
-		"CODECS=\"avc1.64001f\",RESOLUTION=1280x720,FRAME-RATE=30.000\n"+
+		"CODECS=\"avc1.64001f\",RESOLUTION=1280x720,FRAME-RATE=30.000,CLOSED-CAPTIONS=NONE\n"+
 		"video1_stream.m3u8\n", string(bu))
 
 	byts, _, err = doRequest(m, "video1_stream.m3u8")
@@ -1341,7 +1398,7 @@ func TestMuxerFMP4SequenceNumber(t *testing.T) {
 		"#EXT-X-INDEPENDENT-SEGMENTS\n"+
 		"\n"+
 		"#EXT-X-STREAM-INF:BANDWIDTH=964,AVERAGE-BANDWIDTH=964,"+
-		"CODECS=\"avc1.42c028\",RESOLUTION=1920x1080,FRAME-RATE=30.000\n"+
+		"CODECS=\"avc1.42c028\",RESOLUTION=1920x1080,FRAME-RATE=30.000,CLOSED-CAPTIONS=NONE\n"+
 		"video1_stream.m3u8\n", string(byts))
 
 	byts, _, err = doRequest(m, "video1_stream.m3u8")
@@ -1468,7 +1525,7 @@ func TestMuxerExpiredSegment(t *testing.T) {
 		"#EXT-X-INDEPENDENT-SEGMENTS\n"+
 		"\n"+
 		"#EXT-X-STREAM-INF:BANDWIDTH=1144,AVERAGE-BANDWIDTH=1144,"+
-		"CODECS=\"avc1.42c028\",RESOLUTION=1920x1080,FRAME-RATE=30.000\n"+
+		"CODECS=\"avc1.42c028\",RESOLUTION=1920x1080,FRAME-RATE=30.000,CLOSED-CAPTIONS=NONE\n"+
 		"video1_stream.m3u8\n", string(byts))
 
 	v := url.Values{}
@@ -1520,7 +1577,7 @@ func TestMuxerPreloadHint(t *testing.T) {
 		"#EXT-X-INDEPENDENT-SEGMENTS\n"+
 		"\n"+
 		"#EXT-X-STREAM-INF:BANDWIDTH=1144,AVERAGE-BANDWIDTH=1144,"+
-		"CODECS=\"avc1.42c028\",RESOLUTION=1920x1080,FRAME-RATE=30.000\n"+
+		"CODECS=\"avc1.42c028\",RESOLUTION=1920x1080,FRAME-RATE=30.000,CLOSED-CAPTIONS=NONE\n"+
 		"video1_stream.m3u8\n", string(byts))
 
 	byts, _, err = doRequest(m, "video1_stream.m3u8")
@@ -1604,3 +1661,1905 @@ func TestMuxerPreloadHint(t *testing.T) {
 		}},
 	}}, parts)
 }
+
+func TestMuxerCloseAfterFailedStart(t *testing.T) {
+	m := &Muxer{
+		Variant:      MuxerVariantLowLatency,
+		SegmentCount: 3,
+		Tracks:       []*Track{testVideoTrack},
+	}
+
+	err := m.Start()
+	require.Error(t, err)
+
+	m.Close()
+}
+
+func TestMuxerStartContext(t *testing.T) {
+	ctx, ctxCancel := context.WithCancel(context.Background())
+
+	m := &Muxer{
+		Variant:            MuxerVariantFMP4,
+		SegmentCount:       3,
+		SegmentMinDuration: 1 * time.Second,
+		Tracks:             []*Track{testVideoTrack},
+	}
+
+	err := m.StartContext(ctx)
+	require.NoError(t, err)
+
+	done := make(chan struct{})
+
+	go func() {
+		b, _, _ := doRequest(m, "video1_stream.m3u8")
+		require.Equal(t, []byte(nil), b)
+		close(done)
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+
+	ctxCancel()
+	<-done
+
+	err = m.WriteH264(testVideoTrack, testTime, 0, [][]byte{
+		testSPS,
+		{5}, // IDR
+	})
+	require.ErrorIs(t, err, context.Canceled)
+
+	m.Close()
+
+	err = m.WriteH264(testVideoTrack, testTime, 0, [][]byte{
+		testSPS,
+		{5}, // IDR
+	})
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+func TestMuxerClose(t *testing.T) {
+	m := &Muxer{
+		Variant:            MuxerVariantFMP4,
+		SegmentCount:       3,
+		SegmentMinDuration: 1 * time.Second,
+		Tracks:             []*Track{testVideoTrack},
+	}
+
+	err := m.Start()
+	require.NoError(t, err)
+
+	m.Close()
+
+	err = m.WriteH264(testVideoTrack, testTime, 0, [][]byte{
+		testSPS,
+		{5}, // IDR
+	})
+	require.ErrorIs(t, err, ErrMuxerClosed)
+}
+
+func TestMuxerIFramePlaylist(t *testing.T) {
+	for _, ca := range []string{
+		"mpegts",
+		"fmp4",
+	} {
+		t.Run(ca, func(t *testing.T) {
+			var v MuxerVariant
+			if ca == "mpegts" {
+				v = MuxerVariantMPEGTS
+			} else {
+				v = MuxerVariantFMP4
+			}
+
+			videoTrack := &Track{
+				Codec: &codecs.H264{
+					SPS: testSPS,
+					PPS: []byte{0x08},
+				},
+				ClockRate: 90000,
+			}
+
+			m := &Muxer{
+				Variant:            v,
+				SegmentCount:       3,
+				SegmentMinDuration: 1 * time.Second,
+				Tracks:             []*Track{videoTrack},
+				IFramePlaylist:     true,
+			}
+
+			err := m.Start()
+			require.NoError(t, err)
+			defer m.Close()
+
+			for i := range 5 {
+				var au [][]byte
+				switch {
+				case i == 0:
+					au = [][]byte{testSPS, {8}, {5, 1}}
+				case (i % 2) == 0:
+					au = [][]byte{{5, 1}}
+				default:
+					au = [][]byte{{1, 2}}
+				}
+
+				err = m.WriteH264(videoTrack, testTime, int64(i)*90000, au)
+				require.NoError(t, err)
+			}
+
+			byts, _, err := doRequest(m, "index.m3u8")
+			require.NoError(t, err)
+
+			re := regexp.MustCompile(`#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=[0-9]+,AVERAGE-BANDWIDTH=[0-9]+,` +
+				`CODECS="avc1.42c028",RESOLUTION=1920x1080,URI="(.*?_iframes\.m3u8)"\n$`)
+			require.Regexp(t, re, string(byts))
+			ma := re.FindStringSubmatch(string(byts))
+
+			byts, _, err = doRequest(m, ma[1])
+			require.NoError(t, err)
+
+			var ext string
+			var mapLine string
+			var version string
+			if ca == "mpegts" {
+				ext = "ts"
+				version = "4"
+			} else {
+				ext = "mp4"
+				mapLine = `#EXT-X-MAP:URI="(.*?_init\.mp4)"\n`
+				version = "10"
+			}
+
+			re = regexp.MustCompile(`^#EXTM3U\n` +
+				`#EXT-X-VERSION:` + version + `\n` +
+				`#EXT-X-TARGETDURATION:2\n` +
+				`#EXT-X-MEDIA-SEQUENCE:0\n` +
+				`#EXT-X-I-FRAMES-ONLY\n` +
+				mapLine +
+				`#EXTINF:2\.00000,\n` +
+				`#EXT-X-BYTERANGE:([0-9]+)@0\n` +
+				`(.*?_seg0\.` + ext + `)\n` +
+				`#EXTINF:2\.00000,\n` +
+				`#EXT-X-BYTERANGE:([0-9]+)@0\n` +
+				`.*?_seg1\.` + ext + `\n$`)
+			require.Regexp(t, re, string(byts))
+			ma = re.FindStringSubmatch(string(byts))
+			ma = ma[len(ma)-3:]
+
+			iframeSize, err := strconv.ParseUint(ma[0], 10, 64)
+			require.NoError(t, err)
+
+			u, err := url.Parse("http://localhost/" + ma[1])
+			require.NoError(t, err)
+
+			w := &dummyResponseWriter{
+				h: make(http.Header),
+			}
+
+			m.Handle(w, &http.Request{
+				URL: u,
+				Header: http.Header{
+					"Range": []string{"bytes=0-" + strconv.FormatUint(iframeSize-1, 10)},
+				},
+			})
+			require.Equal(t, http.StatusPartialContent, w.statusCode)
+			require.Equal(t, int(iframeSize), w.Len())
+
+			if ca == "mpegts" {
+				r := &mpegts.Reader{R: bytes.NewReader(w.Bytes())}
+				err = r.Initialize()
+				require.NoError(t, err)
+
+				var aus [][][]byte
+				r.OnDataH264(r.Tracks()[0], func(_ int64, _ int64, au [][]byte) error {
+					aus = append(aus, au)
+					return nil
+				})
+
+				for {
+					err = r.Read()
+					if err != nil {
+						break
+					}
+				}
+
+				require.Equal(t, [][][]byte{{testSPS, {8}, {5, 1}}}, aus)
+			} else {
+				var parts fmp4.Parts
+				err = parts.Unmarshal(w.Bytes())
+				require.NoError(t, err)
+				require.Len(t, parts, 1)
+				require.Len(t, parts[0].Tracks[0].Samples, 1)
+				require.False(t, parts[0].Tracks[0].Samples[0].IsNonSyncSample)
+			}
+		})
+	}
+}
+
+func TestMuxerLogger(t *testing.T) {
+	var buf bytes.Buffer
+
+	m := &Muxer{
+		Variant:            MuxerVariantMPEGTS,
+		SegmentCount:       3,
+		SegmentMinDuration: 1 * time.Second,
+		Tracks:             []*Track{testVideoTrack},
+		Logger: slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{
+			Level: slog.LevelDebug,
+			ReplaceAttr: func(_ []string, a slog.Attr) slog.Attr {
+				if a.Key == slog.TimeKey {
+					return slog.Attr{}
+				}
+				return a
+			},
+		})),
+	}
+
+	err := m.Start()
+	require.NoError(t, err)
+	defer m.Close()
+
+	for i := range 2 {
+		err = m.WriteH264(
+			testVideoTrack,
+			testTime,
+			int64(i)*2*90000,
+			[][]byte{
+				testSPS,
+				testPPS,
+				{5}, // IDR
+			})
+		require.NoError(t, err)
+	}
+
+	require.Contains(t, buf.String(),
+		`{"level":"DEBUG","msg":"segment created","stream":"main","media_sequence":0,"duration":2000000000,"size":`)
+}
+
+func TestMuxerUnsupportedCodec(t *testing.T) {
+	m := &Muxer{
+		Variant: MuxerVariantMPEGTS,
+		Tracks: []*Track{{
+			Codec:     &codecs.VP9{},
+			ClockRate: 90000,
+		}},
+	}
+
+	err := m.Start()
+	require.ErrorIs(t, err, ErrUnsupportedCodec)
+}
+
+func TestMuxerPackedAudio(t *testing.T) {
+	m := &Muxer{
+		Variant:            MuxerVariantPackedAudio,
+		SegmentCount:       3,
+		SegmentMinDuration: 1 * time.Second,
+		Tracks:             []*Track{testAudioTrack},
+	}
+
+	err := m.Start()
+	require.NoError(t, err)
+	defer m.Close()
+
+	for i := range 100 {
+		err = m.WriteMPEG4Audio(testAudioTrack,
+			testTime.Add(time.Duration(i)*1024*time.Second/44100),
+			int64(i)*1024,
+			[][]byte{{1, 2, 3, byte(i)}})
+		require.NoError(t, err)
+	}
+
+	byts, _, err := doRequest(m, "index.m3u8")
+	require.NoError(t, err)
+	require.Regexp(t, `^#EXTM3U\n`+
+		`#EXT-X-VERSION:3\n`+
+		`#EXT-X-INDEPENDENT-SEGMENTS\n`+
+		`\n`+
+		`#EXT-X-STREAM-INF:BANDWIDTH=[0-9]+,AVERAGE-BANDWIDTH=[0-9]+,CODECS="mp4a.40.2"\n`+
+		`main_stream.m3u8\n$`, string(byts))
+
+	byts, _, err = doRequest(m, "main_stream.m3u8")
+	require.NoError(t, err)
+
+	ma := regexp.MustCompile(`^#EXTM3U\n` +
+		`#EXT-X-VERSION:3\n` +
+		`#EXT-X-ALLOW-CACHE:NO\n` +
+		`#EXT-X-TARGETDURATION:1\n` +
+		`#EXT-X-MEDIA-SEQUENCE:0\n` +
+		`#EXT-X-PROGRAM-DATE-TIME:2010-01-01T01:01:01Z\n` +
+		`#EXTINF:1.02[0-9]+,\n` +
+		`(.*?_main_seg0\.aac)\n` +
+		`#EXT-X-PROGRAM-DATE-TIME:2010-01-01T01:01:02.02[0-9]+Z\n` +
+		`#EXTINF:1.02[0-9]+,\n` +
+		`(.*?_main_seg1\.aac)\n$`).FindStringSubmatch(string(byts))
+	require.NotNil(t, ma)
+
+	byts, h, err := doRequest(m, ma[2])
+	require.NoError(t, err)
+	require.Equal(t, "audio/aac", h.Get("Content-Type"))
+
+	ts, n, err := packedAudioUnmarshalHeader(byts)
+	require.NoError(t, err)
+	require.Equal(t, int64(44*1024*90000/44100), ts)
+
+	var pkts mpeg4audio.ADTSPackets
+	err = pkts.Unmarshal(byts[n:])
+	require.NoError(t, err)
+	require.Len(t, pkts, 44)
+	require.Equal(t, &mpeg4audio.ADTSPacket{
+		Type:          2,
+		SampleRate:    44100,
+		ChannelConfig: 2,
+		ChannelCount:  2,
+		AU:            []byte{1, 2, 3, 44},
+	}, pkts[0])
+}
+
+func TestMuxerPackedAudioMPEG1Audio(t *testing.T) {
+	track := &Track{
+		Codec:     &codecs.MPEG1Audio{},
+		ClockRate: 90000,
+	}
+
+	frame := make([]byte, 417)
+	copy(frame, []byte{0xff, 0xfb, 0x90, 0x64})
+
+	m := &Muxer{
+		Variant:            MuxerVariantPackedAudio,
+		SegmentCount:       3,
+		SegmentMinDuration: 1 * time.Second,
+		Tracks:             []*Track{track},
+	}
+
+	err := m.Start()
+	require.NoError(t, err)
+	defer m.Close()
+
+	for i := range 100 {
+		err = m.WriteMPEG1Audio(track,
+			testTime.Add(time.Duration(i)*1152*time.Second/44100),
+			int64(i)*1152*90000/44100,
+			[][]byte{frame})
+		require.NoError(t, err)
+	}
+
+	byts, _, err := doRequest(m, "main_stream.m3u8")
+	require.NoError(t, err)
+
+	ma := regexp.MustCompile(`(.*?_main_seg0\.mp3)\n`).FindStringSubmatch(string(byts))
+	require.NotNil(t, ma)
+
+	byts, h, err := doRequest(m, ma[1])
+	require.NoError(t, err)
+	require.Equal(t, "audio/mpeg", h.Get("Content-Type"))
+
+	ts, n, err := packedAudioUnmarshalHeader(byts)
+	require.NoError(t, err)
+	require.Equal(t, int64(0), ts)
+	require.Equal(t, 0, (len(byts)-n)%len(frame))
+	require.Equal(t, frame, byts[n:n+len(frame)])
+}
+
+func TestMuxerMultipleVideoTracks(t *testing.T) {
+	videoTrack1 := &Track{
+		Codec: &codecs.H264{
+			SPS: testSPS,
+			PPS: testPPS,
+		},
+		ClockRate: 90000,
+	}
+
+	videoTrack2 := &Track{
+		Codec: &codecs.H264{
+			SPS: testSPS,
+			PPS: testPPS,
+		},
+		ClockRate: 90000,
+	}
+
+	audioTrack := &Track{
+		Codec: &codecs.MPEG4Audio{
+			Config: testConfig,
+		},
+		ClockRate: 44100,
+	}
+
+	m := &Muxer{
+		Variant:            MuxerVariantFMP4,
+		SegmentCount:       3,
+		SegmentMinDuration: 1 * time.Second,
+		Tracks:             []*Track{videoTrack1, videoTrack2, audioTrack},
+	}
+
+	err := m.Start()
+	require.NoError(t, err)
+	defer m.Close()
+
+	for i := range 8 {
+		var au [][]byte
+		if (i % 2) == 0 {
+			au = [][]byte{testSPS, testPPS, {5}} // IDR
+		} else {
+			au = [][]byte{{1}} // non-IDR
+		}
+
+		ntp := testTime.Add(time.Duration(i) * 500 * time.Millisecond)
+		pts := int64(i) * 45000
+
+		// segments are aligned regardless of the order of writes
+		err = m.WriteH264(videoTrack2, ntp, pts, au)
+		require.NoError(t, err)
+
+		err = m.WriteH264(videoTrack1, ntp, pts, au)
+		require.NoError(t, err)
+
+		err = m.WriteMPEG4Audio(audioTrack, ntp, int64(i)*22050, [][]byte{{1, 2, 3, 4}})
+		require.NoError(t, err)
+	}
+
+	byts, _, err := doRequest(m, "index.m3u8")
+	require.NoError(t, err)
+	require.Regexp(t, `^#EXTM3U\n`+
+		`#EXT-X-VERSION:9\n`+
+		`#EXT-X-INDEPENDENT-SEGMENTS\n`+
+		`\n`+
+		`#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="audio",NAME="audio3",AUTOSELECT=YES,DEFAULT=YES,`+
+		`URI="audio3_stream.m3u8"\n`+
+		`\n`+
+		`#EXT-X-STREAM-INF:BANDWIDTH=[0-9]+,AVERAGE-BANDWIDTH=[0-9]+,`+
+		`CODECS="avc1.42c028,mp4a.40.2",RESOLUTION=1920x1080,FRAME-RATE=30.000,AUDIO="audio",CLOSED-CAPTIONS=NONE\n`+
+		`video1_stream.m3u8\n`+
+		`#EXT-X-STREAM-INF:BANDWIDTH=[0-9]+,AVERAGE-BANDWIDTH=[0-9]+,`+
+		`CODECS="avc1.42c028,mp4a.40.2",RESOLUTION=1920x1080,FRAME-RATE=30.000,AUDIO="audio",CLOSED-CAPTIONS=NONE\n`+
+		`video2_stream.m3u8\n$`, string(byts))
+
+	for _, id := range []string{"video1", "video2"} {
+		byts, _, err = doRequest(m, id+"_stream.m3u8")
+		require.NoError(t, err)
+
+		ma := regexp.MustCompile(`^#EXTM3U\n` +
+			`#EXT-X-VERSION:10\n` +
+			`#EXT-X-TARGETDURATION:1\n` +
+			`#EXT-X-MEDIA-SEQUENCE:0\n` +
+			`#EXT-X-MAP:URI="(.*?_` + id + `_init.mp4)"\n` +
+			`#EXTINF:1.00000,\n` +
+			`(.*?_` + id + `_seg0.mp4)\n` +
+			`#EXT-X-PROGRAM-DATE-TIME:2010-01-01T01:01:02Z\n` +
+			`#EXTINF:1.00000,\n` +
+			`(.*?_` + id + `_seg1.mp4)\n` +
+			`#EXT-X-PROGRAM-DATE-TIME:2010-01-01T01:01:03Z\n` +
+			`#EXTINF:1.00000,\n` +
+			`(.*?_` + id + `_seg2.mp4)\n$`).FindStringSubmatch(string(byts))
+		require.NotNil(t, ma, string(byts))
+
+		// each segment starts with a random access sample
+		for _, seg := range ma[2:] {
+			byts, _, err = doRequest(m, seg)
+			require.NoError(t, err)
+
+			var parts fmp4.Parts
+			err = parts.Unmarshal(byts)
+			require.NoError(t, err)
+			require.Equal(t, false, parts[0].Tracks[0].Samples[0].IsNonSyncSample)
+			require.Len(t, parts[0].Tracks[0].Samples, 2)
+		}
+	}
+}
+
+func TestMuxerMultipleVideoTracksAlignment(t *testing.T) {
+	for _, ca := range []string{"aligned", "not aligned"} {
+		t.Run(ca, func(t *testing.T) {
+			videoTrack1 := &Track{
+				Codec: &codecs.H264{
+					SPS: testSPS,
+					PPS: testPPS,
+				},
+				ClockRate: 90000,
+			}
+
+			videoTrack2 := &Track{
+				Codec: &codecs.H264{
+					SPS: testSPS,
+					PPS: testPPS,
+				},
+				ClockRate: 90000,
+			}
+
+			m := &Muxer{
+				Variant:            MuxerVariantFMP4,
+				SegmentCount:       3,
+				SegmentMinDuration: 1 * time.Second,
+				Tracks:             []*Track{videoTrack1, videoTrack2},
+			}
+
+			err := m.Start()
+			require.NoError(t, err)
+			defer m.Close()
+
+			for i := range 12 {
+				ntp := testTime.Add(time.Duration(i) * 500 * time.Millisecond)
+				pts := int64(i) * 45000
+
+				// the leading track has a random access sample every 1.5s
+				au := [][]byte{{1}} // non-IDR
+				if (i % 3) == 0 {
+					au = [][]byte{testSPS, testPPS, {5}} // IDR
+				}
+
+				err = m.WriteH264(videoTrack1, ntp, pts, au)
+				require.NoError(t, err)
+
+				au = [][]byte{{1}} // non-IDR
+				if ca == "aligned" || (i%4) == 0 {
+					au = [][]byte{testSPS, testPPS, {5}} // IDR
+				}
+
+				err = m.WriteH264(videoTrack2, ntp, pts, au)
+
+				if ca == "not aligned" && i == 4 {
+					require.EqualError(t, err, "additional video track has no random access sample at 1.5s, "+
+						"where the leading track switches segment")
+					return
+				}
+				require.NoError(t, err)
+			}
+
+			// segments of the additional track follow the ones of the leading track
+			// even if the additional track has more random access samples.
+			for _, id := range []string{"video1", "video2"} {
+				var byts []byte
+				byts, _, err = doRequest(m, id+"_stream.m3u8")
+				require.NoError(t, err)
+				require.Regexp(t, `^#EXTM3U\n`+
+					`#EXT-X-VERSION:10\n`+
+					`#EXT-X-TARGETDURATION:2\n`+
+					`#EXT-X-MEDIA-SEQUENCE:0\n`+
+					`#EXT-X-MAP:URI=".*?_`+id+`_init.mp4"\n`+
+					`#EXTINF:1.50000,\n`+
+					`.*?_`+id+`_seg0.mp4\n`+
+					`#EXT-X-PROGRAM-DATE-TIME:2010-01-01T01:01:02.5Z\n`+
+					`#EXTINF:1.50000,\n`+
+					`.*?_`+id+`_seg1.mp4\n`+
+					`#EXT-X-PROGRAM-DATE-TIME:2010-01-01T01:01:04Z\n`+
+					`#EXTINF:1.50000,\n`+
+					`.*?_`+id+`_seg2.mp4\n$`, string(byts))
+			}
+		})
+	}
+}
+
+func TestMuxerVideoRenditions(t *testing.T) {
+	videoTrack1 := &Track{
+		Codec: &codecs.H264{
+			SPS: testSPS,
+			PPS: testPPS,
+		},
+		ClockRate: 90000,
+		Name:      "front",
+	}
+
+	videoTrack2 := &Track{
+		Codec: &codecs.H264{
+			SPS: testSPS,
+			PPS: testPPS,
+		},
+		ClockRate: 90000,
+		Name:      "side",
+		IsDefault: true,
+	}
+
+	m := &Muxer{
+		Variant:            MuxerVariantFMP4,
+		SegmentCount:       3,
+		SegmentMinDuration: 1 * time.Second,
+		Tracks:             []*Track{videoTrack1, videoTrack2},
+	}
+
+	err := m.Start()
+	require.NoError(t, err)
+	defer m.Close()
+
+	for i := range 8 {
+		var au [][]byte
+		if (i % 2) == 0 {
+			au = [][]byte{testSPS, testPPS, {5}} // IDR
+		} else {
+			au = [][]byte{{1}} // non-IDR
+		}
+
+		ntp := testTime.Add(time.Duration(i) * 500 * time.Millisecond)
+		pts := int64(i) * 45000
+
+		err = m.WriteH264(videoTrack1, ntp, pts, au)
+		require.NoError(t, err)
+
+		err = m.WriteH264(videoTrack2, ntp, pts, au)
+		require.NoError(t, err)
+	}
+
+	byts, _, err := doRequest(m, "index.m3u8")
+	require.NoError(t, err)
+	require.Regexp(t, `^#EXTM3U\n`+
+		`#EXT-X-VERSION:9\n`+
+		`#EXT-X-INDEPENDENT-SEGMENTS\n`+
+		`\n`+
+		`#EXT-X-MEDIA:TYPE=VIDEO,GROUP-ID="video",NAME="front",AUTOSELECT=YES\n`+
+		`#EXT-X-MEDIA:TYPE=VIDEO,GROUP-ID="video",NAME="side",AUTOSELECT=YES,DEFAULT=YES,`+
+		`URI="video2_stream.m3u8"\n`+
+		`\n`+
+		`#EXT-X-STREAM-INF:BANDWIDTH=[0-9]+,AVERAGE-BANDWIDTH=[0-9]+,`+
+		`CODECS="avc1.42c028",RESOLUTION=1920x1080,FRAME-RATE=30.000,VIDEO="video",CLOSED-CAPTIONS=NONE\n`+
+		`video1_stream.m3u8\n$`, string(byts))
+
+	byts, _, err = doRequest(m, "video2_stream.m3u8")
+	require.NoError(t, err)
+	require.Regexp(t, `^#EXTM3U\n`+
+		`#EXT-X-VERSION:10\n`+
+		`#EXT-X-TARGETDURATION:1\n`+
+		`#EXT-X-MEDIA-SEQUENCE:0\n`+
+		`#EXT-X-MAP:URI=".*?_video2_init.mp4"\n`+
+		`#EXTINF:1.00000,\n`+
+		`.*?_video2_seg0.mp4\n`+
+		`#EXT-X-PROGRAM-DATE-TIME:2010-01-01T01:01:02Z\n`+
+		`#EXTINF:1.00000,\n`+
+		`.*?_video2_seg1.mp4\n`+
+		`#EXT-X-PROGRAM-DATE-TIME:2010-01-01T01:01:03Z\n`+
+		`#EXTINF:1.00000,\n`+
+		`.*?_video2_seg2.mp4\n$`, string(byts))
+}
+
+func TestMuxerMPEGTSCodecs(t *testing.T) {
+	ac3Frame := make([]byte, 128)
+	copy(ac3Frame, []byte{0x0b, 0x77, 0x00, 0x00, 0x00})
+
+	mp3Frame := make([]byte, 417)
+	copy(mp3Frame, []byte{0xff, 0xfb, 0x90, 0x64})
+
+	for _, ca := range []string{"opus", "mpeg-1 audio", "ac-3"} {
+		t.Run(ca, func(t *testing.T) {
+			videoTrack := &Track{
+				Codec: &codecs.H265{
+					VPS: []byte{0x40, 0x01, 0x0c, 0x01},
+					SPS: []byte{
+						0x42, 0x01, 0x01, 0x01, 0x60, 0x00, 0x00, 0x03,
+						0x00, 0x90, 0x00, 0x00, 0x03, 0x00, 0x00, 0x03,
+						0x00, 0x78, 0xa0, 0x03, 0xc0, 0x80, 0x10, 0xe5,
+						0x96, 0x66, 0x69, 0x24, 0xca, 0xe0, 0x10, 0x00,
+						0x00, 0x03, 0x00, 0x10, 0x00, 0x00, 0x03, 0x01,
+						0xe0, 0x80,
+					},
+					PPS: []byte{0x44, 0x01, 0xc0, 0x25, 0x2f, 0x05, 0x32, 0x40},
+				},
+				ClockRate: 90000,
+			}
+
+			var audioTrack *Track
+			var codecString string
+			var mpegtsCodec mpegtscodecs.Codec
+
+			switch ca {
+			case "opus":
+				audioTrack = &Track{
+					Codec: &codecs.Opus{
+						ChannelCount: 2,
+					},
+					ClockRate: 48000,
+				}
+				codecString = "opus"
+				mpegtsCodec = &mpegtscodecs.Opus{ChannelCount: 2}
+
+			case "mpeg-1 audio":
+				audioTrack = &Track{
+					Codec: &codecs.MPEG1Audio{
+						SampleRate:   44100,
+						ChannelCount: 2,
+					},
+					ClockRate: 44100,
+				}
+				codecString = "mp4a.40.34"
+				mpegtsCodec = &mpegtscodecs.MPEG1Audio{}
+
+			case "ac-3":
+				audioTrack = &Track{
+					Codec: &codecs.AC3{
+						SampleRate:   48000,
+						ChannelCount: 2,
+					},
+					ClockRate: 48000,
+				}
+				codecString = "ac-3"
+				mpegtsCodec = &mpegtscodecs.AC3{SampleRate: 48000, ChannelCount: 2}
+			}
+
+			m := &Muxer{
+				Variant:            MuxerVariantMPEGTS,
+				SegmentCount:       3,
+				SegmentMinDuration: 1 * time.Second,
+				Tracks:             []*Track{videoTrack, audioTrack},
+			}
+
+			err := m.Start()
+			require.NoError(t, err)
+			defer m.Close()
+
+			for i := range 4 {
+				ntp := testTime.Add(time.Duration(i) * time.Second)
+
+				codec := videoTrack.Codec.(*codecs.H265)
+
+				err = m.WriteH265(videoTrack, ntp, int64(i)*90000, [][]byte{
+					codec.VPS,
+					codec.SPS,
+					codec.PPS,
+					{byte(h265.NALUType_IDR_W_RADL) << 1, 1},
+				})
+				require.NoError(t, err)
+
+				switch ca {
+				case "opus":
+					err = m.WriteOpus(audioTrack, ntp, int64(i)*48000, [][]byte{{0xf8, 0xff, 0xfe}})
+
+				case "mpeg-1 audio":
+					err = m.WriteMPEG1Audio(audioTrack, ntp, int64(i)*44100, [][]byte{mp3Frame})
+
+				case "ac-3":
+					err = m.WriteAC3(audioTrack, ntp, int64(i)*48000, ac3Frame)
+				}
+				require.NoError(t, err)
+			}
+
+			byts, _, err := doRequest(m, "index.m3u8")
+			require.NoError(t, err)
+			require.Regexp(t, `^#EXTM3U\n`+
+				`#EXT-X-VERSION:3\n`+
+				`#EXT-X-INDEPENDENT-SEGMENTS\n`+
+				`\n`+
+				`#EXT-X-STREAM-INF:BANDWIDTH=[0-9]+,AVERAGE-BANDWIDTH=[0-9]+,`+
+				`CODECS="hvc1.1.6.L120.90,`+regexp.QuoteMeta(codecString)+`",RESOLUTION=1920x1080,FRAME-RATE=30.000,`+
+				`CLOSED-CAPTIONS=NONE\n`+
+				`main_stream.m3u8\n$`, string(byts))
+
+			byts, _, err = doRequest(m, "main_stream.m3u8")
+			require.NoError(t, err)
+
+			ma := regexp.MustCompile(`(.*?_main_seg0.ts)\n`).FindStringSubmatch(string(byts))
+			require.NotNil(t, ma, string(byts))
+
+			byts, _, err = doRequest(m, ma[1])
+			require.NoError(t, err)
+
+			r := &mpegts.Reader{R: bytes.NewReader(byts)}
+			err = r.Initialize()
+			require.NoError(t, err)
+			require.Len(t, r.Tracks(), 2)
+			require.Equal(t, &mpegtscodecs.H265{}, r.Tracks()[0].Codec)
+			require.Equal(t, mpegtsCodec, r.Tracks()[1].Codec)
+		})
+	}
+}
+
+func TestMuxerAC3MissingSampleRate(t *testing.T) {
+	ac3Frame := make([]byte, 128)
+	copy(ac3Frame, []byte{0x0b, 0x77, 0x00, 0x00, 0x00})
+
+	audioTrack := &Track{
+		Codec: &codecs.AC3{
+			ChannelCount: 2,
+		},
+		ClockRate: 48000,
+	}
+
+	m := &Muxer{
+		Variant:            MuxerVariantMPEGTS,
+		SegmentCount:       3,
+		SegmentMinDuration: 1 * time.Second,
+		Tracks:             []*Track{audioTrack},
+	}
+
+	err := m.Start()
+	require.NoError(t, err)
+	defer m.Close()
+
+	// frame duration is taken from the frame itself
+	err = m.WriteAC3(audioTrack, testTime, 0, ac3Frame)
+	require.NoError(t, err)
+
+	err = m.WriteAC3(audioTrack, testTime, 1536, []byte{0x0b, 0x77})
+	require.Error(t, err)
+}
+
+type testKeyProvider struct{}
+
+func (testKeyProvider) Key(id uint64) ([]byte, error) {
+	return bytes.Repeat([]byte{byte(id + 1)}, 16), nil
+}
+
+func TestMuxerEncryption(t *testing.T) {
+	for _, ca := range testVariants {
+		t.Run(ca.name, func(t *testing.T) {
+			m := &Muxer{
+				Variant:             ca.variant,
+				SegmentCount:        3,
+				SegmentMinDuration:  1 * time.Second,
+				Tracks:              []*Track{testVideoTrack},
+				Encryption:          MuxerEncryptionAES128,
+				KeyProvider:         testKeyProvider{},
+				KeyRotationSegments: 2,
+			}
+
+			if ca.variant == MuxerVariantFMP4 {
+				m.KeyURITemplate = "https://keys.example.com/{id}"
+			}
+
+			err := m.Start()
+			require.NoError(t, err)
+			defer m.Close()
+
+			for i := range 5 {
+				writeTestH264(t, m, i, true)
+			}
+
+			byts, _, err := doRequest(m, "index.m3u8?key=value")
+			require.NoError(t, err)
+
+			var re string
+			if ca.variant == MuxerVariantMPEGTS {
+				re = `^#EXTM3U\n` +
+					`#EXT-X-VERSION:3\n` +
+					`#EXT-X-ALLOW-CACHE:NO\n` +
+					`#EXT-X-TARGETDURATION:1\n` +
+					`#EXT-X-MEDIA-SEQUENCE:1\n` +
+					`#EXT-X-KEY:METHOD=AES-128,URI="(.*?_key0.key)\?key=value"\n` +
+					`#EXT-X-PROGRAM-DATE-TIME:2010-01-01T01:01:02Z\n` +
+					`#EXTINF:1.00000,\n` +
+					`(.*?_main_seg1.ts)\?key=value\n` +
+					`#EXT-X-KEY:METHOD=AES-128,URI="(.*?_key1.key)\?key=value"\n` +
+					`#EXT-X-PROGRAM-DATE-TIME:2010-01-01T01:01:03Z\n` +
+					`#EXTINF:1.00000,\n` +
+					`(.*?_main_seg2.ts)\?key=value\n` +
+					`#EXT-X-PROGRAM-DATE-TIME:2010-01-01T01:01:04Z\n` +
+					`#EXTINF:1.00000,\n` +
+					`(.*?_main_seg3.ts)\?key=value\n$`
+			} else {
+				re = `^#EXTM3U\n` +
+					`#EXT-X-VERSION:10\n` +
+					`#EXT-X-TARGETDURATION:1\n` +
+					`#EXT-X-MEDIA-SEQUENCE:1\n` +
+					`#EXT-X-MAP:URI=".*?_video1_init.mp4\?key=value"\n` +
+					`#EXT-X-KEY:METHOD=AES-128,URI="(https://keys.example.com/0)"\n` +
+					`#EXTINF:1.00000,\n` +
+					`(.*?_video1_seg1.mp4)\?key=value\n` +
+					`#EXT-X-KEY:METHOD=AES-128,URI="(https://keys.example.com/1)"\n` +
+					`#EXT-X-PROGRAM-DATE-TIME:2010-01-01T01:01:03Z\n` +
+					`#EXTINF:1.00000,\n` +
+					`(.*?_video1_seg2.mp4)\?key=value\n` +
+					`#EXT-X-PROGRAM-DATE-TIME:2010-01-01T01:01:04Z\n` +
+					`#EXTINF:1.00000,\n` +
+					`(.*?_video1_seg3.mp4)\?key=value\n$`
+			}
+
+			byts, _, err = doRequest(m, ca.streamPath+"?key=value")
+			require.NoError(t, err)
+
+			ma := regexp.MustCompile(re).FindStringSubmatch(string(byts))
+			require.NotNil(t, ma, string(byts))
+
+			if ca.variant == MuxerVariantMPEGTS {
+				var key []byte
+				key, _, err = doRequest(m, ma[1])
+				require.NoError(t, err)
+				require.Equal(t, bytes.Repeat([]byte{1}, 16), key)
+			} else {
+				// keys are served by the application
+				_, _, err = doRequest(m, keyPath(m.prefix, 0))
+				require.Error(t, err)
+			}
+
+			for i, seg := range []string{ma[2], ma[4], ma[5]} {
+				segmentID := uint64(i + 1)
+
+				byts, _, err = doRequest(m, seg)
+				require.NoError(t, err)
+				require.Equal(t, 0, len(byts)%aes.BlockSize)
+
+				key, _ := testKeyProvider{}.Key(segmentID / 2)
+				block, err2 := aes.NewCipher(key)
+				require.NoError(t, err2)
+
+				iv := make([]byte, aes.BlockSize)
+				iv[15] = byte(segmentID)
+
+				dec := make([]byte, len(byts))
+				cipher.NewCBCDecrypter(block, iv).CryptBlocks(dec, byts)
+				dec = dec[:len(dec)-int(dec[len(dec)-1])]
+
+				if ca.variant == MuxerVariantMPEGTS {
+					r := &mpegts.Reader{R: bytes.NewReader(dec)}
+					err = r.Initialize()
+					require.NoError(t, err)
+				} else {
+					var parts fmp4.Parts
+					err = parts.Unmarshal(dec)
+					require.NoError(t, err)
+					require.Len(t, parts, 1)
+				}
+			}
+		})
+	}
+}
+
+func testSampleAESDecrypt(t *testing.T, key []byte, iv []byte, buf []byte, cryptBlocks int, skipBlocks int) {
+	block, err := aes.NewCipher(key)
+	require.NoError(t, err)
+
+	mode := cipher.NewCBCDecrypter(block, iv)
+
+	for len(buf) >= aes.BlockSize {
+		n := min(cryptBlocks*aes.BlockSize, len(buf)-len(buf)%aes.BlockSize)
+		mode.CryptBlocks(buf[:n], buf[:n])
+		buf = buf[n:]
+		buf = buf[min(skipBlocks*aes.BlockSize, len(buf)):]
+	}
+}
+
+func TestMuxerEncryptionLateStream(t *testing.T) {
+	videoTrack1 := &Track{
+		Codec: &codecs.H264{
+			SPS: testSPS,
+			PPS: testPPS,
+		},
+		ClockRate: 90000,
+	}
+
+	videoTrack2 := &Track{
+		Codec: &codecs.H264{
+			SPS: testSPS,
+			PPS: testPPS,
+		},
+		ClockRate: 90000,
+	}
+
+	m := &Muxer{
+		Variant:             MuxerVariantFMP4,
+		SegmentCount:        3,
+		SegmentMinDuration:  1 * time.Second,
+		Tracks:              []*Track{videoTrack1, videoTrack2},
+		Encryption:          MuxerEncryptionAES128,
+		KeyProvider:         testKeyProvider{},
+		KeyRotationSegments: 1,
+	}
+
+	err := m.Start()
+	require.NoError(t, err)
+	defer m.Close()
+
+	au := [][]byte{testSPS, testPPS, {5}} // IDR
+
+	for i := range 8 {
+		err = m.WriteH264(videoTrack1, testTime.Add(time.Duration(i)*time.Second), int64(i)*90000, au)
+		require.NoError(t, err)
+
+		// the second track is late
+		if i < 4 {
+			err = m.WriteH264(videoTrack2, testTime.Add(time.Duration(i)*time.Second), int64(i)*90000, au)
+			require.NoError(t, err)
+		}
+	}
+
+	byts, _, err := doRequest(m, "video2_stream.m3u8")
+	require.NoError(t, err)
+
+	mas := regexp.MustCompile(`#EXT-X-KEY:METHOD=AES-128,URI="(.*?_key([0-9]+)\.key)"`).
+		FindAllStringSubmatch(string(byts), -1)
+	require.NotEmpty(t, mas, string(byts))
+	require.Equal(t, "0", mas[0][2])
+
+	// keys of segments listed by any stream are still available
+	for _, ma := range mas {
+		_, _, err = doRequest(m, ma[1])
+		require.NoError(t, err)
+	}
+}
+
+func TestMuxerSampleAES(t *testing.T) {
+	slice := append([]byte{0x65}, bytes.Repeat([]byte{1, 2, 3, 4}, 64)...)
+	audioAU := bytes.Repeat([]byte{1, 2, 3, 4}, 25)
+
+	for _, ca := range testVariants {
+		t.Run(ca.name, func(t *testing.T) {
+			m := &Muxer{
+				Variant:            ca.variant,
+				SegmentCount:       3,
+				SegmentMinDuration: 1 * time.Second,
+				Tracks:             []*Track{testVideoTrack, testAudioTrack},
+				Encryption:         MuxerEncryptionSampleAES,
+				KeyProvider:        testKeyProvider{},
+				KeyFormat:          "com.apple.streamingkeydelivery",
+				KeyFormatVersions:  "1",
+			}
+
+			err := m.Start()
+			require.NoError(t, err)
+			defer m.Close()
+
+			for i := range 3 {
+				err = m.WriteH264(
+					testVideoTrack,
+					testTime.Add(time.Duration(i)*time.Second),
+					int64(i)*90000,
+					[][]byte{
+						testSPS,
+						testPPS,
+						slice,
+					})
+				require.NoError(t, err)
+
+				err = m.WriteMPEG4Audio(
+					testAudioTrack,
+					testTime.Add(time.Duration(i)*time.Second),
+					int64(i)*44100,
+					[][]byte{audioAU})
+				require.NoError(t, err)
+			}
+
+			byts, _, err := doRequest(m, ca.streamPath)
+			require.NoError(t, err)
+
+			ma := regexp.MustCompile(`#EXT-X-VERSION:(\d+)\n(?s:.*?)` +
+				`#EXT-X-KEY:METHOD=SAMPLE-AES,URI="(.*?_key0.key)",IV=0x([0-9a-f]{32}),` +
+				`KEYFORMAT="com.apple.streamingkeydelivery",KEYFORMATVERSIONS="1"\n` +
+				`(?s:.*?)\n(.*?_seg0.` + ca.segExt + `)\n`).FindStringSubmatch(string(byts))
+			require.NotNil(t, ma, string(byts))
+
+			if ca.variant == MuxerVariantMPEGTS {
+				require.Equal(t, "5", ma[1])
+			} else {
+				require.Equal(t, "10", ma[1])
+			}
+
+			key, _, err := doRequest(m, ma[2])
+			require.NoError(t, err)
+			require.Equal(t, bytes.Repeat([]byte{1}, 16), key)
+
+			iv, err := hex.DecodeString(ma[3])
+			require.NoError(t, err)
+
+			byts, _, err = doRequest(m, ma[4])
+			require.NoError(t, err)
+
+			if ca.variant == MuxerVariantMPEGTS {
+				dem := astits.NewDemuxer(context.Background(), bytes.NewReader(byts))
+
+				videoChecked := false
+				audioChecked := false
+
+				for !videoChecked || !audioChecked {
+					var data *astits.DemuxerData
+					data, err = dem.NextData()
+					require.NoError(t, err)
+
+					switch {
+					case data.PMT != nil:
+						require.Equal(t, astits.StreamType(0xdb), data.PMT.ElementaryStreams[0].StreamType)
+						require.Equal(t, astits.StreamType(0xcf), data.PMT.ElementaryStreams[1].StreamType)
+
+					case data.PES != nil && data.PID == 256 && !videoChecked:
+						var au h264.AnnexB
+						err = au.Unmarshal(data.PES.Data)
+						require.NoError(t, err)
+
+						dec := h264.EmulationPreventionRemove(au[len(au)-1])
+						require.NotEqual(t, slice, dec)
+						testSampleAESDecrypt(t, key, iv, dec[32:len(dec)-1], 1, 9)
+						require.Equal(t, slice, dec)
+						videoChecked = true
+
+					case data.PES != nil && data.PID == 257 && !audioChecked:
+						var pkts mpeg4audio.ADTSPackets
+						err = pkts.Unmarshal(data.PES.Data)
+						require.NoError(t, err)
+
+						dec := pkts[0].AU
+						require.NotEqual(t, audioAU, dec)
+						testSampleAESDecrypt(t, key, iv, dec[16:], 1, 0)
+						require.Equal(t, audioAU, dec)
+						audioChecked = true
+					}
+				}
+			} else {
+				for _, typ := range []string{"senc", "saiz", "saio"} {
+					require.True(t, bytes.Contains(byts, []byte(typ)))
+				}
+
+				var parts fmp4.Parts
+				err = parts.Unmarshal(byts)
+				require.NoError(t, err)
+
+				dec := parts[0].Tracks[0].Samples[0].Payload
+				dec = dec[len(dec)-len(slice):]
+				testSampleAESDecrypt(t, key, iv, dec[32:32+((len(slice)-32)/16)*16], 1, 9)
+				require.Equal(t, slice, dec)
+
+				byts, _, err = doRequest(m, initFilePath(m.prefix, "video1"))
+				require.NoError(t, err)
+
+				for _, typ := range []string{"encv", "sinf", "frma", "avc1", "schm", "cbcs", "schi", "tenc"} {
+					require.True(t, bytes.Contains(byts, []byte(typ)))
+				}
+
+				byts, _, err = doRequest(m, initFilePath(m.prefix, "audio2"))
+				require.NoError(t, err)
+				require.True(t, bytes.Contains(byts, []byte("enca")))
+			}
+		})
+	}
+}
+
+func TestMuxerWebVTT(t *testing.T) {
+	for _, ca := range []string{"mpegts", "fmp4"} {
+		t.Run(ca, func(t *testing.T) {
+			subtitleTrack := &Track{
+				Codec:     &codecs.WebVTT{},
+				ClockRate: 1000,
+				Name:      "English",
+				Language:  "en",
+				IsForced:  true,
+			}
+
+			m := &Muxer{
+				SegmentCount:       3,
+				SegmentMinDuration: 1 * time.Second,
+				Tracks:             []*Track{testVideoTrack, subtitleTrack},
+			}
+
+			if ca == "mpegts" {
+				m.Variant = MuxerVariantMPEGTS
+			} else {
+				m.Variant = MuxerVariantFMP4
+			}
+
+			err := m.Start()
+			require.NoError(t, err)
+			defer m.Close()
+
+			err = m.WriteWebVTT(subtitleTrack, &MuxerWebVTTCue{
+				ID:       "1",
+				PTS:      500,
+				Duration: 1000,
+				Text:     "first cue",
+			})
+			require.NoError(t, err)
+
+			err = m.WriteWebVTT(subtitleTrack, &MuxerWebVTTCue{
+				PTS:      2200,
+				Duration: 200,
+				Settings: "line:0",
+				Text:     "second\ncue",
+			})
+			require.NoError(t, err)
+
+			err = m.WriteWebVTT(subtitleTrack, &MuxerWebVTTCue{
+				PTS:      3000,
+				Duration: 200,
+				Text:     "invalid --> cue",
+			})
+			require.Error(t, err)
+
+			for i := range 4 {
+				err = m.WriteH264(
+					testVideoTrack,
+					testTime.Add(time.Duration(i)*time.Second),
+					int64(i)*90000,
+					[][]byte{
+						testSPS,
+						testPPS,
+						{5}, // IDR
+					})
+				require.NoError(t, err)
+			}
+
+			byts, _, err := doRequest(m, "index.m3u8")
+			require.NoError(t, err)
+
+			var streamURI string
+			if ca == "mpegts" {
+				streamURI = "main_stream.m3u8"
+			} else {
+				streamURI = "video1_stream.m3u8"
+			}
+
+			require.Regexp(t, `^#EXTM3U\n`+
+				`#EXT-X-VERSION:[0-9]+\n`+
+				`#EXT-X-INDEPENDENT-SEGMENTS\n`+
+				`\n`+
+				`#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID="subtitles",LANGUAGE="en",NAME="English",`+
+				`AUTOSELECT=YES,DEFAULT=YES,FORCED=YES,URI="subtitles2_stream.m3u8"\n`+
+				`\n`+
+				`#EXT-X-STREAM-INF:BANDWIDTH=[0-9]+,AVERAGE-BANDWIDTH=[0-9]+,`+
+				`CODECS="avc1.42c028",RESOLUTION=1920x1080,FRAME-RATE=30.000,SUBTITLES="subtitles",CLOSED-CAPTIONS=NONE\n`+
+				streamURI+`\n$`, string(byts))
+
+			byts, _, err = doRequest(m, "subtitles2_stream.m3u8")
+			require.NoError(t, err)
+
+			ma := regexp.MustCompile(`^#EXTM3U\n` +
+				`#EXT-X-VERSION:3\n` +
+				`#EXT-X-ALLOW-CACHE:NO\n` +
+				`#EXT-X-TARGETDURATION:1\n` +
+				`#EXT-X-MEDIA-SEQUENCE:0\n` +
+				`#EXT-X-PROGRAM-DATE-TIME:2010-01-01T01:01:01Z\n` +
+				`#EXTINF:1.00000,\n` +
+				`(.*?_subtitles2_seg0.vtt)\n` +
+				`#EXT-X-PROGRAM-DATE-TIME:2010-01-01T01:01:02Z\n` +
+				`#EXTINF:1.00000,\n` +
+				`(.*?_subtitles2_seg1.vtt)\n` +
+				`#EXT-X-PROGRAM-DATE-TIME:2010-01-01T01:01:03Z\n` +
+				`#EXTINF:1.00000,\n` +
+				`(.*?_subtitles2_seg2.vtt)\n$`).FindStringSubmatch(string(byts))
+			require.NotNil(t, ma, string(byts))
+
+			// timestamps of fMP4 segments are shifted
+			mediaOffset := int64(0)
+			if ca == "fmp4" {
+				mediaOffset = 900000
+			}
+
+			for i, expected := range []string{
+				"WEBVTT\n" +
+					"X-TIMESTAMP-MAP=MPEGTS:" + strconv.FormatInt(mediaOffset, 10) + ",LOCAL:00:00:00.000\n" +
+					"\n" +
+					"1\n" +
+					"00:00:00.500 --> 00:00:01.500\n" +
+					"first cue\n" +
+					"\n",
+				"WEBVTT\n" +
+					"X-TIMESTAMP-MAP=MPEGTS:" + strconv.FormatInt(mediaOffset+90000, 10) + ",LOCAL:00:00:01.000\n" +
+					"\n" +
+					"1\n" +
+					"00:00:00.500 --> 00:00:01.500\n" +
+					"first cue\n" +
+					"\n",
+				"WEBVTT\n" +
+					"X-TIMESTAMP-MAP=MPEGTS:" + strconv.FormatInt(mediaOffset+180000, 10) + ",LOCAL:00:00:02.000\n" +
+					"\n" +
+					"00:00:02.200 --> 00:00:02.400 line:0\n" +
+					"second\n" +
+					"cue\n" +
+					"\n",
+			} {
+				var h http.Header
+				byts, h, err = doRequest(m, ma[1+i])
+				require.NoError(t, err)
+				require.Equal(t, "text/vtt", h.Get("Content-Type"))
+				require.Equal(t, expected, string(byts))
+			}
+		})
+	}
+}
+
+func TestMuxerClosedCaptions(t *testing.T) {
+	m := &Muxer{
+		Variant:            MuxerVariantMPEGTS,
+		SegmentCount:       3,
+		SegmentMinDuration: 1 * time.Second,
+		Tracks:             []*Track{testVideoTrack},
+		ClosedCaptions: []*MuxerClosedCaptions{
+			{
+				InstreamID: "CC1",
+				Language:   "en",
+				IsDefault:  true,
+			},
+			{
+				InstreamID: "CC3",
+				Name:       "Spanish",
+				Language:   "es",
+			},
+		},
+	}
+
+	err := m.Start()
+	require.NoError(t, err)
+	defer m.Close()
+
+	err = m.WriteCEA608(testVideoTrack, 3, []byte{0x94, 0x2c})
+	require.Error(t, err)
+
+	err = m.WriteCEA608(testVideoTrack, 1, []byte{0x94})
+	require.Error(t, err)
+
+	err = m.WriteCEA608(testVideoTrack, 1, []byte{0x94, 0x2c})
+	require.NoError(t, err)
+
+	for i := range 2 {
+		err = m.WriteH264(
+			testVideoTrack,
+			testTime.Add(time.Duration(i)*time.Second),
+			int64(i)*90000,
+			[][]byte{
+				testSPS,
+				testPPS,
+				{5}, // IDR
+			})
+		require.NoError(t, err)
+	}
+
+	byts, _, err := doRequest(m, "index.m3u8")
+	require.NoError(t, err)
+	require.Equal(t, "#EXTM3U\n"+
+		"#EXT-X-VERSION:3\n"+
+		"#EXT-X-INDEPENDENT-SEGMENTS\n"+
+		"\n"+
+		"#EXT-X-MEDIA:TYPE=CLOSED-CAPTIONS,GROUP-ID=\"cc\",LANGUAGE=\"en\","+
+		"NAME=\"CC1\",AUTOSELECT=YES,DEFAULT=YES,INSTREAM-ID=\"CC1\"\n"+
+		"#EXT-X-MEDIA:TYPE=CLOSED-CAPTIONS,GROUP-ID=\"cc\",LANGUAGE=\"es\","+
+		"NAME=\"Spanish\",AUTOSELECT=YES,INSTREAM-ID=\"CC3\"\n"+
+		"\n"+
+		"#EXT-X-STREAM-INF:BANDWIDTH=4512,AVERAGE-BANDWIDTH=4512,"+
+		"CODECS=\"avc1.42c028\",RESOLUTION=1920x1080,FRAME-RATE=30.000,CLOSED-CAPTIONS=\"cc\"\n"+
+		"main_stream.m3u8\n", string(byts))
+
+	byts, _, err = doRequest(m, "main_stream.m3u8")
+	require.NoError(t, err)
+
+	ma := regexp.MustCompile(`\n(.*?_seg0\.ts)\n`).FindStringSubmatch(string(byts))
+	require.NotNil(t, ma)
+
+	byts, _, err = doRequest(m, ma[1])
+	require.NoError(t, err)
+
+	dem := astits.NewDemuxer(context.Background(), bytes.NewReader(byts))
+
+	for {
+		var data *astits.DemuxerData
+		data, err = dem.NextData()
+		require.NoError(t, err)
+
+		if data.PES != nil {
+			var au h264.AnnexB
+			err = au.Unmarshal(data.PES.Data)
+			require.NoError(t, err)
+
+			i := slices.IndexFunc(au, func(nalu []byte) bool {
+				return h264.NALUType(nalu[0]&0x1F) == h264.NALUTypeSEI
+			})
+			require.NotEqual(t, -1, i)
+			require.Equal(t, []byte{
+				0x06, 0x04, 0x0e, 0xb5, 0x00, 0x31, 'G', 'A', '9', '4',
+				0x03, 0x41, 0xff, 0xfc, 0x94, 0x2c, 0xff, 0x80,
+			}, au[i])
+			require.Less(t, i, slices.IndexFunc(au, func(nalu []byte) bool {
+				return bytes.Equal(nalu, []byte{5})
+			}))
+			break
+		}
+	}
+}
+
+func TestMuxerTimedMetadata(t *testing.T) {
+	for _, ca := range testVariants {
+		t.Run(ca.name, func(t *testing.T) {
+			m := &Muxer{
+				Variant:            ca.variant,
+				SegmentCount:       3,
+				SegmentMinDuration: 1 * time.Second,
+				Tracks:             []*Track{testVideoTrack},
+				TimedMetadata:      true,
+			}
+
+			if ca.variant == MuxerVariantFMP4 {
+				m.MetadataSchemeIDURI = "urn:test"
+			}
+
+			err := m.Start()
+			require.NoError(t, err)
+			defer m.Close()
+
+			payload := append([]byte("ID3"), bytes.Repeat([]byte{1}, 200)...)
+
+			for i := range 3 {
+				writeTestH264(t, m, i, true)
+
+				if i == 1 {
+					// PTS is generated by a clock that drifted,
+					// the position is computed from the absolute timestamp.
+					err = m.WriteMetadata(testTime.Add(1500*time.Millisecond), 130000, payload)
+					require.NoError(t, err)
+				}
+			}
+
+			byts, _, err := doRequest(m, ca.streamPath)
+			require.NoError(t, err)
+
+			ma := regexp.MustCompile(`\n(.*?_seg1\.` + ca.segExt + `)\n`).FindStringSubmatch(string(byts))
+			require.NotNil(t, ma, string(byts))
+
+			byts, _, err = doRequest(m, ma[1])
+			require.NoError(t, err)
+
+			if ca.variant == MuxerVariantMPEGTS {
+				dem := astits.NewDemuxer(context.Background(), bytes.NewReader(byts))
+
+				for {
+					var data *astits.DemuxerData
+					data, err = dem.NextData()
+					require.NoError(t, err)
+
+					if data.PMT != nil {
+						require.Equal(t, uint16(0x25), uint16(data.PMT.ProgramDescriptors[0].Tag))
+						es := data.PMT.ElementaryStreams[1]
+						require.Equal(t, astits.StreamType(0x15), es.StreamType)
+						require.Equal(t, uint16(257), es.ElementaryPID)
+						require.Equal(t, uint8(0x26), es.ElementaryStreamDescriptors[0].Tag)
+					}
+
+					if data.PES != nil && data.PID == 257 {
+						require.Equal(t, uint8(0xbd), data.PES.Header.StreamID)
+						require.Equal(t, int64(135000), data.PES.Header.OptionalHeader.PTS.Base)
+						require.Equal(t, payload, data.PES.Data)
+						break
+					}
+				}
+			} else {
+				i := bytes.Index(byts, []byte("emsg"))
+				require.NotEqual(t, -1, i)
+
+				require.Equal(t, append([]byte{
+					0x00, 0x00, 0x00, 0xf5, 'e', 'm', 's', 'g',
+					0x01, 0x00, 0x00, 0x00, 0x00, 0x01, 0x5f, 0x90,
+					0x00, 0x00, 0x00, 0x00, 0x00, 0x0f, 0xca, 0xf8,
+					0xff, 0xff, 0xff, 0xff, 0x00, 0x00, 0x00, 0x00,
+					'u', 'r', 'n', ':', 't', 'e', 's', 't',
+					0x00, 0x00,
+				}, payload...), byts[i-4:i-4+0xf5])
+			}
+		})
+	}
+}
+
+func TestMuxerDateRange(t *testing.T) {
+	for _, ca := range testVariants {
+		t.Run(ca.name, func(t *testing.T) {
+			m := &Muxer{
+				Variant:            ca.variant,
+				SegmentCount:       3,
+				SegmentMinDuration: 2 * time.Second,
+				Tracks:             []*Track{testVideoTrack},
+				LegacyCueTags:      true,
+			}
+
+			err := m.Start()
+			require.NoError(t, err)
+			defer m.Close()
+
+			err = m.AddDateRange(&MuxerDateRange{
+				PTS: 90000,
+			})
+			require.Error(t, err)
+
+			err = m.AddDateRange(&MuxerDateRange{
+				ID:              "splice-1",
+				PTS:             90000,
+				PlannedDuration: 30 * time.Second,
+				SCTE35Out:       []byte{0xfc, 0x01},
+			})
+			require.NoError(t, err)
+
+			err = m.AddDateRange(&MuxerDateRange{
+				ID:       "splice-1",
+				PTS:      3 * 90000,
+				SCTE35In: []byte{0xfc, 0x02},
+			})
+			require.NoError(t, err)
+
+			for i := range 7 {
+				writeTestH264(t, m, i, true)
+			}
+
+			byts, _, err := doRequest(m, ca.streamPath)
+			require.NoError(t, err)
+
+			startDate := testTime.Add(1 * time.Second).Format("2006-01-02T15:04:05.999Z07:00")
+
+			require.Regexp(t, `#EXTINF:1.00000,\n`+
+				`.*?_seg0\.`+ca.segExt+`\n`+
+				`#EXT-X-CUE-OUT:30.000\n`+
+				`#EXT-X-PROGRAM-DATE-TIME:`+regexp.QuoteMeta(startDate)+`\n`+
+				`#EXT-X-DATERANGE:ID="splice-1",START-DATE="`+regexp.QuoteMeta(startDate)+`",`+
+				`PLANNED-DURATION=30.000,SCTE35-OUT=0xFC01\n`+
+				`#EXTINF:2.00000,\n`+
+				`.*?_seg1\.`+ca.segExt+`\n`+
+				`#EXT-X-CUE-IN\n`+
+				`#EXT-X-PROGRAM-DATE-TIME:.*?\n`+
+				`#EXT-X-DATERANGE:ID="splice-1",START-DATE="`+regexp.QuoteMeta(startDate)+`",`+
+				`DURATION=2.000,SCTE35-IN=0xFC02\n`+
+				`#EXTINF:2.00000,\n`+
+				`.*?_seg2\.`+ca.segExt+`\n`, string(byts))
+		})
+	}
+}
+
+func TestMuxerDateRangeDeltaUpdate(t *testing.T) {
+	m := &Muxer{
+		Variant:            MuxerVariantLowLatency,
+		SegmentCount:       10,
+		SegmentMinDuration: 1 * time.Second,
+		Tracks:             []*Track{testVideoTrack},
+	}
+
+	err := m.Start()
+	require.NoError(t, err)
+	defer m.Close()
+
+	err = m.AddDateRange(&MuxerDateRange{
+		ID:       "ad-1",
+		PTS:      90000,
+		Duration: 1 * time.Second,
+	})
+	require.NoError(t, err)
+
+	for i := range 12 {
+		writeTestH264(t, m, i, true)
+	}
+
+	byts, _, err := doRequest(m, "video1_stream.m3u8")
+	require.NoError(t, err)
+	require.Equal(t, 1, strings.Count(string(byts), "#EXT-X-DATERANGE:"))
+
+	// the closed date range of a skipped segment is added to the first segment
+	byts, _, err = doRequest(m, "video1_stream.m3u8?_HLS_skip=YES")
+	require.NoError(t, err)
+	require.Regexp(t, `#EXT-X-SKIP:SKIPPED-SEGMENTS=[1-9][0-9]*\n`+
+		`#EXT-X-DATERANGE:ID="ad-1",START-DATE="2010-01-01T01:01:02Z",DURATION=1.000\n`+
+		`#EXTINF:1.00000,\n`, string(byts))
+	require.NotContains(t, string(byts), "_seg1.mp4")
+}
+
+func TestMuxerEvent(t *testing.T) {
+	for _, ca := range testVariants {
+		t.Run(ca.name, func(t *testing.T) {
+			m := &Muxer{
+				Variant:      ca.variant,
+				SegmentCount: 3,
+				PlaylistType: MuxerPlaylistTypeEvent,
+				Directory:    t.TempDir(),
+				Tracks:       []*Track{testVideoTrack},
+			}
+
+			err := m.Start()
+			require.NoError(t, err)
+			defer m.Close()
+
+			for i := range 8 {
+				writeTestH264(t, m, i, i != 7)
+			}
+
+			err = m.End()
+			require.NoError(t, err)
+
+			err = m.WriteH264(
+				testVideoTrack,
+				testTime.Add(8*time.Second),
+				8*90000,
+				[][]byte{{1}})
+			require.Equal(t, ErrMuxerEnded, err)
+
+			byts, _, err := doRequest(m, ca.streamPath)
+			require.NoError(t, err)
+
+			require.Regexp(t, `#EXT-X-MEDIA-SEQUENCE:0\n`+
+				`#EXT-X-PLAYLIST-TYPE:EVENT\n`+
+				`(?s).*?`+
+				`#EXTINF:1.00000,\n`+
+				`.*?_seg0\.`+ca.segExt+`\n`+
+				`.*?`+
+				`#EXTINF:2.00000,\n`+
+				`[^\n]*?_seg6\.`+ca.segExt+`\n`+
+				`#EXT-X-ENDLIST\n$`, string(byts))
+
+			require.Equal(t, 7, strings.Count(string(byts), "#EXTINF:"))
+		})
+	}
+}
+
+func TestMuxerDVRWindow(t *testing.T) {
+	for _, ca := range testVariants {
+		t.Run(ca.name, func(t *testing.T) {
+			m := &Muxer{
+				Variant:      ca.variant,
+				SegmentCount: 3,
+				DVRWindow:    4 * time.Second,
+				Tracks:       []*Track{testVideoTrack},
+			}
+
+			err := m.Start()
+			require.NoError(t, err)
+			defer m.Close()
+
+			for i := range 10 {
+				writeTestH264(t, m, i, true)
+			}
+
+			byts, _, err := doRequest(m, ca.streamPath)
+			require.NoError(t, err)
+
+			require.Regexp(t, `#EXT-X-MEDIA-SEQUENCE:5\n`+
+				`(?s).*?`+
+				`[^\n]*?_seg5\.`+ca.segExt+`\n`, string(byts))
+
+			require.Equal(t, 4, strings.Count(string(byts), "#EXTINF:"))
+			require.NotContains(t, string(byts), "#EXT-X-ENDLIST")
+		})
+	}
+}
+
+func TestMuxerEndLastAccessUnit(t *testing.T) {
+	for _, ca := range testVariants {
+		t.Run(ca.name, func(t *testing.T) {
+			m := &Muxer{
+				Variant:            ca.variant,
+				SegmentCount:       3,
+				SegmentMinDuration: 1 * time.Second,
+				Tracks:             []*Track{testVideoTrack},
+			}
+
+			err := m.Start()
+			require.NoError(t, err)
+			defer m.Close()
+
+			for i := range 3 {
+				writeTestH264(t, m, i, true)
+			}
+
+			err = m.End()
+			require.NoError(t, err)
+
+			byts, _, err := doRequest(m, ca.streamPath)
+			require.NoError(t, err)
+
+			// the last segment contains a single access unit
+			require.Regexp(t, `#EXTINF:1.00000,\n`+
+				`[^\n]*?_seg2\.`+ca.segExt+`\n`+
+				`#EXT-X-ENDLIST\n$`, string(byts))
+		})
+	}
+
+	t.Run("packed audio", func(t *testing.T) {
+		m := &Muxer{
+			Variant:            MuxerVariantPackedAudio,
+			SegmentCount:       3,
+			SegmentMinDuration: 1 * time.Second,
+			Tracks:             []*Track{testAudioTrack},
+		}
+
+		err := m.Start()
+		require.NoError(t, err)
+		defer m.Close()
+
+		for i := range 50 {
+			err = m.WriteMPEG4Audio(testAudioTrack, testTime, int64(i)*1024, [][]byte{{1, 2, 3, 4}})
+			require.NoError(t, err)
+		}
+
+		err = m.End()
+		require.NoError(t, err)
+
+		byts, _, err := doRequest(m, "main_stream.m3u8")
+		require.NoError(t, err)
+
+		// segments last 50 * 1024 / 44100 = 1.16100s in total
+		require.Regexp(t, `#EXTINF:1.02168,\n`+
+			`[^\n]*?_seg0\.aac\n`+
+			`(#EXT-X-PROGRAM-DATE-TIME:.*?\n)?`+
+			`#EXTINF:0.13932,\n`+
+			`[^\n]*?_seg1\.aac\n`+
+			`#EXT-X-ENDLIST\n$`, string(byts))
+	})
+}
+
+func TestMuxerEndWhileWriting(t *testing.T) {
+	m := &Muxer{
+		Variant:      MuxerVariantMPEGTS,
+		SegmentCount: 3,
+		Tracks:       []*Track{testVideoTrack},
+	}
+
+	err := m.Start()
+	require.NoError(t, err)
+	defer m.Close()
+
+	done := make(chan error)
+
+	go func() {
+		for i := 0; ; i++ {
+			err2 := m.WriteH264(
+				testVideoTrack,
+				testTime.Add(time.Duration(i)*time.Second),
+				int64(i)*90000,
+				[][]byte{testSPS, testPPS, {5}})
+			if err2 != nil {
+				done <- err2
+				return
+			}
+		}
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+
+	err = m.End()
+	require.NoError(t, err)
+
+	require.Equal(t, ErrMuxerEnded, <-done)
+}
+
+func TestMuxerSink(t *testing.T) {
+	for _, ca := range testVariants {
+		t.Run(ca.name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			m := &Muxer{
+				Variant:      ca.variant,
+				SegmentCount: 3,
+				Sink:         &MuxerSinkDirectory{Path: dir},
+				Tracks:       []*Track{testVideoTrack},
+			}
+
+			err := m.Start()
+			require.NoError(t, err)
+			defer m.Close()
+
+			for i := range 7 {
+				writeTestH264(t, m, i, true)
+			}
+
+			// files are written by a dedicated routine
+			m.sinkWriter.flush()
+
+			entries, err := os.ReadDir(dir)
+			require.NoError(t, err)
+
+			var names []string
+			for _, entry := range entries {
+				names = append(names, regexp.MustCompile(`^[0-9a-f]+_`).ReplaceAllString(entry.Name(), ""))
+
+				var info os.FileInfo
+				info, err = entry.Info()
+				require.NoError(t, err)
+				require.Equal(t, os.FileMode(0o644), info.Mode().Perm())
+			}
+
+			expected := []string{
+				"index.m3u8",
+				ca.streamPath,
+			}
+			if ca.variant == MuxerVariantMPEGTS {
+				expected = append(expected, "main_seg3.ts", "main_seg4.ts", "main_seg5.ts")
+			} else {
+				expected = append(expected, "video1_init.mp4", "video1_seg3.mp4", "video1_seg4.mp4", "video1_seg5.mp4")
+			}
+			require.ElementsMatch(t, expected, names)
+
+			byts, err := os.ReadFile(filepath.Join(dir, ca.streamPath))
+			require.NoError(t, err)
+
+			byts2, _, err := doRequest(m, ca.streamPath)
+			require.NoError(t, err)
+			require.Equal(t, byts2, byts)
+			require.Regexp(t, `_seg5\.`+ca.segExt+`\n$`, string(byts))
+
+			byts, err = os.ReadFile(filepath.Join(dir, "index.m3u8"))
+			require.NoError(t, err)
+
+			byts2, _, err = doRequest(m, "index.m3u8")
+			require.NoError(t, err)
+			require.Equal(t, byts2, byts)
+		})
+	}
+}
+
+func TestMuxerVOD(t *testing.T) {
+	for _, ca := range testVariants {
+		t.Run(ca.name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			m := &Muxer{
+				Variant:      ca.variant,
+				SegmentCount: 3,
+				PlaylistType: MuxerPlaylistTypeVOD,
+				Directory:    t.TempDir(),
+				Sink:         &MuxerSinkDirectory{Path: dir},
+				Tracks:       []*Track{testVideoTrack},
+			}
+
+			err := m.Start()
+			require.NoError(t, err)
+			defer m.Close()
+
+			for i := range 8 {
+				writeTestH264(t, m, i, i != 7)
+			}
+
+			// playlists are written once the stream has ended
+			_, err = os.Stat(filepath.Join(dir, "index.m3u8"))
+			require.ErrorIs(t, err, os.ErrNotExist)
+
+			err = m.End()
+			require.NoError(t, err)
+
+			_, err = os.Stat(filepath.Join(dir, "index.m3u8"))
+			require.NoError(t, err)
+
+			byts, err := os.ReadFile(filepath.Join(dir, ca.streamPath))
+			require.NoError(t, err)
+
+			require.Regexp(t, `#EXT-X-MEDIA-SEQUENCE:0\n`+
+				`#EXT-X-PLAYLIST-TYPE:VOD\n`+
+				`(?s).*?`+
+				`[^\n]*?_seg0\.`+ca.segExt+`\n`+
+				`.*?`+
+				`[^\n]*?_seg6\.`+ca.segExt+`\n`+
+				`#EXT-X-ENDLIST\n$`, string(byts))
+
+			require.Equal(t, 7, strings.Count(string(byts), "#EXTINF:"))
+
+			for i := range 7 {
+				matches, err2 := filepath.Glob(filepath.Join(dir, "*_seg"+strconv.Itoa(i)+"."+ca.segExt))
+				require.NoError(t, err2)
+				require.Len(t, matches, 1)
+			}
+		})
+	}
+}
+
+type testBlockingSink struct {
+	unblock chan struct{}
+	err     error
+}
+
+func (s *testBlockingSink) WriteFile(_ string, r io.Reader) error {
+	<-s.unblock
+	_, err := io.Copy(io.Discard, r)
+	if err != nil {
+		return err
+	}
+	return s.err
+}
+
+func (s *testBlockingSink) RemoveFile(_ string) error {
+	<-s.unblock
+	return s.err
+}
+
+func TestMuxerSinkBlocking(t *testing.T) {
+	sink := &testBlockingSink{
+		unblock: make(chan struct{}),
+		err:     fmt.Errorf("sink error"),
+	}
+
+	encodeErrors := make(chan error, 100)
+
+	m := &Muxer{
+		Variant:      MuxerVariantMPEGTS,
+		SegmentCount: 3,
+		Sink:         sink,
+		Tracks:       []*Track{testVideoTrack},
+		OnEncodeError: func(err error) {
+			encodeErrors <- err
+		},
+	}
+
+	err := m.Start()
+	require.NoError(t, err)
+	defer m.Close()
+
+	for i := range 7 {
+		writeTestH264(t, m, i, true)
+	}
+
+	// the stream is served while the sink is blocked
+	byts, _, err := doRequest(m, "main_stream.m3u8")
+	require.NoError(t, err)
+	require.Regexp(t, `_seg5\.ts\n$`, string(byts))
+
+	close(sink.unblock)
+
+	// errors are reported without stopping the muxer
+	err = <-encodeErrors
+	require.Regexp(t, `^unable to write [0-9a-f]+_main_seg0\.ts into sink: sink error$`, err.Error())
+}
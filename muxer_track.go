@@ -1,6 +1,8 @@
 package gohlslib
 
 import (
+	"time"
+
 	"github.com/bluenviron/gohlslib/v2/pkg/codecs"
 	"github.com/bluenviron/mediacommon/v2/pkg/codecs/h264"
 	"github.com/bluenviron/mediacommon/v2/pkg/codecs/h265"
@@ -14,17 +16,24 @@ type muxerTrack struct {
 	stream    *muxerStream
 	isLeading bool
 
+	pendingParamsChange       bool
 	firstRandomAccessReceived bool
 	h264DTSExtractor          *h264.DTSExtractor
 	h265DTSExtractor          *h265.DTSExtractor
-	mpegtsTrack               *mpegts.Track        // mpegts only
-	fmp4NextSample            *fmp4AugmentedSample // fmp4 only
-	fmp4Samples               []*fmp4.Sample       // fmp4 only
-	fmp4StartDTS              int64                // fmp4 only
+	mpegtsTrack               *mpegts.Track          // mpegts only
+	lastDTS                   time.Duration          // mpegts video only
+	endDTS                    time.Duration          // mpegts and packed audio only
+	fmp4NextSample            *fmp4AugmentedSample   // fmp4 only
+	fmp4LastDuration          uint32                 // fmp4 only
+	fmp4Samples               []*fmp4.Sample         // fmp4 only
+	fmp4StartDTS              int64                  // fmp4 only
+	fmp4ID                    int                    // fmp4 only
+	fmp4FollowerSamples       []*fmp4AugmentedSample // fmp4 additional video tracks only
+	pendingCEA608             []byte                 // H264 and H265 only
 }
 
 func (t *muxerTrack) initialize() {
-	if t.variant == MuxerVariantMPEGTS {
+	if t.variant == MuxerVariantMPEGTS && !isSubtitles(t.Codec) {
 		t.mpegtsTrack = &mpegts.Track{
 			Codec: codecs.ToMPEGTS(t.Codec),
 		}
@@ -8,4 +8,9 @@ const (
 	MuxerVariantMPEGTS MuxerVariant = iota + 1
 	MuxerVariantFMP4
 	MuxerVariantLowLatency
+
+	// MuxerVariantPackedAudio generates packed audio segments
+	// (ADTS for MPEG-4 Audio, raw frames for MPEG-1 Audio),
+	// and supports a single MPEG-4 Audio or MPEG-1 Audio track.
+	MuxerVariantPackedAudio
 )
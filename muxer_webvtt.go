@@ -0,0 +1,109 @@
+package gohlslib
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MuxerWebVTTCue is a WebVTT cue.
+type MuxerWebVTTCue struct {
+	// Identifier (optional).
+	ID string
+
+	// Presentation timestamp of the start of the cue, expressed in track clock rate.
+	PTS int64
+
+	// Duration of the cue, expressed in track clock rate.
+	Duration int64
+
+	// Settings (optional), for instance "line:0 position:20%".
+	Settings string
+
+	// Text.
+	Text string
+}
+
+func (c *MuxerWebVTTCue) validate() error {
+	if c.Duration <= 0 {
+		return fmt.Errorf("invalid cue duration")
+	}
+
+	if c.Text == "" {
+		return fmt.Errorf("cue text is empty")
+	}
+
+	for _, s := range []string{c.ID, c.Settings, c.Text} {
+		if strings.Contains(s, "-->") {
+			return fmt.Errorf("cue contains '-->'")
+		}
+	}
+
+	if strings.ContainsAny(c.ID+c.Settings, "\r\n") {
+		return fmt.Errorf("cue identifier or settings contain a line break")
+	}
+
+	if strings.Contains(strings.ReplaceAll(c.Text, "\r\n", "\n"), "\n\n") {
+		return fmt.Errorf("cue text contains an empty line")
+	}
+
+	return nil
+}
+
+type muxerWebVTTCue struct {
+	MuxerWebVTTCue
+	start time.Duration
+	end   time.Duration
+}
+
+func (c *muxerWebVTTCue) marshal() string {
+	ret := ""
+
+	if c.ID != "" {
+		ret += c.ID + "\n"
+	}
+
+	ret += webvttMarshalTimestamp(c.start) + " --> " + webvttMarshalTimestamp(c.end)
+
+	if c.Settings != "" {
+		ret += " " + c.Settings
+	}
+
+	ret += "\n" + c.Text + "\n\n"
+
+	return ret
+}
+
+func webvttMarshalTimestamp(d time.Duration) string {
+	if d < 0 {
+		d = 0
+	}
+
+	ms := int64(d / time.Millisecond)
+
+	return leadingZeros(ms/3600000, 2) + ":" +
+		leadingZeros((ms/60000)%60, 2) + ":" +
+		leadingZeros((ms/1000)%60, 2) + "." +
+		leadingZeros(ms%1000, 3)
+}
+
+func leadingZeros(v int64, size int) string {
+	out := strconv.FormatInt(v, 10)
+	if len(out) >= size {
+		return out
+	}
+
+	return strings.Repeat("0", size-len(out)) + out
+}
+
+// webvttMarshalHeader returns the header of a segment.
+// X-TIMESTAMP-MAP maps the timeline of cues to the one of media segments,
+// whose timestamps are expressed with a 90kHz clock and are wrapped around 33 bits.
+func webvttMarshalHeader(mediaStart time.Duration, localStart time.Duration) string {
+	mpegts := durationToTimestamp(mediaStart, 90000) & 0x1FFFFFFFF
+
+	return "WEBVTT\n" +
+		"X-TIMESTAMP-MAP=MPEGTS:" + strconv.FormatInt(mpegts, 10) +
+		",LOCAL:" + webvttMarshalTimestamp(localStart) + "\n\n"
+}
@@ -0,0 +1,120 @@
+package gohlslib
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// packed audio segments start with an ID3 tag that contains the timestamp
+// of the first sample, in a PRIV frame with this owner.
+const packedAudioTimestampOwner = "com.apple.streaming.transportStreamTimestamp"
+
+func id3EncodeSyncSafe(v uint32) []byte {
+	return []byte{
+		byte(v>>21) & 0x7F,
+		byte(v>>14) & 0x7F,
+		byte(v>>7) & 0x7F,
+		byte(v) & 0x7F,
+	}
+}
+
+func id3DecodeSyncSafe(buf []byte) uint32 {
+	return uint32(buf[0]&0x7F)<<21 |
+		uint32(buf[1]&0x7F)<<14 |
+		uint32(buf[2]&0x7F)<<7 |
+		uint32(buf[3]&0x7F)
+}
+
+// packedAudioMarshalHeader generates the ID3 tag of a packed audio segment.
+// timestamp is expressed in 90kHz units.
+func packedAudioMarshalHeader(timestamp int64) []byte {
+	var ts [8]byte
+	binary.BigEndian.PutUint64(ts[:], uint64(timestamp&mpegtsTimestampMask))
+
+	frameBody := append(append([]byte(packedAudioTimestampOwner), 0), ts[:]...)
+
+	buf := make([]byte, 0, 20+len(frameBody))
+	buf = append(buf, 'I', 'D', '3', 4, 0, 0)
+	buf = append(buf, id3EncodeSyncSafe(uint32(10+len(frameBody)))...)
+	buf = append(buf, 'P', 'R', 'I', 'V')
+	buf = append(buf, id3EncodeSyncSafe(uint32(len(frameBody)))...)
+	buf = append(buf, 0, 0)
+	buf = append(buf, frameBody...)
+
+	return buf
+}
+
+// packedAudioUnmarshalHeader parses the ID3 tags at the beginning of a packed audio segment.
+// It returns the timestamp of the first sample, expressed in 90kHz units,
+// and the size of the tags.
+func packedAudioUnmarshalHeader(buf []byte) (int64, int, error) {
+	pos := 0
+	var timestamp *int64
+
+	for len(buf[pos:]) >= 10 && bytes.Equal(buf[pos:pos+3], []byte("ID3")) {
+		version := buf[pos+3]
+		if version != 3 && version != 4 {
+			return 0, 0, fmt.Errorf("unsupported ID3 version: %d", version)
+		}
+
+		flags := buf[pos+5]
+		tagSize := int(id3DecodeSyncSafe(buf[pos+6 : pos+10]))
+		tagEnd := pos + 10 + tagSize
+		if (flags & 0x10) != 0 { // footer
+			tagEnd += 10
+		}
+		if tagEnd > len(buf) {
+			return 0, 0, fmt.Errorf("ID3 tag is too big")
+		}
+
+		framesPos := pos + 10
+		framesEnd := pos + 10 + tagSize
+
+		if (flags & 0x40) != 0 { // extended header
+			if framesEnd-framesPos < 4 {
+				return 0, 0, fmt.Errorf("invalid ID3 extended header")
+			}
+			if version == 4 {
+				framesPos += int(id3DecodeSyncSafe(buf[framesPos : framesPos+4]))
+			} else {
+				framesPos += 4 + int(binary.BigEndian.Uint32(buf[framesPos:framesPos+4]))
+			}
+		}
+
+		for framesEnd-framesPos >= 10 && buf[framesPos] != 0 {
+			id := string(buf[framesPos : framesPos+4])
+
+			var frameSize int
+			if version == 4 {
+				frameSize = int(id3DecodeSyncSafe(buf[framesPos+4 : framesPos+8]))
+			} else {
+				frameSize = int(binary.BigEndian.Uint32(buf[framesPos+4 : framesPos+8]))
+			}
+
+			body := buf[framesPos+10:]
+			if frameSize > len(body) || framesPos+10+frameSize > framesEnd {
+				return 0, 0, fmt.Errorf("ID3 frame is too big")
+			}
+			body = body[:frameSize]
+
+			if id == "PRIV" {
+				owner, data, ok := bytes.Cut(body, []byte{0})
+				if ok && string(owner) == packedAudioTimestampOwner && len(data) == 8 {
+					v := int64(binary.BigEndian.Uint64(data) & mpegtsTimestampMask)
+					timestamp = &v
+				}
+			}
+
+			framesPos += 10 + frameSize
+		}
+
+		pos = tagEnd
+	}
+
+	if timestamp == nil {
+		return 0, 0, fmt.Errorf("timestamp of packed audio segment not found")
+	}
+
+	return *timestamp, pos, nil
+}
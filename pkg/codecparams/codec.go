@@ -0,0 +1,135 @@
+package codecparams
+
+// Codec is a codec descriptor, obtained by parsing codec parameters.
+type Codec interface {
+	// IsVideo returns whether the codec is a video one.
+	IsVideo() bool
+
+	isCodec()
+}
+
+// AVC is a H264 codec descriptor (avc1, avc3).
+type AVC struct {
+	Profile              uint8
+	ProfileCompatibility uint8
+	Level                uint8
+}
+
+// IsVideo returns whether the codec is a video one.
+func (*AVC) IsVideo() bool {
+	return true
+}
+
+func (*AVC) isCodec() {
+}
+
+// HEVC is a H265 codec descriptor (hvc1, hev1).
+type HEVC struct {
+	ProfileSpace             uint8
+	Profile                  uint8
+	ProfileCompatibility     [32]bool
+	HighTier                 bool
+	Level                    uint8
+	ConstraintIndicatorFlags []uint8
+}
+
+// IsVideo returns whether the codec is a video one.
+func (*HEVC) IsVideo() bool {
+	return true
+}
+
+func (*HEVC) isCodec() {
+}
+
+// AV1 is a AV1 codec descriptor (av01).
+type AV1 struct {
+	Profile  uint8
+	Level    uint8
+	HighTier bool
+	BitDepth uint8
+}
+
+// IsVideo returns whether the codec is a video one.
+func (*AV1) IsVideo() bool {
+	return true
+}
+
+func (*AV1) isCodec() {
+}
+
+// VP9 is a VP9 codec descriptor (vp09).
+type VP9 struct {
+	Profile  uint8
+	Level    uint8
+	BitDepth uint8
+}
+
+// IsVideo returns whether the codec is a video one.
+func (*VP9) IsVideo() bool {
+	return true
+}
+
+func (*VP9) isCodec() {
+}
+
+// MPEG4Audio is a MPEG-4 Audio codec descriptor (mp4a).
+// It also describes MPEG-1/2 audio, that uses a different object type indication.
+type MPEG4Audio struct {
+	// object type indication, in hexadecimal form in the codec parameter.
+	ObjectTypeIndication uint8
+
+	// audio object type. It is filled only when ObjectTypeIndication is 0x40.
+	AudioObjectType uint8
+}
+
+// IsVideo returns whether the codec is a video one.
+func (*MPEG4Audio) IsVideo() bool {
+	return false
+}
+
+func (*MPEG4Audio) isCodec() {
+}
+
+// Opus is a Opus codec descriptor (opus).
+type Opus struct{}
+
+// IsVideo returns whether the codec is a video one.
+func (*Opus) IsVideo() bool {
+	return false
+}
+
+func (*Opus) isCodec() {
+}
+
+// AC3 is a AC-3 codec descriptor (ac-3).
+type AC3 struct{}
+
+// IsVideo returns whether the codec is a video one.
+func (*AC3) IsVideo() bool {
+	return false
+}
+
+func (*AC3) isCodec() {
+}
+
+// EAC3 is a E-AC-3 codec descriptor (ec-3).
+type EAC3 struct{}
+
+// IsVideo returns whether the codec is a video one.
+func (*EAC3) IsVideo() bool {
+	return false
+}
+
+func (*EAC3) isCodec() {
+}
+
+// FLAC is a FLAC codec descriptor (flac).
+type FLAC struct{}
+
+// IsVideo returns whether the codec is a video one.
+func (*FLAC) IsVideo() bool {
+	return false
+}
+
+func (*FLAC) isCodec() {
+}
@@ -182,6 +182,13 @@ func Marshal(codec codecs.Codec) string {
 	case *codecs.MPEG4Audio:
 		// https://developer.mozilla.org/en-US/docs/Web/Media/Formats/codecs_parameter
 		return "mp4a.40." + strconv.FormatInt(int64(codec.Config.Type), 10)
+
+	case *codecs.MPEG1Audio:
+		// HLS Authoring Specification for Apple Devices
+		return "mp4a.40.34"
+
+	case *codecs.AC3:
+		return "ac-3"
 	}
 
 	return ""
@@ -82,6 +82,22 @@ func TestMarshal(t *testing.T) {
 			},
 			"mp4a.40.2",
 		},
+		{
+			"mpeg-1 audio",
+			&codecs.MPEG1Audio{
+				SampleRate:   48000,
+				ChannelCount: 2,
+			},
+			"mp4a.40.34",
+		},
+		{
+			"ac-3",
+			&codecs.AC3{
+				SampleRate:   48000,
+				ChannelCount: 2,
+			},
+			"ac-3",
+		},
 	} {
 		t.Run(ca.name, func(t *testing.T) {
 			enc := Marshal(ca.codec)
@@ -0,0 +1,265 @@
+package codecparams
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+func parseUint8(v string, base int) (uint8, error) {
+	tmp, err := strconv.ParseUint(v, base, 8)
+	if err != nil {
+		return 0, err
+	}
+	return uint8(tmp), nil
+}
+
+func unmarshalAVC(parts []string) (*AVC, error) {
+	// legacy form: avc1.PROFILE.LEVEL
+	if len(parts) == 3 {
+		profile, err := parseUint8(parts[1], 10)
+		if err != nil {
+			return nil, fmt.Errorf("invalid profile: %w", err)
+		}
+
+		level, err := parseUint8(parts[2], 10)
+		if err != nil {
+			return nil, fmt.Errorf("invalid level: %w", err)
+		}
+
+		return &AVC{
+			Profile: profile,
+			Level:   level,
+		}, nil
+	}
+
+	if len(parts) != 2 || len(parts[1]) != 6 {
+		return nil, fmt.Errorf("invalid AVC parameters")
+	}
+
+	var vals [3]uint8
+	for i := range vals {
+		var err error
+		vals[i], err = parseUint8(parts[1][i*2:i*2+2], 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid AVC parameters: %w", err)
+		}
+	}
+
+	return &AVC{
+		Profile:              vals[0],
+		ProfileCompatibility: vals[1],
+		Level:                vals[2],
+	}, nil
+}
+
+func unmarshalHEVC(parts []string) (*HEVC, error) {
+	if len(parts) < 4 {
+		return nil, fmt.Errorf("invalid HEVC parameters")
+	}
+
+	c := &HEVC{}
+
+	profile := parts[1]
+	if profile != "" && profile[0] >= 'A' && profile[0] <= 'C' {
+		c.ProfileSpace = profile[0] - 'A' + 1
+		profile = profile[1:]
+	}
+
+	var err error
+	c.Profile, err = parseUint8(profile, 10)
+	if err != nil {
+		return nil, fmt.Errorf("invalid profile: %w", err)
+	}
+
+	compat, err := strconv.ParseUint(parts[2], 16, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid profile compatibility flags: %w", err)
+	}
+	for i := range c.ProfileCompatibility {
+		c.ProfileCompatibility[i] = (compat & (1 << i)) != 0
+	}
+
+	tierLevel := parts[3]
+	if tierLevel == "" {
+		return nil, fmt.Errorf("invalid tier")
+	}
+	switch tierLevel[0] {
+	case 'L':
+	case 'H':
+		c.HighTier = true
+	default:
+		return nil, fmt.Errorf("invalid tier")
+	}
+
+	c.Level, err = parseUint8(tierLevel[1:], 10)
+	if err != nil {
+		return nil, fmt.Errorf("invalid level: %w", err)
+	}
+
+	if len(parts) > 10 {
+		return nil, fmt.Errorf("too many constraint indicator flags")
+	}
+
+	for _, p := range parts[4:] {
+		var flags uint8
+		flags, err = parseUint8(p, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid constraint indicator flags: %w", err)
+		}
+		c.ConstraintIndicatorFlags = append(c.ConstraintIndicatorFlags, flags)
+	}
+
+	return c, nil
+}
+
+func unmarshalAV1(parts []string) (*AV1, error) {
+	if len(parts) < 4 {
+		return nil, fmt.Errorf("invalid AV1 parameters")
+	}
+
+	c := &AV1{}
+
+	var err error
+	c.Profile, err = parseUint8(parts[1], 10)
+	if err != nil {
+		return nil, fmt.Errorf("invalid profile: %w", err)
+	}
+
+	levelTier := parts[2]
+	if len(levelTier) != 3 {
+		return nil, fmt.Errorf("invalid level")
+	}
+
+	c.Level, err = parseUint8(levelTier[:2], 10)
+	if err != nil {
+		return nil, fmt.Errorf("invalid level: %w", err)
+	}
+
+	switch levelTier[2] {
+	case 'M':
+	case 'H':
+		c.HighTier = true
+	default:
+		return nil, fmt.Errorf("invalid tier")
+	}
+
+	c.BitDepth, err = parseUint8(parts[3], 10)
+	if err != nil {
+		return nil, fmt.Errorf("invalid bit depth: %w", err)
+	}
+
+	return c, nil
+}
+
+func unmarshalVP9(parts []string) (*VP9, error) {
+	if len(parts) < 4 {
+		return nil, fmt.Errorf("invalid VP9 parameters")
+	}
+
+	c := &VP9{}
+
+	var err error
+	c.Profile, err = parseUint8(parts[1], 10)
+	if err != nil {
+		return nil, fmt.Errorf("invalid profile: %w", err)
+	}
+
+	c.Level, err = parseUint8(parts[2], 10)
+	if err != nil {
+		return nil, fmt.Errorf("invalid level: %w", err)
+	}
+
+	c.BitDepth, err = parseUint8(parts[3], 10)
+	if err != nil {
+		return nil, fmt.Errorf("invalid bit depth: %w", err)
+	}
+
+	return c, nil
+}
+
+func unmarshalMPEG4Audio(parts []string) (*MPEG4Audio, error) {
+	if len(parts) < 2 || len(parts) > 3 {
+		return nil, fmt.Errorf("invalid MPEG-4 Audio parameters")
+	}
+
+	c := &MPEG4Audio{}
+
+	var err error
+	c.ObjectTypeIndication, err = parseUint8(parts[1], 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid object type indication: %w", err)
+	}
+
+	if len(parts) == 3 {
+		c.AudioObjectType, err = parseUint8(parts[2], 10)
+		if err != nil {
+			return nil, fmt.Errorf("invalid audio object type: %w", err)
+		}
+	}
+
+	return c, nil
+}
+
+// Unmarshal decodes codec parameters, in the format defined by RFC 6381.
+func Unmarshal(v string) (Codec, error) {
+	parts := strings.Split(strings.TrimSpace(v), ".")
+
+	// return a nil interface on error, instead of a nil pointer.
+	switch parts[0] {
+	case "avc1", "avc3":
+		c, err := unmarshalAVC(parts)
+		if err != nil {
+			return nil, err
+		}
+		return c, nil
+
+	case "hvc1", "hev1":
+		c, err := unmarshalHEVC(parts)
+		if err != nil {
+			return nil, err
+		}
+		return c, nil
+
+	case "av01":
+		c, err := unmarshalAV1(parts)
+		if err != nil {
+			return nil, err
+		}
+		return c, nil
+
+	case "vp09":
+		c, err := unmarshalVP9(parts)
+		if err != nil {
+			return nil, err
+		}
+		return c, nil
+
+	case "mp4a":
+		c, err := unmarshalMPEG4Audio(parts)
+		if err != nil {
+			return nil, err
+		}
+		return c, nil
+	}
+
+	if len(parts) != 1 {
+		return nil, fmt.Errorf("unsupported codec: '%s'", v)
+	}
+
+	switch parts[0] {
+	case "opus", "Opus":
+		return &Opus{}, nil
+
+	case "ac-3":
+		return &AC3{}, nil
+
+	case "ec-3":
+		return &EAC3{}, nil
+
+	case "flac", "fLaC":
+		return &FLAC{}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported codec: '%s'", v)
+}
@@ -0,0 +1,192 @@
+package codecparams
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnmarshal(t *testing.T) {
+	for _, ca := range []struct {
+		name  string
+		enc   string
+		codec Codec
+	}{
+		{
+			"av1",
+			"av01.0.08M.08.0.110.01.01.01.0",
+			&AV1{
+				Profile:  0,
+				Level:    8,
+				BitDepth: 8,
+			},
+		},
+		{
+			"av1 high tier",
+			"av01.0.12H.10",
+			&AV1{
+				Profile:  0,
+				Level:    12,
+				HighTier: true,
+				BitDepth: 10,
+			},
+		},
+		{
+			"vp9",
+			"vp09.01.10.08",
+			&VP9{
+				Profile:  1,
+				Level:    10,
+				BitDepth: 8,
+			},
+		},
+		{
+			"h265",
+			"hvc1.1.6.L120.90",
+			&HEVC{
+				Profile: 1,
+				ProfileCompatibility: [32]bool{
+					false, true, true, false, false, false, false, false,
+					false, false, false, false, false, false, false, false,
+					false, false, false, false, false, false, false, false,
+					false, false, false, false, false, false, false, false,
+				},
+				Level:                    120,
+				ConstraintIndicatorFlags: []uint8{0x90},
+			},
+		},
+		{
+			"h265 high tier",
+			"hev1.A2.4.H153.B0",
+			&HEVC{
+				ProfileSpace: 1,
+				Profile:      2,
+				ProfileCompatibility: [32]bool{
+					false, false, true, false, false, false, false, false,
+					false, false, false, false, false, false, false, false,
+					false, false, false, false, false, false, false, false,
+					false, false, false, false, false, false, false, false,
+				},
+				HighTier:                 true,
+				Level:                    153,
+				ConstraintIndicatorFlags: []uint8{0xb0},
+			},
+		},
+		{
+			"h264",
+			"avc1.42c028",
+			&AVC{
+				Profile:              0x42,
+				ProfileCompatibility: 0xc0,
+				Level:                0x28,
+			},
+		},
+		{
+			"h264 avc3",
+			"avc3.640028",
+			&AVC{
+				Profile: 0x64,
+				Level:   0x28,
+			},
+		},
+		{
+			"h264 legacy",
+			"avc1.66.30",
+			&AVC{
+				Profile: 66,
+				Level:   30,
+			},
+		},
+		{
+			"mpeg-4 audio",
+			"mp4a.40.2",
+			&MPEG4Audio{
+				ObjectTypeIndication: 0x40,
+				AudioObjectType:      2,
+			},
+		},
+		{
+			"mpeg-1 audio",
+			"mp4a.6B",
+			&MPEG4Audio{
+				ObjectTypeIndication: 0x6b,
+			},
+		},
+		{
+			"opus",
+			"opus",
+			&Opus{},
+		},
+		{
+			"ac-3",
+			"ac-3",
+			&AC3{},
+		},
+		{
+			"e-ac-3",
+			"ec-3",
+			&EAC3{},
+		},
+		{
+			"flac",
+			"fLaC",
+			&FLAC{},
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			codec, err := Unmarshal(ca.enc)
+			require.NoError(t, err)
+			require.Equal(t, ca.codec, codec)
+		})
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	for _, ca := range []struct {
+		name string
+		enc  string
+		err  string
+	}{
+		{
+			"unsupported",
+			"mjpg",
+			"unsupported codec: 'mjpg'",
+		},
+		{
+			"h264 invalid",
+			"avc1.42c0",
+			"invalid AVC parameters",
+		},
+		{
+			"h265 invalid tier",
+			"hvc1.1.6.X120",
+			"invalid tier",
+		},
+		{
+			"av1 invalid level",
+			"av01.0.8M.08",
+			"invalid level",
+		},
+		{
+			"vp9 missing bit depth",
+			"vp09.00.10",
+			"invalid VP9 parameters",
+		},
+		{
+			"mpeg-4 audio invalid",
+			"mp4a.40.x",
+			"invalid audio object type: strconv.ParseUint: parsing \"x\": invalid syntax",
+		},
+		{
+			"opus with parameters",
+			"opus.1",
+			"unsupported codec: 'opus.1'",
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			codec, err := Unmarshal(ca.enc)
+			require.EqualError(t, err, ca.err)
+			require.True(t, codec == nil) // nil interface, not a nil pointer
+		})
+	}
+}
@@ -42,6 +42,18 @@ func FromFMP4(in codecs.Codec) Codec {
 		return &MPEG4Audio{
 			Config: in.Config,
 		}
+
+	case *codecs.MPEG1Audio:
+		return &MPEG1Audio{
+			SampleRate:   in.SampleRate,
+			ChannelCount: in.ChannelCount,
+		}
+
+	case *codecs.AC3:
+		return &AC3{
+			SampleRate:   in.SampleRate,
+			ChannelCount: in.ChannelCount,
+		}
 	}
 
 	return nil
@@ -7,13 +7,30 @@ import (
 // FromMPEGTS imports a codec from MPEG-TS.
 func FromMPEGTS(in codecs.Codec) Codec {
 	switch in := in.(type) {
+	case *codecs.H265:
+		return &H265{}
+
 	case *codecs.H264:
 		return &H264{}
 
+	case *codecs.Opus:
+		return &Opus{
+			ChannelCount: in.ChannelCount,
+		}
+
 	case *codecs.MPEG4Audio:
 		return &MPEG4Audio{
 			Config: in.Config,
 		}
+
+	case *codecs.MPEG1Audio:
+		return &MPEG1Audio{}
+
+	case *codecs.AC3:
+		return &AC3{
+			SampleRate:   in.SampleRate,
+			ChannelCount: in.ChannelCount,
+		}
 	}
 
 	return nil
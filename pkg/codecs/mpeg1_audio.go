@@ -0,0 +1,15 @@
+package codecs
+
+// MPEG1Audio is a MPEG-1/2 Audio codec (including MP3).
+type MPEG1Audio struct {
+	SampleRate   int
+	ChannelCount int
+}
+
+// IsVideo returns whether the codec is a video one.
+func (*MPEG1Audio) IsVideo() bool {
+	return false
+}
+
+func (*MPEG1Audio) isCodec() {
+}
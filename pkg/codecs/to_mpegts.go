@@ -7,13 +7,30 @@ import (
 // ToMPEGTS converts a codec in its MPEG-TS equivalent.
 func ToMPEGTS(in Codec) codecs.Codec {
 	switch in := in.(type) {
+	case *H265:
+		return &codecs.H265{}
+
 	case *H264:
 		return &codecs.H264{}
 
+	case *Opus:
+		return &codecs.Opus{
+			ChannelCount: in.ChannelCount,
+		}
+
 	case *MPEG4Audio:
 		return &codecs.MPEG4Audio{
 			Config: in.Config,
 		}
+
+	case *MPEG1Audio:
+		return &codecs.MPEG1Audio{}
+
+	case *AC3:
+		return &codecs.AC3{
+			SampleRate:   in.SampleRate,
+			ChannelCount: in.ChannelCount,
+		}
 	}
 
 	return nil
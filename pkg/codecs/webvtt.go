@@ -0,0 +1,12 @@
+package codecs
+
+// WebVTT is a WebVTT subtitle codec.
+type WebVTT struct{}
+
+// IsVideo returns whether the codec is a video one.
+func (*WebVTT) IsVideo() bool {
+	return false
+}
+
+func (*WebVTT) isCodec() {
+}
@@ -69,7 +69,12 @@ type Media struct {
 	// EXT-X-PLAYLIST-TYPE
 	PlaylistType *MediaPlaylistType
 
+	// EXT-X-I-FRAMES-ONLY
+	IFramesOnly bool
+
 	// EXT-X-MAP
+	// In case of multiple tags, this is the first one.
+	// Following ones are stored into MediaSegment.Map.
 	Map *MediaMap
 
 	// EXT-X-START
@@ -106,6 +111,7 @@ func (m *Media) Unmarshal(buf []byte) error {
 	}
 
 	var curKey *MediaKey
+	var curMap *MediaMap
 
 	curSegment := &MediaSegment{}
 
@@ -134,6 +140,9 @@ func (m *Media) Unmarshal(buf []byte) error {
 		case strings.HasPrefix(line, "#EXT-X-INDEPENDENT-SEGMENTS"):
 			m.IndependentSegments = true
 
+		case line == "#EXT-X-I-FRAMES-ONLY":
+			m.IFramesOnly = true
+
 		case strings.HasPrefix(line, "#EXT-X-ALLOW-CACHE:"):
 			line = line[len("#EXT-X-ALLOW-CACHE:"):]
 
@@ -207,12 +216,16 @@ func (m *Media) Unmarshal(buf []byte) error {
 		case strings.HasPrefix(line, "#EXT-X-MAP:"):
 			line = line[len("#EXT-X-MAP:"):]
 
-			m.Map = &MediaMap{}
-			err = m.Map.unmarshal(line)
+			curMap = &MediaMap{}
+			err = curMap.unmarshal(line)
 			if err != nil {
 				return err
 			}
 
+			if m.Map == nil {
+				m.Map = curMap
+			}
+
 		case strings.HasPrefix(line, "#EXT-X-KEY:"):
 			line = line[len("#EXT-X-KEY:"):]
 
@@ -257,6 +270,34 @@ func (m *Media) Unmarshal(buf []byte) error {
 
 			curSegment.DateTime = &tmp
 
+		case strings.HasPrefix(line, "#EXT-X-DATERANGE:"):
+			line = line[len("#EXT-X-DATERANGE:"):]
+
+			dateRange := &MediaDateRange{}
+			err = dateRange.unmarshal(line)
+			if err != nil {
+				return err
+			}
+
+			curSegment.DateRanges = append(curSegment.DateRanges, dateRange)
+
+		case line == "#EXT-X-CUE-OUT" || strings.HasPrefix(line, "#EXT-X-CUE-OUT:"):
+			line = strings.TrimPrefix(line[len("#EXT-X-CUE-OUT"):], ":")
+			line = strings.TrimPrefix(line, "DURATION=")
+
+			var d primitives.Duration
+			if line != "" {
+				err = d.Unmarshal(line)
+				if err != nil {
+					return err
+				}
+			}
+
+			curSegment.CueOut = ptrOf(time.Duration(d))
+
+		case line == "#EXT-X-CUE-IN":
+			curSegment.CueIn = true
+
 		case strings.HasPrefix(line, "#EXT-X-BITRATE:"):
 			line = line[len("#EXT-X-BITRATE:"):]
 
@@ -289,6 +330,10 @@ func (m *Media) Unmarshal(buf []byte) error {
 
 			curSegment.Key = curKey
 
+			if curMap != m.Map {
+				curSegment.Map = curMap
+			}
+
 		case strings.HasPrefix(line, "#EXT-X-BYTERANGE:"):
 			line = line[len("#EXT-X-BYTERANGE:"):]
 
@@ -400,6 +445,10 @@ func (m Media) Marshal() ([]byte, error) {
 		ret.WriteString("#EXT-X-PLAYLIST-TYPE:" + string(*m.PlaylistType) + "\n")
 	}
 
+	if m.IFramesOnly {
+		ret.WriteString("#EXT-X-I-FRAMES-ONLY\n")
+	}
+
 	if m.Map != nil {
 		ret.WriteString(m.Map.marshal())
 	}
@@ -413,12 +462,18 @@ func (m Media) Marshal() ([]byte, error) {
 	}
 
 	var prevKey *MediaKey
+	prevMap := m.Map
 	for _, seg := range m.Segments {
 		if seg.Key != nil && (prevKey == nil || !seg.Key.Equal(prevKey)) {
 			ret.WriteString(seg.Key.marshal())
 			prevKey = seg.Key
 		}
 
+		if seg.Map != nil && !seg.Map.Equal(prevMap) {
+			ret.WriteString(seg.Map.marshal())
+			prevMap = seg.Map
+		}
+
 		ret.WriteString(seg.marshal())
 	}
 
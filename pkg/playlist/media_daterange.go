@@ -0,0 +1,190 @@
+package playlist
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bluenviron/gohlslib/v2/pkg/playlist/primitives"
+)
+
+func unmarshalHexSequence(v string) ([]byte, error) {
+	if !strings.HasPrefix(v, "0x") && !strings.HasPrefix(v, "0X") {
+		return nil, fmt.Errorf("invalid hexadecimal sequence: %s", v)
+	}
+	return hex.DecodeString(v[2:])
+}
+
+func marshalHexSequence(v []byte) string {
+	return "0x" + strings.ToUpper(hex.EncodeToString(v))
+}
+
+// MediaDateRange is a EXT-X-DATERANGE tag.
+type MediaDateRange struct {
+	// ID
+	// required
+	ID string
+
+	// CLASS
+	Class string
+
+	// START-DATE
+	// required
+	StartDate time.Time
+
+	// END-DATE
+	EndDate *time.Time
+
+	// DURATION
+	Duration *time.Duration
+
+	// PLANNED-DURATION
+	PlannedDuration *time.Duration
+
+	// SCTE35-CMD
+	SCTE35Cmd []byte
+
+	// SCTE35-OUT
+	SCTE35Out []byte
+
+	// SCTE35-IN
+	SCTE35In []byte
+
+	// END-ON-NEXT
+	EndOnNext bool
+}
+
+func (t *MediaDateRange) unmarshal(v string) error {
+	var attrs primitives.Attributes
+	err := attrs.Unmarshal(v)
+	if err != nil {
+		return err
+	}
+
+	for key, val := range attrs {
+		switch key {
+		case "ID":
+			t.ID = val
+
+		case "CLASS":
+			t.Class = val
+
+		case "START-DATE":
+			t.StartDate, err = parseTime(val)
+			if err != nil {
+				return err
+			}
+
+		case "END-DATE":
+			var tmp time.Time
+			tmp, err = parseTime(val)
+			if err != nil {
+				return err
+			}
+			t.EndDate = &tmp
+
+		case "DURATION", "PLANNED-DURATION":
+			var d primitives.Duration
+			err = d.Unmarshal(val)
+			if err != nil {
+				return err
+			}
+			tmp := time.Duration(d)
+
+			if key == "DURATION" {
+				t.Duration = &tmp
+			} else {
+				t.PlannedDuration = &tmp
+			}
+
+		case "SCTE35-CMD":
+			t.SCTE35Cmd, err = unmarshalHexSequence(val)
+			if err != nil {
+				return err
+			}
+
+		case "SCTE35-OUT":
+			t.SCTE35Out, err = unmarshalHexSequence(val)
+			if err != nil {
+				return err
+			}
+
+		case "SCTE35-IN":
+			t.SCTE35In, err = unmarshalHexSequence(val)
+			if err != nil {
+				return err
+			}
+
+		case "END-ON-NEXT":
+			t.EndOnNext = (val == "YES")
+		}
+	}
+
+	if t.ID == "" {
+		return fmt.Errorf("ID missing")
+	}
+
+	if t.StartDate.IsZero() {
+		return fmt.Errorf("START-DATE missing")
+	}
+
+	// An EXT-X-DATERANGE tag with an END-ON-NEXT=YES attribute MUST have a
+	// CLASS attribute. Other EXT-X-DATERANGE tags with the same CLASS
+	// attribute MUST NOT specify Date Ranges that overlap.
+	// An EXT-X-DATERANGE tag with an END-ON-NEXT=YES attribute MUST NOT
+	// contain DURATION or END-DATE attributes.
+	if t.EndOnNext {
+		if t.Class == "" {
+			return fmt.Errorf("CLASS is required when END-ON-NEXT is present")
+		}
+		if t.Duration != nil || t.EndDate != nil {
+			return fmt.Errorf("DURATION and END-DATE are forbidden when END-ON-NEXT is present")
+		}
+	}
+
+	return nil
+}
+
+func (t MediaDateRange) marshal() string {
+	ret := "#EXT-X-DATERANGE:ID=\"" + t.ID + "\""
+
+	if t.Class != "" {
+		ret += ",CLASS=\"" + t.Class + "\""
+	}
+
+	ret += ",START-DATE=\"" + t.StartDate.Format(timeRFC3339Millis) + "\""
+
+	if t.EndDate != nil {
+		ret += ",END-DATE=\"" + t.EndDate.Format(timeRFC3339Millis) + "\""
+	}
+
+	if t.Duration != nil {
+		ret += ",DURATION=" + strconv.FormatFloat(t.Duration.Seconds(), 'f', 3, 64)
+	}
+
+	if t.PlannedDuration != nil {
+		ret += ",PLANNED-DURATION=" + strconv.FormatFloat(t.PlannedDuration.Seconds(), 'f', 3, 64)
+	}
+
+	if t.SCTE35Cmd != nil {
+		ret += ",SCTE35-CMD=" + marshalHexSequence(t.SCTE35Cmd)
+	}
+
+	if t.SCTE35Out != nil {
+		ret += ",SCTE35-OUT=" + marshalHexSequence(t.SCTE35Out)
+	}
+
+	if t.SCTE35In != nil {
+		ret += ",SCTE35-IN=" + marshalHexSequence(t.SCTE35In)
+	}
+
+	if t.EndOnNext {
+		ret += ",END-ON-NEXT=YES"
+	}
+
+	ret += "\n"
+
+	return ret
+}
@@ -62,3 +62,25 @@ func (t MediaMap) marshal() string {
 
 	return ret
 }
+
+// Equal checks if two MediaMap objects are equal.
+func (t *MediaMap) Equal(other *MediaMap) bool {
+	if t == other {
+		return true
+	}
+
+	if t == nil || other == nil {
+		return false
+	}
+
+	return t.URI == other.URI &&
+		uint64PtrEqual(t.ByteRangeLength, other.ByteRangeLength) &&
+		uint64PtrEqual(t.ByteRangeStart, other.ByteRangeStart)
+}
+
+func uint64PtrEqual(a *uint64, b *uint64) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
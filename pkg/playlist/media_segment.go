@@ -29,12 +29,26 @@ type MediaSegment struct {
 	// EXT-X-PROGRAM-DATE-TIME
 	DateTime *time.Time
 
+	// EXT-X-DATERANGE
+	DateRanges []*MediaDateRange
+
+	// EXT-X-CUE-OUT (not standard)
+	// A zero value means that the duration is unknown.
+	CueOut *time.Duration
+
+	// EXT-X-CUE-IN (not standard)
+	CueIn bool
+
 	// EXT-X-BITRATE
 	Bitrate *int
 
 	// EXT-X-KEY
 	Key *MediaKey
 
+	// EXT-X-MAP
+	// It is filled only when it differs from Media.Map.
+	Map *MediaMap
+
 	// EXT-X-BYTERANGE
 	ByteRangeLength *uint64
 	ByteRangeStart  *uint64
@@ -62,10 +76,26 @@ func (s MediaSegment) marshal() string {
 		ret.WriteString("#EXT-X-GAP\n")
 	}
 
+	if s.CueIn {
+		ret.WriteString("#EXT-X-CUE-IN\n")
+	}
+
+	if s.CueOut != nil {
+		if *s.CueOut != 0 {
+			ret.WriteString("#EXT-X-CUE-OUT:" + strconv.FormatFloat(s.CueOut.Seconds(), 'f', 3, 64) + "\n")
+		} else {
+			ret.WriteString("#EXT-X-CUE-OUT\n")
+		}
+	}
+
 	if s.DateTime != nil {
 		ret.WriteString("#EXT-X-PROGRAM-DATE-TIME:" + s.DateTime.Format(timeRFC3339Millis) + "\n")
 	}
 
+	for _, dateRange := range s.DateRanges {
+		ret.WriteString(dateRange.marshal())
+	}
+
 	if s.Bitrate != nil {
 		ret.WriteString("#EXT-X-BITRATE:" + strconv.FormatInt(int64(*s.Bitrate), 10) + "\n")
 	}
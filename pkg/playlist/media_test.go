@@ -415,6 +415,208 @@ segment1.ts
 			},
 		},
 	},
+	{
+		"i-frames only",
+		`#EXTM3U
+#EXT-X-VERSION:4
+#EXT-X-TARGETDURATION:2
+#EXT-X-MEDIA-SEQUENCE:0
+#EXT-X-PLAYLIST-TYPE:VOD
+#EXT-X-I-FRAMES-ONLY
+#EXTINF:2.00000,
+#EXT-X-BYTERANGE:1316@376
+seg1.ts
+#EXTINF:2.00000,
+#EXT-X-BYTERANGE:1692@376
+seg2.ts
+#EXT-X-ENDLIST
+`,
+		`#EXTM3U
+#EXT-X-VERSION:4
+#EXT-X-TARGETDURATION:2
+#EXT-X-MEDIA-SEQUENCE:0
+#EXT-X-PLAYLIST-TYPE:VOD
+#EXT-X-I-FRAMES-ONLY
+#EXTINF:2.00000,
+#EXT-X-BYTERANGE:1316@376
+seg1.ts
+#EXTINF:2.00000,
+#EXT-X-BYTERANGE:1692@376
+seg2.ts
+#EXT-X-ENDLIST
+`,
+		Media{
+			Version:        4,
+			TargetDuration: 2,
+			PlaylistType:   ptrOf(MediaPlaylistTypeVOD),
+			IFramesOnly:    true,
+			Segments: []*MediaSegment{
+				{
+					Duration:        2 * time.Second,
+					ByteRangeLength: ptrOf(uint64(1316)),
+					ByteRangeStart:  ptrOf(uint64(376)),
+					URI:             "seg1.ts",
+				},
+				{
+					Duration:        2 * time.Second,
+					ByteRangeLength: ptrOf(uint64(1692)),
+					ByteRangeStart:  ptrOf(uint64(376)),
+					URI:             "seg2.ts",
+				},
+			},
+			Endlist: true,
+		},
+	},
+	{
+		"daterange",
+		"#EXTM3U\n" +
+			"#EXT-X-VERSION:3\n" +
+			"#EXT-X-TARGETDURATION:2\n" +
+			"#EXT-X-MEDIA-SEQUENCE:0\n" +
+			"#EXT-X-PROGRAM-DATE-TIME:2014-08-25T00:00:00Z\n" +
+			"#EXTINF:2.00000,\n" +
+			"seg1.ts\n" +
+			"#EXT-X-CUE-OUT:DURATION=30\n" +
+			"#EXT-X-PROGRAM-DATE-TIME:2014-08-25T00:00:02Z\n" +
+			"#EXT-X-DATERANGE:ID=\"splice-1\",START-DATE=\"2014-08-25T00:00:02Z\"," +
+			"PLANNED-DURATION=30.000,SCTE35-OUT=0xFC002F0000000000FF000014056FFFFFF000E011622DCAFF000052636200000000000A0008029896F50000008700000000\n" +
+			"#EXTINF:2.00000,\n" +
+			"seg2.ts\n" +
+			"#EXT-X-CUE-IN\n" +
+			"#EXT-X-DATERANGE:ID=\"splice-1\",START-DATE=\"2014-08-25T00:00:02Z\",DURATION=2.000,SCTE35-IN=0xFC\n" +
+			"#EXT-X-DATERANGE:ID=\"ad\",CLASS=\"com.example.ad\",START-DATE=\"2014-08-25T00:00:04Z\"," +
+			"END-ON-NEXT=YES\n" +
+			"#EXTINF:2.00000,\n" +
+			"seg3.ts\n",
+		"#EXTM3U\n" +
+			"#EXT-X-VERSION:3\n" +
+			"#EXT-X-TARGETDURATION:2\n" +
+			"#EXT-X-MEDIA-SEQUENCE:0\n" +
+			"#EXT-X-PROGRAM-DATE-TIME:2014-08-25T00:00:00Z\n" +
+			"#EXTINF:2.00000,\n" +
+			"seg1.ts\n" +
+			"#EXT-X-CUE-OUT:30.000\n" +
+			"#EXT-X-PROGRAM-DATE-TIME:2014-08-25T00:00:02Z\n" +
+			"#EXT-X-DATERANGE:ID=\"splice-1\",START-DATE=\"2014-08-25T00:00:02Z\"," +
+			"PLANNED-DURATION=30.000,SCTE35-OUT=0xFC002F0000000000FF000014056FFFFFF000E011622DCAFF000052636200000000000A0008029896F50000008700000000\n" +
+			"#EXTINF:2.00000,\n" +
+			"seg2.ts\n" +
+			"#EXT-X-CUE-IN\n" +
+			"#EXT-X-DATERANGE:ID=\"splice-1\",START-DATE=\"2014-08-25T00:00:02Z\",DURATION=2.000,SCTE35-IN=0xFC\n" +
+			"#EXT-X-DATERANGE:ID=\"ad\",CLASS=\"com.example.ad\",START-DATE=\"2014-08-25T00:00:04Z\"," +
+			"END-ON-NEXT=YES\n" +
+			"#EXTINF:2.00000,\n" +
+			"seg3.ts\n",
+		Media{
+			Version:        3,
+			TargetDuration: 2,
+			Segments: []*MediaSegment{
+				{
+					DateTime: ptrOf(time.Date(2014, 8, 25, 0, 0, 0, 0, time.UTC)),
+					Duration: 2 * time.Second,
+					URI:      "seg1.ts",
+				},
+				{
+					CueOut:   ptrOf(30 * time.Second),
+					DateTime: ptrOf(time.Date(2014, 8, 25, 0, 0, 2, 0, time.UTC)),
+					DateRanges: []*MediaDateRange{{
+						ID:              "splice-1",
+						StartDate:       time.Date(2014, 8, 25, 0, 0, 2, 0, time.UTC),
+						PlannedDuration: ptrOf(30 * time.Second),
+						SCTE35Out: []byte{
+							0xfc, 0x00, 0x2f, 0x00, 0x00, 0x00, 0x00, 0x00,
+							0xff, 0x00, 0x00, 0x14, 0x05, 0x6f, 0xff, 0xff,
+							0xf0, 0x00, 0xe0, 0x11, 0x62, 0x2d, 0xca, 0xff,
+							0x00, 0x00, 0x52, 0x63, 0x62, 0x00, 0x00, 0x00,
+							0x00, 0x00, 0x0a, 0x00, 0x08, 0x02, 0x98, 0x96,
+							0xf5, 0x00, 0x00, 0x00, 0x87, 0x00, 0x00, 0x00,
+							0x00,
+						},
+					}},
+					Duration: 2 * time.Second,
+					URI:      "seg2.ts",
+				},
+				{
+					CueIn: true,
+					DateRanges: []*MediaDateRange{
+						{
+							ID:        "splice-1",
+							StartDate: time.Date(2014, 8, 25, 0, 0, 2, 0, time.UTC),
+							Duration:  ptrOf(2 * time.Second),
+							SCTE35In:  []byte{0xfc},
+						},
+						{
+							ID:        "ad",
+							Class:     "com.example.ad",
+							StartDate: time.Date(2014, 8, 25, 0, 0, 4, 0, time.UTC),
+							EndOnNext: true,
+						},
+					},
+					Duration: 2 * time.Second,
+					URI:      "seg3.ts",
+				},
+			},
+		},
+	},
+	{
+		"map change",
+		`#EXTM3U
+#EXT-X-VERSION:7
+#EXT-X-TARGETDURATION:2
+#EXT-X-MEDIA-SEQUENCE:0
+#EXT-X-MAP:URI="init1.mp4"
+#EXTINF:2.00000,
+seg1.mp4
+#EXT-X-DISCONTINUITY
+#EXT-X-MAP:URI="init2.mp4"
+#EXTINF:2.00000,
+seg2.mp4
+#EXTINF:2.00000,
+seg3.mp4
+`,
+		`#EXTM3U
+#EXT-X-VERSION:7
+#EXT-X-TARGETDURATION:2
+#EXT-X-MEDIA-SEQUENCE:0
+#EXT-X-MAP:URI="init1.mp4"
+#EXTINF:2.00000,
+seg1.mp4
+#EXT-X-MAP:URI="init2.mp4"
+#EXT-X-DISCONTINUITY
+#EXTINF:2.00000,
+seg2.mp4
+#EXTINF:2.00000,
+seg3.mp4
+`,
+		Media{
+			Version:        7,
+			TargetDuration: 2,
+			Map: &MediaMap{
+				URI: "init1.mp4",
+			},
+			Segments: []*MediaSegment{
+				{
+					Duration: 2 * time.Second,
+					URI:      "seg1.mp4",
+				},
+				{
+					Discontinuity: true,
+					Duration:      2 * time.Second,
+					URI:           "seg2.mp4",
+					Map: &MediaMap{
+						URI: "init2.mp4",
+					},
+				},
+				{
+					Duration: 2 * time.Second,
+					URI:      "seg3.mp4",
+					Map: &MediaMap{
+						URI: "init2.mp4",
+					},
+				},
+			},
+		},
+	},
 }
 
 func TestMediaUnmarshal(t *testing.T) {
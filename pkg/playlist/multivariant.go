@@ -22,6 +22,9 @@ type Multivariant struct {
 	// EXT-X-STREAM-INF (at least one is required)
 	Variants []*MultivariantVariant
 
+	// EXT-X-I-FRAME-STREAM-INF
+	IFrameVariants []*MultivariantIFrameVariant
+
 	// EXT-X-MEDIA
 	Renditions []*MultivariantRendition
 }
@@ -86,6 +89,17 @@ func (m *Multivariant) Unmarshal(buf []byte) error {
 
 			m.Variants = append(m.Variants, &v)
 
+		case strings.HasPrefix(line, "#EXT-X-I-FRAME-STREAM-INF:"):
+			line = line[len("#EXT-X-I-FRAME-STREAM-INF:"):]
+
+			var v MultivariantIFrameVariant
+			err = v.unmarshal(line)
+			if err != nil {
+				return fmt.Errorf("invalid I-frame variant: %w", err)
+			}
+
+			m.IFrameVariants = append(m.IFrameVariants, &v)
+
 		case strings.HasPrefix(line, "#EXT-X-MEDIA:"):
 			line = line[len("#EXT-X-MEDIA:"):]
 
@@ -134,5 +148,13 @@ func (m Multivariant) Marshal() ([]byte, error) {
 		ret.WriteString(v.marshal())
 	}
 
+	if len(m.IFrameVariants) != 0 {
+		ret.WriteString("\n")
+
+		for _, v := range m.IFrameVariants {
+			ret.WriteString(v.marshal())
+		}
+	}
+
 	return []byte(ret.String()), nil
 }
@@ -0,0 +1,101 @@
+package playlist
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/bluenviron/gohlslib/v2/pkg/playlist/primitives"
+)
+
+// MultivariantIFrameVariant is a EXT-X-I-FRAME-STREAM-INF tag.
+type MultivariantIFrameVariant struct {
+	// BANDWIDTH
+	// required
+	Bandwidth int
+
+	// CODECS
+	// required
+	Codecs []string
+
+	// URI
+	// required
+	URI string
+
+	// AVERAGE-BANDWIDTH
+	AverageBandwidth *int
+
+	// RESOLUTION
+	Resolution string
+
+	// VIDEO
+	Video string
+}
+
+func (v *MultivariantIFrameVariant) unmarshal(va string) error {
+	var attrs primitives.Attributes
+	err := attrs.Unmarshal(va)
+	if err != nil {
+		return err
+	}
+
+	for key, val := range attrs {
+		switch key {
+		case "BANDWIDTH":
+			var tmp uint64
+			tmp, err = strconv.ParseUint(val, 10, 31)
+			if err != nil {
+				return err
+			}
+			v.Bandwidth = int(tmp)
+
+		case "AVERAGE-BANDWIDTH":
+			var tmp uint64
+			tmp, err = strconv.ParseUint(val, 10, 31)
+			if err != nil {
+				return err
+			}
+			v.AverageBandwidth = ptrOf(int(tmp))
+
+		case "CODECS":
+			v.Codecs = strings.Split(val, ",")
+
+		case "URI":
+			v.URI = val
+
+		case "RESOLUTION":
+			v.Resolution = val
+
+		case "VIDEO":
+			v.Video = val
+		}
+	}
+
+	if v.URI == "" {
+		return fmt.Errorf("URI is missing")
+	}
+
+	return nil
+}
+
+func (v MultivariantIFrameVariant) marshal() string {
+	ret := "#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=" + strconv.FormatInt(int64(v.Bandwidth), 10)
+
+	if v.AverageBandwidth != nil {
+		ret += ",AVERAGE-BANDWIDTH=" + strconv.FormatInt(int64(*v.AverageBandwidth), 10)
+	}
+
+	ret += ",CODECS=\"" + strings.Join(v.Codecs, ",") + "\""
+
+	if v.Resolution != "" {
+		ret += ",RESOLUTION=" + v.Resolution
+	}
+
+	if v.Video != "" {
+		ret += ",VIDEO=\"" + v.Video + "\""
+	}
+
+	ret += ",URI=\"" + v.URI + "\"\n"
+
+	return ret
+}
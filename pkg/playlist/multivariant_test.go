@@ -234,6 +234,13 @@ v4/prog_index.m3u8
 v3/prog_index.m3u8
 #EXT-X-STREAM-INF:BANDWIDTH=571555,AVERAGE-BANDWIDTH=561224,CODECS="avc1.640015,ec-3",RESOLUTION=480x270,FRAME-RATE=30.000,AUDIO="aud3",SUBTITLES="sub1",CLOSED-CAPTIONS="cc1"
 v2/prog_index.m3u8
+
+#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=187492,AVERAGE-BANDWIDTH=183689,CODECS="avc1.64002a",RESOLUTION=1920x1080,URI="v7/iframe_index.m3u8"
+#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=136398,AVERAGE-BANDWIDTH=132672,CODECS="avc1.640020",RESOLUTION=1280x720,URI="v6/iframe_index.m3u8"
+#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=101378,AVERAGE-BANDWIDTH=97767,CODECS="avc1.640020",RESOLUTION=960x540,URI="v5/iframe_index.m3u8"
+#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=77818,AVERAGE-BANDWIDTH=75722,CODECS="avc1.64001e",RESOLUTION=768x432,URI="v4/iframe_index.m3u8"
+#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=65091,AVERAGE-BANDWIDTH=63522,CODECS="avc1.64001e",RESOLUTION=640x360,URI="v3/iframe_index.m3u8"
+#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=40282,AVERAGE-BANDWIDTH=39678,CODECS="avc1.640015",RESOLUTION=480x270,URI="v2/iframe_index.m3u8"
 `,
 		Multivariant{
 			Version:             6,
@@ -576,6 +583,50 @@ v2/prog_index.m3u8
 					URI:            "v2/prog_index.m3u8",
 				},
 			},
+			IFrameVariants: []*MultivariantIFrameVariant{
+				{
+					Bandwidth:        187492,
+					AverageBandwidth: ptrOf(183689),
+					Codecs:           []string{"avc1.64002a"},
+					Resolution:       "1920x1080",
+					URI:              "v7/iframe_index.m3u8",
+				},
+				{
+					Bandwidth:        136398,
+					AverageBandwidth: ptrOf(132672),
+					Codecs:           []string{"avc1.640020"},
+					Resolution:       "1280x720",
+					URI:              "v6/iframe_index.m3u8",
+				},
+				{
+					Bandwidth:        101378,
+					AverageBandwidth: ptrOf(97767),
+					Codecs:           []string{"avc1.640020"},
+					Resolution:       "960x540",
+					URI:              "v5/iframe_index.m3u8",
+				},
+				{
+					Bandwidth:        77818,
+					AverageBandwidth: ptrOf(75722),
+					Codecs:           []string{"avc1.64001e"},
+					Resolution:       "768x432",
+					URI:              "v4/iframe_index.m3u8",
+				},
+				{
+					Bandwidth:        65091,
+					AverageBandwidth: ptrOf(63522),
+					Codecs:           []string{"avc1.64001e"},
+					Resolution:       "640x360",
+					URI:              "v3/iframe_index.m3u8",
+				},
+				{
+					Bandwidth:        40282,
+					AverageBandwidth: ptrOf(39678),
+					Codecs:           []string{"avc1.640015"},
+					Resolution:       "480x270",
+					URI:              "v2/iframe_index.m3u8",
+				},
+			},
 			Renditions: []*MultivariantRendition{
 				{
 					Type:       MultivariantRenditionTypeAudio,
@@ -682,6 +733,15 @@ QualityLevels(3385171)/Manifest(video,format=m3u8-aapl)
 QualityLevels(4681440)/Manifest(video,format=m3u8-aapl)
 #EXT-X-STREAM-INF:BANDWIDTH=6254125,CODECS="avc1.640028,mp4a.40.2",RESOLUTION=1920x1080,AUDIO="audio"
 QualityLevels(5977913)/Manifest(video,format=m3u8-aapl)
+
+#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=546902,CODECS="avc1.64000d",RESOLUTION=320x180,URI="QualityLevels(393546)/Manifest(video,format=m3u8-aapl,type=keyframes)"
+#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=801672,CODECS="avc1.64001e",RESOLUTION=640x360,URI="QualityLevels(642832)/Manifest(video,format=m3u8-aapl,type=keyframes)"
+#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=1158387,CODECS="avc1.64001e",RESOLUTION=640x360,URI="QualityLevels(991868)/Manifest(video,format=m3u8-aapl,type=keyframes)"
+#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=1667928,CODECS="avc1.64001f",RESOLUTION=960x540,URI="QualityLevels(1490441)/Manifest(video,format=m3u8-aapl,type=keyframes)"
+#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=2432306,CODECS="avc1.64001f",RESOLUTION=960x540,URI="QualityLevels(2238364)/Manifest(video,format=m3u8-aapl,type=keyframes)"
+#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=3604342,CODECS="avc1.64001f",RESOLUTION=1280x720,URI="QualityLevels(3385171)/Manifest(video,format=m3u8-aapl,type=keyframes)"
+#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=4929129,CODECS="avc1.640028",RESOLUTION=1920x1080,URI="QualityLevels(4681440)/Manifest(video,format=m3u8-aapl,type=keyframes)"
+#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=6254125,CODECS="avc1.640028",RESOLUTION=1920x1080,URI="QualityLevels(5977913)/Manifest(video,format=m3u8-aapl,type=keyframes)"
 `,
 		Multivariant{
 			Version: 4,
@@ -767,6 +827,56 @@ QualityLevels(5977913)/Manifest(video,format=m3u8-aapl)
 					Audio:      "audio",
 				},
 			},
+			IFrameVariants: []*MultivariantIFrameVariant{
+				{
+					Bandwidth:  546902,
+					Codecs:     []string{"avc1.64000d"},
+					Resolution: "320x180",
+					URI:        "QualityLevels(393546)/Manifest(video,format=m3u8-aapl,type=keyframes)",
+				},
+				{
+					Bandwidth:  801672,
+					Codecs:     []string{"avc1.64001e"},
+					Resolution: "640x360",
+					URI:        "QualityLevels(642832)/Manifest(video,format=m3u8-aapl,type=keyframes)",
+				},
+				{
+					Bandwidth:  1158387,
+					Codecs:     []string{"avc1.64001e"},
+					Resolution: "640x360",
+					URI:        "QualityLevels(991868)/Manifest(video,format=m3u8-aapl,type=keyframes)",
+				},
+				{
+					Bandwidth:  1667928,
+					Codecs:     []string{"avc1.64001f"},
+					Resolution: "960x540",
+					URI:        "QualityLevels(1490441)/Manifest(video,format=m3u8-aapl,type=keyframes)",
+				},
+				{
+					Bandwidth:  2432306,
+					Codecs:     []string{"avc1.64001f"},
+					Resolution: "960x540",
+					URI:        "QualityLevels(2238364)/Manifest(video,format=m3u8-aapl,type=keyframes)",
+				},
+				{
+					Bandwidth:  3604342,
+					Codecs:     []string{"avc1.64001f"},
+					Resolution: "1280x720",
+					URI:        "QualityLevels(3385171)/Manifest(video,format=m3u8-aapl,type=keyframes)",
+				},
+				{
+					Bandwidth:  4929129,
+					Codecs:     []string{"avc1.640028"},
+					Resolution: "1920x1080",
+					URI:        "QualityLevels(4681440)/Manifest(video,format=m3u8-aapl,type=keyframes)",
+				},
+				{
+					Bandwidth:  6254125,
+					Codecs:     []string{"avc1.640028"},
+					Resolution: "1920x1080",
+					URI:        "QualityLevels(5977913)/Manifest(video,format=m3u8-aapl,type=keyframes)",
+				},
+			},
 			Renditions: []*MultivariantRendition{
 				{
 					Type:    MultivariantRenditionTypeAudio,
@@ -784,6 +894,30 @@ QualityLevels(5977913)/Manifest(video,format=m3u8-aapl)
 			},
 		},
 	},
+	{
+		"closed captions none",
+		"#EXTM3U\n" +
+			"#EXT-X-VERSION:3\n" +
+			"\n" +
+			"#EXT-X-STREAM-INF:BANDWIDTH=155000,CODECS=\"avc1.42c028\",CLOSED-CAPTIONS=NONE\n" +
+			"stream1.m3u8\n",
+		"#EXTM3U\n" +
+			"#EXT-X-VERSION:3\n" +
+			"\n" +
+			"#EXT-X-STREAM-INF:BANDWIDTH=155000,CODECS=\"avc1.42c028\",CLOSED-CAPTIONS=NONE\n" +
+			"stream1.m3u8\n",
+		Multivariant{
+			Version: 3,
+			Variants: []*MultivariantVariant{
+				{
+					Bandwidth:      155000,
+					Codecs:         []string{"avc1.42c028"},
+					ClosedCaptions: "NONE",
+					URI:            "stream1.m3u8",
+				},
+			},
+		},
+	},
 	{
 		"apple multivideo",
 		"#EXTM3U\n" +
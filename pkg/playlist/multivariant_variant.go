@@ -41,6 +41,7 @@ type MultivariantVariant struct {
 	Subtitles string
 
 	// CLOSED-CAPTIONS
+	// NONE means that no variant contains closed captions.
 	ClosedCaptions string
 }
 
@@ -136,7 +137,10 @@ func (v MultivariantVariant) marshal() string {
 		ret += ",SUBTITLES=\"" + v.Subtitles + "\""
 	}
 
-	if v.ClosedCaptions != "" {
+	// NONE is an enumerated string and is not quoted
+	if v.ClosedCaptions == "NONE" {
+		ret += ",CLOSED-CAPTIONS=NONE"
+	} else if v.ClosedCaptions != "" {
 		ret += ",CLOSED-CAPTIONS=\"" + v.ClosedCaptions + "\""
 	}
 
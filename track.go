@@ -1,9 +1,15 @@
 package gohlslib
 
 import (
+	"errors"
+
 	"github.com/bluenviron/gohlslib/v2/pkg/codecs"
+	"github.com/bluenviron/gohlslib/v2/pkg/playlist"
 )
 
+// ErrUnsupportedCodec is returned when a codec is not supported.
+var ErrUnsupportedCodec = errors.New("unsupported codec")
+
 // Track is a HLS track.
 type Track struct {
 	// Codec
@@ -13,14 +19,40 @@ type Track struct {
 	ClockRate int
 
 	// Name
-	// For audio renditions only.
+	// For renditions only.
+	// In Muxer, setting the name of a video track publishes video tracks
+	// as alternate renditions (for instance, camera angles) instead of variants.
 	Name string
 
 	// Language
-	// For audio renditions only.
+	// For renditions only.
 	Language string
 
 	// whether this is the default track.
-	// For audio renditions only.
+	// For renditions only.
 	IsDefault bool
+
+	// whether this is a forced subtitle track, that must be displayed
+	// even when subtitles are disabled.
+	// For subtitle renditions only.
+	IsForced bool
+}
+
+// newRenditionTrack creates a track that belongs to the given rendition.
+// Rendition is nil for tracks of the leading playlist.
+// Tracks whose type differs from the one of the rendition are not part of it.
+func newRenditionTrack(rendition *playlist.MultivariantRendition, codec codecs.Codec, clockRate int) *Track {
+	track := &Track{
+		Codec:     codec,
+		ClockRate: clockRate,
+	}
+
+	if rendition != nil &&
+		(rendition.Type == playlist.MultivariantRenditionTypeVideo) == codec.IsVideo() {
+		track.Name = rendition.Name
+		track.Language = rendition.Language
+		track.IsDefault = rendition.Default
+	}
+
+	return track
 }